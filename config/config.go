@@ -2,31 +2,212 @@ package config
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log"
+	"net/netip"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/joho/godotenv"
+	"github.com/redis/go-redis/v9"
+	"github.com/snavarro/microtracker/internal/domain"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Supported storage backends
+const (
+	StorageMongo    = "mongo"
+	StoragePostgres = "postgres"
+	StorageMemory   = "memory"
+)
+
+// Supported rate limit stores
+const (
+	RateLimitStoreMemory = "memory"
+	RateLimitStoreRedis  = "redis"
+)
+
+// Supported rate limit identities
+const (
+	// IdentityPrincipal is the API key ID or the subject of a bearer token
+	IdentityPrincipal = "principal"
+	IdentityTenant    = "tenant"
+	IdentityIP        = "ip"
+)
+
+// Supported rate limit modes
+const (
+	// RateLimitModeEnforce rejects requests over the limit
+	RateLimitModeEnforce = "enforce"
+	// RateLimitModeShadow logs and counts requests over the limit, but lets
+	// them through
+	RateLimitModeShadow = "shadow"
+	// RateLimitModeOff does not limit the route
+	RateLimitModeOff = "off"
+)
+
+// Supported client IP headers
+const (
+	ClientIPHeaderXForwardedFor  = "X-Forwarded-For"
+	ClientIPHeaderXRealIP        = "X-Real-IP"
+	ClientIPHeaderForwarded      = "Forwarded"
+	ClientIPHeaderCFConnectingIP = "CF-Connecting-IP"
+)
+
 type Config struct {
-	Environment   string
-	MongoURI      string
-	DatabaseName  string
-	ServerAddress string
-	RateLimit     RateLimitConfig
+	Environment           string
+	StorageBackend        string
+	SeedFile              string
+	MongoURI              string
+	DatabaseName          string
+	PostgresDSN           string
+	ServerAddress         string
+	StatusTransitionsFile string
+	RunMigrations         bool
+	MetricsEnabled        bool
+	MetricsAddress        string
+	Timeouts              TimeoutConfig
+	Idempotency           IdempotencyConfig
+	Auth                  AuthConfig
+	RateLimit             RateLimitConfig
+	Proxy                 ProxyConfig
+}
+
+// TimeoutConfig bounds how long each kind of database operation may take.
+// Operations are also cancelled as soon as the calling request goes away.
+type TimeoutConfig struct {
+	Connect time.Duration
+	Read    time.Duration
+	Write   time.Duration
+	Search  time.Duration
+}
+
+// IdempotencyConfig controls how long responses to requests carrying an
+// Idempotency-Key are kept for replay, and how long a request holds its key
+// before a retry may take it over
+type IdempotencyConfig struct {
+	TTL         time.Duration
+	LockTimeout time.Duration
+}
+
+// AuthConfig controls API key authentication. BootstrapKey, when set, is
+// stored as an admin key at startup so that other keys can be issued.
+type AuthConfig struct {
+	Enabled      bool
+	BootstrapKey string
+	JWT          JWTConfig
+}
+
+// JWTConfig controls validation of bearer tokens issued by an OIDC provider.
+// Tokens are accepted when a JWKS file or URL is set.
+type JWTConfig struct {
+	JWKSFile string
+	JWKSURL  string
+	// JWKSRefresh is how long fetched keys are used before fetching them
+	// again. Unknown key IDs trigger an earlier refresh.
+	JWKSRefresh time.Duration
+	Issuer      string
+	Audience    string
+	// Leeway tolerates clock skew when checking expiry
+	Leeway      time.Duration
+	RolesClaim  string
+	TenantClaim string
+	// PlatformTenant is the tenant claim value of platform callers, which
+	// may act for any tenant. Every other token must carry a valid tenant.
+	PlatformTenant string
+	PlanClaim      string
+	// RoleMap maps values of the roles claim to API roles. Values that are
+	// API role names map to themselves.
+	RoleMap map[string]string
+}
+
+// Enabled reports whether bearer tokens are accepted
+func (c JWTConfig) Enabled() bool {
+	return c.JWKSFile != "" || c.JWKSURL != ""
 }
 
+// RateLimitConfig holds the default limit and the limits of individual
+// routes. Endpoints are keyed by route template as registered with the
+// router, optionally prefixed with a comma separated method list, e.g.
+// "GET:/api/v1/packages/:id" or "PUT,PATCH:/api/v1/packages/*". A template
+// without methods applies to every method, and * matches one path segment.
 type RateLimitConfig struct {
 	Default   EndpointRateLimit
 	Endpoints map[string]EndpointRateLimit
+	// IP limits every client address per route ahead of authentication, as
+	// a ceiling above the limits of the callers that share an address
+	IP EndpointRateLimit
+	// Store keeps the buckets: memory for each replica separately, or redis
+	// to share them between replicas
+	Store    string
+	RedisURL string
+	// StoreTimeout bounds each call to the store
+	StoreTimeout time.Duration
+	// FailOpen lets requests through while the store is unavailable instead
+	// of rejecting them
+	FailOpen bool
+	// Identity lists what identifies a caller, in order of preference; the
+	// first one known for a request is used
+	Identity []string
+	// Plans are the limits and quotas of callers by plan name
+	Plans map[string]PlanLimit
+	// DefaultPlan applies to callers without a plan, if set
+	DefaultPlan string
+	// TrackUsage counts every caller's daily and monthly requests in the
+	// storage backend, which quotas require
+	TrackUsage bool
+	// UsageRetention is how long usage counters are kept after the end of
+	// their month
+	UsageRetention time.Duration
+	// AllowCIDRs are client networks that bypass rate limits and quotas, such
+	// as internal services
+	AllowCIDRs []netip.Prefix
+	// DenyCIDRs are client networks whose requests are rejected with 403
+	// before anything else. They take precedence over AllowCIDRs.
+	DenyCIDRs []netip.Prefix
+	// MaxBuckets bounds the buckets of the memory store, evicting the least
+	// recently used ones; 0 is unbounded
+	MaxBuckets int
+	// MaxClients bounds the callers each replica tracks for metrics and the
+	// admin endpoints, evicting the least recently seen ones; 0 is unbounded
+	MaxClients int
+}
+
+// ProxyConfig controls how the client IP of a request is found. Requests
+// from a trusted proxy are attributed to the client named in ClientIPHeader,
+// every other request to the address it comes from, so that clients cannot
+// choose their IP by sending the header themselves.
+type ProxyConfig struct {
+	// TrustedProxies are the networks of the load balancers and proxies in
+	// front of the service; none are trusted by default
+	TrustedProxies []netip.Prefix
+	// ClientIPHeader is one of the ClientIPHeader constants
+	ClientIPHeader string
+}
+
+// PlanLimit is the limit of the callers on a plan. RequestsPerMinute and
+// BurstSize replace the default limit, and scale the limits of routes with
+// their own by their ratio to the default limit. Zero quotas are unlimited.
+type PlanLimit struct {
+	RequestsPerMinute int
+	BurstSize         int
+	DailyQuota        int64
+	MonthlyQuota      int64
 }
 
+// EndpointRateLimit is the limit of a route. The token bucket algorithm
+// allows BurstSize requests at once, refilled at RequestsPerMinute; the
+// sliding and fixed window algorithms allow RequestsPerMinute requests per
+// minute; the concurrency algorithm allows BurstSize requests in flight.
+// Mode is one of the RateLimitMode constants; empty enforces the limit.
 type EndpointRateLimit struct {
+	Mode              string
+	Algorithm         string
 	RequestsPerMinute int
 	BurstSize         int
 	TTLMinutes        int
@@ -61,12 +242,16 @@ func NewConfig() (*Config, error) {
 	defaultRequestsPerMinute, _ := strconv.Atoi(getEnv("RATE_LIMIT_REQUESTS_PER_MINUTE", "100"))
 	defaultBurstSize, _ := strconv.Atoi(getEnv("RATE_LIMIT_BURST_SIZE", "50"))
 	defaultTTLMinutes, _ := strconv.Atoi(getEnv("RATE_LIMIT_TTL_MINUTES", "5"))
+	defaultAlgorithm := getEnv("RATE_LIMIT_ALGORITHM", string(domain.AlgorithmTokenBucket))
+	defaultMode := getEnv("RATE_LIMIT_MODE", RateLimitModeEnforce)
 
 	// Parse endpoint-specific rate limits
 	endpoints := make(map[string]EndpointRateLimit)
 
 	// List packages endpoint
 	endpoints["GET:/api/v1/packages"] = EndpointRateLimit{
+		Mode:              getEnv("RATE_LIMIT_LIST_MODE", defaultMode),
+		Algorithm:         getEnv("RATE_LIMIT_LIST_ALGORITHM", defaultAlgorithm),
 		RequestsPerMinute: getIntEnv("RATE_LIMIT_LIST_REQUESTS_PER_MINUTE", 200),
 		BurstSize:         getIntEnv("RATE_LIMIT_LIST_BURST_SIZE", 100),
 		TTLMinutes:        getIntEnv("RATE_LIMIT_LIST_TTL_MINUTES", 5),
@@ -74,6 +259,8 @@ func NewConfig() (*Config, error) {
 
 	// Search packages endpoint
 	endpoints["GET:/api/v1/packages/search"] = EndpointRateLimit{
+		Mode:              getEnv("RATE_LIMIT_SEARCH_MODE", defaultMode),
+		Algorithm:         getEnv("RATE_LIMIT_SEARCH_ALGORITHM", defaultAlgorithm),
 		RequestsPerMinute: getIntEnv("RATE_LIMIT_SEARCH_REQUESTS_PER_MINUTE", 150),
 		BurstSize:         getIntEnv("RATE_LIMIT_SEARCH_BURST_SIZE", 75),
 		TTLMinutes:        getIntEnv("RATE_LIMIT_SEARCH_TTL_MINUTES", 5),
@@ -81,33 +268,198 @@ func NewConfig() (*Config, error) {
 
 	// Create package endpoint
 	endpoints["POST:/api/v1/packages"] = EndpointRateLimit{
+		Mode:              getEnv("RATE_LIMIT_CREATE_MODE", defaultMode),
+		Algorithm:         getEnv("RATE_LIMIT_CREATE_ALGORITHM", defaultAlgorithm),
 		RequestsPerMinute: getIntEnv("RATE_LIMIT_CREATE_REQUESTS_PER_MINUTE", 50),
 		BurstSize:         getIntEnv("RATE_LIMIT_CREATE_BURST_SIZE", 25),
 		TTLMinutes:        getIntEnv("RATE_LIMIT_CREATE_TTL_MINUTES", 5),
 	}
 
+	// Any other routes
+	routes, err := getRouteLimitsEnv("RATE_LIMIT_ROUTES", defaultMode, defaultAlgorithm, defaultTTLMinutes)
+	if err != nil {
+		return nil, err
+	}
+	for route, limit := range routes {
+		endpoints[route] = limit
+	}
+
+	plans, err := getPlansEnv("RATE_LIMIT_PLANS")
+	if err != nil {
+		return nil, err
+	}
+	allowCIDRs, err := getPrefixesEnv("RATE_LIMIT_ALLOW_CIDRS")
+	if err != nil {
+		return nil, err
+	}
+	denyCIDRs, err := getPrefixesEnv("RATE_LIMIT_DENY_CIDRS")
+	if err != nil {
+		return nil, err
+	}
+	trustedProxies, err := getPrefixesEnv("TRUSTED_PROXIES")
+	if err != nil {
+		return nil, err
+	}
+	clientIPHeader, ok := canonicalClientIPHeader(getEnv("CLIENT_IP_HEADER", ClientIPHeaderXForwardedFor))
+	if !ok {
+		return nil, fmt.Errorf("unsupported client IP header %q", clientIPHeader)
+	}
+
+	ipLimit := EndpointRateLimit{
+		Mode:              getEnv("RATE_LIMIT_IP_MODE", defaultMode),
+		Algorithm:         getEnv("RATE_LIMIT_IP_ALGORITHM", defaultAlgorithm),
+		RequestsPerMinute: getIntEnv("RATE_LIMIT_IP_REQUESTS_PER_MINUTE", 1200),
+		BurstSize:         getIntEnv("RATE_LIMIT_IP_BURST_SIZE", 300),
+		TTLMinutes:        getIntEnv("RATE_LIMIT_IP_TTL_MINUTES", 5),
+	}
+
 	config := &Config{
-		Environment:   getEnv("APP_ENV", "development"),
-		MongoURI:      getEnv("MONGO_URI", "mongodb://localhost:27017"),
-		DatabaseName:  getEnv("DATABASE_NAME", "tracker"),
-		ServerAddress: getEnv("SERVER_ADDRESS", ":9090"),
+		Environment:           getEnv("APP_ENV", "development"),
+		StorageBackend:        getEnv("STORAGE_BACKEND", StorageMongo),
+		SeedFile:              getEnv("STORAGE_SEED_FILE", ""),
+		MongoURI:              getEnv("MONGO_URI", "mongodb://localhost:27017"),
+		DatabaseName:          getEnv("DATABASE_NAME", "tracker"),
+		PostgresDSN:           getEnv("POSTGRES_DSN", "postgres://localhost:5432/tracker?sslmode=disable"),
+		ServerAddress:         getEnv("SERVER_ADDRESS", ":9090"),
+		StatusTransitionsFile: getEnv("STATUS_TRANSITIONS_FILE", ""),
+		RunMigrations:         getBoolEnv("RUN_MIGRATIONS", true),
+		MetricsEnabled:        getBoolEnv("METRICS_ENABLED", false),
+		MetricsAddress:        getEnv("METRICS_ADDRESS", "127.0.0.1:9091"),
+		Timeouts: TimeoutConfig{
+			Connect: getDurationEnv("DB_CONNECT_TIMEOUT", 10*time.Second),
+			Read:    getDurationEnv("DB_READ_TIMEOUT", 5*time.Second),
+			Write:   getDurationEnv("DB_WRITE_TIMEOUT", 5*time.Second),
+			Search:  getDurationEnv("DB_SEARCH_TIMEOUT", 5*time.Second),
+		},
+		Idempotency: IdempotencyConfig{
+			TTL:         getDurationEnv("IDEMPOTENCY_TTL", 24*time.Hour),
+			LockTimeout: getDurationEnv("IDEMPOTENCY_LOCK_TIMEOUT", time.Minute),
+		},
+		Auth: AuthConfig{
+			Enabled:      getBoolEnv("AUTH_ENABLED", true),
+			BootstrapKey: getEnv("AUTH_BOOTSTRAP_KEY", ""),
+			JWT: JWTConfig{
+				JWKSFile:       getEnv("JWT_JWKS_FILE", ""),
+				JWKSURL:        getEnv("JWT_JWKS_URL", ""),
+				JWKSRefresh:    getDurationEnv("JWT_JWKS_REFRESH", 15*time.Minute),
+				Issuer:         getEnv("JWT_ISSUER", ""),
+				Audience:       getEnv("JWT_AUDIENCE", ""),
+				Leeway:         getDurationEnv("JWT_LEEWAY", 30*time.Second),
+				RolesClaim:     getEnv("JWT_ROLES_CLAIM", "roles"),
+				TenantClaim:    getEnv("JWT_TENANT_CLAIM", "tenant"),
+				PlatformTenant: getEnv("JWT_PLATFORM_TENANT", ""),
+				PlanClaim:      getEnv("JWT_PLAN_CLAIM", "plan"),
+				RoleMap:        getMapEnv("JWT_ROLE_MAP"),
+			},
+		},
 		RateLimit: RateLimitConfig{
 			Default: EndpointRateLimit{
+				Mode:              defaultMode,
+				Algorithm:         defaultAlgorithm,
 				RequestsPerMinute: defaultRequestsPerMinute,
 				BurstSize:         defaultBurstSize,
 				TTLMinutes:        defaultTTLMinutes,
 			},
-			Endpoints: endpoints,
+			Endpoints:      endpoints,
+			IP:             ipLimit,
+			Store:          getEnv("RATE_LIMIT_STORE", RateLimitStoreMemory),
+			RedisURL:       getEnv("RATE_LIMIT_REDIS_URL", "redis://localhost:6379/0"),
+			StoreTimeout:   getDurationEnv("RATE_LIMIT_STORE_TIMEOUT", 100*time.Millisecond),
+			FailOpen:       getBoolEnv("RATE_LIMIT_FAIL_OPEN", true),
+			Identity:       getListEnv("RATE_LIMIT_IDENTITY", []string{IdentityIP}),
+			Plans:          plans,
+			DefaultPlan:    getEnv("RATE_LIMIT_DEFAULT_PLAN", ""),
+			TrackUsage:     getBoolEnv("RATE_LIMIT_TRACK_USAGE", false),
+			UsageRetention: getDurationEnv("RATE_LIMIT_USAGE_RETENTION", 90*24*time.Hour),
+			AllowCIDRs:     allowCIDRs,
+			DenyCIDRs:      denyCIDRs,
+			MaxBuckets:     getIntEnv("RATE_LIMIT_MAX_BUCKETS", 100000),
+			MaxClients:     getIntEnv("RATE_LIMIT_MAX_CLIENTS", 10000),
+		},
+		Proxy: ProxyConfig{
+			TrustedProxies: trustedProxies,
+			ClientIPHeader: clientIPHeader,
 		},
 	}
 
-	log.Printf("Loaded configuration: Environment=%s, MongoURI=%s, DatabaseName=%s, ServerAddress=%s",
-		config.Environment, config.MongoURI, config.DatabaseName, config.ServerAddress)
-	log.Printf("Rate Limit Configuration: Default={RequestsPerMinute=%d, BurstSize=%d, TTLMinutes=%d}",
+	switch config.StorageBackend {
+	case StorageMongo, StoragePostgres, StorageMemory:
+	default:
+		return nil, fmt.Errorf("unsupported storage backend %q", config.StorageBackend)
+	}
+	switch config.RateLimit.Store {
+	case RateLimitStoreMemory, RateLimitStoreRedis:
+	default:
+		return nil, fmt.Errorf("unsupported rate limit store %q", config.RateLimit.Store)
+	}
+	if err := validateRateLimit("the default limit", config.RateLimit.Default); err != nil {
+		return nil, err
+	}
+	if err := validateRateLimit("the IP limit", ipLimit); err != nil {
+		return nil, err
+	}
+	for endpoint, limit := range config.RateLimit.Endpoints {
+		if err := validateRateLimit(endpoint, limit); err != nil {
+			return nil, err
+		}
+	}
+	if config.RateLimit.MaxBuckets < 0 || config.RateLimit.MaxClients < 0 {
+		return nil, fmt.Errorf("RATE_LIMIT_MAX_BUCKETS and RATE_LIMIT_MAX_CLIENTS must not be negative")
+	}
+	for _, identity := range config.RateLimit.Identity {
+		switch identity {
+		case IdentityPrincipal, IdentityTenant, IdentityIP:
+		default:
+			return nil, fmt.Errorf("unsupported rate limit identity %q", identity)
+		}
+	}
+	if plan := config.RateLimit.DefaultPlan; plan != "" {
+		if _, exists := config.RateLimit.Plans[plan]; !exists {
+			return nil, fmt.Errorf("default rate limit plan %q is not defined in RATE_LIMIT_PLANS", plan)
+		}
+	}
+	if config.Idempotency.LockTimeout <= 0 || config.Idempotency.LockTimeout > config.Idempotency.TTL {
+		return nil, fmt.Errorf("IDEMPOTENCY_LOCK_TIMEOUT must be positive and at most IDEMPOTENCY_TTL")
+	}
+	if config.MetricsEnabled && config.MetricsAddress == config.ServerAddress {
+		return nil, fmt.Errorf("METRICS_ADDRESS must differ from SERVER_ADDRESS")
+	}
+	if config.RateLimit.TrackUsage && config.RateLimit.UsageRetention <= 0 {
+		return nil, fmt.Errorf("RATE_LIMIT_USAGE_RETENTION must be positive")
+	}
+
+	log.Printf("Loaded configuration: Environment=%s, StorageBackend=%s, MongoURI=%s, DatabaseName=%s, ServerAddress=%s",
+		config.Environment, config.StorageBackend, config.MongoURI, config.DatabaseName, config.ServerAddress)
+	if config.MetricsEnabled {
+		log.Printf("Metrics: Address=%s", config.MetricsAddress)
+	}
+	log.Printf("Database Timeouts: Connect=%s, Read=%s, Write=%s, Search=%s",
+		config.Timeouts.Connect, config.Timeouts.Read, config.Timeouts.Write, config.Timeouts.Search)
+	log.Printf("Idempotency keys expire after %s, and pending ones are taken over after %s",
+		config.Idempotency.TTL, config.Idempotency.LockTimeout)
+	log.Printf("Authentication: Enabled=%t, BootstrapKey=%t", config.Auth.Enabled, config.Auth.BootstrapKey != "")
+	if jwt := config.Auth.JWT; jwt.Enabled() {
+		log.Printf("JWT Authentication: Issuer=%s, Audience=%s, JWKSFile=%s, JWKSURL=%s, JWKSRefresh=%s, PlatformTenant=%s",
+			jwt.Issuer, jwt.Audience, jwt.JWKSFile, jwt.JWKSURL, jwt.JWKSRefresh, jwt.PlatformTenant)
+	}
+	log.Printf("Rate Limit Configuration: Store=%s, FailOpen=%t, MaxBuckets=%d, MaxClients=%d, AllowCIDRs=%v, DenyCIDRs=%v, Default={Mode=%s, Algorithm=%s, RequestsPerMinute=%d, BurstSize=%d, TTLMinutes=%d}",
+		config.RateLimit.Store, config.RateLimit.FailOpen, config.RateLimit.MaxBuckets, config.RateLimit.MaxClients, config.RateLimit.AllowCIDRs, config.RateLimit.DenyCIDRs,
+		config.RateLimit.Default.Mode, config.RateLimit.Default.Algorithm,
 		config.RateLimit.Default.RequestsPerMinute, config.RateLimit.Default.BurstSize, config.RateLimit.Default.TTLMinutes)
+	log.Printf("Rate Limit Usage: Track=%t, Retention=%s", config.RateLimit.TrackUsage, config.RateLimit.UsageRetention)
+	if !config.RateLimit.TrackUsage {
+		for name, plan := range config.RateLimit.Plans {
+			if plan.DailyQuota > 0 || plan.MonthlyQuota > 0 {
+				log.Printf("Warning: the quotas of plan %s are not enforced unless RATE_LIMIT_TRACK_USAGE is true", name)
+			}
+		}
+	}
+	log.Printf("Client IP: Header=%s, TrustedProxies=%v", config.Proxy.ClientIPHeader, config.Proxy.TrustedProxies)
+	log.Printf("Rate Limit per IP: {Mode=%s, Algorithm=%s, RequestsPerMinute=%d, BurstSize=%d, TTLMinutes=%d}",
+		ipLimit.Mode, ipLimit.Algorithm, ipLimit.RequestsPerMinute, ipLimit.BurstSize, ipLimit.TTLMinutes)
 	for endpoint, limit := range config.RateLimit.Endpoints {
-		log.Printf("Rate Limit for %s: {RequestsPerMinute=%d, BurstSize=%d, TTLMinutes=%d}",
-			endpoint, limit.RequestsPerMinute, limit.BurstSize, limit.TTLMinutes)
+		log.Printf("Rate Limit for %s: {Mode=%s, Algorithm=%s, RequestsPerMinute=%d, BurstSize=%d, TTLMinutes=%d}",
+			endpoint, limit.Mode, limit.Algorithm, limit.RequestsPerMinute, limit.BurstSize, limit.TTLMinutes)
 	}
 
 	return config, nil
@@ -129,8 +481,207 @@ func getIntEnv(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getBoolEnv(key string, defaultValue bool) bool {
+	if value, exists := os.LookupEnv(key); exists {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
+func getDurationEnv(key string, defaultValue time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		if durationValue, err := time.ParseDuration(value); err == nil {
+			return durationValue
+		}
+	}
+	return defaultValue
+}
+
+// validRateLimitMode reports whether mode is a known mode. The empty mode
+// enforces the limit.
+func validRateLimitMode(mode string) bool {
+	switch mode {
+	case "", RateLimitModeEnforce, RateLimitModeShadow, RateLimitModeOff:
+		return true
+	}
+	return false
+}
+
+// canonicalClientIPHeader returns the ClientIPHeader constant matching
+// header in any case, and whether there is one
+func canonicalClientIPHeader(header string) (string, bool) {
+	for _, supported := range []string{ClientIPHeaderXForwardedFor, ClientIPHeaderXRealIP, ClientIPHeaderForwarded, ClientIPHeaderCFConnectingIP} {
+		if strings.EqualFold(strings.TrimSpace(header), supported) {
+			return supported, true
+		}
+	}
+	return header, false
+}
+
+// validateRateLimit checks the algorithm and mode of a limit, and that its
+// numbers are positive. Stores do not define what a limit of zero does.
+func validateRateLimit(name string, limit EndpointRateLimit) error {
+	if !domain.RateLimitAlgorithm(limit.Algorithm).Valid() {
+		return fmt.Errorf("unsupported rate limit algorithm %q for %s", limit.Algorithm, name)
+	}
+	if !validRateLimitMode(limit.Mode) {
+		return fmt.Errorf("unsupported rate limit mode %q for %s", limit.Mode, name)
+	}
+	if limit.RequestsPerMinute <= 0 || limit.BurstSize <= 0 || limit.TTLMinutes <= 0 {
+		return fmt.Errorf("rate limit requests per minute, burst size and TTL must be positive for %s", name)
+	}
+	return nil
+}
+
+// getRouteLimitsEnv parses a semicolon separated list of route limits written
+// as route=[mode:][algorithm:]requestsPerMinute/burstSize[/ttlMinutes], e.g.
+// "GET,HEAD:/api/v1/packages/:id=300/100;/api/v1/admin/*=shadow:fixed_window:20/10/1"
+func getRouteLimitsEnv(key, defaultMode, defaultAlgorithm string, defaultTTLMinutes int) (map[string]EndpointRateLimit, error) {
+	result := make(map[string]EndpointRateLimit)
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return result, nil
+	}
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		route, limits, found := strings.Cut(entry, "=")
+		route = strings.TrimSpace(route)
+		if !found || !strings.Contains(route, "/") {
+			return nil, fmt.Errorf("invalid %s entry %q: expected route=[mode:][algorithm:]requests/burst[/ttl]", key, entry)
+		}
+
+		// Modes and algorithms have distinct names, so either may be left out
+		mode, algorithm := defaultMode, defaultAlgorithm
+		for {
+			name, rest, found := strings.Cut(limits, ":")
+			if !found {
+				break
+			}
+			if name = strings.TrimSpace(name); validRateLimitMode(name) {
+				mode = name
+			} else {
+				algorithm = name
+			}
+			limits = rest
+		}
+
+		fields := strings.Split(limits, "/")
+		numbers := make([]int, 0, 3)
+		for _, field := range fields {
+			n, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid %s entry %q: limits must be positive integers", key, entry)
+			}
+			numbers = append(numbers, n)
+		}
+		if len(numbers) < 2 || len(numbers) > 3 {
+			return nil, fmt.Errorf("invalid %s entry %q: expected route=[mode:][algorithm:]requests/burst[/ttl]", key, entry)
+		}
+
+		limit := EndpointRateLimit{Mode: mode, Algorithm: algorithm, RequestsPerMinute: numbers[0], BurstSize: numbers[1], TTLMinutes: defaultTTLMinutes}
+		if len(numbers) == 3 {
+			limit.TTLMinutes = numbers[2]
+		}
+		result[route] = limit
+	}
+	return result, nil
+}
+
+// getPlansEnv parses a semicolon separated list of plans written as
+// name=requestsPerMinute/burstSize[/dailyQuota[/monthlyQuota]], e.g.
+// "free=60/20/1000/20000;pro=600/200"
+func getPlansEnv(key string) (map[string]PlanLimit, error) {
+	result := make(map[string]PlanLimit)
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return result, nil
+	}
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, limits, found := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		fields := strings.Split(limits, "/")
+		if !found || name == "" || len(fields) < 2 || len(fields) > 4 {
+			return nil, fmt.Errorf("invalid %s entry %q: expected name=requests/burst[/daily[/monthly]]", key, entry)
+		}
+
+		numbers := make([]int64, 4)
+		for i, field := range fields {
+			n, err := strconv.ParseInt(strings.TrimSpace(field), 10, 64)
+			if err != nil || n < 0 || (i < 2 && n == 0) {
+				return nil, fmt.Errorf("invalid %s entry %q: limits must be positive integers", key, entry)
+			}
+			numbers[i] = n
+		}
+		result[name] = PlanLimit{
+			RequestsPerMinute: int(numbers[0]),
+			BurstSize:         int(numbers[1]),
+			DailyQuota:        numbers[2],
+			MonthlyQuota:      numbers[3],
+		}
+	}
+	return result, nil
+}
+
+// getPrefixesEnv parses a comma separated list of CIDRs and IP addresses,
+// e.g. "10.0.0.0/8,192.0.2.7,2001:db8::/32"
+func getPrefixesEnv(key string) ([]netip.Prefix, error) {
+	var result []netip.Prefix
+	for _, item := range getListEnv(key, nil) {
+		if addr, err := netip.ParseAddr(item); err == nil {
+			result = append(result, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q: expected a CIDR or an IP address", key, item)
+		}
+		result = append(result, prefix.Masked())
+	}
+	return result, nil
+}
+
+// getListEnv parses a comma separated list
+func getListEnv(key string, defaultValue []string) []string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+	var result []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// getMapEnv parses a comma separated list of key=value pairs
+func getMapEnv(key string) map[string]string {
+	result := make(map[string]string)
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return result
+	}
+	for _, pair := range strings.Split(value, ",") {
+		k, v, found := strings.Cut(pair, "=")
+		if found && strings.TrimSpace(k) != "" {
+			result[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return result
+}
+
 func ConnectDB(cfg *Config) (*mongo.Database, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.Connect)
 	defer cancel()
 
 	clientOptions := options.Client().ApplyURI(cfg.MongoURI)
@@ -146,3 +697,46 @@ func ConnectDB(cfg *Config) (*mongo.Database, error) {
 
 	return client.Database(cfg.DatabaseName), nil
 }
+
+func ConnectPostgres(cfg *Config) (*sql.DB, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.Connect)
+	defer cancel()
+
+	db, err := sql.Open("pgx", cfg.PostgresDSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open PostgreSQL connection: %v", err)
+	}
+
+	// Ping the database to verify connection
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping PostgreSQL: %v", err)
+	}
+
+	return db, nil
+}
+
+// ConnectRedis connects to the Redis server of the rate limit store. An
+// unreachable server is only an error when the rate limiter fails closed,
+// since requests are let through until it comes back otherwise.
+func ConnectRedis(cfg *Config) (*redis.Client, error) {
+	opts, err := redis.ParseURL(cfg.RateLimit.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %v", err)
+	}
+	client := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Timeouts.Connect)
+	defer cancel()
+
+	// Ping the server to verify connection
+	if err := client.Ping(ctx).Err(); err != nil {
+		if !cfg.RateLimit.FailOpen {
+			client.Close()
+			return nil, fmt.Errorf("failed to ping Redis: %v", err)
+		}
+		log.Printf("Failed to ping Redis, requests are not rate limited until it is reachable: %v", err)
+	}
+
+	return client, nil
+}
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetRouteLimitsEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]EndpointRateLimit
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			value: "",
+			want:  map[string]EndpointRateLimit{},
+		},
+		{
+			name:  "defaults",
+			value: "GET,HEAD:/api/v1/packages/:id=300/100",
+			want: map[string]EndpointRateLimit{
+				"GET,HEAD:/api/v1/packages/:id": {Mode: RateLimitModeEnforce, Algorithm: "token_bucket", RequestsPerMinute: 300, BurstSize: 100, TTLMinutes: 5},
+			},
+		},
+		{
+			name:  "several entries",
+			value: " PUT,PATCH:/api/v1/packages/* = 60/20 ; /api/v1/admin/*/*=20/5/1;",
+			want: map[string]EndpointRateLimit{
+				"PUT,PATCH:/api/v1/packages/*": {Mode: RateLimitModeEnforce, Algorithm: "token_bucket", RequestsPerMinute: 60, BurstSize: 20, TTLMinutes: 5},
+				"/api/v1/admin/*/*":            {Mode: RateLimitModeEnforce, Algorithm: "token_bucket", RequestsPerMinute: 20, BurstSize: 5, TTLMinutes: 1},
+			},
+		},
+		{
+			name:  "mode and algorithm",
+			value: "/api/v1/admin/*=shadow:fixed_window:20/10/1",
+			want: map[string]EndpointRateLimit{
+				"/api/v1/admin/*": {Mode: RateLimitModeShadow, Algorithm: "fixed_window", RequestsPerMinute: 20, BurstSize: 10, TTLMinutes: 1},
+			},
+		},
+		{
+			name:  "algorithm only",
+			value: "GET:/api/v1/packages/search=concurrency:60/4",
+			want: map[string]EndpointRateLimit{
+				"GET:/api/v1/packages/search": {Mode: RateLimitModeEnforce, Algorithm: "concurrency", RequestsPerMinute: 60, BurstSize: 4, TTLMinutes: 5},
+			},
+		},
+		{
+			name:  "mode only",
+			value: "POST:/api/v1/packages=off:50/50",
+			want: map[string]EndpointRateLimit{
+				"POST:/api/v1/packages": {Mode: RateLimitModeOff, Algorithm: "token_bucket", RequestsPerMinute: 50, BurstSize: 50, TTLMinutes: 5},
+			},
+		},
+		{name: "no limits", value: "/api/v1/packages", wantErr: true},
+		{name: "no route", value: "=60/20", wantErr: true},
+		{name: "route without a slash", value: "packages=60/20", wantErr: true},
+		{name: "one number", value: "/api/v1/packages=60", wantErr: true},
+		{name: "four numbers", value: "/api/v1/packages=60/20/5/1", wantErr: true},
+		{name: "zero", value: "/api/v1/packages=60/0", wantErr: true},
+		{name: "negative", value: "/api/v1/packages=-60/20", wantErr: true},
+		{name: "not a number", value: "/api/v1/packages=many/20", wantErr: true},
+		{name: "one invalid entry", value: "/api/v1/packages=60/20;/api/v1/admin/*=20", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_ROUTES", tt.value)
+			got, err := getRouteLimitsEnv("TEST_ROUTES", RateLimitModeEnforce, "token_bucket", 5)
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "TEST_ROUTES")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("unset", func(t *testing.T) {
+		got, err := getRouteLimitsEnv("TEST_ROUTES_UNSET", RateLimitModeEnforce, "token_bucket", 5)
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+}
+
+func TestGetPlansEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    map[string]PlanLimit
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			value: "",
+			want:  map[string]PlanLimit{},
+		},
+		{
+			name:  "rate limit only",
+			value: "pro=600/200",
+			want:  map[string]PlanLimit{"pro": {RequestsPerMinute: 600, BurstSize: 200}},
+		},
+		{
+			name:  "quotas",
+			value: " free = 60/20/1000/20000 ; pro=600/200/0/1000000;",
+			want: map[string]PlanLimit{
+				"free": {RequestsPerMinute: 60, BurstSize: 20, DailyQuota: 1000, MonthlyQuota: 20000},
+				"pro":  {RequestsPerMinute: 600, BurstSize: 200, MonthlyQuota: 1000000},
+			},
+		},
+		{
+			name:  "daily quota only",
+			value: "free=60/20/1000",
+			want:  map[string]PlanLimit{"free": {RequestsPerMinute: 60, BurstSize: 20, DailyQuota: 1000}},
+		},
+		{name: "no limits", value: "free", wantErr: true},
+		{name: "no name", value: "=60/20", wantErr: true},
+		{name: "one number", value: "free=60", wantErr: true},
+		{name: "five numbers", value: "free=60/20/1000/20000/1", wantErr: true},
+		{name: "zero rate", value: "free=0/20", wantErr: true},
+		{name: "zero burst", value: "free=60/0", wantErr: true},
+		{name: "negative quota", value: "free=60/20/-1", wantErr: true},
+		{name: "not a number", value: "free=60/20/lots", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_PLANS", tt.value)
+			got, err := getPlansEnv("TEST_PLANS")
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "TEST_PLANS")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGetPrefixesEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []netip.Prefix
+		wantErr bool
+	}{
+		{
+			name:  "empty",
+			value: "",
+		},
+		{
+			name:  "networks",
+			value: "10.0.0.0/8, 2001:db8::/32",
+			want:  []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("2001:db8::/32")},
+		},
+		{
+			name:  "addresses",
+			value: "192.0.2.7,2001:db8::1",
+			want:  []netip.Prefix{netip.MustParsePrefix("192.0.2.7/32"), netip.MustParsePrefix("2001:db8::1/128")},
+		},
+		{
+			name:  "IPv4-mapped address",
+			value: "::ffff:192.0.2.7",
+			want:  []netip.Prefix{netip.MustParsePrefix("192.0.2.7/32")},
+		},
+		{
+			name:  "host bits are masked",
+			value: "192.0.2.7/24",
+			want:  []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")},
+		},
+		{name: "hostname", value: "localhost", wantErr: true},
+		{name: "prefix too long", value: "10.0.0.0/33", wantErr: true},
+		{name: "one invalid entry", value: "10.0.0.0/8,10.0.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_CIDRS", tt.value)
+			got, err := getPrefixesEnv("TEST_CIDRS")
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "TEST_CIDRS")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
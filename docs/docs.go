@@ -15,9 +15,858 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/admin/api-keys": {
+            "get": {
+                "security": [
+                    {
+                        "APIKey": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List all API keys, including revoked ones. Secrets are never returned.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "api-keys"
+                ],
+                "summary": "List API keys",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "APIKey": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Issue a key with the viewer, operator or admin role, optionally bound to a tenant and\nassigned a rate limit plan. The secret is only returned in this response.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "api-keys"
+                ],
+                "summary": "Issue an API key",
+                "parameters": [
+                    {
+                        "description": "Key name, role, tenant and plan",
+                        "name": "key",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.issueAPIKeyRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/api-keys/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "APIKey": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Permanently disable a key. Revoked keys stay listed.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "api-keys"
+                ],
+                "summary": "Revoke an API key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "API key ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/api-keys/{id}/rotate": {
+            "post": {
+                "security": [
+                    {
+                        "APIKey": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Replace the secret of a key. The previous secret stops working immediately and the new one\nis only returned in this response.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "api-keys"
+                ],
+                "summary": "Rotate an API key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "API key ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/rate-limits/clients": {
+            "get": {
+                "security": [
+                    {
+                        "APIKey": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List the callers that made the most requests in the last hour, with their allowed and\nrejected requests. Callers are identified as the rate limiter identifies them, e.g.\nprincipal:\u003ckey ID\u003e, tenant:\u003ctenant\u003e or ip:\u003caddress\u003e. Every replica only knows the callers it served.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rate-limits"
+                ],
+                "summary": "List the hottest rate limit clients",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Number of clients (default 20)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/rate-limits/clients/{identity}": {
+            "get": {
+                "security": [
+                    {
+                        "APIKey": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get a caller's requests, the current state of its buckets and its exemption",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rate-limits"
+                ],
+                "summary": "Get a rate limit client",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Caller identity, e.g. ip:192.0.2.1",
+                        "name": "identity",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/rate-limits/clients/{identity}/exemption": {
+            "put": {
+                "security": [
+                    {
+                        "APIKey": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Let a caller's requests through rate limits and quotas for a while. Exempting a caller again\nreplaces its exemption.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rate-limits"
+                ],
+                "summary": "Exempt a rate limit client",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Caller identity, e.g. ip:192.0.2.1",
+                        "name": "identity",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Duration of the exemption",
+                        "name": "exemption",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/handler.exemptRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "APIKey": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "End a caller's exemption from rate limits and quotas",
+                "tags": [
+                    "rate-limits"
+                ],
+                "summary": "Remove a rate limit exemption",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Caller identity, e.g. ip:192.0.2.1",
+                        "name": "identity",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/rate-limits/clients/{identity}/reset": {
+            "post": {
+                "security": [
+                    {
+                        "APIKey": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Refill every bucket of a caller, forgetting the requests they counted. Quotas are not reset.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "rate-limits"
+                ],
+                "summary": "Reset a rate limit client",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Caller identity, e.g. ip:192.0.2.1",
+                        "name": "identity",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/usage": {
+            "get": {
+                "security": [
+                    {
+                        "APIKey": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "List a page of the number of requests each caller made in a day or month, highest first. Callers are\nidentified as the rate limiter identifies them, e.g. principal:\u003ckey ID\u003e, tenant:\u003ctenant\u003e or\nip:\u003caddress\u003e. Requests rejected by a quota are counted too.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "usage"
+                ],
+                "summary": "List usage",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Day (YYYY-MM-DD) or month (YYYY-MM); defaults to the current month",
+                        "name": "period",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 1,
+                        "description": "Page number",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 10,
+                        "description": "Page size",
+                        "name": "size",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    }
+                }
+            }
+        },
         "/packages": {
             "get": {
-                "description": "Get a paginated list of all packages",
+                "security": [
+                    {
+                        "APIKey": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get a paginated list of packages, optionally filtered. Text filters match exactly.\nDates are RFC 3339 timestamps or YYYY-MM-DD dates (UTC midnight); From bounds are\ninclusive and To bounds exclusive.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "packages"
+                ],
+                "summary": "List all packages",
+                "parameters": [
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Current status, repeated or comma separated",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Origin",
+                        "name": "origin",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Destination",
+                        "name": "destination",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Sender name",
+                        "name": "senderName",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Recipient name",
+                        "name": "recipientName",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Created at or after",
+                        "name": "createdFrom",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Created before",
+                        "name": "createdTo",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Updated at or after",
+                        "name": "updatedFrom",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Updated before",
+                        "name": "updatedTo",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "default": "-createdAt",
+                        "description": "Comma separated sort fields, prefixed with - for descending: createdAt, updatedAt, packageId, origin, destination, currentStatus",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor from the previous page; continues after it and ignores page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 1,
+                        "description": "Page number",
+                        "name": "page",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 10,
+                        "description": "Page size",
+                        "name": "size",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant to use; defaults to the caller's tenant, or default for platform credentials",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "APIKey": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Create a new package. Events cannot be sent with it; they are added through\nPOST /packages/{id}/events. Retries with the same Idempotency-Key receive the original response.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "packages"
+                ],
+                "summary": "Create a new package",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Unique key that makes the request safe to retry",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Package details",
+                        "name": "package",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.Package"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant to use; defaults to the caller's tenant, or default for platform credentials",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/packages/search": {
+            "get": {
+                "security": [
+                    {
+                        "APIKey": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Search package ID, sender and recipient names, origin, destination and status with pagination.\nliteral matches a case-insensitive substring, prefix a case-sensitive prefix, exact the whole\nvalue, regex a case-insensitive regular expression without quantifiers or alternations inside\nrepeated groups and with at most four quantifiers, and text any of\nthe query's words as whole words using the text index.",
                 "consumes": [
                     "application/json"
                 ],
@@ -27,8 +876,42 @@ const docTemplate = `{
                 "tags": [
                     "packages"
                 ],
-                "summary": "List all packages",
+                "summary": "Search packages",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Search query",
+                        "name": "query",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "literal",
+                            "prefix",
+                            "exact",
+                            "regex",
+                            "text"
+                        ],
+                        "type": "string",
+                        "default": "literal",
+                        "description": "Search mode",
+                        "name": "mode",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "default": "-createdAt",
+                        "description": "Comma separated sort fields, prefixed with - for descending: createdAt, updatedAt, packageId, origin, destination, currentStatus",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "next_cursor from the previous page; continues after it and ignores page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
                     {
                         "type": "integer",
                         "default": 1,
@@ -37,11 +920,93 @@ const docTemplate = `{
                         "in": "query"
                     },
                     {
-                        "type": "integer",
-                        "default": 10,
-                        "description": "Page size",
-                        "name": "size",
-                        "in": "query"
+                        "type": "integer",
+                        "default": 10,
+                        "description": "Page size",
+                        "name": "size",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant to use; defaults to the caller's tenant, or default for platform credentials",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    }
+                }
+            }
+        },
+        "/packages/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "APIKey": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Get package details by package ID. The ETag header holds the package version; requests with a\nmatching If-None-Match receive 304 Not Modified.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "packages"
+                ],
+                "summary": "Get a package by ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Package ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag from a previous response",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant to use; defaults to the caller's tenant, or default for platform credentials",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -51,6 +1016,33 @@ const docTemplate = `{
                             "$ref": "#/definitions/handler.response"
                         }
                     },
+                    "304": {
+                        "description": "Not Modified"
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -59,8 +1051,16 @@ const docTemplate = `{
                     }
                 }
             },
-            "post": {
-                "description": "Create a new package",
+            "put": {
+                "security": [
+                    {
+                        "APIKey": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Update an existing package. The update only applies to the version given by If-Match, or by\nthe version field of the body when the header is missing. A stale If-Match fails with 412 and\na stale body version or a concurrent write with 409. The current status must be the stored\nstatus, as it only changes by adding events.",
                 "consumes": [
                     "application/json"
                 ],
@@ -70,8 +1070,21 @@ const docTemplate = `{
                 "tags": [
                     "packages"
                 ],
-                "summary": "Create a new package",
+                "summary": "Update a package",
                 "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Package ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version being replaced",
+                        "name": "If-Match",
+                        "in": "header"
+                    },
                     {
                         "description": "Package details",
                         "name": "package",
@@ -80,11 +1093,17 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/domain.Package"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant to use; defaults to the caller's tenant, or default for platform credentials",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Created",
+                    "200": {
+                        "description": "OK",
                         "schema": {
                             "$ref": "#/definitions/handler.response"
                         }
@@ -95,54 +1114,32 @@ const docTemplate = `{
                             "$ref": "#/definitions/handler.response"
                         }
                     },
-                    "500": {
-                        "description": "Internal Server Error",
+                    "401": {
+                        "description": "Unauthorized",
                         "schema": {
                             "$ref": "#/definitions/handler.response"
                         }
-                    }
-                }
-            }
-        },
-        "/packages/search": {
-            "get": {
-                "description": "Search packages with pagination",
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "packages"
-                ],
-                "summary": "Search packages",
-                "parameters": [
-                    {
-                        "type": "string",
-                        "description": "Search query",
-                        "name": "query",
-                        "in": "query",
-                        "required": true
                     },
-                    {
-                        "type": "integer",
-                        "default": 1,
-                        "description": "Page number",
-                        "name": "page",
-                        "in": "query"
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
                     },
-                    {
-                        "type": "integer",
-                        "default": 10,
-                        "description": "Page size",
-                        "name": "size",
-                        "in": "query"
-                    }
-                ],
-                "responses": {
-                    "200": {
-                        "description": "OK",
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
                         "schema": {
                             "$ref": "#/definitions/handler.response"
                         }
@@ -154,11 +1151,17 @@ const docTemplate = `{
                         }
                     }
                 }
-            }
-        },
-        "/packages/{id}": {
-            "get": {
-                "description": "Get package details by package ID",
+            },
+            "delete": {
+                "security": [
+                    {
+                        "APIKey": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Delete a package by ID",
                 "consumes": [
                     "application/json"
                 ],
@@ -168,7 +1171,7 @@ const docTemplate = `{
                 "tags": [
                     "packages"
                 ],
-                "summary": "Get a package by ID",
+                "summary": "Delete a package",
                 "parameters": [
                     {
                         "type": "string",
@@ -176,17 +1179,32 @@ const docTemplate = `{
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant to use; defaults to the caller's tenant, or default for platform credentials",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Bad Request",
                         "schema": {
                             "$ref": "#/definitions/handler.response"
                         }
                     },
-                    "400": {
-                        "description": "Bad Request",
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "$ref": "#/definitions/handler.response"
                         }
@@ -205,10 +1223,19 @@ const docTemplate = `{
                     }
                 }
             },
-            "put": {
-                "description": "Update an existing package",
+            "patch": {
+                "security": [
+                    {
+                        "APIKey": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Apply a JSON merge patch (RFC 7386) or JSON patch (RFC 6902) to a package. Only the sender,\nrecipient, origin and destination can be changed; the current status only changes through\nevents. The patched package must still be valid. With If-Match the patch only applies to that version and fails with 412\notherwise; a concurrent write fails with 409.",
                 "consumes": [
-                    "application/json"
+                    "application/merge-patch+json",
+                    "application/json-patch+json"
                 ],
                 "produces": [
                     "application/json"
@@ -216,7 +1243,7 @@ const docTemplate = `{
                 "tags": [
                     "packages"
                 ],
-                "summary": "Update a package",
+                "summary": "Partially update a package",
                 "parameters": [
                     {
                         "type": "string",
@@ -226,13 +1253,25 @@ const docTemplate = `{
                         "required": true
                     },
                     {
-                        "description": "Package details",
-                        "name": "package",
+                        "type": "string",
+                        "description": "ETag of the version being patched",
+                        "name": "If-Match",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Patch document",
+                        "name": "patch",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/domain.Package"
+                            "type": "object"
                         }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant to use; defaults to the caller's tenant, or default for platform credentials",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
                     }
                 ],
                 "responses": {
@@ -248,12 +1287,42 @@ const docTemplate = `{
                             "$ref": "#/definitions/handler.response"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
                             "$ref": "#/definitions/handler.response"
                         }
                     },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "415": {
+                        "description": "Unsupported Media Type",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -261,9 +1330,19 @@ const docTemplate = `{
                         }
                     }
                 }
-            },
-            "delete": {
-                "description": "Delete a package by ID",
+            }
+        },
+        "/packages/{id}/events": {
+            "post": {
+                "security": [
+                    {
+                        "APIKey": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Append a tracking event to a package and derive its current status. Retries with the same\nIdempotency-Key receive the original response instead of adding the event again.",
                 "consumes": [
                     "application/json"
                 ],
@@ -273,7 +1352,7 @@ const docTemplate = `{
                 "tags": [
                     "packages"
                 ],
-                "summary": "Delete a package",
+                "summary": "Add a tracking event",
                 "parameters": [
                     {
                         "type": "string",
@@ -281,11 +1360,35 @@ const docTemplate = `{
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key that makes the request safe to retry",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    },
+                    {
+                        "description": "Event details",
+                        "name": "event",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/domain.Event"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tenant to use; defaults to the caller's tenant, or default for platform credentials",
+                        "name": "X-Tenant-ID",
+                        "in": "header"
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content"
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
                     },
                     "400": {
                         "description": "Bad Request",
@@ -293,12 +1396,36 @@ const docTemplate = `{
                             "$ref": "#/definitions/handler.response"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
                     "404": {
                         "description": "Not Found",
                         "schema": {
                             "$ref": "#/definitions/handler.response"
                         }
                     },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/handler.response"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -347,12 +1474,19 @@ const docTemplate = `{
                 "destination": {
                     "type": "string"
                 },
+                "eventCount": {
+                    "type": "integer"
+                },
                 "events": {
+                    "description": "Events holds the full tracking history. It is stored separately through\nEventRepository and only populated when a single package is loaded.",
                     "type": "array",
                     "items": {
                         "$ref": "#/definitions/domain.Event"
                     }
                 },
+                "latestEvent": {
+                    "$ref": "#/definitions/domain.Event"
+                },
                 "origin": {
                     "type": "string"
                 },
@@ -365,8 +1499,74 @@ const docTemplate = `{
                 "sender": {
                     "$ref": "#/definitions/domain.Address"
                 },
+                "tenantId": {
+                    "description": "TenantID is the owner of the package. It is set by the repository from\nthe request context; package IDs are unique per tenant.",
+                    "type": "string"
+                },
                 "updatedAt": {
                     "type": "string"
+                },
+                "version": {
+                    "description": "Version starts at 1 and is incremented by every write. It is used for\noptimistic concurrency control and as the package's ETag.",
+                    "type": "integer"
+                }
+            }
+        },
+        "domain.Role": {
+            "type": "string",
+            "enum": [
+                "viewer",
+                "operator",
+                "admin"
+            ],
+            "x-enum-varnames": [
+                "RoleViewer",
+                "RoleOperator",
+                "RoleAdmin"
+            ]
+        },
+        "handler.exemptRequest": {
+            "type": "object",
+            "required": [
+                "duration"
+            ],
+            "properties": {
+                "duration": {
+                    "description": "Duration is how long the exemption lasts, such as 30m or 2h",
+                    "type": "string",
+                    "example": "1h"
+                }
+            }
+        },
+        "handler.issueAPIKeyRequest": {
+            "type": "object",
+            "required": [
+                "name",
+                "role"
+            ],
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "plan": {
+                    "description": "Plan selects the key's rate limits and quotas. Keys without a plan get\nthe default plan.",
+                    "type": "string"
+                },
+                "role": {
+                    "enum": [
+                        "viewer",
+                        "operator",
+                        "admin"
+                    ],
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/domain.Role"
+                        }
+                    ]
+                },
+                "tenant": {
+                    "description": "Tenant binds the key to one tenant. Keys without a tenant are platform\nkeys.",
+                    "type": "string"
                 }
             }
         },
@@ -377,6 +1577,9 @@ const docTemplate = `{
                 "error": {
                     "type": "string"
                 },
+                "next_cursor": {
+                    "type": "string"
+                },
                 "page": {
                     "type": "integer"
                 },
@@ -391,6 +1594,19 @@ const docTemplate = `{
                 }
             }
         }
+    },
+    "securityDefinitions": {
+        "APIKey": {
+            "type": "apiKey",
+            "name": "X-API-Key",
+            "in": "header"
+        },
+        "BearerAuth": {
+            "description": "OIDC token as \"Bearer \u003ctoken\u003e\"",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
+        }
     }
 }`
 
@@ -0,0 +1,98 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	ErrAPIKeyRevoked  = errors.New("API key is revoked")
+	ErrUnauthorized   = errors.New("missing or invalid credentials")
+)
+
+// Role grants access to a set of routes. Each role includes the permissions
+// of the roles below it.
+type Role string
+
+const (
+	// RoleViewer may read packages
+	RoleViewer Role = "viewer"
+	// RoleOperator may also create, update and track packages
+	RoleOperator Role = "operator"
+	// RoleAdmin may also delete packages and manage API keys
+	RoleAdmin Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// Valid reports whether r is a known role
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Includes reports whether r grants the permissions of required
+func (r Role) Includes(required Role) bool {
+	return r.Valid() && roleRanks[r] >= roleRanks[required]
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	// ID identifies the caller, such as the ID of its API key
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+	// Tenant is the only tenant the caller may act for. Callers without a
+	// tenant may choose one per request.
+	Tenant string `json:"tenant,omitempty"`
+	// Plan selects the caller's rate limits and quotas
+	Plan string `json:"plan,omitempty"`
+}
+
+// APIKey is a credential for calling the API. Only a hash of the secret is
+// stored; the secret itself is returned once, when the key is issued or
+// rotated.
+type APIKey struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+	// Tenant restricts the key to one tenant. Keys without a tenant may act
+	// for any tenant.
+	Tenant string `json:"tenant,omitempty"`
+	// Plan selects the key's rate limits and quotas
+	Plan string `json:"plan,omitempty"`
+	// Prefix is the start of the secret, shown to help identify keys
+	Prefix    string     `json:"prefix"`
+	Hash      string     `json:"-"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RotatedAt *time.Time `json:"rotatedAt,omitempty"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"`
+}
+
+// Revoked reports whether the key can no longer be used
+func (k *APIKey) Revoked() bool {
+	return k.RevokedAt != nil
+}
+
+type APIKeyRepository interface {
+	// Create stores a new key. IDs and hashes are unique.
+	Create(ctx context.Context, key *APIKey) error
+	FindByID(ctx context.Context, id string) (*APIKey, error)
+	// FindByHash returns the key whose secret has the given hash
+	FindByHash(ctx context.Context, hash string) (*APIKey, error)
+	// FindAll returns every key, including revoked ones, oldest first
+	FindAll(ctx context.Context) ([]APIKey, error)
+	// Rotate replaces the hash and prefix of a key and sets its rotation
+	// time. It fails with ErrAPIKeyRevoked if the key is revoked, including
+	// when it was revoked after being read.
+	Rotate(ctx context.Context, key *APIKey) error
+	// Revoke sets the revocation time of a key. A revoked key keeps its
+	// original revocation time.
+	Revoke(ctx context.Context, id string, revokedAt time.Time) error
+}
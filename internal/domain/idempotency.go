@@ -0,0 +1,37 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// IdempotencyRecord is the stored outcome of a request sent with an
+// Idempotency-Key header
+type IdempotencyRecord struct {
+	Key string
+	// Fingerprint identifies the request the key was first used with
+	Fingerprint string
+	// Completed is false while the first request is still being processed
+	Completed bool
+	// LockedUntil is when the reservation of a pending record lapses. A
+	// request that has not completed by then is presumed lost, e.g. with a
+	// crashed replica, and a retry may take the key over.
+	LockedUntil time.Time
+	StatusCode  int
+	Header      map[string]string
+	Body        []byte
+	ExpiresAt   time.Time
+}
+
+// IdempotencyStore keeps request outcomes until they expire
+type IdempotencyStore interface {
+	// Reserve stores record as pending unless an unexpired record exists for
+	// its key, in which case the existing record is returned instead. Pending
+	// records whose reservation has lapsed are replaced.
+	Reserve(ctx context.Context, record *IdempotencyRecord) (*IdempotencyRecord, error)
+	// Complete stores the response of a reserved key
+	Complete(ctx context.Context, record *IdempotencyRecord) error
+	// Release removes the pending reservation of record so that the request
+	// can be retried, unless another request has taken the key over since
+	Release(ctx context.Context, record *IdempotencyRecord) error
+}
@@ -0,0 +1,147 @@
+package domain
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrInvalidSort   = errors.New("invalid sort")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// SortField is a package field results can be ordered by. Values match the
+// JSON and BSON field names.
+type SortField string
+
+const (
+	SortCreatedAt     SortField = "createdAt"
+	SortUpdatedAt     SortField = "updatedAt"
+	SortPackageID     SortField = "packageId"
+	SortOrigin        SortField = "origin"
+	SortDestination   SortField = "destination"
+	SortCurrentStatus SortField = "currentStatus"
+)
+
+// SortFields lists the fields clients may sort by
+var SortFields = []SortField{
+	SortCreatedAt, SortUpdatedAt, SortPackageID, SortOrigin, SortDestination, SortCurrentStatus,
+}
+
+// IsTime reports whether the field holds a timestamp rather than a string
+func (f SortField) IsTime() bool {
+	return f == SortCreatedAt || f == SortUpdatedAt
+}
+
+// Value returns the package's value for the field, a time.Time for
+// timestamps and a string otherwise
+func (f SortField) Value(pkg *Package) interface{} {
+	switch f {
+	case SortCreatedAt:
+		return pkg.CreatedAt
+	case SortUpdatedAt:
+		return pkg.UpdatedAt
+	case SortPackageID:
+		return pkg.PackageID
+	case SortOrigin:
+		return pkg.Origin
+	case SortDestination:
+		return pkg.Destination
+	case SortCurrentStatus:
+		return pkg.CurrentStatus
+	}
+	return nil
+}
+
+type SortOrder struct {
+	Field      SortField
+	Descending bool
+}
+
+// DefaultSort orders packages newest first. The package ID breaks ties so
+// that every ordering is total.
+var DefaultSort = []SortOrder{
+	{Field: SortCreatedAt, Descending: true},
+	{Field: SortPackageID},
+}
+
+// ListOptions selects a page of FindAll or Search results
+type ListOptions struct {
+	Page int
+	Size int
+	// Sort orders the results. Repositories use DefaultSort when it is empty.
+	Sort []SortOrder
+	// After holds one value per Sort entry. When set, results start after
+	// that position and Page is ignored.
+	After []interface{}
+}
+
+// PageRequest is a client's request for a page of packages. Sort and Cursor
+// are the raw sort and cursor query parameters.
+type PageRequest struct {
+	Page   int
+	Size   int
+	Sort   string
+	Cursor string
+}
+
+// PackagePage is a page of packages. NextCursor continues after the last
+// package and is empty when there are no more results.
+type PackagePage struct {
+	Packages   []Package
+	Total      int64
+	Page       int
+	Size       int
+	NextCursor string
+}
+
+// compareValues orders two sort values of the same field
+func compareValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case time.Time:
+		b, _ := b.(time.Time)
+		return a.Compare(b)
+	case string:
+		b, _ := b.(string)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	}
+	return 0
+}
+
+// ComparePackages orders two packages by sort. It returns a negative number
+// when a comes first, a positive number when b does and zero on ties.
+func ComparePackages(sort []SortOrder, a, b *Package) int {
+	return CompareKey(sort, a, SortKey(sort, b))
+}
+
+// CompareKey orders a package against a position produced by SortKey or
+// held in ListOptions.After
+func CompareKey(sort []SortOrder, pkg *Package, key []interface{}) int {
+	for i, order := range sort {
+		if i >= len(key) {
+			break
+		}
+		c := compareValues(order.Field.Value(pkg), key[i])
+		if order.Descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// SortKey returns the package's position in the given order
+func SortKey(sort []SortOrder, pkg *Package) []interface{} {
+	key := make([]interface{}, len(sort))
+	for i, order := range sort {
+		key[i] = order.Field.Value(pkg)
+	}
+	return key
+}
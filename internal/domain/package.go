@@ -1,6 +1,20 @@
 package domain
 
-import "time"
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var (
+	ErrPackageNotFound = errors.New("package not found")
+	ErrPackageExists   = errors.New("package already exists")
+	ErrInvalidSearch   = errors.New("invalid search query")
+	ErrInvalidFilter   = errors.New("invalid package filter")
+	ErrVersionConflict = errors.New("package was modified concurrently")
+)
 
 type Address struct {
 	Name    string `json:"name" bson:"name"`
@@ -14,6 +28,9 @@ type Event struct {
 }
 
 type Package struct {
+	// TenantID is the owner of the package. It is set by the repository from
+	// the request context; package IDs are unique per tenant.
+	TenantID      string    `json:"tenantId" bson:"tenantId"`
 	PackageID     string    `json:"packageId" bson:"packageId"`
 	Sender        Address   `json:"sender" bson:"sender"`
 	Recipient     Address   `json:"recipient" bson:"recipient"`
@@ -22,14 +39,122 @@ type Package struct {
 	CurrentStatus string    `json:"currentStatus" bson:"currentStatus"`
 	CreatedAt     time.Time `json:"createdAt" bson:"createdAt"`
 	UpdatedAt     time.Time `json:"updatedAt" bson:"updatedAt"`
-	Events        []Event   `json:"events,omitempty" bson:"events,omitempty"`
+	LatestEvent   *Event    `json:"latestEvent,omitempty" bson:"latestEvent,omitempty"`
+	EventCount    int       `json:"eventCount" bson:"eventCount"`
+	// Version starts at 1 and is incremented by every write. It is used for
+	// optimistic concurrency control and as the package's ETag.
+	Version int64 `json:"version" bson:"version"`
+	// Events holds the full tracking history. It is stored separately through
+	// EventRepository and only populated when a single package is loaded.
+	Events []Event `json:"events,omitempty" bson:"-"`
+}
+
+// SearchMode selects how a search query is matched against package fields
+type SearchMode string
+
+const (
+	// SearchLiteral matches the query as a case-insensitive substring
+	SearchLiteral SearchMode = "literal"
+	// SearchPrefix matches fields starting with the query. It is case
+	// sensitive so that it can use indexes.
+	SearchPrefix SearchMode = "prefix"
+	// SearchExact matches fields equal to the query
+	SearchExact SearchMode = "exact"
+	// SearchRegex matches the query as a case-insensitive regular expression
+	SearchRegex SearchMode = "regex"
+	// SearchText matches fields containing any of the query's words as whole
+	// words, ignoring case. It is served by the databases' text indexes.
+	SearchText SearchMode = "text"
+)
+
+type SearchQuery struct {
+	Text string
+	Mode SearchMode
 }
 
+// Words splits the query text into the lowercase words matched by text
+// searches. Anything but letters and digits separates words.
+func (q SearchQuery) Words() []string {
+	return strings.FieldsFunc(strings.ToLower(q.Text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// TimeRange bounds a timestamp. From is inclusive, To is exclusive and zero
+// values leave that side unbounded.
+type TimeRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// Contains reports whether t falls within the range
+func (r TimeRange) Contains(t time.Time) bool {
+	if !r.From.IsZero() && t.Before(r.From) {
+		return false
+	}
+	if !r.To.IsZero() && !t.Before(r.To) {
+		return false
+	}
+	return true
+}
+
+// PackageFilter restricts the packages returned by FindAll. Zero-valued
+// fields do not filter; string fields match exactly.
+type PackageFilter struct {
+	// Statuses matches packages in any of the given statuses
+	Statuses      []string
+	Origin        string
+	Destination   string
+	SenderName    string
+	RecipientName string
+	Created       TimeRange
+	Updated       TimeRange
+}
+
+// PackageUpdate holds the fields changed by a partial update. Nil fields keep
+// their stored value.
+type PackageUpdate struct {
+	SenderName       *string
+	SenderAddress    *string
+	RecipientName    *string
+	RecipientAddress *string
+	Origin           *string
+	Destination      *string
+	CurrentStatus    *string
+	// Version, when non-zero, makes the update conditional on the stored
+	// version
+	Version int64
+}
+
+// IsEmpty reports whether the update changes no fields
+func (u PackageUpdate) IsEmpty() bool {
+	return u == PackageUpdate{Version: u.Version}
+}
+
+// PackageRepository stores packages. Every operation is scoped to the tenant
+// of the context, see TenantFromContext.
 type PackageRepository interface {
-	FindByID(id string) (*Package, error)
-	FindAll(page, size int) ([]Package, int64, error)
-	Search(query string, page, size int) ([]Package, int64, error)
-	Create(pkg *Package) error
-	Update(pkg *Package) error
-	Delete(id string) error
+	FindByID(ctx context.Context, id string) (*Package, error)
+	FindAll(ctx context.Context, filter PackageFilter, opts ListOptions) ([]Package, int64, error)
+	Search(ctx context.Context, query SearchQuery, opts ListOptions) ([]Package, int64, error)
+	// Create stores a new package with version 1 for the context's tenant.
+	// It fails with ErrPackageExists when the tenant already uses the ID.
+	Create(ctx context.Context, pkg *Package) error
+	// Update replaces a package's details and increments its version.
+	// CreatedAt keeps its stored value. When pkg.Version is non-zero the
+	// update only applies to that version and fails with ErrVersionConflict
+	// otherwise. pkg.Version is set to the new version.
+	Update(ctx context.Context, pkg *Package) error
+	// Patch atomically sets the non-nil fields of update, increments the
+	// version and returns the updated package. Conditional like Update.
+	Patch(ctx context.Context, id string, update PackageUpdate) (*Package, error)
+	Delete(ctx context.Context, id string) error
+}
+
+// EventRepository stores tracking events, scoped to the tenant of the
+// context like PackageRepository
+type EventRepository interface {
+	Append(ctx context.Context, packageID string, events ...Event) error
+	FindByPackageID(ctx context.Context, packageID string) ([]Event, error)
+	DeleteByPackageID(ctx context.Context, packageID string) error
 }
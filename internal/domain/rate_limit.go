@@ -0,0 +1,116 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrRateLimitClientNotFound = errors.New("rate limit client not found")
+
+// RateLimitAlgorithm decides which requests a rate limit bucket allows
+type RateLimitAlgorithm string
+
+const (
+	// AlgorithmTokenBucket allows Burst requests at once, refilled at
+	// RequestsPerMinute
+	AlgorithmTokenBucket RateLimitAlgorithm = "token_bucket"
+	// AlgorithmSlidingWindow allows RequestsPerMinute requests in any minute.
+	// It keeps the time of every request of the last minute.
+	AlgorithmSlidingWindow RateLimitAlgorithm = "sliding_window"
+	// AlgorithmFixedWindow allows RequestsPerMinute requests per clock minute
+	AlgorithmFixedWindow RateLimitAlgorithm = "fixed_window"
+	// AlgorithmConcurrency allows Burst requests in flight at once, each
+	// holding a slot until it is released
+	AlgorithmConcurrency RateLimitAlgorithm = "concurrency"
+)
+
+// Valid reports whether a is a known algorithm. The empty algorithm is the
+// token bucket.
+func (a RateLimitAlgorithm) Valid() bool {
+	switch a {
+	case "", AlgorithmTokenBucket, AlgorithmSlidingWindow, AlgorithmFixedWindow, AlgorithmConcurrency:
+		return true
+	}
+	return false
+}
+
+// ConcurrencyRetryAfter is the Retry-After of requests rejected by a
+// concurrency limit, whose slots free up whenever a request completes
+const ConcurrencyRetryAfter = time.Second
+
+// RateLimit is the limit of a rate limit bucket. RequestsPerMinute and Burst
+// must be positive; what stores do with a limit of zero is not defined.
+type RateLimit struct {
+	Algorithm         RateLimitAlgorithm
+	RequestsPerMinute int
+	// Burst is the number of requests allowed at once
+	Burst int
+	// TTL is how long stores keep an idle bucket, unless they can tell when
+	// it has refilled. Slots of the concurrency algorithm that are never
+	// released expire after it as well.
+	TTL time.Duration
+}
+
+// RateLimitResult is the state of a bucket after a request
+type RateLimitResult struct {
+	Allowed bool
+	Limit   int
+	// Remaining is the number of requests that can still be made right away
+	Remaining int
+	// Reset is the time until the bucket is full again
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, zero when the
+	// request was allowed or the bucket never refills
+	RetryAfter time.Duration
+	// Lease identifies the slot of a request allowed by the concurrency
+	// algorithm, which must be released when the request completes
+	Lease string
+}
+
+// RateLimitStore keeps rate limit buckets, possibly shared between replicas,
+// and the identities exempted from rate limits
+type RateLimitStore interface {
+	// Take spends a token from the bucket with the given key for a request
+	// made at now. A rejected request spends nothing. The limit must be
+	// positive (see RateLimit).
+	Take(ctx context.Context, key string, limit RateLimit, now time.Time) (*RateLimitResult, error)
+	// Release frees the slot a concurrency limit leased to a request.
+	// Releasing an expired or unknown lease is a no-op.
+	Release(ctx context.Context, key string, limit RateLimit, lease string) error
+	// Peek returns the state of a bucket at now without spending anything. A
+	// bucket that does not exist is full.
+	Peek(ctx context.Context, key string, limit RateLimit, now time.Time) (*RateLimitResult, error)
+	// Reset fills a bucket, forgetting every request it counted
+	Reset(ctx context.Context, key string, limit RateLimit, now time.Time) error
+	// Exempt exempts an identity from rate limits until the given time, or
+	// removes its exemption if until is zero
+	Exempt(ctx context.Context, identity string, until time.Time) error
+	// Exemption returns when the exemption of an identity ends, or the zero
+	// time if it is not exempt at now
+	Exemption(ctx context.Context, identity string, now time.Time) (time.Time, error)
+}
+
+// RateLimitClient is a caller seen by the rate limiter
+type RateLimitClient struct {
+	// Identity is the caller as identified by the rate limiter, such as
+	// principal:<id> or ip:<address>
+	Identity string `json:"identity"`
+	// Allowed and Denied count the caller's requests while it is tracked
+	Allowed  int64     `json:"allowed"`
+	Denied   int64     `json:"denied"`
+	LastSeen time.Time `json:"lastSeen"`
+	// ExemptUntil is set while the caller is exempt from rate limits
+	ExemptUntil *time.Time        `json:"exemptUntil,omitempty"`
+	Buckets     []RateLimitBucket `json:"buckets,omitempty"`
+}
+
+// RateLimitBucket is the state of one of a caller's buckets
+type RateLimitBucket struct {
+	Endpoint  string             `json:"endpoint"`
+	Algorithm RateLimitAlgorithm `json:"algorithm"`
+	Limit     int                `json:"limit"`
+	Remaining int                `json:"remaining"`
+	// ResetSeconds is the number of seconds until the bucket is full again
+	ResetSeconds int `json:"resetSeconds"`
+}
@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"context"
+	"regexp"
+)
+
+// DefaultTenant owns packages of requests that name no tenant, including
+// every package stored before tenants were introduced
+const DefaultTenant = "default"
+
+// tenantPattern restricts tenant IDs to characters safe in keys and logs
+var tenantPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// ValidTenant reports whether tenant is a well-formed tenant ID
+func ValidTenant(tenant string) bool {
+	return tenantPattern.MatchString(tenant)
+}
+
+type tenantKey struct{}
+
+// WithTenant returns a context whose repository operations are scoped to
+// tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant set by WithTenant, or DefaultTenant.
+// Repositories scope every package and event operation to this tenant.
+func TenantFromContext(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok && tenant != "" {
+		return tenant
+	}
+	return DefaultTenant
+}
@@ -0,0 +1,58 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// Layouts of the daily and monthly usage periods
+const (
+	DailyPeriodLayout   = "2006-01-02"
+	MonthlyPeriodLayout = "2006-01"
+)
+
+// DailyPeriod returns the UTC day containing t
+func DailyPeriod(t time.Time) string {
+	return t.UTC().Format(DailyPeriodLayout)
+}
+
+// MonthlyPeriod returns the UTC month containing t
+func MonthlyPeriod(t time.Time) string {
+	return t.UTC().Format(MonthlyPeriodLayout)
+}
+
+// MonthEnd returns the start of the UTC month after the one containing t
+func MonthEnd(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Usage is the number of requests a rate limit identity made in a period
+type Usage struct {
+	// Identity is the caller as identified by the rate limiter, such as
+	// principal:<id> or ip:<address>
+	Identity string `json:"identity"`
+	// Period is a day (2006-01-02) or a month (2006-01)
+	Period string `json:"period"`
+	Count  int64  `json:"count"`
+}
+
+// UsagePage is a page of the usage of a period, highest count first
+type UsagePage struct {
+	Usage []Usage
+	Total int64
+	Page  int
+	Size  int
+}
+
+// UsageRepository persists request counts for quotas
+type UsageRepository interface {
+	// Increment adds a request to the identity's count in each period and
+	// returns the new counts in the order of periods. The counters are kept
+	// at least until expiresAt, after which they may be deleted.
+	Increment(ctx context.Context, identity string, expiresAt time.Time, periods ...string) ([]int64, error)
+	// FindByPeriod returns a page of the usage of every identity in a
+	// period, highest count first, and the number of identities. Only the
+	// Page and Size of opts are used.
+	FindByPeriod(ctx context.Context, period string, opts ListOptions) ([]Usage, int64, error)
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/snavarro/microtracker/internal/service"
+)
+
+type APIKeyService interface {
+	IssueAPIKey(ctx context.Context, name string, role domain.Role, tenant, plan string) (*service.IssuedAPIKey, error)
+	ListAPIKeys(ctx context.Context) ([]domain.APIKey, error)
+	RotateAPIKey(ctx context.Context, id string) (*service.IssuedAPIKey, error)
+	RevokeAPIKey(ctx context.Context, id string) (*domain.APIKey, error)
+}
+
+type APIKeyHandler struct {
+	service APIKeyService
+}
+
+func NewAPIKeyHandler(service APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{
+		service: service,
+	}
+}
+
+// issueAPIKeyRequest is the body of an API key issue request
+type issueAPIKeyRequest struct {
+	Name string      `json:"name" binding:"required"`
+	Role domain.Role `json:"role" binding:"required" enums:"viewer,operator,admin"`
+	// Tenant binds the key to one tenant. Keys without a tenant are platform
+	// keys.
+	Tenant string `json:"tenant,omitempty"`
+	// Plan selects the key's rate limits and quotas. Keys without a plan get
+	// the default plan.
+	Plan string `json:"plan,omitempty"`
+}
+
+// @Summary List API keys
+// @Description List all API keys, including revoked ones. Secrets are never returned.
+// @Tags api-keys
+// @Produce json
+// @Security APIKey
+// @Security BearerAuth
+// @Success 200 {object} response
+// @Failure 401 {object} response
+// @Failure 403 {object} response
+// @Failure 500 {object} response
+// @Router /admin/api-keys [get]
+func (h *APIKeyHandler) ListAPIKeys(c *gin.Context) {
+	keys, err := h.service.ListAPIKeys(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response{Error: err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, response{Data: keys, Total: int64(len(keys)), Success: true})
+}
+
+// @Summary Issue an API key
+// @Description Issue a key with the viewer, operator or admin role, optionally bound to a tenant and
+// @Description assigned a rate limit plan. The secret is only returned in this response.
+// @Tags api-keys
+// @Accept json
+// @Produce json
+// @Security APIKey
+// @Security BearerAuth
+// @Param key body issueAPIKeyRequest true "Key name, role, tenant and plan"
+// @Success 201 {object} response
+// @Failure 400 {object} response
+// @Failure 401 {object} response
+// @Failure 403 {object} response
+// @Failure 500 {object} response
+// @Router /admin/api-keys [post]
+func (h *APIKeyHandler) IssueAPIKey(c *gin.Context) {
+	var req issueAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: "Invalid request body", Success: false})
+		return
+	}
+
+	issued, err := h.service.IssueAPIKey(c.Request.Context(), req.Name, req.Role, req.Tenant, req.Plan)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrInvalidAPIKey) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, response{Error: err.Error(), Success: false})
+		return
+	}
+
+	c.JSON(http.StatusCreated, response{Data: issued, Success: true})
+}
+
+// @Summary Rotate an API key
+// @Description Replace the secret of a key. The previous secret stops working immediately and the new one
+// @Description is only returned in this response.
+// @Tags api-keys
+// @Produce json
+// @Security APIKey
+// @Security BearerAuth
+// @Param id path string true "API key ID"
+// @Success 200 {object} response
+// @Failure 401 {object} response
+// @Failure 403 {object} response
+// @Failure 404 {object} response
+// @Failure 409 {object} response
+// @Failure 500 {object} response
+// @Router /admin/api-keys/{id}/rotate [post]
+func (h *APIKeyHandler) RotateAPIKey(c *gin.Context) {
+	issued, err := h.service.RotateAPIKey(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(apiKeyErrorStatus(err), response{Error: err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, response{Data: issued, Success: true})
+}
+
+// @Summary Revoke an API key
+// @Description Permanently disable a key. Revoked keys stay listed.
+// @Tags api-keys
+// @Produce json
+// @Security APIKey
+// @Security BearerAuth
+// @Param id path string true "API key ID"
+// @Success 200 {object} response
+// @Failure 401 {object} response
+// @Failure 403 {object} response
+// @Failure 404 {object} response
+// @Failure 500 {object} response
+// @Router /admin/api-keys/{id} [delete]
+func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	key, err := h.service.RevokeAPIKey(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		c.JSON(apiKeyErrorStatus(err), response{Error: err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, response{Data: key, Success: true})
+}
+
+func apiKeyErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, domain.ErrAPIKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, domain.ErrAPIKeyRevoked):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
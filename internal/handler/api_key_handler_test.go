@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/snavarro/microtracker/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockAPIKeyService is a mock implementation of APIKeyService
+type MockAPIKeyService struct {
+	mock.Mock
+}
+
+func (m *MockAPIKeyService) IssueAPIKey(ctx context.Context, name string, role domain.Role, tenant, plan string) (*service.IssuedAPIKey, error) {
+	args := m.Called(ctx, name, role, tenant, plan)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.IssuedAPIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) ListAPIKeys(ctx context.Context) ([]domain.APIKey, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]domain.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) RotateAPIKey(ctx context.Context, id string) (*service.IssuedAPIKey, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*service.IssuedAPIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) RevokeAPIKey(ctx context.Context, id string) (*domain.APIKey, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.APIKey), args.Error(1)
+}
+
+func setupAPIKeyRouter(handler *APIKeyHandler) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	keys := router.Group("/api/v1/admin/api-keys")
+	{
+		keys.GET("", handler.ListAPIKeys)
+		keys.POST("", handler.IssueAPIKey)
+		keys.POST("/:id/rotate", handler.RotateAPIKey)
+		keys.DELETE("/:id", handler.RevokeAPIKey)
+	}
+	return router
+}
+
+func TestAPIKeyHandler_IssueAPIKey(t *testing.T) {
+	mockService := new(MockAPIKeyService)
+	router := setupAPIKeyRouter(NewAPIKeyHandler(mockService))
+
+	t.Run("returns the secret once", func(t *testing.T) {
+		issued := &service.IssuedAPIKey{
+			APIKey: domain.APIKey{ID: "k1", Name: "ci", Role: domain.RoleOperator, Prefix: "mt_abcdefgh", Hash: "hash", CreatedAt: time.Now()},
+			Secret: "mt_abcdefghsecret",
+		}
+		mockService.On("IssueAPIKey", mock.Anything, "ci", domain.RoleOperator, "acme", "pro").Return(issued, nil)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/admin/api-keys", bytes.NewBufferString(`{"name":"ci","role":"operator","tenant":"acme","plan":"pro"}`))
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var body struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, "k1", body.Data["id"])
+		assert.Equal(t, "mt_abcdefghsecret", body.Data["secret"])
+		assert.NotContains(t, body.Data, "hash")
+	})
+
+	t.Run("invalid role", func(t *testing.T) {
+		mockService.On("IssueAPIKey", mock.Anything, "ci", domain.Role("root"), "", "").Return(nil, service.ErrInvalidAPIKey)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/admin/api-keys", bytes.NewBufferString(`{"name":"ci","role":"root"}`))
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("missing fields", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/admin/api-keys", bytes.NewBufferString(`{"name":"ci"}`))
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestAPIKeyHandler_RotateAndRevoke(t *testing.T) {
+	mockService := new(MockAPIKeyService)
+	router := setupAPIKeyRouter(NewAPIKeyHandler(mockService))
+
+	mockService.On("RotateAPIKey", mock.Anything, "missing").Return(nil, domain.ErrAPIKeyNotFound)
+	mockService.On("RotateAPIKey", mock.Anything, "revoked").Return(nil, domain.ErrAPIKeyRevoked)
+	mockService.On("RevokeAPIKey", mock.Anything, "k1").Return(&domain.APIKey{ID: "k1"}, nil)
+
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{http.MethodPost, "/api/v1/admin/api-keys/missing/rotate", http.StatusNotFound},
+		{http.MethodPost, "/api/v1/admin/api-keys/revoked/rotate", http.StatusConflict},
+		{http.MethodDelete, "/api/v1/admin/api-keys/k1", http.StatusOK},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(tt.method, tt.path, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, tt.want, w.Code, "%s %s", tt.method, tt.path)
+	}
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+// errPreconditionFailed is returned for If-Match headers that cannot match
+// any version
+var errPreconditionFailed = errors.New("If-Match must be a single entity tag returned by the API or *")
+
+// etag is the strong entity tag of a package version
+func etag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+func setETag(c *gin.Context, pkg *domain.Package) {
+	c.Header("ETag", etag(pkg.Version))
+}
+
+// ifMatch reads the version required by the If-Match header. It returns zero
+// when the header is missing or *, which any existing package matches.
+func ifMatch(c *gin.Context) (int64, error) {
+	value := strings.TrimSpace(c.GetHeader("If-Match"))
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+	// If-Match uses strong comparison, so weak tags never match
+	unquoted, err := strconv.Unquote(value)
+	if err != nil || !strings.HasPrefix(value, `"`) {
+		return 0, errPreconditionFailed
+	}
+	version, err := strconv.ParseInt(unquoted, 10, 64)
+	if err != nil || version <= 0 {
+		return 0, errPreconditionFailed
+	}
+	return version, nil
+}
+
+// ifNoneMatch reports whether the If-None-Match header matches tag. Entity
+// tags are compared weakly as required for GET requests.
+func ifNoneMatch(c *gin.Context, tag string) bool {
+	header := c.GetHeader("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// conflictStatus maps a version conflict to 412 when the expected version
+// came from If-Match and to 409 otherwise
+func conflictStatus(precondition bool) int {
+	if precondition {
+		return http.StatusPreconditionFailed
+	}
+	return http.StatusConflict
+}
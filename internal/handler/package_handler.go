@@ -1,32 +1,38 @@
 package handler
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/snavarro/microtracker/internal/domain"
-	"github.com/snavarro/microtracker/internal/repository/mongo"
 	"github.com/snavarro/microtracker/internal/service"
 )
 
 type response struct {
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
-	Total   int64       `json:"total,omitempty"`
-	Page    int         `json:"page,omitempty"`
-	Size    int         `json:"size,omitempty"`
-	Success bool        `json:"success"`
+	Data       interface{} `json:"data,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Total      int64       `json:"total,omitempty"`
+	Page       int         `json:"page,omitempty"`
+	Size       int         `json:"size,omitempty"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	Success    bool        `json:"success"`
 }
 
 type PackageService interface {
-	GetPackage(id string) (*domain.Package, error)
-	ListPackages(page, size int) ([]domain.Package, int64, error)
-	SearchPackages(query string, page, size int) ([]domain.Package, int64, error)
-	CreatePackage(pkg *domain.Package) error
-	UpdatePackage(pkg *domain.Package) error
-	DeletePackage(id string) error
+	GetPackage(ctx context.Context, id string) (*domain.Package, error)
+	ListPackages(ctx context.Context, filter domain.PackageFilter, req domain.PageRequest) (*domain.PackagePage, error)
+	SearchPackages(ctx context.Context, query domain.SearchQuery, req domain.PageRequest) (*domain.PackagePage, error)
+	CreatePackage(ctx context.Context, pkg *domain.Package) error
+	UpdatePackage(ctx context.Context, pkg *domain.Package) error
+	PatchPackage(ctx context.Context, id string, version int64, format service.PatchFormat, patch []byte) (*domain.Package, error)
+	DeletePackage(ctx context.Context, id string) error
+	AddEvent(ctx context.Context, id string, event *domain.Event) (*domain.Package, error)
 }
 
 type PackageHandler struct {
@@ -40,100 +46,155 @@ func NewPackageHandler(service PackageService) *PackageHandler {
 }
 
 // @Summary Get a package by ID
-// @Description Get package details by package ID
+// @Description Get package details by package ID. The ETag header holds the package version; requests with a
+// @Description matching If-None-Match receive 304 Not Modified.
 // @Tags packages
 // @Accept json
 // @Produce json
+// @Security APIKey
+// @Security BearerAuth
 // @Param id path string true "Package ID"
+// @Param If-None-Match header string false "ETag from a previous response"
+// @Param X-Tenant-ID header string false "Tenant to use; defaults to the caller's tenant, or default for platform credentials"
 // @Success 200 {object} response
+// @Success 304
 // @Failure 400 {object} response
+// @Failure 401 {object} response
+// @Failure 403 {object} response
 // @Failure 404 {object} response
 // @Failure 500 {object} response
 // @Router /packages/{id} [get]
 func (h *PackageHandler) GetPackage(c *gin.Context) {
 	id := c.Param("id")
-	pkg, err := h.service.GetPackage(id)
+	pkg, err := h.service.GetPackage(c.Request.Context(), id)
 	if err != nil {
 		status := http.StatusInternalServerError
 		if errors.Is(err, service.ErrEmptyPackageID) {
 			status = http.StatusBadRequest
-		} else if errors.Is(err, mongo.ErrPackageNotFound) {
+		} else if errors.Is(err, domain.ErrPackageNotFound) {
 			status = http.StatusNotFound
 		}
 		c.JSON(status, response{Error: err.Error(), Success: false})
 		return
 	}
+
+	setETag(c, pkg)
+	if ifNoneMatch(c, etag(pkg.Version)) {
+		c.Status(http.StatusNotModified)
+		return
+	}
 	c.JSON(http.StatusOK, response{Data: pkg, Success: true})
 }
 
 // @Summary List all packages
-// @Description Get a paginated list of all packages
+// @Description Get a paginated list of packages, optionally filtered. Text filters match exactly.
+// @Description Dates are RFC 3339 timestamps or YYYY-MM-DD dates (UTC midnight); From bounds are
+// @Description inclusive and To bounds exclusive.
 // @Tags packages
 // @Accept json
 // @Produce json
+// @Security APIKey
+// @Security BearerAuth
+// @Param status query []string false "Current status, repeated or comma separated" collectionFormat(multi)
+// @Param origin query string false "Origin"
+// @Param destination query string false "Destination"
+// @Param senderName query string false "Sender name"
+// @Param recipientName query string false "Recipient name"
+// @Param createdFrom query string false "Created at or after"
+// @Param createdTo query string false "Created before"
+// @Param updatedFrom query string false "Updated at or after"
+// @Param updatedTo query string false "Updated before"
+// @Param sort query string false "Comma separated sort fields, prefixed with - for descending: createdAt, updatedAt, packageId, origin, destination, currentStatus" default(-createdAt)
+// @Param cursor query string false "next_cursor from the previous page; continues after it and ignores page"
 // @Param page query int false "Page number" default(1)
 // @Param size query int false "Page size" default(10)
+// @Param X-Tenant-ID header string false "Tenant to use; defaults to the caller's tenant, or default for platform credentials"
 // @Success 200 {object} response
+// @Failure 400 {object} response
+// @Failure 401 {object} response
+// @Failure 403 {object} response
 // @Failure 500 {object} response
 // @Router /packages [get]
 func (h *PackageHandler) ListPackages(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	size, _ := strconv.Atoi(c.DefaultQuery("size", "10"))
+	filter, err := parsePackageFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: err.Error(), Success: false})
+		return
+	}
 
-	packages, total, err := h.service.ListPackages(page, size)
+	page, err := h.service.ListPackages(c.Request.Context(), filter, pageRequest(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, response{Error: err.Error(), Success: false})
+		status := http.StatusInternalServerError
+		if errors.Is(err, domain.ErrInvalidFilter) || errors.Is(err, service.ErrInvalidStatus) || isPagingError(err) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, response{Error: err.Error(), Success: false})
 		return
 	}
 
-	c.JSON(http.StatusOK, response{
-		Data:    packages,
-		Total:   total,
-		Page:    page,
-		Size:    size,
-		Success: true,
-	})
+	c.JSON(http.StatusOK, pageResponse(page))
 }
 
 // @Summary Search packages
-// @Description Search packages with pagination
+// @Description Search package ID, sender and recipient names, origin, destination and status with pagination.
+// @Description literal matches a case-insensitive substring, prefix a case-sensitive prefix, exact the whole
+// @Description value, regex a case-insensitive regular expression without quantifiers or alternations inside
+// @Description repeated groups and with at most four quantifiers, and text any of
+// @Description the query's words as whole words using the text index.
 // @Tags packages
 // @Accept json
 // @Produce json
+// @Security APIKey
+// @Security BearerAuth
 // @Param query query string true "Search query"
+// @Param mode query string false "Search mode" Enums(literal, prefix, exact, regex, text) default(literal)
+// @Param sort query string false "Comma separated sort fields, prefixed with - for descending: createdAt, updatedAt, packageId, origin, destination, currentStatus" default(-createdAt)
+// @Param cursor query string false "next_cursor from the previous page; continues after it and ignores page"
 // @Param page query int false "Page number" default(1)
 // @Param size query int false "Page size" default(10)
+// @Param X-Tenant-ID header string false "Tenant to use; defaults to the caller's tenant, or default for platform credentials"
 // @Success 200 {object} response
+// @Failure 400 {object} response
+// @Failure 401 {object} response
+// @Failure 403 {object} response
 // @Failure 500 {object} response
 // @Router /packages/search [get]
 func (h *PackageHandler) SearchPackages(c *gin.Context) {
-	query := c.Query("query")
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	size, _ := strconv.Atoi(c.DefaultQuery("size", "10"))
+	query := domain.SearchQuery{
+		Text: c.Query("query"),
+		Mode: domain.SearchMode(c.DefaultQuery("mode", string(domain.SearchLiteral))),
+	}
 
-	packages, total, err := h.service.SearchPackages(query, page, size)
+	page, err := h.service.SearchPackages(c.Request.Context(), query, pageRequest(c))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, response{Error: err.Error(), Success: false})
+		status := http.StatusInternalServerError
+		if errors.Is(err, domain.ErrInvalidSearch) || isPagingError(err) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, response{Error: err.Error(), Success: false})
 		return
 	}
 
-	c.JSON(http.StatusOK, response{
-		Data:    packages,
-		Total:   total,
-		Page:    page,
-		Size:    size,
-		Success: true,
-	})
+	c.JSON(http.StatusOK, pageResponse(page))
 }
 
 // @Summary Create a new package
-// @Description Create a new package
+// @Description Create a new package. Events cannot be sent with it; they are added through
+// @Description POST /packages/{id}/events. Retries with the same Idempotency-Key receive the original response.
 // @Tags packages
 // @Accept json
 // @Produce json
+// @Security APIKey
+// @Security BearerAuth
+// @Param Idempotency-Key header string false "Unique key that makes the request safe to retry"
 // @Param package body domain.Package true "Package details"
+// @Param X-Tenant-ID header string false "Tenant to use; defaults to the caller's tenant, or default for platform credentials"
 // @Success 201 {object} response
 // @Failure 400 {object} response
+// @Failure 401 {object} response
+// @Failure 403 {object} response
+// @Failure 409 {object} response
+// @Failure 422 {object} response
 // @Failure 500 {object} response
 // @Router /packages [post]
 func (h *PackageHandler) CreatePackage(c *gin.Context) {
@@ -143,28 +204,43 @@ func (h *PackageHandler) CreatePackage(c *gin.Context) {
 		return
 	}
 
-	if err := h.service.CreatePackage(&pkg); err != nil {
+	if err := h.service.CreatePackage(c.Request.Context(), &pkg); err != nil {
 		status := http.StatusInternalServerError
-		if errors.Is(err, service.ErrInvalidPackage) || errors.Is(err, service.ErrEmptyPackageID) {
+		if errors.Is(err, service.ErrInvalidPackage) || errors.Is(err, service.ErrEmptyPackageID) ||
+			errors.Is(err, service.ErrInvalidStatus) {
 			status = http.StatusBadRequest
+		} else if errors.Is(err, domain.ErrPackageExists) {
+			status = http.StatusConflict
 		}
 		c.JSON(status, response{Error: err.Error(), Success: false})
 		return
 	}
 
+	setETag(c, &pkg)
 	c.JSON(http.StatusCreated, response{Data: pkg, Success: true})
 }
 
 // @Summary Update a package
-// @Description Update an existing package
+// @Description Update an existing package. The update only applies to the version given by If-Match, or by
+// @Description the version field of the body when the header is missing. A stale If-Match fails with 412 and
+// @Description a stale body version or a concurrent write with 409. The current status must be the stored
+// @Description status, as it only changes by adding events.
 // @Tags packages
 // @Accept json
 // @Produce json
+// @Security APIKey
+// @Security BearerAuth
 // @Param id path string true "Package ID"
+// @Param If-Match header string false "ETag of the version being replaced"
 // @Param package body domain.Package true "Package details"
+// @Param X-Tenant-ID header string false "Tenant to use; defaults to the caller's tenant, or default for platform credentials"
 // @Success 200 {object} response
 // @Failure 400 {object} response
+// @Failure 401 {object} response
+// @Failure 403 {object} response
 // @Failure 404 {object} response
+// @Failure 409 {object} response
+// @Failure 412 {object} response
 // @Failure 500 {object} response
 // @Router /packages/{id} [put]
 func (h *PackageHandler) UpdatePackage(c *gin.Context) {
@@ -175,18 +251,103 @@ func (h *PackageHandler) UpdatePackage(c *gin.Context) {
 		return
 	}
 
+	version, err := ifMatch(c)
+	if err != nil {
+		c.JSON(http.StatusPreconditionFailed, response{Error: err.Error(), Success: false})
+		return
+	}
+	precondition := version != 0
+	if precondition {
+		pkg.Version = version
+	}
+
 	pkg.PackageID = id
-	if err := h.service.UpdatePackage(&pkg); err != nil {
+	if err := h.service.UpdatePackage(c.Request.Context(), &pkg); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrInvalidPackage) || errors.Is(err, service.ErrEmptyPackageID) ||
+			errors.Is(err, service.ErrInvalidStatus) {
+			status = http.StatusBadRequest
+		} else if errors.Is(err, domain.ErrPackageNotFound) {
+			status = http.StatusNotFound
+		} else if errors.Is(err, domain.ErrVersionConflict) {
+			status = conflictStatus(precondition)
+		}
+		c.JSON(status, response{Error: err.Error(), Success: false})
+		return
+	}
+
+	setETag(c, &pkg)
+	c.JSON(http.StatusOK, response{Data: pkg, Success: true})
+}
+
+// patchFormats maps the supported PATCH content types to patch formats
+var patchFormats = map[string]service.PatchFormat{
+	"application/merge-patch+json": service.MergePatch,
+	"application/json-patch+json":  service.JSONPatch,
+}
+
+// @Summary Partially update a package
+// @Description Apply a JSON merge patch (RFC 7386) or JSON patch (RFC 6902) to a package. Only the sender,
+// @Description recipient, origin and destination can be changed; the current status only changes through
+// @Description events. The patched package must still be valid. With If-Match the patch only applies to that version and fails with 412
+// @Description otherwise; a concurrent write fails with 409.
+// @Tags packages
+// @Accept application/merge-patch+json,application/json-patch+json
+// @Produce json
+// @Security APIKey
+// @Security BearerAuth
+// @Param id path string true "Package ID"
+// @Param If-Match header string false "ETag of the version being patched"
+// @Param patch body object true "Patch document"
+// @Param X-Tenant-ID header string false "Tenant to use; defaults to the caller's tenant, or default for platform credentials"
+// @Success 200 {object} response
+// @Failure 400 {object} response
+// @Failure 401 {object} response
+// @Failure 403 {object} response
+// @Failure 404 {object} response
+// @Failure 409 {object} response
+// @Failure 412 {object} response
+// @Failure 415 {object} response
+// @Failure 500 {object} response
+// @Router /packages/{id} [patch]
+func (h *PackageHandler) PatchPackage(c *gin.Context) {
+	format, ok := patchFormats[c.ContentType()]
+	if !ok {
+		c.JSON(http.StatusUnsupportedMediaType, response{
+			Error:   "Content-Type must be application/merge-patch+json or application/json-patch+json",
+			Success: false,
+		})
+		return
+	}
+
+	version, err := ifMatch(c)
+	if err != nil {
+		c.JSON(http.StatusPreconditionFailed, response{Error: err.Error(), Success: false})
+		return
+	}
+
+	patch, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: "Invalid request body", Success: false})
+		return
+	}
+
+	pkg, err := h.service.PatchPackage(c.Request.Context(), c.Param("id"), version, format, patch)
+	if err != nil {
 		status := http.StatusInternalServerError
-		if errors.Is(err, service.ErrInvalidPackage) || errors.Is(err, service.ErrEmptyPackageID) {
+		if errors.Is(err, service.ErrInvalidPatch) || errors.Is(err, service.ErrInvalidPackage) ||
+			errors.Is(err, service.ErrEmptyPackageID) || errors.Is(err, service.ErrInvalidStatus) {
 			status = http.StatusBadRequest
-		} else if errors.Is(err, mongo.ErrPackageNotFound) {
+		} else if errors.Is(err, domain.ErrPackageNotFound) {
 			status = http.StatusNotFound
+		} else if errors.Is(err, domain.ErrVersionConflict) {
+			status = conflictStatus(version != 0)
 		}
 		c.JSON(status, response{Error: err.Error(), Success: false})
 		return
 	}
 
+	setETag(c, pkg)
 	c.JSON(http.StatusOK, response{Data: pkg, Success: true})
 }
 
@@ -195,19 +356,24 @@ func (h *PackageHandler) UpdatePackage(c *gin.Context) {
 // @Tags packages
 // @Accept json
 // @Produce json
+// @Security APIKey
+// @Security BearerAuth
 // @Param id path string true "Package ID"
+// @Param X-Tenant-ID header string false "Tenant to use; defaults to the caller's tenant, or default for platform credentials"
 // @Success 204
 // @Failure 400 {object} response
+// @Failure 401 {object} response
+// @Failure 403 {object} response
 // @Failure 404 {object} response
 // @Failure 500 {object} response
 // @Router /packages/{id} [delete]
 func (h *PackageHandler) DeletePackage(c *gin.Context) {
 	id := c.Param("id")
-	if err := h.service.DeletePackage(id); err != nil {
+	if err := h.service.DeletePackage(c.Request.Context(), id); err != nil {
 		status := http.StatusInternalServerError
 		if errors.Is(err, service.ErrEmptyPackageID) {
 			status = http.StatusBadRequest
-		} else if errors.Is(err, mongo.ErrPackageNotFound) {
+		} else if errors.Is(err, domain.ErrPackageNotFound) {
 			status = http.StatusNotFound
 		}
 		c.JSON(status, response{Error: err.Error(), Success: false})
@@ -215,3 +381,133 @@ func (h *PackageHandler) DeletePackage(c *gin.Context) {
 	}
 	c.Status(http.StatusNoContent)
 }
+
+// @Summary Add a tracking event
+// @Description Append a tracking event to a package and derive its current status. Retries with the same
+// @Description Idempotency-Key receive the original response instead of adding the event again.
+// @Tags packages
+// @Accept json
+// @Produce json
+// @Security APIKey
+// @Security BearerAuth
+// @Param id path string true "Package ID"
+// @Param Idempotency-Key header string false "Unique key that makes the request safe to retry"
+// @Param event body domain.Event true "Event details"
+// @Param X-Tenant-ID header string false "Tenant to use; defaults to the caller's tenant, or default for platform credentials"
+// @Success 201 {object} response
+// @Failure 400 {object} response
+// @Failure 401 {object} response
+// @Failure 403 {object} response
+// @Failure 404 {object} response
+// @Failure 409 {object} response
+// @Failure 422 {object} response
+// @Failure 500 {object} response
+// @Router /packages/{id}/events [post]
+func (h *PackageHandler) AddEvent(c *gin.Context) {
+	id := c.Param("id")
+	var event domain.Event
+	if err := c.ShouldBindJSON(&event); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: "Invalid request body", Success: false})
+		return
+	}
+
+	pkg, err := h.service.AddEvent(c.Request.Context(), id, &event)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrInvalidEvent) || errors.Is(err, service.ErrEmptyPackageID) ||
+			errors.Is(err, service.ErrInvalidStatus) {
+			status = http.StatusBadRequest
+		} else if errors.Is(err, domain.ErrPackageNotFound) {
+			status = http.StatusNotFound
+		} else if errors.Is(err, service.ErrInvalidTransition) || errors.Is(err, domain.ErrVersionConflict) {
+			status = http.StatusConflict
+		}
+		c.JSON(status, response{Error: err.Error(), Success: false})
+		return
+	}
+
+	setETag(c, pkg)
+	c.JSON(http.StatusCreated, response{Data: pkg, Success: true})
+}
+
+// pageRequest reads the pagination and sort parameters
+func pageRequest(c *gin.Context) domain.PageRequest {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	size, _ := strconv.Atoi(c.DefaultQuery("size", "10"))
+	return domain.PageRequest{
+		Page:   page,
+		Size:   size,
+		Sort:   c.Query("sort"),
+		Cursor: c.Query("cursor"),
+	}
+}
+
+func isPagingError(err error) bool {
+	return errors.Is(err, domain.ErrInvalidSort) || errors.Is(err, domain.ErrInvalidCursor)
+}
+
+func pageResponse(page *domain.PackagePage) response {
+	return response{
+		Data:       page.Packages,
+		Total:      page.Total,
+		Page:       page.Page,
+		Size:       page.Size,
+		NextCursor: page.NextCursor,
+		Success:    true,
+	}
+}
+
+// parsePackageFilter reads the list filters from the query string
+func parsePackageFilter(c *gin.Context) (domain.PackageFilter, error) {
+	filter := domain.PackageFilter{
+		Origin:        c.Query("origin"),
+		Destination:   c.Query("destination"),
+		SenderName:    c.Query("senderName"),
+		RecipientName: c.Query("recipientName"),
+	}
+
+	for _, value := range c.QueryArray("status") {
+		for _, status := range strings.Split(value, ",") {
+			if status = strings.TrimSpace(status); status != "" {
+				filter.Statuses = append(filter.Statuses, status)
+			}
+		}
+	}
+
+	var err error
+	if filter.Created, err = parseTimeRange(c, "created"); err != nil {
+		return filter, err
+	}
+	if filter.Updated, err = parseTimeRange(c, "updated"); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+func parseTimeRange(c *gin.Context, prefix string) (domain.TimeRange, error) {
+	var r domain.TimeRange
+	var err error
+	if r.From, err = parseFilterTime(c, prefix+"From"); err != nil {
+		return r, err
+	}
+	if r.To, err = parseFilterTime(c, prefix+"To"); err != nil {
+		return r, err
+	}
+	return r, nil
+}
+
+// parseFilterTime accepts RFC 3339 timestamps and YYYY-MM-DD dates, which
+// are read as midnight UTC. Missing parameters return the zero time.
+func parseFilterTime(c *gin.Context, param string) (time.Time, error) {
+	value := c.Query(param)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("%w: %s must be an RFC 3339 timestamp or YYYY-MM-DD date", domain.ErrInvalidFilter, param)
+}
@@ -2,7 +2,9 @@ package handler
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -20,39 +22,61 @@ type MockPackageService struct {
 	mock.Mock
 }
 
-func (m *MockPackageService) GetPackage(id string) (*domain.Package, error) {
-	args := m.Called(id)
+func (m *MockPackageService) GetPackage(ctx context.Context, id string) (*domain.Package, error) {
+	args := m.Called(ctx, id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*domain.Package), args.Error(1)
 }
 
-func (m *MockPackageService) ListPackages(page, size int) ([]domain.Package, int64, error) {
-	args := m.Called(page, size)
-	return args.Get(0).([]domain.Package), args.Get(1).(int64), args.Error(2)
+func (m *MockPackageService) ListPackages(ctx context.Context, filter domain.PackageFilter, req domain.PageRequest) (*domain.PackagePage, error) {
+	args := m.Called(ctx, filter, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.PackagePage), args.Error(1)
 }
 
-func (m *MockPackageService) SearchPackages(query string, page, size int) ([]domain.Package, int64, error) {
-	args := m.Called(query, page, size)
-	return args.Get(0).([]domain.Package), args.Get(1).(int64), args.Error(2)
+func (m *MockPackageService) SearchPackages(ctx context.Context, query domain.SearchQuery, req domain.PageRequest) (*domain.PackagePage, error) {
+	args := m.Called(ctx, query, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.PackagePage), args.Error(1)
 }
 
-func (m *MockPackageService) CreatePackage(pkg *domain.Package) error {
-	args := m.Called(pkg)
+func (m *MockPackageService) CreatePackage(ctx context.Context, pkg *domain.Package) error {
+	args := m.Called(ctx, pkg)
 	return args.Error(0)
 }
 
-func (m *MockPackageService) UpdatePackage(pkg *domain.Package) error {
-	args := m.Called(pkg)
+func (m *MockPackageService) UpdatePackage(ctx context.Context, pkg *domain.Package) error {
+	args := m.Called(ctx, pkg)
 	return args.Error(0)
 }
 
-func (m *MockPackageService) DeletePackage(id string) error {
-	args := m.Called(id)
+func (m *MockPackageService) PatchPackage(ctx context.Context, id string, version int64, format service.PatchFormat, patch []byte) (*domain.Package, error) {
+	args := m.Called(ctx, id, version, format, patch)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Package), args.Error(1)
+}
+
+func (m *MockPackageService) DeletePackage(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
 	return args.Error(0)
 }
 
+func (m *MockPackageService) AddEvent(ctx context.Context, id string, event *domain.Event) (*domain.Package, error) {
+	args := m.Called(ctx, id, event)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Package), args.Error(1)
+}
+
 func setupTestRouter(handler *PackageHandler) *gin.Engine {
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
@@ -65,7 +89,9 @@ func setupTestRouter(handler *PackageHandler) *gin.Engine {
 			packages.GET("/:id", handler.GetPackage)
 			packages.POST("", handler.CreatePackage)
 			packages.PUT("/:id", handler.UpdatePackage)
+			packages.PATCH("/:id", handler.PatchPackage)
 			packages.DELETE("/:id", handler.DeletePackage)
+			packages.POST("/:id/events", handler.AddEvent)
 		}
 	}
 	return router
@@ -87,7 +113,7 @@ func TestPackageHandler_GetPackage(t *testing.T) {
 			UpdatedAt: time.Now(),
 		}
 
-		mockService.On("GetPackage", "123").Return(expectedPkg, nil)
+		mockService.On("GetPackage", mock.Anything, "123").Return(expectedPkg, nil)
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/api/v1/packages/123", nil)
@@ -109,8 +135,41 @@ func TestPackageHandler_GetPackage(t *testing.T) {
 		assert.Equal(t, expectedPkg.Sender, actualPkg.Sender)
 	})
 
+	t.Run("ETag", func(t *testing.T) {
+		mockService.On("GetPackage", mock.Anything, "789").Return(&domain.Package{PackageID: "789", Version: 4}, nil)
+
+		tests := []struct {
+			name        string
+			ifNoneMatch string
+			wantStatus  int
+		}{
+			{"no condition", "", http.StatusOK},
+			{"current version", `"4"`, http.StatusNotModified},
+			{"weak tag", `W/"4"`, http.StatusNotModified},
+			{"list", `"3", "4"`, http.StatusNotModified},
+			{"wildcard", "*", http.StatusNotModified},
+			{"stale version", `"3"`, http.StatusOK},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", "/api/v1/packages/789", nil)
+				if tt.ifNoneMatch != "" {
+					req.Header.Set("If-None-Match", tt.ifNoneMatch)
+				}
+				router.ServeHTTP(w, req)
+
+				assert.Equal(t, tt.wantStatus, w.Code)
+				assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+				if tt.wantStatus == http.StatusNotModified {
+					assert.Empty(t, w.Body.String())
+				}
+			})
+		}
+	})
+
 	t.Run("not found", func(t *testing.T) {
-		mockService.On("GetPackage", "456").Return(nil, service.ErrEmptyPackageID)
+		mockService.On("GetPackage", mock.Anything, "456").Return(nil, service.ErrEmptyPackageID)
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/api/v1/packages/456", nil)
@@ -148,7 +207,8 @@ func TestPackageHandler_ListPackages(t *testing.T) {
 			},
 		}
 
-		mockService.On("ListPackages", 1, 10).Return(expectedPackages, int64(2), nil)
+		mockService.On("ListPackages", mock.Anything, domain.PackageFilter{}, domain.PageRequest{Page: 1, Size: 10}).
+			Return(&domain.PackagePage{Packages: expectedPackages, Total: 2, Page: 1, Size: 10}, nil)
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/api/v1/packages?page=1&size=10", nil)
@@ -175,6 +235,77 @@ func TestPackageHandler_ListPackages(t *testing.T) {
 			assert.Equal(t, expected.Sender, actualPackages[i].Sender)
 		}
 	})
+
+	t.Run("filters", func(t *testing.T) {
+		filter := domain.PackageFilter{
+			Statuses:   []string{"IN_TRANSIT", "DELIVERED", "CREATED"},
+			Origin:     "New York",
+			SenderName: "John Doe",
+			Created:    domain.TimeRange{From: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)},
+			Updated:    domain.TimeRange{To: time.Date(2024, 5, 8, 12, 30, 0, 0, time.UTC)},
+		}
+		mockService.On("ListPackages", mock.Anything, filter, domain.PageRequest{Page: 1, Size: 10}).
+			Return(&domain.PackagePage{Page: 1, Size: 10}, nil)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/packages?status=IN_TRANSIT,DELIVERED&status=CREATED"+
+			"&origin=New+York&senderName=John+Doe&createdFrom=2024-05-01&updatedTo=2024-05-08T12:30:00Z", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockService.AssertExpectations(t)
+	})
+
+	t.Run("malformed date", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/packages?createdFrom=last-week", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		var response response
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.False(t, response.Success)
+		assert.Contains(t, response.Error, "createdFrom")
+	})
+
+	t.Run("invalid status", func(t *testing.T) {
+		filter := domain.PackageFilter{Statuses: []string{"LOST"}}
+		mockService.On("ListPackages", mock.Anything, filter, domain.PageRequest{Page: 1, Size: 10}).Return(nil, service.ErrInvalidStatus)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/packages?status=LOST", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("cursor", func(t *testing.T) {
+		req := domain.PageRequest{Page: 1, Size: 2, Sort: "-updatedAt", Cursor: "abc"}
+		mockService.On("ListPackages", mock.Anything, domain.PackageFilter{}, req).
+			Return(&domain.PackagePage{Packages: []domain.Package{{PackageID: "123"}}, Total: 5, Page: 1, Size: 2, NextCursor: "def"}, nil)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/api/v1/packages?size=2&sort=-updatedAt&cursor=abc", nil)
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response response
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, "def", response.NextCursor)
+	})
+
+	t.Run("invalid cursor", func(t *testing.T) {
+		req := domain.PageRequest{Page: 1, Size: 10, Cursor: "bogus"}
+		mockService.On("ListPackages", mock.Anything, domain.PackageFilter{}, req).Return(nil, domain.ErrInvalidCursor)
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/api/v1/packages?cursor=bogus", nil)
+		router.ServeHTTP(w, r)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
 }
 
 func TestPackageHandler_SearchPackages(t *testing.T) {
@@ -193,7 +324,8 @@ func TestPackageHandler_SearchPackages(t *testing.T) {
 			},
 		}
 
-		mockService.On("SearchPackages", "John", 1, 10).Return(expectedPackages, int64(1), nil)
+		mockService.On("SearchPackages", mock.Anything, domain.SearchQuery{Text: "John", Mode: domain.SearchLiteral}, domain.PageRequest{Page: 1, Size: 10}).
+			Return(&domain.PackagePage{Packages: expectedPackages, Total: 1, Page: 1, Size: 10}, nil)
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("GET", "/api/v1/packages/search?query=John&page=1&size=10", nil)
@@ -220,6 +352,22 @@ func TestPackageHandler_SearchPackages(t *testing.T) {
 			assert.Equal(t, expected.Sender, actualPackages[i].Sender)
 		}
 	})
+
+	t.Run("invalid regex", func(t *testing.T) {
+		query := domain.SearchQuery{Text: "(a+)+", Mode: domain.SearchRegex}
+		mockService.On("SearchPackages", mock.Anything, query, domain.PageRequest{Page: 1, Size: 10}).Return(nil, domain.ErrInvalidSearch)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/v1/packages/search?query=%28a%2B%29%2B&mode=regex", nil)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		var response response
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.False(t, response.Success)
+		assert.Equal(t, domain.ErrInvalidSearch.Error(), response.Error)
+	})
 }
 
 func TestPackageHandler_CreatePackage(t *testing.T) {
@@ -243,7 +391,7 @@ func TestPackageHandler_CreatePackage(t *testing.T) {
 			CurrentStatus: "created",
 		}
 
-		mockService.On("CreatePackage", pkg).Return(nil)
+		mockService.On("CreatePackage", mock.Anything, pkg).Return(nil)
 
 		jsonData, _ := json.Marshal(pkg)
 		w := httptest.NewRecorder()
@@ -284,6 +432,24 @@ func TestPackageHandler_CreatePackage(t *testing.T) {
 		assert.False(t, response.Success)
 		assert.Equal(t, "Invalid request body", response.Error)
 	})
+
+	t.Run("duplicate package ID", func(t *testing.T) {
+		mockService.On("CreatePackage", mock.Anything, mock.MatchedBy(func(pkg *domain.Package) bool {
+			return pkg.PackageID == "DUP1"
+		})).Return(fmt.Errorf("%w: DUP1", domain.ErrPackageExists))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/packages", bytes.NewBufferString(`{"packageId":"DUP1"}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		var response response
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.False(t, response.Success)
+		assert.Equal(t, "package already exists: DUP1", response.Error)
+	})
 }
 
 func TestPackageHandler_UpdatePackage(t *testing.T) {
@@ -307,7 +473,7 @@ func TestPackageHandler_UpdatePackage(t *testing.T) {
 			CurrentStatus: "updated",
 		}
 
-		mockService.On("UpdatePackage", pkg).Return(nil)
+		mockService.On("UpdatePackage", mock.Anything, pkg).Return(nil)
 
 		jsonData, _ := json.Marshal(pkg)
 		w := httptest.NewRecorder()
@@ -335,12 +501,58 @@ func TestPackageHandler_UpdatePackage(t *testing.T) {
 		assert.Equal(t, pkg.CurrentStatus, actualPkg.CurrentStatus)
 	})
 
+	conflicts := []struct {
+		name        string
+		ifMatch     string
+		bodyVersion int64
+		wantVersion int64
+		wantStatus  int
+	}{
+		{"stale If-Match", `"2"`, 0, 2, http.StatusPreconditionFailed},
+		{"If-Match overrides body version", `"2"`, 7, 2, http.StatusPreconditionFailed},
+		{"stale body version", "", 2, 2, http.StatusConflict},
+		{"concurrent write", "*", 0, 0, http.StatusConflict},
+	}
+	for _, tt := range conflicts {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockPackageService)
+			router := setupTestRouter(NewPackageHandler(mockService))
+			mockService.On("UpdatePackage", mock.Anything, mock.MatchedBy(func(pkg *domain.Package) bool {
+				return pkg.Version == tt.wantVersion
+			})).Return(domain.ErrVersionConflict)
+
+			jsonData, _ := json.Marshal(domain.Package{Origin: "Boston", Version: tt.bodyVersion})
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("PUT", "/api/v1/packages/123", bytes.NewBuffer(jsonData))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.wantStatus, w.Code)
+			mockService.AssertExpectations(t)
+		})
+	}
+
+	t.Run("malformed If-Match", func(t *testing.T) {
+		for _, value := range []string{"2", `W/"2"`, `"abc"`, `"1", "2"`} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("PUT", "/api/v1/packages/123", bytes.NewBufferString(`{}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("If-Match", value)
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusPreconditionFailed, w.Code, value)
+		}
+	})
+
 	t.Run("not found", func(t *testing.T) {
 		pkg := &domain.Package{
 			PackageID: "456",
 		}
 
-		mockService.On("UpdatePackage", pkg).Return(service.ErrEmptyPackageID)
+		mockService.On("UpdatePackage", mock.Anything, pkg).Return(service.ErrEmptyPackageID)
 
 		jsonData, _ := json.Marshal(pkg)
 		w := httptest.NewRecorder()
@@ -357,13 +569,110 @@ func TestPackageHandler_UpdatePackage(t *testing.T) {
 	})
 }
 
+func TestPackageHandler_PatchPackage(t *testing.T) {
+	mockService := new(MockPackageService)
+	handler := NewPackageHandler(mockService)
+	router := setupTestRouter(handler)
+
+	t.Run("merge patch", func(t *testing.T) {
+		patch := []byte(`{"destination":"Seattle"}`)
+		mockService.On("PatchPackage", mock.Anything, "123", int64(0), service.MergePatch, patch).
+			Return(&domain.Package{PackageID: "123", Destination: "Seattle"}, nil)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/api/v1/packages/123", bytes.NewBuffer(patch))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response response
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.True(t, response.Success)
+	})
+
+	t.Run("JSON patch", func(t *testing.T) {
+		patch := []byte(`[{"op":"replace","path":"/origin","value":"Boston"}]`)
+		mockService.On("PatchPackage", mock.Anything, "123", int64(0), service.JSONPatch, patch).
+			Return(&domain.Package{PackageID: "123", Origin: "Boston"}, nil)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/api/v1/packages/123", bytes.NewBuffer(patch))
+		req.Header.Set("Content-Type", "application/json-patch+json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("unsupported content type", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/api/v1/packages/123", bytes.NewBufferString(`{"destination":"Seattle"}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	})
+
+	t.Run("invalid patch", func(t *testing.T) {
+		patch := []byte(`{"createdAt":null}`)
+		mockService.On("PatchPackage", mock.Anything, "123", int64(0), service.MergePatch, patch).Return(nil, service.ErrInvalidPatch)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/api/v1/packages/123", bytes.NewBuffer(patch))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		patch := []byte(`{}`)
+		mockService.On("PatchPackage", mock.Anything, "456", int64(0), service.MergePatch, patch).Return(nil, domain.ErrPackageNotFound)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/api/v1/packages/456", bytes.NewBuffer(patch))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("If-Match", func(t *testing.T) {
+		patch := []byte(`{"origin":"Denver"}`)
+		mockService.On("PatchPackage", mock.Anything, "123", int64(3), service.MergePatch, patch).
+			Return(&domain.Package{PackageID: "123", Origin: "Denver", Version: 4}, nil)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/api/v1/packages/123", bytes.NewBuffer(patch))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req.Header.Set("If-Match", `"3"`)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+	})
+
+	t.Run("stale If-Match", func(t *testing.T) {
+		patch := []byte(`{"origin":"Denver"}`)
+		mockService.On("PatchPackage", mock.Anything, "123", int64(2), service.MergePatch, patch).Return(nil, domain.ErrVersionConflict)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PATCH", "/api/v1/packages/123", bytes.NewBuffer(patch))
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+		req.Header.Set("If-Match", `"2"`)
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	})
+}
+
 func TestPackageHandler_DeletePackage(t *testing.T) {
 	mockService := new(MockPackageService)
 	handler := NewPackageHandler(mockService)
 	router := setupTestRouter(handler)
 
 	t.Run("success", func(t *testing.T) {
-		mockService.On("DeletePackage", "123").Return(nil)
+		mockService.On("DeletePackage", mock.Anything, "123").Return(nil)
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("DELETE", "/api/v1/packages/123", nil)
@@ -373,7 +682,7 @@ func TestPackageHandler_DeletePackage(t *testing.T) {
 	})
 
 	t.Run("not found", func(t *testing.T) {
-		mockService.On("DeletePackage", "456").Return(service.ErrEmptyPackageID)
+		mockService.On("DeletePackage", mock.Anything, "456").Return(service.ErrEmptyPackageID)
 
 		w := httptest.NewRecorder()
 		req, _ := http.NewRequest("DELETE", "/api/v1/packages/456", nil)
@@ -387,3 +696,60 @@ func TestPackageHandler_DeletePackage(t *testing.T) {
 		assert.Equal(t, service.ErrEmptyPackageID.Error(), response.Error)
 	})
 }
+
+func TestPackageHandler_AddEvent(t *testing.T) {
+	mockService := new(MockPackageService)
+	handler := NewPackageHandler(mockService)
+	router := setupTestRouter(handler)
+
+	t.Run("success", func(t *testing.T) {
+		event := &domain.Event{Location: "Memphis Hub", Status: "IN_TRANSIT"}
+		updated := &domain.Package{
+			PackageID:     "123",
+			CurrentStatus: "IN_TRANSIT",
+			Events:        []domain.Event{*event},
+		}
+
+		mockService.On("AddEvent", mock.Anything, "123", event).Return(updated, nil)
+
+		jsonData, _ := json.Marshal(event)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/packages/123/events", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var response response
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.True(t, response.Success)
+
+		// Convert response data to Package
+		jsonData, err = json.Marshal(response.Data)
+		assert.NoError(t, err)
+		var actualPkg domain.Package
+		err = json.Unmarshal(jsonData, &actualPkg)
+		assert.NoError(t, err)
+		assert.Equal(t, "IN_TRANSIT", actualPkg.CurrentStatus)
+		assert.Len(t, actualPkg.Events, 1)
+	})
+
+	t.Run("illegal transition", func(t *testing.T) {
+		event := &domain.Event{Location: "Memphis Hub", Status: "IN_TRANSIT"}
+
+		mockService.On("AddEvent", mock.Anything, "456", event).Return(nil, service.ErrInvalidTransition)
+
+		jsonData, _ := json.Marshal(event)
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/v1/packages/456/events", bytes.NewBuffer(jsonData))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		var response response
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.False(t, response.Success)
+		assert.Equal(t, service.ErrInvalidTransition.Error(), response.Error)
+	})
+}
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+// defaultHotClients is the number of clients listed without a limit
+const defaultHotClients = 20
+
+type RateLimitAdmin interface {
+	HotClients(n int) []domain.RateLimitClient
+	Client(ctx context.Context, identity string) (*domain.RateLimitClient, error)
+	ResetClient(ctx context.Context, identity string) error
+	Exempt(ctx context.Context, identity string, d time.Duration) (time.Time, error)
+	RemoveExemption(ctx context.Context, identity string) error
+}
+
+type RateLimitHandler struct {
+	limiter RateLimitAdmin
+}
+
+func NewRateLimitHandler(limiter RateLimitAdmin) *RateLimitHandler {
+	return &RateLimitHandler{
+		limiter: limiter,
+	}
+}
+
+// exemptRequest is the body of a rate limit exemption request
+type exemptRequest struct {
+	// Duration is how long the exemption lasts, such as 30m or 2h
+	Duration string `json:"duration" binding:"required" example:"1h"`
+}
+
+// exemption is the response to a rate limit exemption request
+type exemption struct {
+	Identity    string    `json:"identity"`
+	ExemptUntil time.Time `json:"exemptUntil"`
+}
+
+// @Summary List the hottest rate limit clients
+// @Description List the callers that made the most requests in the last hour, with their allowed and
+// @Description rejected requests. Callers are identified as the rate limiter identifies them, e.g.
+// @Description principal:<key ID>, tenant:<tenant> or ip:<address>. Every replica only knows the callers it served.
+// @Tags rate-limits
+// @Produce json
+// @Security APIKey
+// @Security BearerAuth
+// @Param limit query int false "Number of clients (default 20)"
+// @Success 200 {object} response
+// @Failure 400 {object} response
+// @Failure 401 {object} response
+// @Failure 403 {object} response
+// @Router /admin/rate-limits/clients [get]
+func (h *RateLimitHandler) ListClients(c *gin.Context) {
+	limit := defaultHotClients
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, response{Error: "Invalid limit", Success: false})
+			return
+		}
+		limit = n
+	}
+
+	clients := h.limiter.HotClients(limit)
+	c.JSON(http.StatusOK, response{Data: clients, Total: int64(len(clients)), Success: true})
+}
+
+// @Summary Get a rate limit client
+// @Description Get a caller's requests, the current state of its buckets and its exemption
+// @Tags rate-limits
+// @Produce json
+// @Security APIKey
+// @Security BearerAuth
+// @Param identity path string true "Caller identity, e.g. ip:192.0.2.1"
+// @Success 200 {object} response
+// @Failure 401 {object} response
+// @Failure 403 {object} response
+// @Failure 404 {object} response
+// @Failure 500 {object} response
+// @Router /admin/rate-limits/clients/{identity} [get]
+func (h *RateLimitHandler) GetClient(c *gin.Context) {
+	client, err := h.limiter.Client(c.Request.Context(), c.Param("identity"))
+	if err != nil {
+		c.JSON(rateLimitErrorStatus(err), response{Error: err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, response{Data: client, Success: true})
+}
+
+// @Summary Reset a rate limit client
+// @Description Refill every bucket of a caller, forgetting the requests they counted. Quotas are not reset.
+// @Tags rate-limits
+// @Produce json
+// @Security APIKey
+// @Security BearerAuth
+// @Param identity path string true "Caller identity, e.g. ip:192.0.2.1"
+// @Success 204
+// @Failure 401 {object} response
+// @Failure 403 {object} response
+// @Failure 404 {object} response
+// @Failure 500 {object} response
+// @Router /admin/rate-limits/clients/{identity}/reset [post]
+func (h *RateLimitHandler) ResetClient(c *gin.Context) {
+	if err := h.limiter.ResetClient(c.Request.Context(), c.Param("identity")); err != nil {
+		c.JSON(rateLimitErrorStatus(err), response{Error: err.Error(), Success: false})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// @Summary Exempt a rate limit client
+// @Description Let a caller's requests through rate limits and quotas for a while. Exempting a caller again
+// @Description replaces its exemption.
+// @Tags rate-limits
+// @Accept json
+// @Produce json
+// @Security APIKey
+// @Security BearerAuth
+// @Param identity path string true "Caller identity, e.g. ip:192.0.2.1"
+// @Param exemption body exemptRequest true "Duration of the exemption"
+// @Success 200 {object} response
+// @Failure 400 {object} response
+// @Failure 401 {object} response
+// @Failure 403 {object} response
+// @Failure 500 {object} response
+// @Router /admin/rate-limits/clients/{identity}/exemption [put]
+func (h *RateLimitHandler) ExemptClient(c *gin.Context) {
+	var req exemptRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, response{Error: "Invalid request body", Success: false})
+		return
+	}
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil || duration <= 0 {
+		c.JSON(http.StatusBadRequest, response{Error: "Invalid duration", Success: false})
+		return
+	}
+
+	identity := c.Param("identity")
+	until, err := h.limiter.Exempt(c.Request.Context(), identity, duration)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, response{Error: err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, response{Data: exemption{Identity: identity, ExemptUntil: until}, Success: true})
+}
+
+// @Summary Remove a rate limit exemption
+// @Description End a caller's exemption from rate limits and quotas
+// @Tags rate-limits
+// @Security APIKey
+// @Security BearerAuth
+// @Param identity path string true "Caller identity, e.g. ip:192.0.2.1"
+// @Success 204
+// @Failure 401 {object} response
+// @Failure 403 {object} response
+// @Failure 500 {object} response
+// @Router /admin/rate-limits/clients/{identity}/exemption [delete]
+func (h *RateLimitHandler) RemoveExemption(c *gin.Context) {
+	if err := h.limiter.RemoveExemption(c.Request.Context(), c.Param("identity")); err != nil {
+		c.JSON(http.StatusInternalServerError, response{Error: err.Error(), Success: false})
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func rateLimitErrorStatus(err error) int {
+	if errors.Is(err, domain.ErrRateLimitClientNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
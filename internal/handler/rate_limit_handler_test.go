@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockRateLimitAdmin is a mock implementation of RateLimitAdmin
+type MockRateLimitAdmin struct {
+	mock.Mock
+}
+
+func (m *MockRateLimitAdmin) HotClients(n int) []domain.RateLimitClient {
+	return m.Called(n).Get(0).([]domain.RateLimitClient)
+}
+
+func (m *MockRateLimitAdmin) Client(ctx context.Context, identity string) (*domain.RateLimitClient, error) {
+	args := m.Called(ctx, identity)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.RateLimitClient), args.Error(1)
+}
+
+func (m *MockRateLimitAdmin) ResetClient(ctx context.Context, identity string) error {
+	return m.Called(ctx, identity).Error(0)
+}
+
+func (m *MockRateLimitAdmin) Exempt(ctx context.Context, identity string, d time.Duration) (time.Time, error) {
+	args := m.Called(ctx, identity, d)
+	return args.Get(0).(time.Time), args.Error(1)
+}
+
+func (m *MockRateLimitAdmin) RemoveExemption(ctx context.Context, identity string) error {
+	return m.Called(ctx, identity).Error(0)
+}
+
+func setupRateLimitRouter(handler *RateLimitHandler) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	clients := router.Group("/api/v1/admin/rate-limits/clients")
+	{
+		clients.GET("", handler.ListClients)
+		clients.GET("/:identity", handler.GetClient)
+		clients.POST("/:identity/reset", handler.ResetClient)
+		clients.PUT("/:identity/exemption", handler.ExemptClient)
+		clients.DELETE("/:identity/exemption", handler.RemoveExemption)
+	}
+	return router
+}
+
+func TestRateLimitHandler_ListClients(t *testing.T) {
+	mockLimiter := new(MockRateLimitAdmin)
+	router := setupRateLimitRouter(NewRateLimitHandler(mockLimiter))
+	clients := []domain.RateLimitClient{{Identity: "principal:k1", Allowed: 40, Denied: 2}}
+
+	tests := []struct {
+		query    string
+		limit    int
+		expected int
+	}{
+		{"", defaultHotClients, http.StatusOK},
+		{"?limit=5", 5, http.StatusOK},
+		{"?limit=0", 0, http.StatusBadRequest},
+		{"?limit=many", 0, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if tt.limit > 0 {
+				mockLimiter.On("HotClients", tt.limit).Return(clients).Once()
+			}
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/rate-limits/clients"+tt.query, nil)
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.expected, w.Code)
+		})
+	}
+	mockLimiter.AssertExpectations(t)
+}
+
+func TestRateLimitHandler_GetClient(t *testing.T) {
+	mockLimiter := new(MockRateLimitAdmin)
+	router := setupRateLimitRouter(NewRateLimitHandler(mockLimiter))
+
+	get := func(identity string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/rate-limits/clients/"+identity, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("found", func(t *testing.T) {
+		client := &domain.RateLimitClient{
+			Identity: "ip:192.0.2.1",
+			Allowed:  3,
+			Buckets:  []domain.RateLimitBucket{{Endpoint: "GET:/api/v1/packages", Algorithm: domain.AlgorithmTokenBucket, Limit: 10, Remaining: 7, ResetSeconds: 3}},
+		}
+		mockLimiter.On("Client", mock.Anything, "ip:192.0.2.1").Return(client, nil)
+
+		w := get("ip:192.0.2.1")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var body struct {
+			Data domain.RateLimitClient `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, client.Buckets, body.Data.Buckets)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		mockLimiter.On("Client", mock.Anything, "ip:192.0.2.2").Return(nil, fmt.Errorf("%w: ip:192.0.2.2", domain.ErrRateLimitClientNotFound))
+		assert.Equal(t, http.StatusNotFound, get("ip:192.0.2.2").Code)
+	})
+
+	t.Run("store error", func(t *testing.T) {
+		mockLimiter.On("Client", mock.Anything, "ip:192.0.2.3").Return(nil, errors.New("connection refused"))
+		assert.Equal(t, http.StatusInternalServerError, get("ip:192.0.2.3").Code)
+	})
+}
+
+func TestRateLimitHandler_ResetClient(t *testing.T) {
+	mockLimiter := new(MockRateLimitAdmin)
+	router := setupRateLimitRouter(NewRateLimitHandler(mockLimiter))
+	mockLimiter.On("ResetClient", mock.Anything, "principal:k1").Return(nil)
+	mockLimiter.On("ResetClient", mock.Anything, "principal:k2").Return(domain.ErrRateLimitClientNotFound)
+
+	for identity, expected := range map[string]int{"principal:k1": http.StatusNoContent, "principal:k2": http.StatusNotFound} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPost, "/api/v1/admin/rate-limits/clients/"+identity+"/reset", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, expected, w.Code, identity)
+	}
+}
+
+func TestRateLimitHandler_Exemption(t *testing.T) {
+	mockLimiter := new(MockRateLimitAdmin)
+	router := setupRateLimitRouter(NewRateLimitHandler(mockLimiter))
+	until := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	mockLimiter.On("Exempt", mock.Anything, "tenant:acme", time.Hour).Return(until, nil)
+	mockLimiter.On("RemoveExemption", mock.Anything, "tenant:acme").Return(nil)
+
+	exempt := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPut, "/api/v1/admin/rate-limits/clients/tenant:acme/exemption", bytes.NewBufferString(body))
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := exempt(`{"duration":"1h"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"exemptUntil":"2024-01-01T09:00:00Z"`)
+
+	for _, body := range []string{`{}`, `{"duration":"soon"}`, `{"duration":"-1h"}`} {
+		assert.Equal(t, http.StatusBadRequest, exempt(body).Code, body)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodDelete, "/api/v1/admin/rate-limits/clients/tenant:acme/exemption", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/snavarro/microtracker/internal/service"
+)
+
+type UsageService interface {
+	ListUsage(ctx context.Context, period string, req domain.PageRequest) (*domain.UsagePage, error)
+}
+
+type UsageHandler struct {
+	service UsageService
+}
+
+func NewUsageHandler(service UsageService) *UsageHandler {
+	return &UsageHandler{
+		service: service,
+	}
+}
+
+// @Summary List usage
+// @Description List a page of the number of requests each caller made in a day or month, highest first. Callers are
+// @Description identified as the rate limiter identifies them, e.g. principal:<key ID>, tenant:<tenant> or
+// @Description ip:<address>. Requests rejected by a quota are counted too.
+// @Tags usage
+// @Produce json
+// @Security APIKey
+// @Security BearerAuth
+// @Param period query string false "Day (YYYY-MM-DD) or month (YYYY-MM); defaults to the current month"
+// @Param page query int false "Page number" default(1)
+// @Param size query int false "Page size" default(10)
+// @Success 200 {object} response
+// @Failure 400 {object} response
+// @Failure 401 {object} response
+// @Failure 403 {object} response
+// @Failure 500 {object} response
+// @Router /admin/usage [get]
+func (h *UsageHandler) ListUsage(c *gin.Context) {
+	page, err := h.service.ListUsage(c.Request.Context(), c.Query("period"), pageRequest(c))
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, service.ErrInvalidPeriod) {
+			status = http.StatusBadRequest
+		}
+		c.JSON(status, response{Error: err.Error(), Success: false})
+		return
+	}
+	c.JSON(http.StatusOK, response{Data: page.Usage, Total: page.Total, Page: page.Page, Size: page.Size, Success: true})
+}
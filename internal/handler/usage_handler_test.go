@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/snavarro/microtracker/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockUsageService is a mock implementation of UsageService
+type MockUsageService struct {
+	mock.Mock
+}
+
+func (m *MockUsageService) ListUsage(ctx context.Context, period string, req domain.PageRequest) (*domain.UsagePage, error) {
+	args := m.Called(ctx, period, req)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.UsagePage), args.Error(1)
+}
+
+func TestUsageHandler_ListUsage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	mockService := new(MockUsageService)
+	router := gin.New()
+	router.GET("/api/v1/admin/usage", NewUsageHandler(mockService).ListUsage)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/admin/usage"+query, nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("lists usage", func(t *testing.T) {
+		usage := []domain.Usage{
+			{Identity: "principal:k1", Period: "2024-05", Count: 42},
+			{Identity: "ip:192.0.2.1", Period: "2024-05", Count: 7},
+		}
+		mockService.On("ListUsage", mock.Anything, "2024-05", domain.PageRequest{Page: 2, Size: 2}).
+			Return(&domain.UsagePage{Usage: usage, Total: 5, Page: 2, Size: 2}, nil)
+
+		w := get("?period=2024-05&page=2&size=2")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var body struct {
+			Data  []domain.Usage `json:"data"`
+			Total int64          `json:"total"`
+			Page  int            `json:"page"`
+			Size  int            `json:"size"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		assert.Equal(t, usage, body.Data)
+		assert.EqualValues(t, 5, body.Total)
+		assert.Equal(t, 2, body.Page)
+		assert.Equal(t, 2, body.Size)
+	})
+
+	t.Run("invalid period", func(t *testing.T) {
+		mockService.On("ListUsage", mock.Anything, "2024", mock.Anything).Return(nil, fmt.Errorf("%w: 2024", service.ErrInvalidPeriod))
+		assert.Equal(t, http.StatusBadRequest, get("?period=2024").Code)
+	})
+
+	t.Run("storage error", func(t *testing.T) {
+		mockService.On("ListUsage", mock.Anything, "", mock.Anything).Return(nil, errors.New("connection refused"))
+		assert.Equal(t, http.StatusInternalServerError, get("").Code)
+	})
+}
@@ -8,13 +8,16 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/snavarro/microtracker/config"
+	"github.com/snavarro/microtracker/internal/domain"
 	"github.com/snavarro/microtracker/internal/handler"
 	"github.com/snavarro/microtracker/internal/middleware"
+	"github.com/snavarro/microtracker/internal/repository/memory"
 	mongorepo "github.com/snavarro/microtracker/internal/repository/mongo"
 	"github.com/snavarro/microtracker/internal/service"
 	"github.com/stretchr/testify/assert"
@@ -37,6 +40,7 @@ const (
 func setupTestServer(t *testing.T) (*gin.Engine, *mongo.Database) {
 	// Set test environment
 	os.Setenv("APP_ENV", "test")
+	os.Setenv("AUTH_ENABLED", "false")
 
 	// Set Gin to test mode
 	gin.SetMode(gin.TestMode)
@@ -47,32 +51,45 @@ func setupTestServer(t *testing.T) (*gin.Engine, *mongo.Database) {
 
 	// Connect to test database
 	db, err := config.ConnectDB(cfg)
-	require.NoError(t, err, "Failed to connect to database")
+	if err != nil {
+		t.Skipf("MongoDB is not available: %v", err)
+	}
+
+	_, err = mongorepo.Migrate(context.Background(), db)
+	require.NoError(t, err, "Failed to migrate database")
 
 	// Initialize components
-	packageRepo := mongorepo.NewPackageRepository(db)
-	packageService := service.NewPackageService(packageRepo)
+	packageRepo := mongorepo.NewPackageRepository(db, mongorepo.WithTimeouts(cfg.Timeouts))
+	eventRepo := mongorepo.NewEventRepository(db, mongorepo.WithTimeouts(cfg.Timeouts))
+	packageService := service.NewPackageService(packageRepo, eventRepo)
 	packageHandler := handler.NewPackageHandler(packageService)
+	apiKeyService := service.NewAPIKeyService(mongorepo.NewAPIKeyRepository(db))
 
 	// Create router
 	router := gin.New()
 	router.Use(gin.Recovery())
 
-	// Add rate limiting middleware
-	rateLimiter := middleware.NewRateLimiter(&cfg.RateLimit)
-	router.Use(rateLimiter.RateLimit())
+	// Rate limit API requests after authentication
+	rateLimiter := middleware.NewRateLimiter(memory.NewRateLimitStore(), &cfg.RateLimit)
+	idempotent := middleware.NewIdempotency(mongorepo.NewIdempotencyStore(db), &cfg.Idempotency).Handle()
+	auth := middleware.NewAuth(apiKeyService, &cfg.Auth)
+	viewer := auth.Require(domain.RoleViewer)
+	operator := auth.Require(domain.RoleOperator)
+	admin := auth.Require(domain.RoleAdmin)
 
 	// Setup routes
-	api := router.Group("/api/v1")
+	api := router.Group("/api/v1", auth.Authenticate(), middleware.Tenant(), rateLimiter.RateLimit())
 	{
 		packages := api.Group("/packages")
 		{
-			packages.GET("", packageHandler.ListPackages)
-			packages.GET("/search", packageHandler.SearchPackages)
-			packages.GET("/:id", packageHandler.GetPackage)
-			packages.POST("", packageHandler.CreatePackage)
-			packages.PUT("/:id", packageHandler.UpdatePackage)
-			packages.DELETE("/:id", packageHandler.DeletePackage)
+			packages.GET("", viewer, packageHandler.ListPackages)
+			packages.GET("/search", viewer, packageHandler.SearchPackages)
+			packages.GET("/:id", viewer, packageHandler.GetPackage)
+			packages.POST("", operator, idempotent, packageHandler.CreatePackage)
+			packages.PUT("/:id", operator, packageHandler.UpdatePackage)
+			packages.PATCH("/:id", operator, packageHandler.PatchPackage)
+			packages.DELETE("/:id", admin, packageHandler.DeletePackage)
+			packages.POST("/:id/events", operator, idempotent, packageHandler.AddEvent)
 		}
 	}
 
@@ -251,3 +268,38 @@ func TestRateLimitDifferentIPs(t *testing.T) {
 		assert.Equal(t, http.StatusOK, w.Code, "Request %d from IP2 should succeed", i)
 	}
 }
+
+func TestIdempotentCreatePackage(t *testing.T) {
+	router, db := setupTestServer(t)
+	defer cleanupDatabase(t, db)
+
+	create := func(key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodPost, listEndpoint, bytes.NewBufferString(body))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(middleware.IdempotencyKeyHeader, key)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	body := `{"packageId":"IDEM1","sender":{"name":"Test Sender","address":"123 Test St"},` +
+		`"recipient":{"name":"Test Recipient","address":"456 Test St"},` +
+		`"origin":"Test Origin","destination":"Test Destination","currentStatus":"created"}`
+
+	first := create("retry-1", body)
+	require.Equal(t, http.StatusCreated, first.Code)
+
+	retry := create("retry-1", body)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+
+	reused := create("retry-1", strings.Replace(body, "IDEM1", "IDEM2", 1))
+	assert.Equal(t, http.StatusUnprocessableEntity, reused.Code)
+
+	duplicate := create("", body)
+	assert.Equal(t, http.StatusConflict, duplicate.Code)
+}
@@ -0,0 +1,158 @@
+// Package lru provides a map split into shards, each with its own lock, that
+// evicts its least recently used entries once it is full. It keeps state
+// that every request touches, such as rate limit buckets, bounded without
+// serializing the requests on a single lock.
+package lru
+
+import (
+	"container/list"
+	"hash/maphash"
+	"sync"
+)
+
+// DefaultShards is the number of shards of a cache unless it is created
+// with fewer
+const DefaultShards = 32
+
+// Cache is a sharded LRU map of string keys. Entries are evicted per shard,
+// so a full cache evicts the least recently used entry of the shard a new key
+// falls in, which is only approximately the least recently used overall.
+type Cache[V any] struct {
+	shards []*shard[V]
+	seed   maphash.Seed
+}
+
+type shard[V any] struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order holds the entries, most recently used first
+	order *list.List
+	// capacity is the maximum number of entries, or 0 for no limit
+	capacity int
+}
+
+type entry[V any] struct {
+	key   string
+	value V
+}
+
+// New creates a cache of at most capacity entries, or of any number of
+// entries if capacity is 0, split into the given number of shards. A cache
+// has at most as many shards as entries, so that every shard holds one.
+func New[V any](capacity, shards int) *Cache[V] {
+	if shards < 1 {
+		shards = 1
+	}
+	if capacity > 0 && shards > capacity {
+		shards = capacity
+	}
+
+	c := &Cache[V]{shards: make([]*shard[V], shards), seed: maphash.MakeSeed()}
+	for i := range c.shards {
+		s := &shard[V]{entries: make(map[string]*list.Element), order: list.New()}
+		if capacity > 0 {
+			// Spread the capacity over the shards, the first ones taking
+			// the remainder
+			s.capacity = capacity / shards
+			if i < capacity%shards {
+				s.capacity++
+			}
+		}
+		c.shards[i] = s
+	}
+	return c
+}
+
+func (c *Cache[V]) shard(key string) *shard[V] {
+	return c.shards[maphash.String(c.seed, key)%uint64(len(c.shards))]
+}
+
+// Update calls fn with the value of key, and whether it exists, while
+// holding the lock of its shard, so fn may read and change the value safely.
+// If fn returns true, the value it returns is stored and becomes the most
+// recently used, evicting the least recently used entry of a full shard;
+// otherwise the cache is left unchanged.
+func (c *Cache[V]) Update(key string, fn func(value V, exists bool) (V, bool)) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var current V
+	elem, exists := s.entries[key]
+	if exists {
+		current = elem.Value.(*entry[V]).value
+	}
+	value, store := fn(current, exists)
+	if !store {
+		return
+	}
+
+	if exists {
+		elem.Value.(*entry[V]).value = value
+		s.order.MoveToFront(elem)
+		return
+	}
+	if s.capacity > 0 && s.order.Len() >= s.capacity {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*entry[V]).key)
+	}
+	s.entries[key] = s.order.PushFront(&entry[V]{key: key, value: value})
+}
+
+// Delete removes key
+func (c *Cache[V]) Delete(key string) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, exists := s.entries[key]; exists {
+		s.order.Remove(elem)
+		delete(s.entries, key)
+	}
+}
+
+// Len returns the number of entries
+func (c *Cache[V]) Len() int {
+	n := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		n += s.order.Len()
+		s.mu.Unlock()
+	}
+	return n
+}
+
+// Range calls fn for every entry, one shard at a time while holding its
+// lock. fn must not call other methods of the cache.
+func (c *Cache[V]) Range(fn func(key string, value V)) {
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for elem := s.order.Front(); elem != nil; elem = elem.Next() {
+			e := elem.Value.(*entry[V])
+			fn(e.key, e.value)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// RemoveIf removes the entries for which fn returns true, one shard at a time
+// while holding its lock, and returns how many it removed. fn must not call
+// other methods of the cache.
+func (c *Cache[V]) RemoveIf(fn func(key string, value V) bool) int {
+	removed := 0
+	for _, s := range c.shards {
+		s.mu.Lock()
+		for elem := s.order.Front(); elem != nil; {
+			next := elem.Next()
+			if e := elem.Value.(*entry[V]); fn(e.key, e.value) {
+				s.order.Remove(elem)
+				delete(s.entries, e.key)
+				removed++
+			}
+			elem = next
+		}
+		s.mu.Unlock()
+	}
+	return removed
+}
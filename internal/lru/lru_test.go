@@ -0,0 +1,105 @@
+package lru
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// set stores value under key
+func set(c *Cache[int], key string, value int) {
+	c.Update(key, func(int, bool) (int, bool) { return value, true })
+}
+
+// get returns the value of key without changing the cache
+func get(c *Cache[int], key string) (value int, found bool) {
+	c.Update(key, func(v int, exists bool) (int, bool) {
+		value, found = v, exists
+		return v, false
+	})
+	return value, found
+}
+
+func TestCache_Evicts(t *testing.T) {
+	c := New[int](2, 1)
+	set(c, "a", 1)
+	set(c, "b", 2)
+	set(c, "a", 10)
+	set(c, "c", 3)
+
+	assert.Equal(t, 2, c.Len())
+	_, found := get(c, "b")
+	assert.False(t, found, "the least recently used entry is evicted")
+	value, found := get(c, "a")
+	assert.True(t, found)
+	assert.Equal(t, 10, value)
+
+	// Reading without storing does not count as a use
+	set(c, "d", 4)
+	_, found = get(c, "a")
+	assert.False(t, found)
+}
+
+func TestCache_Shards(t *testing.T) {
+	c := New[int](100, 8)
+	assert.Len(t, c.shards, 8)
+	total := 0
+	for _, s := range c.shards {
+		total += s.capacity
+	}
+	assert.Equal(t, 100, total, "the capacity is spread over the shards")
+
+	assert.Len(t, New[int](3, 8).shards, 3, "every shard holds an entry")
+	assert.Len(t, New[int](0, 8).shards, 8)
+
+	for i := 0; i < 1000; i++ {
+		set(c, fmt.Sprint(i), i)
+	}
+	assert.Equal(t, 100, c.Len())
+}
+
+func TestCache_Unbounded(t *testing.T) {
+	c := New[int](0, 4)
+	for i := 0; i < 1000; i++ {
+		set(c, fmt.Sprint(i), i)
+	}
+	assert.Equal(t, 1000, c.Len())
+}
+
+func TestCache_DeleteAndRemoveIf(t *testing.T) {
+	c := New[int](0, 4)
+	for i := 0; i < 10; i++ {
+		set(c, fmt.Sprint(i), i)
+	}
+
+	c.Delete("0")
+	c.Delete("missing")
+	assert.Equal(t, 9, c.Len())
+
+	removed := c.RemoveIf(func(_ string, value int) bool { return value%2 == 0 })
+	assert.Equal(t, 4, removed)
+
+	sum := 0
+	c.Range(func(_ string, value int) { sum += value })
+	assert.Equal(t, 1+3+5+7+9, sum)
+}
+
+func TestCache_Concurrent(t *testing.T) {
+	c := New[int](0, 4)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.Update("counter", func(v int, _ bool) (int, bool) { return v + 1, true })
+			}
+		}()
+	}
+	wg.Wait()
+
+	value, _ := get(c, "counter")
+	assert.Equal(t, 8000, value)
+}
@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snavarro/microtracker/config"
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+// APIKeyHeader is the request header carrying the caller's API key
+const APIKeyHeader = "X-API-Key"
+
+// principalKey is the gin context key of the authenticated caller
+const principalKey = "principal"
+
+// anonymous is the principal of every request while authentication is
+// disabled
+var anonymous = &domain.Principal{ID: "anonymous", Name: "anonymous", Role: domain.RoleAdmin}
+
+// Authenticator resolves the principal of a credential. It fails with
+// domain.ErrUnauthorized for credentials that are unknown or no longer valid.
+type Authenticator interface {
+	Authenticate(ctx context.Context, secret string) (*domain.Principal, error)
+}
+
+// Auth authenticates requests by API key or bearer token and authorizes
+// them by role
+type Auth struct {
+	apiKeys Authenticator
+	tokens  Authenticator
+	enabled bool
+}
+
+// AuthOption configures optional Auth behaviour
+type AuthOption func(*Auth)
+
+// WithBearerTokens accepts bearer tokens in the Authorization header,
+// validated by tokens
+func WithBearerTokens(tokens Authenticator) AuthOption {
+	return func(a *Auth) {
+		a.tokens = tokens
+	}
+}
+
+// NewAuth creates an auth middleware. While cfg.Enabled is false every
+// request is treated as coming from an anonymous admin.
+func NewAuth(apiKeys Authenticator, cfg *config.AuthConfig, opts ...AuthOption) *Auth {
+	a := &Auth{
+		apiKeys: apiKeys,
+		enabled: cfg.Enabled,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Authenticate returns a gin middleware that rejects requests without a
+// valid API key or bearer token with 401 and stores the caller's principal
+// in the context
+func (a *Auth) Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.enabled {
+			c.Set(principalKey, anonymous)
+			c.Next()
+			return
+		}
+
+		var (
+			principal *domain.Principal
+			err       error
+		)
+		if token, ok := bearerToken(c); ok {
+			if a.tokens == nil {
+				a.abortUnauthorized(c, "Bearer tokens are not accepted")
+				return
+			}
+			principal, err = a.tokens.Authenticate(c.Request.Context(), token)
+		} else if secret := c.GetHeader(APIKeyHeader); secret != "" {
+			principal, err = a.apiKeys.Authenticate(c.Request.Context(), secret)
+		} else {
+			a.abortUnauthorized(c, "Missing credentials")
+			return
+		}
+
+		if errors.Is(err, domain.ErrUnauthorized) {
+			a.abortUnauthorized(c, "Invalid credentials")
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to authenticate API key: %v", err)
+			abortAuth(c, http.StatusInternalServerError, "Failed to authenticate request")
+			return
+		}
+
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+// Require returns a gin middleware that rejects callers whose role does not
+// include role with 403. It must run after Authenticate.
+func (a *Auth) Require(role domain.Role) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := GetPrincipal(c)
+		if !ok {
+			a.abortUnauthorized(c, "Authentication required")
+			return
+		}
+		if !principal.Role.Includes(role) {
+			abortAuth(c, http.StatusForbidden, "The "+string(principal.Role)+" role cannot access this route")
+			return
+		}
+		c.Next()
+	}
+}
+
+// GetPrincipal returns the caller stored by Authenticate
+func GetPrincipal(c *gin.Context) (*domain.Principal, bool) {
+	value, exists := c.Get(principalKey)
+	if !exists {
+		return nil, false
+	}
+	principal, ok := value.(*domain.Principal)
+	return principal, ok
+}
+
+// bearerToken returns the token of a bearer Authorization header
+func bearerToken(c *gin.Context) (string, bool) {
+	scheme, token, found := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+func (a *Auth) abortUnauthorized(c *gin.Context, message string) {
+	c.Header("WWW-Authenticate", `APIKey header="`+APIKeyHeader+`"`)
+	if a.tokens != nil {
+		c.Writer.Header().Add("WWW-Authenticate", "Bearer")
+	}
+	abortAuth(c, http.StatusUnauthorized, message)
+}
+
+func abortAuth(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, gin.H{
+		"error":   message,
+		"success": false,
+	})
+}
@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snavarro/microtracker/config"
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAuthenticator maps API keys to principals
+type fakeAuthenticator map[string]*domain.Principal
+
+func (f fakeAuthenticator) Authenticate(ctx context.Context, secret string) (*domain.Principal, error) {
+	if secret == "broken" {
+		return nil, errors.New("connection refused")
+	}
+	principal, ok := f[secret]
+	if !ok {
+		return nil, domain.ErrUnauthorized
+	}
+	return principal, nil
+}
+
+func setupAuthRouter(cfg *config.AuthConfig, opts ...AuthOption) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	auth := NewAuth(fakeAuthenticator{
+		"viewer-key":   {ID: "k1", Role: domain.RoleViewer},
+		"operator-key": {ID: "k2", Role: domain.RoleOperator},
+		"admin-key":    {ID: "k3", Role: domain.RoleAdmin},
+	}, cfg, opts...)
+
+	whoami := func(c *gin.Context) {
+		principal, _ := GetPrincipal(c)
+		c.String(http.StatusOK, principal.ID)
+	}
+
+	router := gin.New()
+	router.Use(auth.Authenticate())
+	router.GET("/packages", auth.Require(domain.RoleViewer), whoami)
+	router.POST("/packages", auth.Require(domain.RoleOperator), whoami)
+	router.DELETE("/packages/:id", auth.Require(domain.RoleAdmin), whoami)
+	return router
+}
+
+func request(router *gin.Engine, method, path, apiKey string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, nil)
+	if apiKey != "" {
+		req.Header.Set(APIKeyHeader, apiKey)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAuth(t *testing.T) {
+	router := setupAuthRouter(&config.AuthConfig{Enabled: true})
+
+	t.Run("missing and invalid keys are rejected", func(t *testing.T) {
+		for _, apiKey := range []string{"", "unknown-key"} {
+			w := request(router, http.MethodGet, "/packages", apiKey)
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+			assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+			assert.Contains(t, w.Body.String(), `"success":false`)
+		}
+	})
+
+	t.Run("authenticator errors are server errors", func(t *testing.T) {
+		w := request(router, http.MethodGet, "/packages", "broken")
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	})
+
+	t.Run("roles include lower roles", func(t *testing.T) {
+		tests := []struct {
+			method string
+			path   string
+			apiKey string
+			want   int
+		}{
+			{http.MethodGet, "/packages", "viewer-key", http.StatusOK},
+			{http.MethodPost, "/packages", "viewer-key", http.StatusForbidden},
+			{http.MethodPost, "/packages", "operator-key", http.StatusOK},
+			{http.MethodDelete, "/packages/P1", "operator-key", http.StatusForbidden},
+			{http.MethodDelete, "/packages/P1", "admin-key", http.StatusOK},
+			{http.MethodGet, "/packages", "admin-key", http.StatusOK},
+		}
+		for _, tt := range tests {
+			w := request(router, tt.method, tt.path, tt.apiKey)
+			assert.Equal(t, tt.want, w.Code, "%s %s as %s", tt.method, tt.path, tt.apiKey)
+		}
+	})
+
+	t.Run("principal is available to handlers", func(t *testing.T) {
+		w := request(router, http.MethodGet, "/packages", "operator-key")
+		assert.Equal(t, "k2", w.Body.String())
+	})
+
+	t.Run("bearer tokens", func(t *testing.T) {
+		bearer := func(router *gin.Engine, token string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/packages", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			router.ServeHTTP(w, req)
+			return w
+		}
+
+		w := bearer(router, "token-1")
+		assert.Equal(t, http.StatusUnauthorized, w.Code, "tokens are rejected unless configured")
+
+		withTokens := setupAuthRouter(&config.AuthConfig{Enabled: true}, WithBearerTokens(fakeAuthenticator{
+			"token-1": {ID: "user-1", Role: domain.RoleViewer, Tenant: "acme"},
+		}))
+		w = bearer(withTokens, "token-1")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "user-1", w.Body.String())
+
+		w = bearer(withTokens, "viewer-key")
+		assert.Equal(t, http.StatusUnauthorized, w.Code, "API keys are not bearer tokens")
+		assert.Equal(t, []string{`APIKey header="X-API-Key"`, "Bearer"}, w.Header().Values("WWW-Authenticate"))
+
+		w = request(withTokens, http.MethodGet, "/packages", "viewer-key")
+		assert.Equal(t, http.StatusOK, w.Code, "API keys still work")
+	})
+
+	t.Run("disabled authentication allows everything", func(t *testing.T) {
+		router := setupAuthRouter(&config.AuthConfig{Enabled: false})
+		w := request(router, http.MethodDelete, "/packages/P1", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "anonymous", w.Body.String())
+	})
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snavarro/microtracker/config"
+)
+
+// forwardedClientsHeader carries the for= addresses of a Forwarded header in
+// X-Forwarded-For syntax, which is what gin reads
+const forwardedClientsHeader = "X-Forwarded-Clients"
+
+// ConfigureClientIP sets how gin finds c.ClientIP, which the access log, the
+// rate limiter's ip identity and its network lists all use. The header in
+// cfg.ClientIPHeader is only read on requests from cfg.TrustedProxies, right
+// to left and skipping trusted proxies, so that a client cannot pick its IP
+// by sending the header itself. Every other request, and every request
+// without a valid header, is attributed to the address it comes from. It
+// must be called before any route is registered.
+func ConfigureClientIP(router *gin.Engine, cfg *config.ProxyConfig) error {
+	var proxies []string
+	for _, prefix := range cfg.TrustedProxies {
+		proxies = append(proxies, prefix.String())
+	}
+	if err := router.SetTrustedProxies(proxies); err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	router.ForwardedByClientIP = true
+	router.TrustedPlatform = ""
+
+	header := cfg.ClientIPHeader
+	switch header {
+	case "":
+		header = config.ClientIPHeaderXForwardedFor
+	case config.ClientIPHeaderForwarded:
+		router.Use(forwardedClients())
+		header = forwardedClientsHeader
+	}
+	router.RemoteIPHeaders = []string{header}
+	return nil
+}
+
+// forwardedClients returns a gin middleware that copies the addresses of the
+// Forwarded header into forwardedClientsHeader, replacing whatever the client
+// sent in it
+func forwardedClients() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request.Header.Del(forwardedClientsHeader)
+		if clients := forwardedFor(c.Request.Header.Values(config.ClientIPHeaderForwarded)); clients != "" {
+			c.Request.Header.Set(forwardedClientsHeader, clients)
+		}
+		c.Next()
+	}
+}
+
+// forwardedFor returns the for= parameters of Forwarded header values
+// (RFC 7239), e.g. `for=192.0.2.60;proto=http, for="[2001:db8::1]:4711"`,
+// as a comma separated list of addresses without ports. Elements without an
+// address, such as "unknown" or obfuscated ones, are kept as "unknown", which
+// stops gin from reading further left.
+func forwardedFor(values []string) string {
+	var clients []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			node := "unknown"
+			for _, pair := range strings.Split(element, ";") {
+				name, param, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if found && strings.EqualFold(name, "for") {
+					node = forwardedNode(param)
+				}
+			}
+			clients = append(clients, node)
+		}
+	}
+	return strings.Join(clients, ", ")
+}
+
+// forwardedNode returns the address of a for= value, which may be quoted and
+// carry a port
+func forwardedNode(value string) string {
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+	if addrPort, err := netip.ParseAddrPort(value); err == nil {
+		return addrPort.Addr().String()
+	}
+	if addr, err := netip.ParseAddr(strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")); err == nil {
+		return addr.String()
+	}
+	return "unknown"
+}
@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snavarro/microtracker/config"
+	"github.com/snavarro/microtracker/internal/repository/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigureClientIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	tests := []struct {
+		name       string
+		cfg        config.ProxyConfig
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{
+			name:       "no trusted proxies",
+			cfg:        config.ProxyConfig{ClientIPHeader: config.ClientIPHeaderXForwardedFor},
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "192.0.2.1"},
+			expected:   "10.0.0.1",
+		},
+		{
+			name:       "untrusted peer",
+			cfg:        config.ProxyConfig{TrustedProxies: trusted, ClientIPHeader: config.ClientIPHeaderXForwardedFor},
+			remoteAddr: "198.51.100.7:1234",
+			headers:    map[string]string{"X-Forwarded-For": "192.0.2.1"},
+			expected:   "198.51.100.7",
+		},
+		{
+			name:       "trusted proxy",
+			cfg:        config.ProxyConfig{TrustedProxies: trusted, ClientIPHeader: config.ClientIPHeaderXForwardedFor},
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.9, 192.0.2.1, 10.0.0.2"},
+			expected:   "192.0.2.1",
+		},
+		{
+			name:       "other headers are ignored",
+			cfg:        config.ProxyConfig{TrustedProxies: trusted, ClientIPHeader: config.ClientIPHeaderXForwardedFor},
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Real-IP": "192.0.2.1"},
+			expected:   "10.0.0.1",
+		},
+		{
+			name:       "X-Real-IP",
+			cfg:        config.ProxyConfig{TrustedProxies: trusted, ClientIPHeader: config.ClientIPHeaderXRealIP},
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.9", "X-Real-IP": "192.0.2.1"},
+			expected:   "192.0.2.1",
+		},
+		{
+			name:       "CF-Connecting-IP",
+			cfg:        config.ProxyConfig{TrustedProxies: trusted, ClientIPHeader: config.ClientIPHeaderCFConnectingIP},
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"CF-Connecting-IP": "2001:db8::1"},
+			expected:   "2001:db8::1",
+		},
+		{
+			name:       "Forwarded",
+			cfg:        config.ProxyConfig{TrustedProxies: trusted, ClientIPHeader: config.ClientIPHeaderForwarded},
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"Forwarded": `for=203.0.113.9, for="192.0.2.1:4711";proto=https, for=10.0.0.2`},
+			expected:   "192.0.2.1",
+		},
+		{
+			name:       "Forwarded IPv6",
+			cfg:        config.ProxyConfig{TrustedProxies: trusted, ClientIPHeader: config.ClientIPHeaderForwarded},
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"Forwarded": `For="[2001:db8::1]:4711"`},
+			expected:   "2001:db8::1",
+		},
+		{
+			name:       "Forwarded with an obfuscated client",
+			cfg:        config.ProxyConfig{TrustedProxies: trusted, ClientIPHeader: config.ClientIPHeaderForwarded},
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{"Forwarded": "for=192.0.2.1, for=_hidden"},
+			expected:   "10.0.0.1",
+		},
+		{
+			name:       "Forwarded ignores a spoofed internal header",
+			cfg:        config.ProxyConfig{TrustedProxies: trusted, ClientIPHeader: config.ClientIPHeaderForwarded},
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string]string{forwardedClientsHeader: "192.0.2.1"},
+			expected:   "10.0.0.1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			require.NoError(t, ConfigureClientIP(router, &tt.cfg))
+			router.GET("/ip", func(c *gin.Context) { c.String(http.StatusOK, c.ClientIP()) })
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/ip", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tt.expected, w.Body.String())
+		})
+	}
+}
+
+func TestConfigureClientIP_RateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limiter := NewRateLimiter(memory.NewRateLimitStore(), &config.RateLimitConfig{
+		Default: config.EndpointRateLimit{RequestsPerMinute: 60, BurstSize: 1, TTLMinutes: 5},
+	})
+	router := gin.New()
+	require.NoError(t, ConfigureClientIP(router, &config.ProxyConfig{ClientIPHeader: config.ClientIPHeaderXForwardedFor}))
+	router.Use(limiter.RateLimit())
+	router.GET("/api/v1/packages", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	request := func(forwardedFor string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/packages", nil)
+		req.RemoteAddr = "198.51.100.7:1234"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, request("192.0.2.1"))
+	assert.Equal(t, http.StatusTooManyRequests, request("192.0.2.2"), "a forged header does not get a fresh bucket")
+}
@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snavarro/microtracker/config"
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+// IdempotencyKeyHeader is the request header that makes a request safe to
+// retry
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength bounds the keys accepted from clients
+const maxIdempotencyKeyLength = 255
+
+// replayedHeaders are the response headers stored and sent again on replay
+var replayedHeaders = []string{"Content-Type", "ETag", "Location"}
+
+// Idempotency replays the stored response of requests that repeat an
+// Idempotency-Key instead of running them again
+type Idempotency struct {
+	store       domain.IdempotencyStore
+	ttl         time.Duration
+	lockTimeout time.Duration
+	now         func() time.Time
+}
+
+// NewIdempotency creates an idempotency middleware backed by store
+func NewIdempotency(store domain.IdempotencyStore, cfg *config.IdempotencyConfig) *Idempotency {
+	return &Idempotency{
+		store:       store,
+		ttl:         cfg.TTL,
+		lockTimeout: cfg.LockTimeout,
+		now:         time.Now,
+	}
+}
+
+// Handle returns a gin middleware for idempotent requests. Requests without
+// an Idempotency-Key header are passed through. The first request with a key
+// runs normally and its response is stored, unless it fails with a server
+// error so that it can be retried. Later requests with the same key receive
+// the stored response, or 409 while the first request is still running, and
+// reusing a key for a different request is rejected with 422. A request that
+// has not completed within the lock timeout, such as one lost with a crashed
+// replica, no longer holds its key, and a retry runs again.
+func (i *Idempotency) Handle() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			abortIdempotency(c, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+		// Keys are chosen by clients, so two tenants may well pick the same one
+		key = domain.TenantFromContext(c.Request.Context()) + "/" + key
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			abortIdempotency(c, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		now := i.now()
+		record := &domain.IdempotencyRecord{
+			Key:         key,
+			Fingerprint: fingerprint(c.Request, body),
+			LockedUntil: now.Add(i.lockTimeout),
+			ExpiresAt:   now.Add(i.ttl),
+		}
+
+		existing, err := i.store.Reserve(c.Request.Context(), record)
+		if err != nil {
+			log.Printf("Failed to reserve idempotency key: %v", err)
+			abortIdempotency(c, http.StatusInternalServerError, "Failed to check Idempotency-Key")
+			return
+		}
+		if existing != nil {
+			replay(c, existing, record.Fingerprint)
+			return
+		}
+
+		// The outcome is stored even if the client has gone away, since that
+		// is exactly when it will retry
+		ctx := context.WithoutCancel(c.Request.Context())
+		defer func() {
+			if r := recover(); r != nil {
+				i.release(ctx, record)
+				panic(r)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+
+		if recorder.Status() >= http.StatusInternalServerError {
+			i.release(ctx, record)
+			return
+		}
+
+		record.StatusCode = recorder.Status()
+		record.Header = make(map[string]string)
+		for _, name := range replayedHeaders {
+			if value := recorder.Header().Get(name); value != "" {
+				record.Header[name] = value
+			}
+		}
+		record.Body = recorder.body.Bytes()
+		if err := i.store.Complete(ctx, record); err != nil {
+			log.Printf("Failed to store response for idempotency key: %v", err)
+		}
+	}
+}
+
+func (i *Idempotency) release(ctx context.Context, record *domain.IdempotencyRecord) {
+	if err := i.store.Release(ctx, record); err != nil {
+		log.Printf("Failed to release idempotency key: %v", err)
+	}
+}
+
+// replay answers a repeated request from the stored record
+func replay(c *gin.Context, existing *domain.IdempotencyRecord, fingerprint string) {
+	if existing.Fingerprint != fingerprint {
+		abortIdempotency(c, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+		return
+	}
+	if !existing.Completed {
+		abortIdempotency(c, http.StatusConflict, "A request with this Idempotency-Key is still being processed")
+		return
+	}
+
+	for name, value := range existing.Header {
+		c.Header(name, value)
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.Status(existing.StatusCode)
+	c.Writer.Write(existing.Body)
+	c.Abort()
+}
+
+// fingerprint identifies a request by its method, path and body, so a key
+// cannot be reused for a different request
+func fingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	io.WriteString(hash, r.Method+" "+r.URL.Path+"\n")
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func abortIdempotency(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, gin.H{
+		"error":   message,
+		"success": false,
+	})
+}
+
+// responseRecorder keeps a copy of the response body while writing it
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snavarro/microtracker/config"
+	"github.com/snavarro/microtracker/internal/repository/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupIdempotencyRouter(handler gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	idempotency := NewIdempotency(memory.NewIdempotencyStore(), &config.IdempotencyConfig{TTL: time.Hour, LockTimeout: time.Minute})
+
+	router := gin.New()
+	router.POST("/packages", idempotency.Handle(), handler)
+	router.POST("/packages/:id/events", idempotency.Handle(), handler)
+	return router
+}
+
+func post(router *gin.Engine, path, key, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(IdempotencyKeyHeader, key)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotency(t *testing.T) {
+	t.Run("replays the stored response", func(t *testing.T) {
+		calls := 0
+		router := setupIdempotencyRouter(func(c *gin.Context) {
+			calls++
+			c.Header("ETag", `"1"`)
+			c.JSON(http.StatusCreated, gin.H{"calls": calls})
+		})
+
+		first := post(router, "/packages", "key-1", `{"packageId":"P1"}`)
+		retry := post(router, "/packages", "key-1", `{"packageId":"P1"}`)
+
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, http.StatusCreated, retry.Code)
+		assert.JSONEq(t, first.Body.String(), retry.Body.String())
+		assert.Equal(t, `"1"`, retry.Header().Get("ETag"))
+		assert.Equal(t, "application/json; charset=utf-8", retry.Header().Get("Content-Type"))
+		assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+		assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+	})
+
+	t.Run("client errors are replayed", func(t *testing.T) {
+		calls := 0
+		router := setupIdempotencyRouter(func(c *gin.Context) {
+			calls++
+			c.JSON(http.StatusConflict, gin.H{"error": "package already exists"})
+		})
+
+		post(router, "/packages", "key-1", `{}`)
+		retry := post(router, "/packages", "key-1", `{}`)
+
+		assert.Equal(t, 1, calls)
+		assert.Equal(t, http.StatusConflict, retry.Code)
+	})
+
+	t.Run("server errors can be retried", func(t *testing.T) {
+		calls := 0
+		router := setupIdempotencyRouter(func(c *gin.Context) {
+			calls++
+			if calls == 1 {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			c.JSON(http.StatusCreated, gin.H{})
+		})
+
+		assert.Equal(t, http.StatusInternalServerError, post(router, "/packages", "key-1", `{}`).Code)
+		assert.Equal(t, http.StatusCreated, post(router, "/packages", "key-1", `{}`).Code)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("panics release the key", func(t *testing.T) {
+		calls := 0
+		router := setupIdempotencyRouter(func(c *gin.Context) {
+			calls++
+			if calls == 1 {
+				panic("boom")
+			}
+			c.JSON(http.StatusCreated, gin.H{})
+		})
+
+		assert.Panics(t, func() { post(router, "/packages", "key-1", `{}`) })
+		assert.Equal(t, http.StatusCreated, post(router, "/packages", "key-1", `{}`).Code)
+	})
+
+	t.Run("key reuse with a different request", func(t *testing.T) {
+		router := setupIdempotencyRouter(func(c *gin.Context) {
+			c.JSON(http.StatusCreated, gin.H{})
+		})
+
+		post(router, "/packages", "key-1", `{"packageId":"P1"}`)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, post(router, "/packages", "key-1", `{"packageId":"P2"}`).Code)
+		assert.Equal(t, http.StatusUnprocessableEntity, post(router, "/packages/P1/events", "key-1", `{"packageId":"P1"}`).Code)
+	})
+
+	t.Run("request still in progress", func(t *testing.T) {
+		started, finish := make(chan struct{}), make(chan struct{})
+		router := setupIdempotencyRouter(func(c *gin.Context) {
+			close(started)
+			<-finish
+			c.JSON(http.StatusCreated, gin.H{})
+		})
+
+		done := make(chan *httptest.ResponseRecorder)
+		go func() { done <- post(router, "/packages", "key-1", `{}`) }()
+		<-started
+
+		assert.Equal(t, http.StatusConflict, post(router, "/packages", "key-1", `{}`).Code)
+		close(finish)
+		assert.Equal(t, http.StatusCreated, (<-done).Code)
+	})
+
+	t.Run("lost requests are run again", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+		idempotency := NewIdempotency(memory.NewIdempotencyStore(), &config.IdempotencyConfig{TTL: time.Hour, LockTimeout: time.Minute})
+		started, finish := make(chan struct{}), make(chan struct{})
+		calls := 0
+		router := gin.New()
+		router.POST("/packages", idempotency.Handle(), func(c *gin.Context) {
+			calls++
+			if calls == 1 {
+				close(started)
+				<-finish
+			}
+			c.JSON(http.StatusCreated, gin.H{})
+		})
+
+		// The first request reserved its key two minutes ago and never finished
+		idempotency.now = func() time.Time { return time.Now().Add(-2 * time.Minute) }
+		done := make(chan *httptest.ResponseRecorder)
+		go func() { done <- post(router, "/packages", "key-1", `{}`) }()
+		<-started
+		idempotency.now = time.Now
+
+		assert.Equal(t, http.StatusCreated, post(router, "/packages", "key-1", `{}`).Code)
+		assert.Equal(t, 2, calls)
+		close(finish)
+		<-done
+	})
+
+	t.Run("requests without a key are not stored", func(t *testing.T) {
+		calls := 0
+		router := setupIdempotencyRouter(func(c *gin.Context) {
+			calls++
+			c.JSON(http.StatusCreated, gin.H{})
+		})
+
+		post(router, "/packages", "", `{}`)
+		post(router, "/packages", "", `{}`)
+
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("keys are scoped by tenant", func(t *testing.T) {
+		calls := 0
+		idempotency := NewIdempotency(memory.NewIdempotencyStore(), &config.IdempotencyConfig{TTL: time.Hour, LockTimeout: time.Minute})
+		router := gin.New()
+		router.POST("/packages", Tenant(), idempotency.Handle(), func(c *gin.Context) {
+			calls++
+			c.Status(http.StatusCreated)
+		})
+
+		for _, tenant := range []string{"acme", "globex", "acme"} {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodPost, "/packages", bytes.NewBufferString(`{}`))
+			req.Header.Set(IdempotencyKeyHeader, "key-1")
+			req.Header.Set(TenantHeader, tenant)
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusCreated, w.Code)
+		}
+
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("overlong key", func(t *testing.T) {
+		router := setupIdempotencyRouter(func(c *gin.Context) {
+			t.Fatal("handler must not run")
+		})
+
+		w := post(router, "/packages", strings.Repeat("k", maxIdempotencyKeyLength+1), `{}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("handler sees the request body", func(t *testing.T) {
+		router := setupIdempotencyRouter(func(c *gin.Context) {
+			var body map[string]string
+			require.NoError(t, c.ShouldBindJSON(&body))
+			c.JSON(http.StatusCreated, body)
+		})
+
+		w := post(router, "/packages", "key-1", `{"packageId":"P1"}`)
+		assert.JSONEq(t, `{"packageId":"P1"}`, w.Body.String())
+	})
+}
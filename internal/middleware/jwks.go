@@ -0,0 +1,263 @@
+package middleware
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// errKeyNotFound is returned for key IDs missing from the key set
+var errKeyNotFound = errors.New("signing key not found in JWKS")
+
+// minJWKSRefreshInterval limits how often unknown key IDs may trigger a
+// reload, so that tokens with made-up key IDs cannot flood the JWKS endpoint
+const minJWKSRefreshInterval = 30 * time.Second
+
+// JWKS is a JSON Web Key Set loaded from a file or an HTTP endpoint. Keys are
+// cached for the refresh interval and reloaded earlier when a token names an
+// unknown key ID, which picks up keys rotated in by the provider. A single
+// reload runs at a time, in the background and outside the lock, so that a
+// slow endpoint only holds up the requests that need a key not yet cached.
+type JWKS struct {
+	load        func(ctx context.Context) ([]byte, error)
+	refresh     time.Duration
+	minInterval time.Duration
+	now         func() time.Time
+
+	mu       sync.Mutex
+	keys     map[string]crypto.PublicKey
+	loadedAt time.Time
+	// loading is closed when the running reload completes, and nil while
+	// none runs
+	loading chan struct{}
+	// loadErr is the error of the last reload
+	loadErr error
+}
+
+// NewFileJWKS creates a key set read from a local file
+func NewFileJWKS(path string, refresh time.Duration) *JWKS {
+	return newJWKS(func(ctx context.Context) ([]byte, error) {
+		return os.ReadFile(path)
+	}, refresh)
+}
+
+// NewRemoteJWKS creates a key set fetched from url with client
+func NewRemoteJWKS(url string, client *http.Client, refresh time.Duration) *JWKS {
+	return newJWKS(func(ctx context.Context) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching JWKS from %s: unexpected status %s", url, resp.Status)
+		}
+		return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	}, refresh)
+}
+
+func newJWKS(load func(ctx context.Context) ([]byte, error), refresh time.Duration) *JWKS {
+	return &JWKS{
+		load:        load,
+		refresh:     refresh,
+		minInterval: minJWKSRefreshInterval,
+		now:         time.Now,
+	}
+}
+
+// Key returns the public key with the given key ID. An empty ID matches the
+// only key of a set holding a single key. Cached keys are returned right
+// away, even while they are being reloaded; only unknown key IDs wait for the
+// reload. When the keys cannot be reloaded the cached ones keep being used.
+func (s *JWKS) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	now := s.now()
+	if s.keys != nil && now.Sub(s.loadedAt) >= s.refresh {
+		s.startReload(ctx)
+	}
+	key, found := s.lookup(kid)
+	var loading chan struct{}
+	if !found {
+		// The provider may have rotated in a new key
+		if s.keys == nil || now.Sub(s.loadedAt) >= s.minInterval {
+			s.startReload(ctx)
+		}
+		loading = s.loading
+	}
+	s.mu.Unlock()
+	if found {
+		return key, nil
+	}
+
+	if loading != nil {
+		select {
+		case <-loading:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		return nil, s.loadErr
+	}
+	key, found = s.lookup(kid)
+	if !found {
+		return nil, fmt.Errorf("%w: %q", errKeyNotFound, kid)
+	}
+	return key, nil
+}
+
+func (s *JWKS) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	key, ok := s.keys[kid]
+	return key, ok
+}
+
+// startReload replaces the cached keys in the background, unless a reload is
+// already running. The reload outlives the request that started it, so it
+// is bounded by the load function rather than ctx. Callers must hold the
+// lock.
+func (s *JWKS) startReload(ctx context.Context) {
+	if s.loading != nil {
+		return
+	}
+	// Failed loads are also rate limited
+	s.loadedAt = s.now()
+	loading := make(chan struct{})
+	s.loading = loading
+
+	go func() {
+		defer close(loading)
+		keys, err := s.fetch(context.WithoutCancel(ctx))
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.loading = nil
+		s.loadErr = err
+		switch {
+		case err == nil:
+			s.keys = keys
+		case s.keys != nil:
+			log.Printf("Failed to reload JWKS, using cached keys: %v", err)
+		}
+	}()
+}
+
+// fetch loads and parses the key set
+func (s *JWKS) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	data, err := s.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return parseJWKS(data)
+}
+
+// jsonWebKey holds the JWK members used by RSA and EC signing keys
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS decodes the RSA and P-256 signing keys of a key set. Keys of
+// other types are skipped.
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("invalid JWKS: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		var (
+			key crypto.PublicKey
+			err error
+		)
+		switch jwk.Kty {
+		case "RSA":
+			key, err = jwk.rsaKey()
+		case "EC":
+			key, err = jwk.ecKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid JWKS key %q: %v", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) rsaKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	exponent := new(big.Int).SetBytes(e)
+	if len(n) == 0 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("invalid RSA key")
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+}
+
+func (k jsonWebKey) ecKey() (*ecdsa.PublicKey, error) {
+	if k.Crv != "P-256" {
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, err
+	}
+	if len(x) != 32 || len(y) != 32 {
+		return nil, errors.New("invalid P-256 coordinates")
+	}
+	// ecdh validates that the point is on the curve
+	point := append(append([]byte{4}, x...), y...)
+	if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+		return nil, err
+	}
+	return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+}
@@ -0,0 +1,144 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/snavarro/microtracker/config"
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+// jwtSigningMethods are the accepted token signature algorithms
+var jwtSigningMethods = []string{"RS256", "ES256"}
+
+// JWTAuthenticator validates bearer tokens issued by an OIDC provider and
+// maps their claims to a principal
+type JWTAuthenticator struct {
+	keys        *JWKS
+	parser      *jwt.Parser
+	rolesClaim  string
+	tenantClaim string
+	// platformTenant is the tenant claim value of platform callers, which
+	// may act for any tenant; empty if no token is a platform caller
+	platformTenant string
+	planClaim      string
+	roleMap        map[string]domain.Role
+}
+
+// NewJWTAuthenticator creates an authenticator for tokens signed with the
+// keys of cfg's JWKS. Tokens must be signed with RS256 or ES256, carry the
+// configured issuer and audience and not be expired.
+func NewJWTAuthenticator(cfg *config.JWTConfig) (*JWTAuthenticator, error) {
+	if cfg.Issuer == "" || cfg.Audience == "" {
+		return nil, errors.New("JWT issuer and audience are required")
+	}
+
+	roleMap := make(map[string]domain.Role)
+	for _, role := range []domain.Role{domain.RoleViewer, domain.RoleOperator, domain.RoleAdmin} {
+		roleMap[string(role)] = role
+	}
+	for claim, role := range cfg.RoleMap {
+		if !domain.Role(role).Valid() {
+			return nil, fmt.Errorf("JWT role map: unknown role %q for %q", role, claim)
+		}
+		roleMap[claim] = domain.Role(role)
+	}
+
+	keys := NewFileJWKS(cfg.JWKSFile, cfg.JWKSRefresh)
+	if cfg.JWKSURL != "" {
+		keys = NewRemoteJWKS(cfg.JWKSURL, &http.Client{Timeout: 10 * time.Second}, cfg.JWKSRefresh)
+	}
+
+	return &JWTAuthenticator{
+		keys: keys,
+		parser: jwt.NewParser(
+			jwt.WithValidMethods(jwtSigningMethods),
+			jwt.WithIssuer(cfg.Issuer),
+			jwt.WithAudience(cfg.Audience),
+			jwt.WithExpirationRequired(),
+			jwt.WithLeeway(cfg.Leeway),
+		),
+		rolesClaim:     cfg.RolesClaim,
+		tenantClaim:    cfg.TenantClaim,
+		platformTenant: cfg.PlatformTenant,
+		planClaim:      cfg.PlanClaim,
+		roleMap:        roleMap,
+	}, nil
+}
+
+// Authenticate validates token and returns its principal. Tokens that fail
+// validation, including tokens without a valid tenant claim, fail with
+// domain.ErrUnauthorized; a JWKS that cannot be loaded is reported as a plain
+// error. Only tokens whose tenant claim is the configured platform tenant
+// are platform callers.
+func (a *JWTAuthenticator) Authenticate(ctx context.Context, token string) (*domain.Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return a.keys.Key(ctx, kid)
+	})
+	if err != nil {
+		if errors.Is(err, jwt.ErrTokenUnverifiable) && !errors.Is(err, errKeyNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: %v", domain.ErrUnauthorized, err)
+	}
+
+	subject, _ := claims.GetSubject()
+	if subject == "" {
+		return nil, fmt.Errorf("%w: token has no subject", domain.ErrUnauthorized)
+	}
+
+	principal := &domain.Principal{
+		ID:   subject,
+		Name: subject,
+		Role: a.role(claims[a.rolesClaim]),
+	}
+	if name, ok := claims["name"].(string); ok && name != "" {
+		principal.Name = name
+	}
+	tenant, _ := claims[a.tenantClaim].(string)
+	switch {
+	case a.platformTenant != "" && tenant == a.platformTenant:
+		// Platform callers have no tenant and choose one per request
+	case domain.ValidTenant(tenant):
+		principal.Tenant = tenant
+	default:
+		return nil, fmt.Errorf("%w: token has no valid %s claim", domain.ErrUnauthorized, a.tenantClaim)
+	}
+	if plan, ok := claims[a.planClaim].(string); ok {
+		principal.Plan = plan
+	}
+	return principal, nil
+}
+
+// role returns the highest role mapped from a roles claim, which may be a
+// list or a space separated string. Tokens without a mapped role
+// authenticate but are not allowed to access any route.
+func (a *JWTAuthenticator) role(claim interface{}) domain.Role {
+	var values []string
+	switch claim := claim.(type) {
+	case string:
+		values = strings.Fields(claim)
+	case []interface{}:
+		for _, value := range claim {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	var best domain.Role
+	for _, value := range values {
+		role, ok := a.roleMap[value]
+		if ok && (best == "" || role.Includes(best)) {
+			best = role
+		}
+	}
+	return best
+}
@@ -0,0 +1,336 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/snavarro/microtracker/config"
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// jwksServer is a local stand-in for an OIDC provider's JWKS endpoint
+type jwksServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	keys     []map[string]string
+	requests int
+	down     bool
+	// stall holds up responses until it is closed
+	stall chan struct{}
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	s := &jwksServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		stall := s.stall
+		s.mu.Unlock()
+		if stall != nil {
+			<-stall
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests++
+		if s.down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": s.keys})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// served returns the number of requests answered so far
+func (s *jwksServer) served() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests
+}
+
+func (s *jwksServer) publish(keys ...map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "RSA",
+		"kid": kid,
+		"use": "sig",
+		"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func ecJWK(kid string, key *ecdsa.PublicKey) map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"kid": kid,
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func signToken(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":    "https://portal.example.com",
+		"aud":    "microtracker",
+		"sub":    "user-1",
+		"name":   "Jane Partner",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"roles":  []string{"partner-ops"},
+		"tenant": "acme",
+		"plan":   "pro",
+	}
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	server := newJWKSServer(t)
+	server.publish(rsaJWK("rsa-1", &rsaKey.PublicKey), ecJWK("ec-1", &ecKey.PublicKey))
+
+	auth, err := NewJWTAuthenticator(&config.JWTConfig{
+		JWKSURL:     server.URL,
+		JWKSRefresh: time.Hour,
+		Issuer:      "https://portal.example.com",
+		Audience:    "microtracker",
+		RolesClaim:  "roles",
+		TenantClaim: "tenant",
+		PlanClaim:   "plan",
+		RoleMap:     map[string]string{"partner-ops": "operator", "partner-read": "viewer"},
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	t.Run("RS256 and ES256 tokens", func(t *testing.T) {
+		for _, token := range []string{
+			signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, validClaims()),
+			signToken(t, jwt.SigningMethodES256, "ec-1", ecKey, validClaims()),
+		} {
+			principal, err := auth.Authenticate(ctx, token)
+			require.NoError(t, err)
+			assert.Equal(t, &domain.Principal{ID: "user-1", Name: "Jane Partner", Role: domain.RoleOperator, Tenant: "acme", Plan: "pro"}, principal)
+		}
+	})
+
+	t.Run("invalid tokens", func(t *testing.T) {
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		claims := func(key string, value interface{}) jwt.MapClaims {
+			c := validClaims()
+			if value == nil {
+				delete(c, key)
+			} else {
+				c[key] = value
+			}
+			return c
+		}
+
+		tests := map[string]string{
+			"wrong issuer":   signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims("iss", "https://evil.example.com")),
+			"wrong audience": signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims("aud", "other")),
+			"expired":        signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims("exp", time.Now().Add(-time.Hour).Unix())),
+			"no expiry":      signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims("exp", nil)),
+			"no subject":     signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims("sub", nil)),
+			"no tenant":      signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims("tenant", nil)),
+			"numeric tenant": signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims("tenant", 42)),
+			"invalid tenant": signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims("tenant", "acme/../other")),
+			"no platform":    signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims("tenant", "*")),
+			"wrong key":      signToken(t, jwt.SigningMethodRS256, "rsa-1", otherKey, validClaims()),
+			"HS256":          signToken(t, jwt.SigningMethodHS256, "rsa-1", []byte("secret"), validClaims()),
+			"malformed":      "not.a.token",
+		}
+		for name, token := range tests {
+			_, err := auth.Authenticate(ctx, token)
+			assert.ErrorIs(t, err, domain.ErrUnauthorized, name)
+		}
+	})
+
+	t.Run("platform tenant", func(t *testing.T) {
+		platform, err := NewJWTAuthenticator(&config.JWTConfig{
+			JWKSURL:        server.URL,
+			JWKSRefresh:    time.Hour,
+			Issuer:         "https://portal.example.com",
+			Audience:       "microtracker",
+			RolesClaim:     "roles",
+			TenantClaim:    "tenant",
+			PlatformTenant: "*",
+		})
+		require.NoError(t, err)
+
+		c := validClaims()
+		c["tenant"] = "*"
+		principal, err := platform.Authenticate(ctx, signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, c))
+		require.NoError(t, err)
+		assert.Empty(t, principal.Tenant, "the platform tenant may act for any tenant")
+
+		principal, err = platform.Authenticate(ctx, signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, validClaims()))
+		require.NoError(t, err)
+		assert.Equal(t, "acme", principal.Tenant)
+
+		delete(c, "tenant")
+		_, err = platform.Authenticate(ctx, signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, c))
+		assert.ErrorIs(t, err, domain.ErrUnauthorized, "a missing claim is not the platform tenant")
+	})
+
+	t.Run("roles", func(t *testing.T) {
+		tests := []struct {
+			roles interface{}
+			want  domain.Role
+		}{
+			{[]string{"partner-read", "partner-ops"}, domain.RoleOperator},
+			{"partner-read admin", domain.RoleAdmin},
+			{[]string{"unknown"}, ""},
+			{nil, ""},
+		}
+		for _, tt := range tests {
+			c := validClaims()
+			c["roles"] = tt.roles
+			principal, err := auth.Authenticate(ctx, signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, c))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, principal.Role, "%v", tt.roles)
+		}
+	})
+
+	t.Run("rotated keys are fetched", func(t *testing.T) {
+		rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+		server.publish(rsaJWK("rsa-2", &rotated.PublicKey))
+		auth.keys.minInterval = 0
+
+		_, err = auth.Authenticate(ctx, signToken(t, jwt.SigningMethodRS256, "rsa-2", rotated, validClaims()))
+		assert.NoError(t, err)
+
+		_, err = auth.Authenticate(ctx, signToken(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, validClaims()))
+		assert.ErrorIs(t, err, domain.ErrUnauthorized, "keys removed from the set are no longer accepted")
+	})
+}
+
+func TestJWKS_Caching(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	server := newJWKSServer(t)
+	server.publish(ecJWK("ec-1", &key.PublicKey))
+
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	keys := NewRemoteJWKS(server.URL, server.Client(), time.Hour)
+	keys.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_, err = keys.Key(ctx, "ec-1")
+	require.NoError(t, err)
+	_, err = keys.Key(ctx, "")
+	require.NoError(t, err, "an empty key ID matches the only key")
+	assert.Equal(t, 1, server.requests)
+
+	t.Run("unknown key IDs reload at most once per interval", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			_, err := keys.Key(ctx, "made-up")
+			assert.ErrorIs(t, err, errKeyNotFound)
+		}
+		assert.Equal(t, 1, server.requests)
+
+		now = now.Add(time.Minute)
+		_, err := keys.Key(ctx, "made-up")
+		assert.ErrorIs(t, err, errKeyNotFound)
+		assert.Equal(t, 2, server.requests)
+	})
+
+	t.Run("cached keys are used while the endpoint is slow", func(t *testing.T) {
+		stall := make(chan struct{})
+		server.mu.Lock()
+		server.stall = stall
+		server.mu.Unlock()
+		now = now.Add(2 * time.Hour)
+
+		for i := 0; i < 3; i++ {
+			_, err := keys.Key(ctx, "ec-1")
+			assert.NoError(t, err)
+		}
+
+		timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		_, err := keys.Key(timeout, "rotated")
+		assert.ErrorIs(t, err, context.DeadlineExceeded, "unknown key IDs wait for the reload")
+
+		server.mu.Lock()
+		server.stall = nil
+		server.mu.Unlock()
+		close(stall)
+		assert.Eventually(t, func() bool { return server.served() == 3 }, time.Second, 10*time.Millisecond,
+			"one reload serves every request")
+	})
+
+	t.Run("cached keys are used while the endpoint is down", func(t *testing.T) {
+		server.mu.Lock()
+		server.down = true
+		server.mu.Unlock()
+		now = now.Add(2 * time.Hour)
+
+		_, err := keys.Key(ctx, "ec-1")
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool { return server.served() == 4 }, time.Second, 10*time.Millisecond)
+		_, err = keys.Key(ctx, "ec-1")
+		assert.NoError(t, err)
+	})
+
+	t.Run("nothing cached", func(t *testing.T) {
+		keys := NewRemoteJWKS(server.URL, server.Client(), time.Hour)
+		_, err := keys.Key(ctx, "ec-1")
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, errKeyNotFound)
+	})
+}
+
+func TestFileJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	path := t.TempDir() + "/jwks.json"
+	data, err := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		rsaJWK("rsa-1", &key.PublicKey),
+		{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"},
+		{"kty": "RSA", "kid": "enc", "use": "enc"},
+	}})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	keys := NewFileJWKS(path, time.Hour)
+	found, err := keys.Key(context.Background(), "rsa-1")
+	require.NoError(t, err)
+	assert.Equal(t, &key.PublicKey, found)
+
+	_, err = keys.Key(context.Background(), "hmac")
+	assert.ErrorIs(t, err, errKeyNotFound, "symmetric keys are never accepted")
+}
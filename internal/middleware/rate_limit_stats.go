@@ -0,0 +1,272 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+// Outcomes of rate limited requests, as counted by the requests metric
+const (
+	outcomeAllowed   = "allowed"
+	outcomeLimited   = "limited"
+	outcomeOverQuota = "over_quota"
+	outcomeExempt    = "exempt"
+	outcomeError     = "error"
+	// Requests let through by routes in shadow mode
+	outcomeShadowLimited   = "shadow_limited"
+	outcomeShadowOverQuota = "shadow_over_quota"
+	// Requests from allowed and denied networks
+	outcomeBypassed = "bypassed"
+	outcomeDenied   = "denied"
+)
+
+// clientIdleTTL is how long a caller is tracked after its last request
+const clientIdleTTL = time.Hour
+
+// clientPruneInterval is how often callers idle for longer than
+// clientIdleTTL are forgotten
+const clientPruneInterval = time.Minute
+
+// clientStats are the requests of a caller seen by this replica
+type clientStats struct {
+	allowed  int64
+	denied   int64
+	lastSeen time.Time
+	// buckets maps the keys of the caller's buckets to their endpoint and
+	// the limit they were last taken with
+	buckets map[string]bucketRef
+}
+
+type bucketRef struct {
+	endpoint string
+	limit    domain.RateLimit
+}
+
+// bucketCounter is implemented by stores that can tell how many buckets
+// they keep
+type bucketCounter interface {
+	Buckets() int
+}
+
+// WithMetrics registers the rate limiter's metrics with reg:
+// microtracker_rate_limit_requests_total counts requests by endpoint, caller
+// and outcome, and microtracker_rate_limit_clients and
+// microtracker_rate_limit_buckets track the callers and buckets this replica
+// knows of. Callers identified by IP are counted under the identity "ip" so
+// that the number of series stays bounded.
+func WithMetrics(reg prometheus.Registerer) RateLimiterOption {
+	return func(rl *RateLimiter) {
+		rl.requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "microtracker_rate_limit_requests_total",
+			Help: "Requests checked by the rate limiter by endpoint, caller and outcome.",
+		}, []string{"endpoint", "identity", "outcome"})
+
+		clients := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "microtracker_rate_limit_clients",
+			Help: "Callers seen by this replica in the last hour.",
+		}, func() float64 {
+			return float64(rl.clients.Len())
+		})
+
+		buckets := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "microtracker_rate_limit_buckets",
+			Help: "Rate limit buckets kept by the store, or used by this replica for shared stores.",
+		}, func() float64 {
+			if counter, ok := rl.store.(bucketCounter); ok {
+				return float64(counter.Buckets())
+			}
+			n := 0
+			rl.clients.Range(func(_ string, client *clientStats) {
+				n += len(client.buckets)
+			})
+			return float64(n)
+		})
+
+		reg.MustRegister(rl.requests, clients, buckets)
+	}
+}
+
+// record counts a request in the metrics and the caller's stats. The key is
+// empty for requests that took no bucket, and an empty outcome only tracks
+// the bucket without counting the request.
+func (rl *RateLimiter) record(endpoint, identity, key string, limit domain.RateLimit, outcome string) {
+	if rl.requests != nil && outcome != "" {
+		label := identity
+		if strings.HasPrefix(identity, "ip:") {
+			label = "ip"
+		}
+		rl.requests.WithLabelValues(endpoint, label, outcome).Inc()
+	}
+
+	now := rl.now()
+	// One request a minute prunes idle callers
+	if last := rl.lastPrune.Load(); now.UnixNano()-last > int64(clientPruneInterval) &&
+		rl.lastPrune.CompareAndSwap(last, now.UnixNano()) {
+		rl.clients.RemoveIf(func(_ string, client *clientStats) bool {
+			return now.Sub(client.lastSeen) > clientIdleTTL
+		})
+	}
+
+	rl.clients.Update(identity, func(client *clientStats, exists bool) (*clientStats, bool) {
+		if !exists {
+			client = &clientStats{buckets: make(map[string]bucketRef)}
+		}
+		client.lastSeen = now
+		if key != "" {
+			client.buckets[key] = bucketRef{endpoint: endpoint, limit: limit}
+		}
+		switch outcome {
+		case "":
+		case outcomeLimited, outcomeOverQuota, outcomeDenied:
+			client.denied++
+		default:
+			client.allowed++
+		}
+		return client, true
+	})
+}
+
+// exempted reports whether identity is exempt from rate limits. A failed
+// lookup is logged and treated as no exemption.
+func (rl *RateLimiter) exempted(ctx context.Context, identity string) bool {
+	if rl.config.StoreTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rl.config.StoreTimeout)
+		defer cancel()
+	}
+	until, err := rl.store.Exemption(ctx, identity, rl.now())
+	if err != nil {
+		log.Printf("Failed to check rate limit exemption: %v", err)
+		return false
+	}
+	return !until.IsZero()
+}
+
+// HotClients returns up to n callers seen by this replica in the last hour,
+// those that made the most requests first. Buckets are not included. With a
+// maximum number of clients, only the most recently seen ones are known.
+func (rl *RateLimiter) HotClients(n int) []domain.RateLimitClient {
+	var clients []domain.RateLimitClient
+	rl.clients.Range(func(identity string, stats *clientStats) {
+		clients = append(clients, domain.RateLimitClient{
+			Identity: identity,
+			Allowed:  stats.allowed,
+			Denied:   stats.denied,
+			LastSeen: stats.lastSeen,
+		})
+	})
+
+	sort.Slice(clients, func(i, j int) bool {
+		a, b := clients[i], clients[j]
+		if ta, tb := a.Allowed+a.Denied, b.Allowed+b.Denied; ta != tb {
+			return ta > tb
+		}
+		return a.Identity < b.Identity
+	})
+	if n > 0 && len(clients) > n {
+		clients = clients[:n]
+	}
+	return clients
+}
+
+// Client returns a caller with the current state of the buckets it used on
+// this replica and its exemption. It returns domain.ErrRateLimitClientNotFound
+// for callers this replica has not seen that are not exempt either.
+func (rl *RateLimiter) Client(ctx context.Context, identity string) (*domain.RateLimitClient, error) {
+	now := rl.now()
+	client, buckets := rl.client(identity)
+
+	until, err := rl.store.Exemption(ctx, identity, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rate limit exemption: %w", err)
+	}
+	if !until.IsZero() {
+		client.ExemptUntil = &until
+	}
+	if buckets == nil && client.ExemptUntil == nil {
+		return nil, fmt.Errorf("%w: %s", domain.ErrRateLimitClientNotFound, identity)
+	}
+
+	for key, ref := range buckets {
+		result, err := rl.store.Peek(ctx, key, ref.limit, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get rate limit bucket: %w", err)
+		}
+		client.Buckets = append(client.Buckets, domain.RateLimitBucket{
+			Endpoint:     ref.endpoint,
+			Algorithm:    ref.limit.Algorithm,
+			Limit:        result.Limit,
+			Remaining:    result.Remaining,
+			ResetSeconds: ceilSeconds(result.Reset),
+		})
+	}
+	sort.Slice(client.Buckets, func(i, j int) bool {
+		return client.Buckets[i].Endpoint < client.Buckets[j].Endpoint
+	})
+	return client, nil
+}
+
+// ResetClient fills every bucket the caller used on this replica. It returns
+// domain.ErrRateLimitClientNotFound for callers this replica has not seen.
+func (rl *RateLimiter) ResetClient(ctx context.Context, identity string) error {
+	_, buckets := rl.client(identity)
+	if buckets == nil {
+		return fmt.Errorf("%w: %s", domain.ErrRateLimitClientNotFound, identity)
+	}
+
+	now := rl.now()
+	for key, ref := range buckets {
+		if err := rl.store.Reset(ctx, key, ref.limit, now); err != nil {
+			return fmt.Errorf("failed to reset rate limit bucket: %w", err)
+		}
+	}
+	return nil
+}
+
+// Exempt exempts a caller from rate limits and quotas for the given
+// duration, whether or not it has been seen, and returns when the exemption
+// ends. Exemptions are kept by the store, so shared stores share them.
+func (rl *RateLimiter) Exempt(ctx context.Context, identity string, d time.Duration) (time.Time, error) {
+	until := rl.now().Add(d).UTC()
+	if err := rl.store.Exempt(ctx, identity, until); err != nil {
+		return time.Time{}, fmt.Errorf("failed to exempt rate limit client: %w", err)
+	}
+	return until, nil
+}
+
+// RemoveExemption ends the exemption of a caller. Removing an exemption that
+// does not exist is a no-op.
+func (rl *RateLimiter) RemoveExemption(ctx context.Context, identity string) error {
+	if err := rl.store.Exempt(ctx, identity, time.Time{}); err != nil {
+		return fmt.Errorf("failed to remove rate limit exemption: %w", err)
+	}
+	return nil
+}
+
+// client returns a copy of the stats of a caller and of its buckets, which
+// are nil if the caller is not tracked
+func (rl *RateLimiter) client(identity string) (*domain.RateLimitClient, map[string]bucketRef) {
+	client := &domain.RateLimitClient{Identity: identity}
+	var buckets map[string]bucketRef
+
+	rl.clients.Update(identity, func(stats *clientStats, exists bool) (*clientStats, bool) {
+		if exists {
+			client.Allowed = stats.allowed
+			client.Denied = stats.denied
+			client.LastSeen = stats.lastSeen
+			buckets = make(map[string]bucketRef, len(stats.buckets))
+			for key, ref := range stats.buckets {
+				buckets[key] = ref
+			}
+		}
+		return stats, false
+	})
+	return client, buckets
+}
@@ -0,0 +1,180 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/snavarro/microtracker/config"
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/snavarro/microtracker/internal/repository/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupStatsRouter(opts ...RateLimiterOption) (*gin.Engine, *RateLimiter) {
+	gin.SetMode(gin.TestMode)
+	limiter := NewRateLimiter(memory.NewRateLimitStore(), &config.RateLimitConfig{
+		Default:  config.EndpointRateLimit{RequestsPerMinute: 60, BurstSize: 2, TTLMinutes: 5},
+		Identity: []string{config.IdentityPrincipal},
+	}, opts...)
+
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if id := c.GetHeader("X-Principal"); id != "" {
+			c.Set(principalKey, &domain.Principal{ID: id})
+		}
+	}, limiter.RateLimit())
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/api/v1/packages", ok)
+	router.GET("/api/v1/packages/:id", ok)
+	return router, limiter
+}
+
+func statsRequest(router *gin.Engine, path, principal string) int {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	if principal != "" {
+		req.Header.Set("X-Principal", principal)
+	}
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestRateLimit_Metrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	router, _ := setupStatsRouter(WithMetrics(reg))
+
+	for i := 0; i < 3; i++ {
+		statsRequest(router, "/api/v1/packages", "k1")
+	}
+	statsRequest(router, "/api/v1/packages/P1", "")
+
+	expected := `
+# HELP microtracker_rate_limit_requests_total Requests checked by the rate limiter by endpoint, caller and outcome.
+# TYPE microtracker_rate_limit_requests_total counter
+microtracker_rate_limit_requests_total{endpoint="GET:/api/v1/packages",identity="principal:k1",outcome="allowed"} 2
+microtracker_rate_limit_requests_total{endpoint="GET:/api/v1/packages",identity="principal:k1",outcome="limited"} 1
+microtracker_rate_limit_requests_total{endpoint="GET:/api/v1/packages/:id",identity="ip",outcome="allowed"} 1
+# HELP microtracker_rate_limit_clients Callers seen by this replica in the last hour.
+# TYPE microtracker_rate_limit_clients gauge
+microtracker_rate_limit_clients 2
+# HELP microtracker_rate_limit_buckets Rate limit buckets kept by the store, or used by this replica for shared stores.
+# TYPE microtracker_rate_limit_buckets gauge
+microtracker_rate_limit_buckets 2
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expected)))
+}
+
+func TestRateLimit_Exemption(t *testing.T) {
+	ctx := context.Background()
+	router, limiter := setupStatsRouter()
+
+	for _, principal := range []string{"k1", "k2"} {
+		statsRequest(router, "/api/v1/packages", principal)
+		statsRequest(router, "/api/v1/packages", principal)
+		require.Equal(t, http.StatusTooManyRequests, statsRequest(router, "/api/v1/packages", principal))
+	}
+
+	until, err := limiter.Exempt(ctx, "principal:k1", time.Hour)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), until, time.Second)
+	assert.Equal(t, http.StatusOK, statsRequest(router, "/api/v1/packages", "k1"), "exempt callers are let through")
+	assert.Equal(t, http.StatusTooManyRequests, statsRequest(router, "/api/v1/packages", "k2"), "other callers are not")
+
+	require.NoError(t, limiter.RemoveExemption(ctx, "principal:k1"))
+	assert.Equal(t, http.StatusTooManyRequests, statsRequest(router, "/api/v1/packages", "k1"))
+}
+
+func TestRateLimiter_Clients(t *testing.T) {
+	ctx := context.Background()
+	router, limiter := setupStatsRouter()
+
+	for i := 0; i < 3; i++ {
+		statsRequest(router, "/api/v1/packages", "k1")
+	}
+	statsRequest(router, "/api/v1/packages/P1", "k1")
+	statsRequest(router, "/api/v1/packages", "k2")
+
+	t.Run("hot clients", func(t *testing.T) {
+		clients := limiter.HotClients(10)
+		require.Len(t, clients, 2)
+		assert.Equal(t, "principal:k1", clients[0].Identity)
+		assert.EqualValues(t, 3, clients[0].Allowed)
+		assert.EqualValues(t, 1, clients[0].Denied)
+		assert.Equal(t, "principal:k2", clients[1].Identity)
+
+		assert.Len(t, limiter.HotClients(1), 1)
+	})
+
+	t.Run("client", func(t *testing.T) {
+		client, err := limiter.Client(ctx, "principal:k1")
+		require.NoError(t, err)
+		assert.Nil(t, client.ExemptUntil)
+		require.Len(t, client.Buckets, 2)
+		assert.Equal(t, "GET:/api/v1/packages", client.Buckets[0].Endpoint)
+		assert.Equal(t, domain.AlgorithmTokenBucket, client.Buckets[0].Algorithm)
+		assert.Equal(t, 2, client.Buckets[0].Limit)
+		assert.Equal(t, 0, client.Buckets[0].Remaining)
+		assert.Equal(t, 2, client.Buckets[0].ResetSeconds)
+		assert.Equal(t, "GET:/api/v1/packages/:id", client.Buckets[1].Endpoint)
+		assert.Equal(t, 1, client.Buckets[1].Remaining)
+	})
+
+	t.Run("reset", func(t *testing.T) {
+		require.NoError(t, limiter.ResetClient(ctx, "principal:k1"))
+		client, err := limiter.Client(ctx, "principal:k1")
+		require.NoError(t, err)
+		assert.Equal(t, 2, client.Buckets[0].Remaining)
+		assert.Equal(t, http.StatusOK, statsRequest(router, "/api/v1/packages", "k1"))
+	})
+
+	t.Run("unknown client", func(t *testing.T) {
+		_, err := limiter.Client(ctx, "principal:k3")
+		assert.ErrorIs(t, err, domain.ErrRateLimitClientNotFound)
+		assert.ErrorIs(t, limiter.ResetClient(ctx, "principal:k3"), domain.ErrRateLimitClientNotFound)
+
+		_, err = limiter.Exempt(ctx, "principal:k3", time.Minute)
+		require.NoError(t, err)
+		client, err := limiter.Client(ctx, "principal:k3")
+		require.NoError(t, err, "exempt callers are found before they are seen")
+		assert.NotNil(t, client.ExemptUntil)
+		assert.Empty(t, client.Buckets)
+	})
+
+	t.Run("idle clients are forgotten", func(t *testing.T) {
+		now := time.Now().Add(2 * time.Hour)
+		limiter.now = func() time.Time { return now }
+		statsRequest(router, "/api/v1/packages", "k2")
+
+		clients := limiter.HotClients(0)
+		require.Len(t, clients, 1)
+		assert.Equal(t, "principal:k2", clients[0].Identity)
+	})
+}
+
+func TestRateLimiter_MaxClients(t *testing.T) {
+	limiter := NewRateLimiter(memory.NewRateLimitStore(), &config.RateLimitConfig{
+		Default:    config.EndpointRateLimit{RequestsPerMinute: 60, BurstSize: 2, TTLMinutes: 5},
+		MaxClients: 50,
+	})
+	router := gin.New()
+	router.Use(limiter.RateLimit())
+	router.GET("/api/v1/packages", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for i := 0; i < 500; i++ {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/packages", nil)
+		req.RemoteAddr = fmt.Sprintf("198.51.%d.%d:1234", i/256, i%256)
+		router.ServeHTTP(w, req)
+	}
+	assert.Len(t, limiter.HotClients(0), 50, "the least recently seen clients are forgotten")
+}
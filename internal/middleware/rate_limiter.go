@@ -1,131 +1,544 @@
 package middleware
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"math"
 	"net/http"
-	"sync"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/snavarro/microtracker/config"
-	"golang.org/x/time/rate"
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/snavarro/microtracker/internal/lru"
 )
 
+// defaultBucket is the endpoint key of requests that match no route, so
+// that unknown paths cannot create a bucket each
+const defaultBucket = "default"
+
+// ipBucketPrefix starts the keys of the buckets of RateLimitIP, which are
+// kept apart from the buckets of RateLimit
+const ipBucketPrefix = "ip|"
+
+// bypassKey is the gin context key marking requests from an allowed network
+const bypassKey = "rateLimitBypass"
+
 // RateLimiter represents a rate limiter
 type RateLimiter struct {
 	config   *config.RateLimitConfig
-	limiters map[string]map[string]*rateLimiterInfo
-	mu       *sync.RWMutex
+	store    domain.RateLimitStore
+	usage    domain.UsageRepository
+	routes   []routeLimit
+	now      func() time.Time
+	requests *prometheus.CounterVec
+	// clients are the callers seen in the last hour, and lastPrune when
+	// idle ones were last removed in unix nanoseconds
+	clients   *lru.Cache[*clientStats]
+	lastPrune atomic.Int64
 }
 
-type rateLimiterInfo struct {
-	limiter    *rate.Limiter
-	lastAccess time.Time
-}
+// RateLimiterOption configures optional RateLimiter behaviour
+type RateLimiterOption func(*RateLimiter)
 
-// NewRateLimiter creates a new rate limiter
-func NewRateLimiter(cfg *config.RateLimitConfig) *RateLimiter {
-	limiter := &RateLimiter{
-		config:   cfg,
-		limiters: make(map[string]map[string]*rateLimiterInfo),
-		mu:       &sync.RWMutex{},
+// WithUsage counts the requests of every caller in usage and enforces the
+// daily and monthly quotas of their plan
+func WithUsage(usage domain.UsageRepository) RateLimiterOption {
+	return func(rl *RateLimiter) {
+		rl.usage = usage
 	}
+}
 
-	// Start cleanup routine
-	go limiter.cleanupLoop()
+// routeLimit is a configured limit for the routes matching a template
+type routeLimit struct {
+	// methods is empty when the limit applies to every method
+	methods  []string
+	segments []string
+	limit    config.EndpointRateLimit
+}
 
-	return limiter
+// WithClock sets the clock the limiter reads the time of requests from
+func WithClock(now func() time.Time) RateLimiterOption {
+	return func(rl *RateLimiter) {
+		rl.now = now
+	}
 }
 
-// cleanupLoop periodically removes old rate limiters
-func (rl *RateLimiter) cleanupLoop() {
-	ticker := time.NewTicker(5 * time.Minute)
-	defer ticker.Stop()
+// NewRateLimiter creates a new rate limiter keeping its buckets in store
+func NewRateLimiter(store domain.RateLimitStore, cfg *config.RateLimitConfig, opts ...RateLimiterOption) *RateLimiter {
+	rl := &RateLimiter{
+		config:  cfg,
+		store:   store,
+		routes:  parseRouteLimits(cfg.Endpoints),
+		now:     time.Now,
+		clients: lru.New[*clientStats](cfg.MaxClients, lru.DefaultShards),
+	}
+	for _, opt := range opts {
+		opt(rl)
+	}
+	return rl
+}
 
-	for range ticker.C {
-		rl.mu.Lock()
-		for endpoint, ipLimiters := range rl.limiters {
-			for ip, info := range ipLimiters {
-				if time.Since(info.lastAccess) > time.Duration(rl.getTTL(endpoint))*time.Minute {
-					delete(ipLimiters, ip)
+// parseRouteLimits parses endpoint keys such as "GET,HEAD:/api/v1/packages/:id"
+// and orders them so that the most specific match is found first: exact
+// templates before wildcards, and limits for listed methods before limits for
+// every method
+func parseRouteLimits(endpoints map[string]config.EndpointRateLimit) []routeLimit {
+	routes := make([]routeLimit, 0, len(endpoints))
+	for key, limit := range endpoints {
+		route := routeLimit{limit: limit}
+		template := key
+		if !strings.HasPrefix(key, "/") {
+			methods, rest, _ := strings.Cut(key, ":")
+			template = rest
+			for _, method := range strings.Split(methods, ",") {
+				method = strings.ToUpper(strings.TrimSpace(method))
+				if method == "*" {
+					route.methods = nil
+					break
 				}
-			}
-			if len(ipLimiters) == 0 {
-				delete(rl.limiters, endpoint)
+				route.methods = append(route.methods, method)
 			}
 		}
-		rl.mu.Unlock()
+		route.segments = strings.Split(strings.TrimSpace(template), "/")
+		routes = append(routes, route)
 	}
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		a, b := routes[i], routes[j]
+		if wa, wb := a.wildcards(), b.wildcards(); wa != wb {
+			return wa < wb
+		}
+		if (len(a.methods) == 0) != (len(b.methods) == 0) {
+			return len(a.methods) > 0
+		}
+		return strings.Join(a.segments, "/") < strings.Join(b.segments, "/")
+	})
+	return routes
 }
 
-// getLimiter returns the rate limiter for the given endpoint and IP
-func (rl *RateLimiter) getLimiter(endpoint, ip string) *rateLimiterInfo {
-	rl.mu.Lock()
-	defer rl.mu.Unlock()
+func (r routeLimit) wildcards() int {
+	n := 0
+	for _, segment := range r.segments {
+		if segment == "*" {
+			n++
+		}
+	}
+	return n
+}
+
+// matches reports whether the limit applies to a route template as returned
+// by gin's FullPath. A * segment matches any single segment.
+func (r routeLimit) matches(method, route string) bool {
+	if len(r.methods) > 0 {
+		found := false
+		for _, m := range r.methods {
+			if m == method {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
 
-	if _, exists := rl.limiters[endpoint]; !exists {
-		rl.limiters[endpoint] = make(map[string]*rateLimiterInfo)
+	segments := strings.Split(route, "/")
+	if len(segments) != len(r.segments) {
+		return false
 	}
+	for i, segment := range r.segments {
+		if segment != "*" && segment != segments[i] {
+			return false
+		}
+	}
+	return true
+}
 
-	info, exists := rl.limiters[endpoint][ip]
-	if !exists {
-		info = &rateLimiterInfo{
-			limiter: rate.NewLimiter(
-				rate.Limit(float64(rl.getRequestsPerMinute(endpoint))/60.0),
-				rl.getBurstSize(endpoint),
-			),
-			lastAccess: time.Now(),
+// getLimit returns the limit of the given method and route template for a
+// caller on plan. Routes without a configured limit and requests that matched
+// no route get the plan's limit, or the default limit without a plan. Routes
+// with a limit of their own get it scaled by the ratio of the plan's limit to
+// the default limit, so that a plan twice the default allows twice as much on
+// every route.
+func (rl *RateLimiter) getLimit(method, route, plan string) config.EndpointRateLimit {
+	p, hasPlan := rl.config.Plans[plan]
+	if route != "" {
+		for _, r := range rl.routes {
+			if r.matches(method, route) {
+				limit := r.limit
+				if hasPlan {
+					limit.RequestsPerMinute = scale(limit.RequestsPerMinute, p.RequestsPerMinute, rl.config.Default.RequestsPerMinute)
+					limit.BurstSize = scale(limit.BurstSize, p.BurstSize, rl.config.Default.BurstSize)
+				}
+				return limit
+			}
 		}
-		rl.limiters[endpoint][ip] = info
 	}
 
-	info.lastAccess = time.Now()
-	return info
+	limit := rl.config.Default
+	if hasPlan {
+		limit.RequestsPerMinute = p.RequestsPerMinute
+		limit.BurstSize = p.BurstSize
+	}
+	return limit
 }
 
-// getRequestsPerMinute returns the requests per minute for the given endpoint
-func (rl *RateLimiter) getRequestsPerMinute(endpoint string) int {
-	if limit, exists := rl.config.Endpoints[endpoint]; exists {
-		return limit.RequestsPerMinute
+// scale returns n scaled by to/from, and at least 1
+func scale(n, to, from int) int {
+	if from <= 0 {
+		return n
 	}
-	return rl.config.Default.RequestsPerMinute
+	return max(n*to/from, 1)
 }
 
-// getBurstSize returns the burst size for the given endpoint
-func (rl *RateLimiter) getBurstSize(endpoint string) int {
-	if limit, exists := rl.config.Endpoints[endpoint]; exists {
-		return limit.BurstSize
+// identity returns what identifies the caller, following the configured
+// identities in order: the authenticated principal, the tenant, and finally
+// the client IP, which is always known and only taken from a header sent by a
+// trusted proxy (see ConfigureClientIP)
+func (rl *RateLimiter) identity(c *gin.Context) string {
+	principal, authenticated := GetPrincipal(c)
+	authenticated = authenticated && principal != anonymous
+
+	for _, kind := range rl.config.Identity {
+		switch kind {
+		case config.IdentityPrincipal:
+			if authenticated {
+				return "principal:" + principal.ID
+			}
+		case config.IdentityTenant:
+			// Only a tenant the principal is bound to, as the one platform
+			// and anonymous callers name in X-Tenant-ID is theirs to choose
+			if authenticated && principal.Tenant != "" {
+				return "tenant:" + principal.Tenant
+			}
+		}
 	}
-	return rl.config.Default.BurstSize
+	return "ip:" + c.ClientIP()
 }
 
-// getTTL returns the TTL in minutes for the given endpoint
-func (rl *RateLimiter) getTTL(endpoint string) int {
-	if limit, exists := rl.config.Endpoints[endpoint]; exists {
-		return limit.TTLMinutes
+// plan returns the plan of the caller. Callers without a plan, or with a plan
+// that is not configured, get the default plan.
+func (rl *RateLimiter) plan(c *gin.Context) string {
+	if principal, ok := GetPrincipal(c); ok {
+		if _, exists := rl.config.Plans[principal.Plan]; exists {
+			return principal.Plan
+		}
 	}
-	return rl.config.Default.TTLMinutes
+	return rl.config.DefaultPlan
 }
 
-// RateLimit returns a gin middleware for rate limiting
-func (rl *RateLimiter) RateLimit() gin.HandlerFunc {
+// Networks returns a gin middleware that rejects clients in a denied network
+// with 403 and marks clients in an allowed network so that RateLimit lets
+// them through. It runs ahead of authentication, so that a denied network is
+// turned away before any credential is looked up.
+func (rl *RateLimiter) Networks() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		endpoint := fmt.Sprintf("%s:%s", c.Request.Method, c.Request.URL.Path)
+		if len(rl.config.DenyCIDRs) == 0 && len(rl.config.AllowCIDRs) == 0 {
+			c.Next()
+			return
+		}
 		ip := c.ClientIP()
-		info := rl.getLimiter(endpoint, ip)
-
-		if !info.limiter.Allow() {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error":    "Rate limit exceeded",
-				"success":  false,
-				"endpoint": endpoint,
-				"limit":    rl.getRequestsPerMinute(endpoint),
-				"burst":    rl.getBurstSize(endpoint),
-			})
-			c.Abort()
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			c.Next()
 			return
 		}
 
+		addr = addr.Unmap()
+		switch {
+		case containsAddr(rl.config.DenyCIDRs, addr):
+			rl.record(endpointOf(c), "ip:"+ip, "", domain.RateLimit{}, outcomeDenied)
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":   "Forbidden",
+				"success": false,
+			})
+			return
+		case containsAddr(rl.config.AllowCIDRs, addr):
+			rl.record(endpointOf(c), "ip:"+ip, "", domain.RateLimit{}, outcomeBypassed)
+			c.Set(bypassKey, true)
+		}
 		c.Next()
 	}
 }
+
+// endpointOf returns the endpoint key of a request: its method and route
+// template, or defaultBucket for requests that matched no route
+func endpointOf(c *gin.Context) string {
+	if route := c.FullPath(); route != "" {
+		return fmt.Sprintf("%s:%s", c.Request.Method, route)
+	}
+	return defaultBucket
+}
+
+// RateLimit returns a gin middleware for rate limiting. Requests are counted
+// per caller and route template, so every package ID shares the bucket of
+// its route; callers are identified as configured and default to their IP.
+// Every response carries RateLimit-Limit (the burst size),
+// RateLimit-Remaining and RateLimit-Reset headers, and rejected requests get
+// 429 with Retry-After. With usage tracking, requests over the daily or
+// monthly quota of the caller's plan are rejected as well. Exempt callers
+// are let through instead of being rejected. While a store is unavailable
+// requests are let through, or rejected with 503 if the limiter fails closed.
+//
+// Clients that Networks found in an allowed network bypass the limiter.
+// Routes in shadow mode log the requests they would reject and let them
+// through without RateLimit headers, and routes that are off are not limited.
+//
+// Callers can only be identified by principal or tenant when it runs after
+// Auth.Authenticate and Tenant.
+func (rl *RateLimiter) RateLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool(bypassKey) {
+			c.Next()
+			return
+		}
+		route := c.FullPath()
+		endpoint := endpointOf(c)
+		identity := rl.identity(c)
+
+		plan := rl.plan(c)
+		limit := rl.getLimit(c.Request.Method, route, plan)
+		if limit.Mode == config.RateLimitModeOff {
+			c.Next()
+			return
+		}
+		shadow := limit.Mode == config.RateLimitModeShadow
+
+		key := endpoint + "|" + identity
+		outcome, lease := rl.limitBucket(c, key, endpoint, identity, limit)
+		if lease != "" {
+			defer rl.release(c.Request.Context(), key, limit, lease)
+		}
+		if outcome == outcomeAllowed && rl.usage != nil {
+			outcome = rl.checkQuota(c, identity, plan, shadow)
+		}
+
+		rl.record(endpoint, identity, key, rateLimit(limit), outcome)
+		if !c.IsAborted() {
+			c.Next()
+		}
+	}
+}
+
+// RateLimitIP returns a gin middleware that limits the requests of every
+// client IP on every route, including requests without valid credentials
+// and routes outside the API, with the IP limit. It runs ahead of
+// authentication as a ceiling per address, keeping buckets of its own apart
+// from those of RateLimit, so it should be set above the limits of the
+// callers that share an address. Clients that Networks found in an allowed
+// network bypass it.
+func (rl *RateLimiter) RateLimitIP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := rl.config.IP
+		if c.GetBool(bypassKey) || limit.Mode == config.RateLimitModeOff {
+			c.Next()
+			return
+		}
+		endpoint := endpointOf(c)
+		identity := "ip:" + c.ClientIP()
+
+		key := ipBucketPrefix + endpoint + "|" + identity
+		outcome, lease := rl.limitBucket(c, key, endpoint, identity, limit)
+		if lease != "" {
+			defer rl.release(c.Request.Context(), key, limit, lease)
+		}
+
+		// RateLimit counts the requests this lets through. Its buckets and
+		// rejections are told apart by the prefix of their endpoint.
+		if outcome == outcomeAllowed {
+			outcome = ""
+		}
+		rl.record(ipBucketPrefix+endpoint, identity, key, rateLimit(limit), outcome)
+		if !c.IsAborted() {
+			c.Next()
+		}
+	}
+}
+
+// limitBucket takes a token of the bucket with the given key and rejects the
+// request with 429 once it is empty, unless the limit is in shadow mode or
+// the caller is exempt. It returns the outcome of the request and the lease
+// of a concurrency slot, if any, to release once the request completes.
+func (rl *RateLimiter) limitBucket(c *gin.Context, key, endpoint, identity string, limit config.EndpointRateLimit) (outcome, lease string) {
+	shadow := limit.Mode == config.RateLimitModeShadow
+	result, err := rl.take(c.Request.Context(), key, limit)
+	switch {
+	case err != nil:
+		rl.storeFailed(c, err)
+		return outcomeError, ""
+
+	case !result.Allowed && shadow:
+		log.Printf("Rate limit shadow mode: would reject %s on %s (%s, %d/min, burst %d)",
+			identity, endpoint, rateLimit(limit).Algorithm, limit.RequestsPerMinute, limit.BurstSize)
+		return outcomeShadowLimited, ""
+
+	case !result.Allowed:
+		if rl.exempted(c.Request.Context(), identity) {
+			result.RetryAfter = 0
+			setRateLimitHeaders(c, result)
+			return outcomeExempt, ""
+		}
+		setRateLimitHeaders(c, result)
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+			"error":     "Rate limit exceeded",
+			"success":   false,
+			"endpoint":  endpoint,
+			"algorithm": rateLimit(limit).Algorithm,
+			"limit":     limit.RequestsPerMinute,
+			"burst":     limit.BurstSize,
+		})
+		return outcomeLimited, ""
+	}
+
+	if !shadow {
+		setRateLimitHeaders(c, result)
+	}
+	return outcomeAllowed, result.Lease
+}
+
+// checkQuota counts the request in the caller's daily and monthly usage and
+// rejects it with 429 once either exceeds the quota of the caller's plan,
+// unless the caller is exempt or the route is in shadow mode. Rejected
+// requests are counted too. It returns the outcome of the request, which is
+// aborted unless it may continue.
+func (rl *RateLimiter) checkQuota(c *gin.Context, identity, plan string, shadow bool) string {
+	now := rl.now().UTC()
+	ctx := c.Request.Context()
+	if rl.config.StoreTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rl.config.StoreTimeout)
+		defer cancel()
+	}
+
+	expiresAt := domain.MonthEnd(now).Add(rl.config.UsageRetention)
+	counts, err := rl.usage.Increment(ctx, identity, expiresAt, domain.DailyPeriod(now), domain.MonthlyPeriod(now))
+	if err != nil {
+		rl.storeFailed(c, err)
+		return outcomeError
+	}
+
+	quota := rl.config.Plans[plan]
+	daily := quota.DailyQuota > 0 && counts[0] > quota.DailyQuota
+	monthly := quota.MonthlyQuota > 0 && counts[1] > quota.MonthlyQuota
+	if !daily && !monthly {
+		return outcomeAllowed
+	}
+	if shadow {
+		log.Printf("Rate limit shadow mode: would reject %s over the quota of plan %s", identity, plan)
+		return outcomeShadowOverQuota
+	}
+	if rl.exempted(c.Request.Context(), identity) {
+		return outcomeExempt
+	}
+
+	if daily {
+		nextDay := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		rejectOverQuota(c, "Daily quota exceeded", plan, quota.DailyQuota, nextDay.Sub(now))
+	} else {
+		nextMonth := time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+		rejectOverQuota(c, "Monthly quota exceeded", plan, quota.MonthlyQuota, nextMonth.Sub(now))
+	}
+	return outcomeOverQuota
+}
+
+// rejectOverQuota rejects a request with 429 until the quota's period ends
+func rejectOverQuota(c *gin.Context, message, plan string, quota int64, retryAfter time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(ceilSeconds(retryAfter)))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"error":   message,
+		"success": false,
+		"plan":    plan,
+		"quota":   quota,
+	})
+}
+
+// storeFailed handles an unavailable store: the request may continue if the
+// limiter fails open and is rejected with 503 otherwise. It reports whether
+// the request may continue.
+func (rl *RateLimiter) storeFailed(c *gin.Context, err error) bool {
+	log.Printf("Failed to check rate limit: %v", err)
+	if rl.config.FailOpen {
+		return true
+	}
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+		"error":   "Rate limiter unavailable",
+		"success": false,
+	})
+	return false
+}
+
+// take spends a token of the bucket with the given key
+func (rl *RateLimiter) take(ctx context.Context, key string, limit config.EndpointRateLimit) (*domain.RateLimitResult, error) {
+	if rl.config.StoreTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rl.config.StoreTimeout)
+		defer cancel()
+	}
+	return rl.store.Take(ctx, key, rateLimit(limit), rl.now())
+}
+
+// release frees the concurrency slot of a completed request. It runs even
+// when the client has gone away, so the slot is not held until it expires.
+func (rl *RateLimiter) release(ctx context.Context, key string, limit config.EndpointRateLimit, lease string) {
+	ctx = context.WithoutCancel(ctx)
+	if rl.config.StoreTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rl.config.StoreTimeout)
+		defer cancel()
+	}
+	if err := rl.store.Release(ctx, key, rateLimit(limit), lease); err != nil {
+		log.Printf("Failed to release rate limit slot: %v", err)
+	}
+}
+
+// containsAddr reports whether addr is in any of prefixes
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimit returns the store limit of a configured limit
+func rateLimit(limit config.EndpointRateLimit) domain.RateLimit {
+	algorithm := domain.RateLimitAlgorithm(limit.Algorithm)
+	if algorithm == "" {
+		algorithm = domain.AlgorithmTokenBucket
+	}
+	return domain.RateLimit{
+		Algorithm:         algorithm,
+		RequestsPerMinute: limit.RequestsPerMinute,
+		Burst:             limit.BurstSize,
+		TTL:               time.Duration(limit.TTLMinutes) * time.Minute,
+	}
+}
+
+// setRateLimitHeaders sets the RateLimit headers of the IETF draft
+// (draft-ietf-httpapi-ratelimit-headers) and, for rejected requests,
+// Retry-After. Durations are rounded up to whole seconds so that clients
+// waiting for them are not rejected again.
+func setRateLimitHeaders(c *gin.Context, result *domain.RateLimitResult) {
+	c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	if result.RetryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,625 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/snavarro/microtracker/config"
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/snavarro/microtracker/internal/repository/memory"
+	"github.com/stretchr/testify/assert"
+)
+
+// keyRecorder records the keys of the buckets taken from a store
+type keyRecorder struct {
+	domain.RateLimitStore
+	keys map[string]bool
+}
+
+func (r *keyRecorder) Take(ctx context.Context, key string, limit domain.RateLimit, now time.Time) (*domain.RateLimitResult, error) {
+	r.keys[key] = true
+	return r.RateLimitStore.Take(ctx, key, limit, now)
+}
+
+// failingStore is a store that is unavailable
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, limit domain.RateLimit, now time.Time) (*domain.RateLimitResult, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (failingStore) Release(ctx context.Context, key string, limit domain.RateLimit, lease string) error {
+	return errors.New("connection refused")
+}
+
+func (failingStore) Peek(ctx context.Context, key string, limit domain.RateLimit, now time.Time) (*domain.RateLimitResult, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (failingStore) Reset(ctx context.Context, key string, limit domain.RateLimit, now time.Time) error {
+	return errors.New("connection refused")
+}
+
+func (failingStore) Exempt(ctx context.Context, identity string, until time.Time) error {
+	return errors.New("connection refused")
+}
+
+func (failingStore) Exemption(ctx context.Context, identity string, now time.Time) (time.Time, error) {
+	return time.Time{}, errors.New("connection refused")
+}
+
+func setupRateLimitRouter(cfg *config.RateLimitConfig) (*gin.Engine, *keyRecorder) {
+	return setupRateLimitRouterWithStore(cfg, memory.NewRateLimitStore())
+}
+
+func setupRateLimitRouterWithStore(cfg *config.RateLimitConfig, store domain.RateLimitStore) (*gin.Engine, *keyRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := &keyRecorder{RateLimitStore: store, keys: make(map[string]bool)}
+	limiter := NewRateLimiter(recorder, cfg)
+
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router := gin.New()
+	router.Use(limiter.Networks(), limiter.RateLimit())
+	router.GET("/api/v1/packages", ok)
+	router.GET("/api/v1/packages/search", ok)
+	router.GET("/api/v1/packages/:id", ok)
+	router.PUT("/api/v1/packages/:id", ok)
+	router.DELETE("/api/v1/packages/:id", ok)
+	router.POST("/api/v1/packages/:id/events", ok)
+	return router, recorder
+}
+
+func limitedRequest(router *gin.Engine, method, path string) int {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	router.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestRateLimit_RouteTemplates(t *testing.T) {
+	router, store := setupRateLimitRouter(&config.RateLimitConfig{
+		Default: config.EndpointRateLimit{RequestsPerMinute: 60, BurstSize: 5, TTLMinutes: 5},
+		Endpoints: map[string]config.EndpointRateLimit{
+			"GET:/api/v1/packages/:id": {RequestsPerMinute: 60, BurstSize: 2, TTLMinutes: 5},
+		},
+	})
+
+	assert.Equal(t, http.StatusOK, limitedRequest(router, http.MethodGet, "/api/v1/packages/ABC"))
+	assert.Equal(t, http.StatusOK, limitedRequest(router, http.MethodGet, "/api/v1/packages/XYZ"))
+	assert.Equal(t, http.StatusTooManyRequests, limitedRequest(router, http.MethodGet, "/api/v1/packages/NEW"),
+		"package IDs share the bucket of their route")
+	assert.Equal(t, http.StatusOK, limitedRequest(router, http.MethodPut, "/api/v1/packages/ABC"),
+		"other methods have their own bucket")
+
+	assert.Equal(t, map[string]bool{
+		"GET:/api/v1/packages/:id|ip:192.0.2.1": true,
+		"PUT:/api/v1/packages/:id|ip:192.0.2.1": true,
+	}, store.keys)
+}
+
+func TestRateLimit_UnmatchedRoutes(t *testing.T) {
+	router, store := setupRateLimitRouter(&config.RateLimitConfig{
+		Default: config.EndpointRateLimit{RequestsPerMinute: 60, BurstSize: 3, TTLMinutes: 5},
+	})
+
+	for _, path := range []string{"/a", "/b", "/c"} {
+		assert.Equal(t, http.StatusNotFound, limitedRequest(router, http.MethodGet, path))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, limitedRequest(router, http.MethodGet, "/d"))
+	assert.Equal(t, map[string]bool{defaultBucket + "|ip:192.0.2.1": true}, store.keys)
+}
+
+func TestRateLimitIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := &keyRecorder{RateLimitStore: memory.NewRateLimitStore(), keys: make(map[string]bool)}
+	limiter := NewRateLimiter(recorder, &config.RateLimitConfig{
+		Default:    config.EndpointRateLimit{RequestsPerMinute: 60, BurstSize: 5, TTLMinutes: 5},
+		IP:         config.EndpointRateLimit{RequestsPerMinute: 60, BurstSize: 2, TTLMinutes: 5},
+		AllowCIDRs: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	})
+	auth := NewAuth(fakeAuthenticator{}, &config.AuthConfig{Enabled: true})
+
+	router := gin.New()
+	router.Use(limiter.Networks(), limiter.RateLimitIP())
+	router.GET("/api/v1/packages", auth.Authenticate(), limiter.RateLimit(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	request := func(path, remoteAddr string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set(APIKeyHeader, "guessed")
+		req.RemoteAddr = remoteAddr
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, request("/api/v1/packages", "192.0.2.1:1234"))
+	assert.Equal(t, http.StatusUnauthorized, request("/api/v1/packages", "192.0.2.1:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, request("/api/v1/packages", "192.0.2.1:1234"),
+		"requests with invalid credentials are limited")
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusNotFound, request("/swagger/"+fmt.Sprint(i), "192.0.2.1:1234"))
+	}
+	assert.Equal(t, http.StatusTooManyRequests, request("/unknown", "192.0.2.1:1234"), "unknown paths share a bucket")
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusUnauthorized, request("/api/v1/packages", "10.0.0.1:1234"), "allowed networks bypass it")
+	}
+	assert.Equal(t, map[string]bool{
+		"ip|GET:/api/v1/packages|ip:192.0.2.1":  true,
+		"ip|" + defaultBucket + "|ip:192.0.2.1": true,
+	}, recorder.keys)
+}
+
+func TestRateLimitIP_CountsRequestsOnce(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	limiter := NewRateLimiter(memory.NewRateLimitStore(), &config.RateLimitConfig{
+		Default: config.EndpointRateLimit{RequestsPerMinute: 60, BurstSize: 1, TTLMinutes: 5},
+		IP:      config.EndpointRateLimit{RequestsPerMinute: 60, BurstSize: 10, TTLMinutes: 5},
+	})
+	router := gin.New()
+	router.Use(limiter.RateLimitIP(), limiter.RateLimit())
+	router.GET("/api/v1/packages", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	assert.Equal(t, http.StatusOK, limitedRequest(router, http.MethodGet, "/api/v1/packages"))
+	assert.Equal(t, http.StatusTooManyRequests, limitedRequest(router, http.MethodGet, "/api/v1/packages"),
+		"the caller's limit applies below the IP limit")
+
+	client, err := limiter.Client(context.Background(), "ip:192.0.2.1")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), client.Allowed)
+	assert.Equal(t, int64(1), client.Denied)
+	if assert.Len(t, client.Buckets, 2, "both buckets can be inspected and reset") {
+		assert.Equal(t, "GET:/api/v1/packages", client.Buckets[0].Endpoint)
+		assert.Equal(t, "ip|GET:/api/v1/packages", client.Buckets[1].Endpoint)
+	}
+}
+
+func TestRateLimiter_GetLimit(t *testing.T) {
+	limit := func(burst int) config.EndpointRateLimit {
+		return config.EndpointRateLimit{RequestsPerMinute: 60, BurstSize: burst, TTLMinutes: 5}
+	}
+	limiter := NewRateLimiter(memory.NewRateLimitStore(), &config.RateLimitConfig{
+		Default: limit(1),
+		Endpoints: map[string]config.EndpointRateLimit{
+			"GET:/api/v1/packages":            limit(2),
+			"GET:/api/v1/packages/:id":        limit(3),
+			"PUT, patch:/api/v1/packages/*":   limit(4),
+			"/api/v1/packages/*":              limit(5),
+			"*:/api/v1/packages/:id/events":   limit(6),
+			"GET:/api/v1/packages/*/events":   limit(7),
+			"/api/v1/admin/api-keys/*/rotate": limit(8),
+		},
+	})
+
+	tests := []struct {
+		method string
+		route  string
+		want   int
+	}{
+		{http.MethodGet, "/api/v1/packages", 2},
+		{http.MethodPost, "/api/v1/packages", 1},
+		{http.MethodGet, "/api/v1/packages/:id", 3},
+		{http.MethodPut, "/api/v1/packages/:id", 4},
+		{http.MethodPatch, "/api/v1/packages/:id", 4},
+		{http.MethodDelete, "/api/v1/packages/:id", 5},
+		{http.MethodGet, "/api/v1/packages/search", 5},
+		{http.MethodGet, "/api/v1/packages/:id/events", 6},
+		{http.MethodPost, "/api/v1/packages/:id/events", 6},
+		{http.MethodPost, "/api/v1/admin/api-keys/:id/rotate", 8},
+		{http.MethodPost, "/api/v1/admin/api-keys", 1},
+		{http.MethodGet, "", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.route, func(t *testing.T) {
+			assert.Equal(t, tt.want, limiter.getLimit(tt.method, tt.route, "").BurstSize)
+		})
+	}
+}
+
+func TestRateLimit_Headers(t *testing.T) {
+	router, _ := setupRateLimitRouter(&config.RateLimitConfig{
+		Default: config.EndpointRateLimit{RequestsPerMinute: 60, BurstSize: 2, TTLMinutes: 5},
+	})
+
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/packages", nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	first := get()
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, "2", first.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", first.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "1", first.Header().Get("RateLimit-Reset"))
+	assert.Empty(t, first.Header().Get("Retry-After"))
+
+	second := get()
+	assert.Equal(t, "0", second.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "2", second.Header().Get("RateLimit-Reset"))
+
+	rejected := get()
+	assert.Equal(t, http.StatusTooManyRequests, rejected.Code)
+	assert.Equal(t, "0", rejected.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "1", rejected.Header().Get("Retry-After"))
+}
+
+func TestRateLimit_UnavailableStore(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		Default:  config.EndpointRateLimit{RequestsPerMinute: 60, BurstSize: 1, TTLMinutes: 5},
+		FailOpen: true,
+	}
+
+	router, _ := setupRateLimitRouterWithStore(cfg, failingStore{})
+	assert.Equal(t, http.StatusOK, limitedRequest(router, http.MethodGet, "/api/v1/packages"), "fail open")
+
+	cfg.FailOpen = false
+	router, _ = setupRateLimitRouterWithStore(cfg, failingStore{})
+	assert.Equal(t, http.StatusServiceUnavailable, limitedRequest(router, http.MethodGet, "/api/v1/packages"), "fail closed")
+}
+
+func TestRateLimit_Identity(t *testing.T) {
+	tests := []struct {
+		name      string
+		identity  []string
+		principal *domain.Principal
+		tenant    string
+		want      string
+	}{
+		{"IP by default", nil, &domain.Principal{ID: "k1"}, "", "ip:192.0.2.1"},
+		{"principal", []string{"principal", "ip"}, &domain.Principal{ID: "k1", Tenant: "acme"}, "", "principal:k1"},
+		{"tenant of the principal", []string{"tenant", "ip"}, &domain.Principal{ID: "k1", Tenant: "acme"}, "", "tenant:acme"},
+		{"requested tenant", []string{"tenant", "ip"}, &domain.Principal{ID: "k1"}, "globex", "ip:192.0.2.1"},
+		{"anonymous caller naming a tenant", []string{"tenant", "ip"}, anonymous, "globex", "ip:192.0.2.1"},
+		{"platform caller without a tenant", []string{"tenant", "ip"}, &domain.Principal{ID: "k1"}, "", "ip:192.0.2.1"},
+		{"anonymous caller", []string{"principal", "ip"}, anonymous, "", "ip:192.0.2.1"},
+		{"unauthenticated caller", []string{"principal", "tenant"}, nil, "", "ip:192.0.2.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &keyRecorder{RateLimitStore: memory.NewRateLimitStore(), keys: make(map[string]bool)}
+			limiter := NewRateLimiter(recorder, &config.RateLimitConfig{
+				Default:  config.EndpointRateLimit{RequestsPerMinute: 60, BurstSize: 5, TTLMinutes: 5},
+				Identity: tt.identity,
+			})
+
+			router := gin.New()
+			router.Use(func(c *gin.Context) {
+				if tt.principal != nil {
+					c.Set(principalKey, tt.principal)
+				}
+			}, Tenant(), limiter.RateLimit())
+			router.GET("/api/v1/packages", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/api/v1/packages", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			if tt.tenant != "" {
+				req.Header.Set(TenantHeader, tt.tenant)
+			}
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, map[string]bool{"GET:/api/v1/packages|" + tt.want: true}, recorder.keys)
+		})
+	}
+}
+
+func TestRateLimiter_PlanLimits(t *testing.T) {
+	limiter := NewRateLimiter(memory.NewRateLimitStore(), &config.RateLimitConfig{
+		Default: config.EndpointRateLimit{RequestsPerMinute: 60, BurstSize: 1, TTLMinutes: 5},
+		Endpoints: map[string]config.EndpointRateLimit{
+			"POST:/api/v1/packages": {RequestsPerMinute: 60, BurstSize: 2, TTLMinutes: 5},
+		},
+		Plans: map[string]config.PlanLimit{
+			"free": {RequestsPerMinute: 30, BurstSize: 10},
+			"pro":  {RequestsPerMinute: 600, BurstSize: 100},
+		},
+		DefaultPlan: "free",
+	})
+
+	withPlan := func(plan string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Set(principalKey, &domain.Principal{ID: "k1", Plan: plan})
+		return c
+	}
+	assert.Equal(t, "pro", limiter.plan(withPlan("pro")))
+	assert.Equal(t, "free", limiter.plan(withPlan("")), "callers without a plan get the default plan")
+	assert.Equal(t, "free", limiter.plan(withPlan("enterprise")), "unknown plans get the default plan")
+
+	pro := limiter.getLimit(http.MethodGet, "/api/v1/packages", "pro")
+	assert.Equal(t, 600, pro.RequestsPerMinute)
+	assert.Equal(t, 100, pro.BurstSize)
+	assert.Equal(t, 5, pro.TTLMinutes)
+	proCreate := limiter.getLimit(http.MethodPost, "/api/v1/packages", "pro")
+	assert.Equal(t, 600, proCreate.RequestsPerMinute, "routes with their own limit scale with the plan")
+	assert.Equal(t, 200, proCreate.BurstSize)
+	freeCreate := limiter.getLimit(http.MethodPost, "/api/v1/packages", "free")
+	assert.Equal(t, 30, freeCreate.RequestsPerMinute)
+	assert.Equal(t, 20, freeCreate.BurstSize)
+	assert.Equal(t, 2, limiter.getLimit(http.MethodPost, "/api/v1/packages", "").BurstSize,
+		"routes keep their own limit without a plan")
+	assert.Equal(t, 1, limiter.getLimit(http.MethodGet, "/api/v1/packages", "").BurstSize)
+}
+
+// failingUsage is a usage repository that is unavailable
+type failingUsage struct {
+	domain.UsageRepository
+}
+
+func (failingUsage) Increment(ctx context.Context, identity string, expiresAt time.Time, periods ...string) ([]int64, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestRateLimit_Quotas(t *testing.T) {
+	setup := func(cfg *config.RateLimitConfig, usage domain.UsageRepository, now time.Time) *gin.Engine {
+		limiter := NewRateLimiter(memory.NewRateLimitStore(), cfg, WithUsage(usage), WithClock(func() time.Time { return now }))
+
+		router := gin.New()
+		router.Use(func(c *gin.Context) {
+			c.Set(principalKey, &domain.Principal{ID: "k1", Plan: "free"})
+		}, limiter.RateLimit())
+		router.GET("/api/v1/packages", func(c *gin.Context) { c.Status(http.StatusOK) })
+		return router
+	}
+	get := func(router *gin.Engine) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/packages", nil)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	cfg := func(daily, monthly int64) *config.RateLimitConfig {
+		return &config.RateLimitConfig{
+			Default:  config.EndpointRateLimit{RequestsPerMinute: 6000, BurstSize: 100, TTLMinutes: 5},
+			Identity: []string{config.IdentityPrincipal},
+			Plans: map[string]config.PlanLimit{
+				"free": {RequestsPerMinute: 6000, BurstSize: 100, DailyQuota: daily, MonthlyQuota: monthly},
+			},
+		}
+	}
+	now := time.Date(2024, 5, 31, 18, 0, 0, 0, time.UTC)
+
+	t.Run("daily quota", func(t *testing.T) {
+		usage := memory.NewUsageRepository()
+		router := setup(cfg(2, 0), usage, now)
+
+		assert.Equal(t, http.StatusOK, get(router).Code)
+		assert.Equal(t, http.StatusOK, get(router).Code)
+		rejected := get(router)
+		assert.Equal(t, http.StatusTooManyRequests, rejected.Code)
+		assert.Contains(t, rejected.Body.String(), "Daily quota exceeded")
+		assert.Equal(t, "21600", rejected.Header().Get("Retry-After"), "until midnight UTC")
+
+		daily, total, err := usage.FindByPeriod(context.Background(), "2024-05-31", domain.ListOptions{Page: 1, Size: 10})
+		assert.NoError(t, err)
+		assert.EqualValues(t, 1, total)
+		assert.Equal(t, []domain.Usage{{Identity: "principal:k1", Period: "2024-05-31", Count: 3}}, daily)
+
+		assert.Equal(t, http.StatusOK, get(setup(cfg(2, 0), usage, now.Add(6*time.Hour))).Code,
+			"the quota resets the next day")
+	})
+
+	t.Run("monthly quota", func(t *testing.T) {
+		router := setup(cfg(0, 1), memory.NewUsageRepository(), now)
+
+		assert.Equal(t, http.StatusOK, get(router).Code)
+		rejected := get(router)
+		assert.Equal(t, http.StatusTooManyRequests, rejected.Code)
+		assert.Contains(t, rejected.Body.String(), "Monthly quota exceeded")
+		assert.Equal(t, "21600", rejected.Header().Get("Retry-After"), "until the first of the month")
+	})
+
+	t.Run("unavailable usage", func(t *testing.T) {
+		c := cfg(1, 0)
+		c.FailOpen = true
+		assert.Equal(t, http.StatusOK, get(setup(c, failingUsage{}, now)).Code, "fail open")
+
+		c.FailOpen = false
+		assert.Equal(t, http.StatusServiceUnavailable, get(setup(c, failingUsage{}, now)).Code, "fail closed")
+	})
+}
+
+func TestRateLimit_Algorithms(t *testing.T) {
+	now := time.Date(2024, 1, 1, 8, 0, 30, 0, time.UTC)
+	setup := func(limit config.EndpointRateLimit) *gin.Engine {
+		limiter := NewRateLimiter(memory.NewRateLimitStore(), &config.RateLimitConfig{Default: limit},
+			WithClock(func() time.Time { return now }))
+		router := gin.New()
+		router.Use(limiter.RateLimit())
+		router.GET("/api/v1/packages", func(c *gin.Context) { c.Status(http.StatusOK) })
+		return router
+	}
+
+	t.Run("sliding window", func(t *testing.T) {
+		router := setup(config.EndpointRateLimit{Algorithm: "sliding_window", RequestsPerMinute: 2, BurstSize: 1, TTLMinutes: 5})
+		assert.Equal(t, http.StatusOK, limitedRequest(router, http.MethodGet, "/api/v1/packages"))
+		assert.Equal(t, http.StatusOK, limitedRequest(router, http.MethodGet, "/api/v1/packages"),
+			"the window allows requests per minute regardless of the burst size")
+		assert.Equal(t, http.StatusTooManyRequests, limitedRequest(router, http.MethodGet, "/api/v1/packages"))
+	})
+
+	t.Run("fixed window", func(t *testing.T) {
+		router := setup(config.EndpointRateLimit{Algorithm: "fixed_window", RequestsPerMinute: 1, BurstSize: 1, TTLMinutes: 5})
+		assert.Equal(t, http.StatusOK, limitedRequest(router, http.MethodGet, "/api/v1/packages"))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/packages", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "30", w.Header().Get("Retry-After"), "until the next minute")
+		assert.Contains(t, w.Body.String(), `"algorithm":"fixed_window"`)
+	})
+}
+
+func TestRateLimit_Concurrency(t *testing.T) {
+	limiter := NewRateLimiter(memory.NewRateLimitStore(), &config.RateLimitConfig{
+		Default: config.EndpointRateLimit{Algorithm: "concurrency", RequestsPerMinute: 60, BurstSize: 1, TTLMinutes: 5},
+	})
+
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	router := gin.New()
+	router.Use(limiter.RateLimit())
+	router.GET("/api/v1/packages/search", func(c *gin.Context) {
+		if c.Query("slow") != "" {
+			close(started)
+			<-finish
+		}
+		c.Status(http.StatusOK)
+	})
+
+	done := make(chan int)
+	go func() {
+		done <- limitedRequest(router, http.MethodGet, "/api/v1/packages/search?slow=1")
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/packages/search", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code, "the only slot is taken")
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	close(finish)
+	assert.Equal(t, http.StatusOK, <-done)
+	assert.Equal(t, http.StatusOK, limitedRequest(router, http.MethodGet, "/api/v1/packages/search"),
+		"completed requests release their slot")
+	assert.Equal(t, http.StatusOK, limitedRequest(router, http.MethodGet, "/api/v1/packages/search"))
+}
+
+func TestRateLimit_Modes(t *testing.T) {
+	router, store := setupRateLimitRouter(&config.RateLimitConfig{
+		Default: config.EndpointRateLimit{RequestsPerMinute: 60, BurstSize: 1, TTLMinutes: 5},
+		Endpoints: map[string]config.EndpointRateLimit{
+			"GET:/api/v1/packages/search": {Mode: config.RateLimitModeShadow, RequestsPerMinute: 60, BurstSize: 1, TTLMinutes: 5},
+			"GET:/api/v1/packages/:id":    {Mode: config.RateLimitModeOff, RequestsPerMinute: 60, BurstSize: 1, TTLMinutes: 5},
+		},
+	})
+
+	t.Run("enforce", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, limitedRequest(router, http.MethodGet, "/api/v1/packages"))
+		assert.Equal(t, http.StatusTooManyRequests, limitedRequest(router, http.MethodGet, "/api/v1/packages"))
+	})
+
+	t.Run("shadow", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/api/v1/packages/search", nil)
+			req.RemoteAddr = "192.0.2.1:1234"
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusOK, w.Code, "requests over the limit are let through")
+			assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+		}
+		assert.True(t, store.keys["GET:/api/v1/packages/search|ip:192.0.2.1"], "the bucket is still counted")
+	})
+
+	t.Run("off", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			assert.Equal(t, http.StatusOK, limitedRequest(router, http.MethodGet, "/api/v1/packages/P1"))
+		}
+		assert.False(t, store.keys["GET:/api/v1/packages/:id|ip:192.0.2.1"], "no bucket is taken")
+	})
+}
+
+func TestRateLimit_ShadowQuota(t *testing.T) {
+	cfg := &config.RateLimitConfig{
+		Default:  config.EndpointRateLimit{Mode: config.RateLimitModeShadow, RequestsPerMinute: 6000, BurstSize: 100, TTLMinutes: 5},
+		Identity: []string{config.IdentityPrincipal},
+		Plans:    map[string]config.PlanLimit{"free": {RequestsPerMinute: 6000, BurstSize: 100, DailyQuota: 1}},
+	}
+	limiter := NewRateLimiter(memory.NewRateLimitStore(), cfg, WithUsage(memory.NewUsageRepository()))
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Set(principalKey, &domain.Principal{ID: "k1", Plan: "free"})
+	}, limiter.RateLimit())
+	router.GET("/api/v1/packages", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, limitedRequest(router, http.MethodGet, "/api/v1/packages"))
+	}
+}
+
+func TestRateLimit_NetworkLists(t *testing.T) {
+	router, store := setupRateLimitRouter(&config.RateLimitConfig{
+		Default:    config.EndpointRateLimit{RequestsPerMinute: 60, BurstSize: 1, TTLMinutes: 5},
+		AllowCIDRs: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.0.2.0/24")},
+		DenyCIDRs:  []netip.Prefix{netip.MustParsePrefix("192.0.2.66/32"), netip.MustParsePrefix("2001:db8::/32")},
+	})
+
+	request := func(remoteAddr string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/packages", nil)
+		req.RemoteAddr = remoteAddr
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusOK, request("10.1.2.3:1234"), "allowed networks bypass the limit")
+	}
+	assert.Equal(t, http.StatusForbidden, request("192.0.2.66:1234"), "denied networks take precedence")
+	assert.Equal(t, http.StatusForbidden, request("[2001:db8::1]:1234"))
+	assert.Empty(t, store.keys, "listed clients take no bucket")
+
+	assert.Equal(t, http.StatusOK, request("198.51.100.1:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, request("198.51.100.1:1234"), "other clients are limited")
+
+	t.Run("before authentication", func(t *testing.T) {
+		limiter := NewRateLimiter(memory.NewRateLimitStore(), &config.RateLimitConfig{
+			DenyCIDRs: []netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")},
+		})
+		auth := NewAuth(fakeAuthenticator{}, &config.AuthConfig{Enabled: true})
+		router := gin.New()
+		router.Use(limiter.Networks())
+		router.GET("/api/v1/packages", auth.Authenticate(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/api/v1/packages", nil)
+		req.RemoteAddr = "203.0.113.5:1234"
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code, "denied networks are rejected without credentials")
+	})
+}
+
+func BenchmarkRateLimit(b *testing.B) {
+	gin.SetMode(gin.TestMode)
+	store := memory.NewRateLimitStore(memory.WithMaxBuckets(100000))
+	defer store.Stop()
+	limiter := NewRateLimiter(store, &config.RateLimitConfig{
+		Default:    config.EndpointRateLimit{RequestsPerMinute: 600000, BurstSize: 10000, TTLMinutes: 5},
+		MaxClients: 10000,
+	}, WithMetrics(prometheus.NewRegistry()))
+	router := gin.New()
+	router.Use(limiter.RateLimit())
+	router.GET("/api/v1/packages/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	addrs := make([]string, 1000)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("10.0.%d.%d:1234", i/256, i%256)
+	}
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/api/v1/packages/P1", nil)
+			req.RemoteAddr = addrs[i%len(addrs)]
+			router.ServeHTTP(w, req)
+			i++
+		}
+	})
+}
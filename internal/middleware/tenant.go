@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+// TenantHeader is the request header selecting the tenant of platform
+// callers
+const TenantHeader = "X-Tenant-ID"
+
+// Tenant returns a gin middleware that resolves the tenant of a request and
+// stores it in the request context, where the repositories pick it up. A
+// caller bound to a tenant always gets that tenant and is rejected with 403
+// when it asks for another one. Platform callers choose a tenant with the
+// X-Tenant-ID header and default to domain.DefaultTenant. It must run after
+// Auth.Authenticate.
+func Tenant() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requested := c.GetHeader(TenantHeader)
+		if requested != "" && !domain.ValidTenant(requested) {
+			abortAuth(c, http.StatusBadRequest, "Invalid "+TenantHeader+" header")
+			return
+		}
+
+		tenant := requested
+		if principal, ok := GetPrincipal(c); ok && principal.Tenant != "" {
+			if requested != "" && requested != principal.Tenant {
+				abortAuth(c, http.StatusForbidden, "The credentials cannot access tenant "+requested)
+				return
+			}
+			tenant = principal.Tenant
+		}
+		if tenant == "" {
+			tenant = domain.DefaultTenant
+		}
+
+		c.Request = c.Request.WithContext(domain.WithTenant(c.Request.Context(), tenant))
+		c.Next()
+	}
+}
+
+// RequirePlatform returns a gin middleware that rejects callers bound to a
+// tenant with 403, for routes that manage the whole deployment. It must run
+// after Authenticate.
+func (a *Auth) RequirePlatform() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := GetPrincipal(c)
+		if !ok {
+			a.abortUnauthorized(c, "Authentication required")
+			return
+		}
+		if principal.Tenant != "" {
+			abortAuth(c, http.StatusForbidden, "Tenant credentials cannot access this route")
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/snavarro/microtracker/config"
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func setupTenantRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	auth := NewAuth(fakeAuthenticator{
+		"platform-key": {ID: "k1", Role: domain.RoleAdmin},
+		"acme-key":     {ID: "k2", Role: domain.RoleAdmin, Tenant: "acme"},
+	}, &config.AuthConfig{Enabled: true})
+
+	router := gin.New()
+	router.Use(auth.Authenticate(), Tenant())
+	router.GET("/packages", func(c *gin.Context) {
+		c.String(http.StatusOK, domain.TenantFromContext(c.Request.Context()))
+	})
+	router.GET("/admin", auth.RequirePlatform(), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	return router
+}
+
+func tenantRequest(router *gin.Engine, path, apiKey, tenant string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set(APIKeyHeader, apiKey)
+	if tenant != "" {
+		req.Header.Set(TenantHeader, tenant)
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestTenant(t *testing.T) {
+	router := setupTenantRouter()
+
+	tests := []struct {
+		name       string
+		apiKey     string
+		header     string
+		wantStatus int
+		wantTenant string
+	}{
+		{"platform default", "platform-key", "", http.StatusOK, domain.DefaultTenant},
+		{"platform picks a tenant", "platform-key", "globex", http.StatusOK, "globex"},
+		{"bound key", "acme-key", "", http.StatusOK, "acme"},
+		{"bound key with its own tenant", "acme-key", "acme", http.StatusOK, "acme"},
+		{"bound key with another tenant", "acme-key", "globex", http.StatusForbidden, ""},
+		{"invalid header", "platform-key", "../etc", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := tenantRequest(router, "/packages", tt.apiKey, tt.header)
+			assert.Equal(t, tt.wantStatus, w.Code)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, tt.wantTenant, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequirePlatform(t *testing.T) {
+	router := setupTenantRouter()
+
+	assert.Equal(t, http.StatusNoContent, tenantRequest(router, "/admin", "platform-key", "").Code)
+	assert.Equal(t, http.StatusForbidden, tenantRequest(router, "/admin", "acme-key", "").Code)
+}
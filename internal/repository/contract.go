@@ -0,0 +1,719 @@
+// Package repository holds helpers shared by the storage backends.
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// timestampPrecision is the coarsest timestamp precision among the backends
+// (MongoDB stores milliseconds)
+const timestampPrecision = time.Millisecond
+
+// RepositoryFactory returns an empty repository for a single test
+type RepositoryFactory func(t *testing.T) domain.PackageRepository
+
+// RunPackageRepositoryContract runs the conformance suite every
+// domain.PackageRepository implementation must pass. The factory is called
+// once per subtest and must return a repository without any packages.
+func RunPackageRepositoryContract(t *testing.T, newRepo RepositoryFactory) {
+	t.Run("FindByID", func(t *testing.T) { testFindByID(t, newRepo(t)) })
+	t.Run("DuplicateCreate", func(t *testing.T) { testDuplicateCreate(t, newRepo(t)) })
+	t.Run("FindAll", func(t *testing.T) { testFindAll(t, newRepo(t)) })
+	t.Run("Pagination", func(t *testing.T) { testPagination(t, newRepo(t)) })
+	t.Run("Search", func(t *testing.T) { testSearch(t, newRepo(t)) })
+	t.Run("Filter", func(t *testing.T) { testFilter(t, newRepo(t)) })
+	t.Run("Sort", func(t *testing.T) { testSort(t, newRepo(t)) })
+	t.Run("Keyset", func(t *testing.T) { testKeyset(t, newRepo(t)) })
+	t.Run("Timestamps", func(t *testing.T) { testTimestamps(t, newRepo(t)) })
+	t.Run("Update", func(t *testing.T) { testUpdate(t, newRepo(t)) })
+	t.Run("Patch", func(t *testing.T) { testPatch(t, newRepo(t)) })
+	t.Run("Versioning", func(t *testing.T) { testVersioning(t, newRepo(t)) })
+	t.Run("Delete", func(t *testing.T) { testDelete(t, newRepo(t)) })
+	t.Run("ConcurrentWrites", func(t *testing.T) { testConcurrentWrites(t, newRepo(t)) })
+	t.Run("TenantIsolation", func(t *testing.T) { testTenantIsolation(t, newRepo(t)) })
+}
+
+// contractPackage returns a valid package with the given ID
+func contractPackage(id string) *domain.Package {
+	return &domain.Package{
+		PackageID: id,
+		Sender: domain.Address{
+			Name:    "John Doe",
+			Address: "123 Main St",
+		},
+		Recipient: domain.Address{
+			Name:    "Jane Doe",
+			Address: "456 Oak St",
+		},
+		Origin:        "New York",
+		Destination:   "Los Angeles",
+		CurrentStatus: "CREATED",
+	}
+}
+
+// createSequence creates packages with distinct creation times, oldest first
+func createSequence(t *testing.T, repo domain.PackageRepository, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		require.NoError(t, repo.Create(context.Background(), contractPackage(id)))
+		time.Sleep(2 * timestampPrecision)
+	}
+}
+
+func packageIDs(packages []domain.Package) []string {
+	ids := make([]string, 0, len(packages))
+	for _, pkg := range packages {
+		ids = append(ids, pkg.PackageID)
+	}
+	return ids
+}
+
+func testFindByID(t *testing.T, repo domain.PackageRepository) {
+	ctx := context.Background()
+	pkg := contractPackage("FIND1")
+	pkg.LatestEvent = &domain.Event{
+		Timestamp: time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC),
+		Location:  "New York",
+		Status:    "CREATED",
+	}
+	pkg.EventCount = 1
+	require.NoError(t, repo.Create(ctx, pkg))
+
+	found, err := repo.FindByID(ctx, "FIND1")
+	require.NoError(t, err)
+	assert.Equal(t, pkg.PackageID, found.PackageID)
+	assert.Equal(t, pkg.Sender, found.Sender)
+	assert.Equal(t, pkg.Recipient, found.Recipient)
+	assert.Equal(t, pkg.Origin, found.Origin)
+	assert.Equal(t, pkg.Destination, found.Destination)
+	assert.Equal(t, pkg.CurrentStatus, found.CurrentStatus)
+	assert.Equal(t, 1, found.EventCount)
+	require.NotNil(t, found.LatestEvent)
+	assert.True(t, pkg.LatestEvent.Timestamp.Equal(found.LatestEvent.Timestamp))
+	assert.Equal(t, pkg.LatestEvent.Location, found.LatestEvent.Location)
+
+	found, err = repo.FindByID(ctx, "MISSING")
+	assert.ErrorIs(t, err, domain.ErrPackageNotFound)
+	assert.Nil(t, found)
+}
+
+func testDuplicateCreate(t *testing.T, repo domain.PackageRepository) {
+	ctx := context.Background()
+	require.NoError(t, repo.Create(ctx, contractPackage("DUP1")))
+
+	duplicate := contractPackage("DUP1")
+	duplicate.Destination = "Seattle"
+	assert.ErrorIs(t, repo.Create(ctx, duplicate), domain.ErrPackageExists)
+
+	stored, err := repo.FindByID(ctx, "DUP1")
+	require.NoError(t, err)
+	assert.Equal(t, contractPackage("DUP1").Destination, stored.Destination, "the original package is kept")
+}
+
+func testFindAll(t *testing.T, repo domain.PackageRepository) {
+	ctx := context.Background()
+
+	packages, total, err := repo.FindAll(ctx, domain.PackageFilter{}, domain.ListOptions{Page: 1, Size: 10})
+	require.NoError(t, err)
+	assert.Empty(t, packages)
+	assert.Equal(t, int64(0), total)
+
+	createSequence(t, repo, "ALL1", "ALL2", "ALL3")
+
+	packages, total, err = repo.FindAll(ctx, domain.PackageFilter{}, domain.ListOptions{Page: 1, Size: 10})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	assert.Equal(t, []string{"ALL3", "ALL2", "ALL1"}, packageIDs(packages), "sorted by createdAt desc")
+}
+
+func testPagination(t *testing.T, repo domain.PackageRepository) {
+	ctx := context.Background()
+	createSequence(t, repo, "PAGE1", "PAGE2", "PAGE3", "PAGE4", "PAGE5")
+
+	tests := []struct {
+		name string
+		page int
+		size int
+		want []string
+	}{
+		{name: "first page", page: 1, size: 2, want: []string{"PAGE5", "PAGE4"}},
+		{name: "middle page", page: 2, size: 2, want: []string{"PAGE3", "PAGE2"}},
+		{name: "partial last page", page: 3, size: 2, want: []string{"PAGE1"}},
+		{name: "past the end", page: 4, size: 2, want: []string{}},
+		{name: "exact fit", page: 1, size: 5, want: []string{"PAGE5", "PAGE4", "PAGE3", "PAGE2", "PAGE1"}},
+		{name: "size larger than total", page: 1, size: 100, want: []string{"PAGE5", "PAGE4", "PAGE3", "PAGE2", "PAGE1"}},
+		{name: "single item pages", page: 5, size: 1, want: []string{"PAGE1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packages, total, err := repo.FindAll(ctx, domain.PackageFilter{}, domain.ListOptions{Page: tt.page, Size: tt.size})
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), total, "total counts all packages regardless of page")
+			assert.Equal(t, tt.want, packageIDs(packages))
+		})
+	}
+}
+
+func testSearch(t *testing.T, repo domain.PackageRepository) {
+	ctx := context.Background()
+
+	fields := map[string]func(*domain.Package){
+		"SEARCH-ID-ZEBRA": func(*domain.Package) {},
+		"SEARCH2":         func(p *domain.Package) { p.Sender.Name = "Zebra Sender" },
+		"SEARCH3":         func(p *domain.Package) { p.Recipient.Name = "Zebra Recipient" },
+		"SEARCH4":         func(p *domain.Package) { p.Origin = "Zebra Town" },
+		"SEARCH5":         func(p *domain.Package) { p.Destination = "Zebra City" },
+		"SEARCH6":         func(p *domain.Package) { p.CurrentStatus = "ZEBRA_HOLD" },
+		"SEARCH7":         func(p *domain.Package) { p.Destination = "Hub (North)+" },
+	}
+	for _, id := range []string{"SEARCH-ID-ZEBRA", "SEARCH2", "SEARCH3", "SEARCH4", "SEARCH5", "SEARCH6", "SEARCH7"} {
+		pkg := contractPackage(id)
+		fields[id](pkg)
+		require.NoError(t, repo.Create(ctx, pkg))
+		time.Sleep(2 * timestampPrecision)
+	}
+	createSequence(t, repo, "OTHER")
+
+	literal := func(text string) domain.SearchQuery {
+		return domain.SearchQuery{Text: text, Mode: domain.SearchLiteral}
+	}
+
+	t.Run("matches every searchable field", func(t *testing.T) {
+		packages, total, err := repo.Search(ctx, literal("zebra"), domain.ListOptions{Page: 1, Size: 10})
+		require.NoError(t, err)
+		assert.Equal(t, int64(6), total)
+		assert.Equal(t, []string{"SEARCH6", "SEARCH5", "SEARCH4", "SEARCH3", "SEARCH2", "SEARCH-ID-ZEBRA"}, packageIDs(packages))
+	})
+
+	t.Run("case insensitive", func(t *testing.T) {
+		_, total, err := repo.Search(ctx, literal("ZeBrA"), domain.ListOptions{Page: 1, Size: 10})
+		require.NoError(t, err)
+		assert.Equal(t, int64(6), total)
+	})
+
+	t.Run("paginated with full total", func(t *testing.T) {
+		packages, total, err := repo.Search(ctx, literal("zebra"), domain.ListOptions{Page: 2, Size: 4})
+		require.NoError(t, err)
+		assert.Equal(t, int64(6), total)
+		assert.Equal(t, []string{"SEARCH2", "SEARCH-ID-ZEBRA"}, packageIDs(packages))
+	})
+
+	t.Run("addresses are not searched", func(t *testing.T) {
+		_, total, err := repo.Search(ctx, literal("Main St"), domain.ListOptions{Page: 1, Size: 10})
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), total)
+	})
+
+	t.Run("no match", func(t *testing.T) {
+		packages, total, err := repo.Search(ctx, literal("giraffe"), domain.ListOptions{Page: 1, Size: 10})
+		require.NoError(t, err)
+		assert.Empty(t, packages)
+		assert.Equal(t, int64(0), total)
+	})
+
+	t.Run("literal treats regex metacharacters as text", func(t *testing.T) {
+		packages, _, err := repo.Search(ctx, literal("(north)+"), domain.ListOptions{Page: 1, Size: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"SEARCH7"}, packageIDs(packages))
+
+		_, total, err := repo.Search(ctx, literal("zebra.*"), domain.ListOptions{Page: 1, Size: 10})
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), total)
+	})
+
+	t.Run("prefix is anchored and case sensitive", func(t *testing.T) {
+		packages, _, err := repo.Search(ctx, domain.SearchQuery{Text: "Zebra", Mode: domain.SearchPrefix}, domain.ListOptions{Page: 1, Size: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"SEARCH5", "SEARCH4", "SEARCH3", "SEARCH2"}, packageIDs(packages))
+
+		_, total, err := repo.Search(ctx, domain.SearchQuery{Text: "zebra", Mode: domain.SearchPrefix}, domain.ListOptions{Page: 1, Size: 10})
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), total)
+	})
+
+	t.Run("exact matches the whole value", func(t *testing.T) {
+		packages, _, err := repo.Search(ctx, domain.SearchQuery{Text: "Zebra Town", Mode: domain.SearchExact}, domain.ListOptions{Page: 1, Size: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"SEARCH4"}, packageIDs(packages))
+
+		_, total, err := repo.Search(ctx, domain.SearchQuery{Text: "Zebra", Mode: domain.SearchExact}, domain.ListOptions{Page: 1, Size: 10})
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), total)
+	})
+
+	t.Run("regex is case insensitive", func(t *testing.T) {
+		packages, _, err := repo.Search(ctx, domain.SearchQuery{Text: "^zebra (town|city)$", Mode: domain.SearchRegex}, domain.ListOptions{Page: 1, Size: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"SEARCH5", "SEARCH4"}, packageIDs(packages))
+	})
+
+	t.Run("text matches any whole word", func(t *testing.T) {
+		packages, _, err := repo.Search(ctx, domain.SearchQuery{Text: "TOWN city", Mode: domain.SearchText}, domain.ListOptions{Page: 1, Size: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"SEARCH5", "SEARCH4"}, packageIDs(packages))
+
+		packages, _, err = repo.Search(ctx, domain.SearchQuery{Text: "north", Mode: domain.SearchText}, domain.ListOptions{Page: 1, Size: 10})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"SEARCH7"}, packageIDs(packages), "punctuation separates words")
+
+		_, total, err := repo.Search(ctx, domain.SearchQuery{Text: "tow", Mode: domain.SearchText}, domain.ListOptions{Page: 1, Size: 10})
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), total)
+	})
+
+	t.Run("unknown mode", func(t *testing.T) {
+		_, _, err := repo.Search(ctx, domain.SearchQuery{Text: "zebra", Mode: "fuzzy"}, domain.ListOptions{Page: 1, Size: 10})
+		assert.ErrorIs(t, err, domain.ErrInvalidSearch)
+	})
+}
+
+func testFilter(t *testing.T, repo domain.PackageRepository) {
+	ctx := context.Background()
+
+	create := func(id string, modify func(*domain.Package)) {
+		pkg := contractPackage(id)
+		modify(pkg)
+		require.NoError(t, repo.Create(ctx, pkg))
+		time.Sleep(2 * timestampPrecision)
+	}
+
+	create("FILTER1", func(p *domain.Package) { p.CurrentStatus = "IN_TRANSIT"; p.Origin = "Chicago" })
+	create("FILTER2", func(p *domain.Package) { p.CurrentStatus = "DELIVERED"; p.Destination = "Seattle" })
+	midpoint := time.Now()
+	time.Sleep(2 * timestampPrecision)
+	create("FILTER3", func(p *domain.Package) { p.CurrentStatus = "IN_TRANSIT"; p.Sender.Name = "Acme Corp" })
+	create("FILTER4", func(p *domain.Package) { p.Recipient.Name = "Maria Lopez" })
+
+	updated := contractPackage("FILTER1")
+	updated.CurrentStatus = "IN_TRANSIT"
+	updated.Origin = "Chicago"
+	require.NoError(t, repo.Update(ctx, updated))
+
+	tests := []struct {
+		name   string
+		filter domain.PackageFilter
+		want   []string
+	}{
+		{"no filter", domain.PackageFilter{}, []string{"FILTER4", "FILTER3", "FILTER2", "FILTER1"}},
+		{"single status", domain.PackageFilter{Statuses: []string{"IN_TRANSIT"}}, []string{"FILTER3", "FILTER1"}},
+		{"several statuses", domain.PackageFilter{Statuses: []string{"DELIVERED", "CREATED"}}, []string{"FILTER4", "FILTER2"}},
+		{"origin", domain.PackageFilter{Origin: "Chicago"}, []string{"FILTER1"}},
+		{"destination", domain.PackageFilter{Destination: "Seattle"}, []string{"FILTER2"}},
+		{"sender name", domain.PackageFilter{SenderName: "Acme Corp"}, []string{"FILTER3"}},
+		{"recipient name", domain.PackageFilter{RecipientName: "Maria Lopez"}, []string{"FILTER4"}},
+		{"text filters are exact", domain.PackageFilter{Origin: "chicago"}, []string{}},
+		{"created from", domain.PackageFilter{Created: domain.TimeRange{From: midpoint}}, []string{"FILTER4", "FILTER3"}},
+		{"created to", domain.PackageFilter{Created: domain.TimeRange{To: midpoint}}, []string{"FILTER2", "FILTER1"}},
+		{"updated from", domain.PackageFilter{Updated: domain.TimeRange{From: midpoint}}, []string{"FILTER4", "FILTER3", "FILTER1"}},
+		{"combined", domain.PackageFilter{
+			Statuses: []string{"IN_TRANSIT"},
+			Created:  domain.TimeRange{From: midpoint},
+		}, []string{"FILTER3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packages, total, err := repo.FindAll(ctx, tt.filter, domain.ListOptions{Page: 1, Size: 10})
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(tt.want)), total, "total counts only matching packages")
+			assert.Equal(t, tt.want, packageIDs(packages))
+		})
+	}
+}
+
+func testSort(t *testing.T, repo domain.PackageRepository) {
+	ctx := context.Background()
+
+	origins := map[string]string{"SORT1": "Boston", "SORT2": "Austin", "SORT3": "Chicago", "SORT4": "Austin"}
+	for _, id := range []string{"SORT1", "SORT2", "SORT3", "SORT4"} {
+		pkg := contractPackage(id)
+		pkg.Origin = origins[id]
+		require.NoError(t, repo.Create(ctx, pkg))
+		time.Sleep(2 * timestampPrecision)
+	}
+
+	tests := []struct {
+		name string
+		sort []domain.SortOrder
+		want []string
+	}{
+		{"default newest first", nil, []string{"SORT4", "SORT3", "SORT2", "SORT1"}},
+		{"ascending with tiebreak", []domain.SortOrder{
+			{Field: domain.SortOrigin},
+			{Field: domain.SortPackageID},
+		}, []string{"SORT2", "SORT4", "SORT1", "SORT3"}},
+		{"descending with tiebreak", []domain.SortOrder{
+			{Field: domain.SortOrigin, Descending: true},
+			{Field: domain.SortPackageID},
+		}, []string{"SORT3", "SORT1", "SORT2", "SORT4"}},
+		{"oldest first", []domain.SortOrder{
+			{Field: domain.SortCreatedAt},
+			{Field: domain.SortPackageID},
+		}, []string{"SORT1", "SORT2", "SORT3", "SORT4"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packages, _, err := repo.FindAll(ctx, domain.PackageFilter{}, domain.ListOptions{Page: 1, Size: 10, Sort: tt.sort})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, packageIDs(packages))
+		})
+	}
+}
+
+func testKeyset(t *testing.T, repo domain.PackageRepository) {
+	ctx := context.Background()
+	createSequence(t, repo, "KEY1", "KEY2", "KEY3", "KEY4", "KEY5")
+
+	walk := func(t *testing.T, sort []domain.SortOrder, list func(domain.ListOptions) ([]domain.Package, int64, error)) []string {
+		var ids []string
+		opts := domain.ListOptions{Page: 1, Size: 2, Sort: sort}
+		for i := 0; i < 10; i++ {
+			packages, total, err := list(opts)
+			require.NoError(t, err)
+			assert.Equal(t, int64(5), total, "total ignores the cursor")
+			if len(packages) == 0 {
+				return ids
+			}
+			ids = append(ids, packageIDs(packages)...)
+			opts.After = domain.SortKey(sort, &packages[len(packages)-1])
+		}
+		t.Fatal("walk did not terminate")
+		return nil
+	}
+
+	t.Run("FindAll", func(t *testing.T) {
+		ids := walk(t, domain.DefaultSort, func(opts domain.ListOptions) ([]domain.Package, int64, error) {
+			return repo.FindAll(ctx, domain.PackageFilter{}, opts)
+		})
+		assert.Equal(t, []string{"KEY5", "KEY4", "KEY3", "KEY2", "KEY1"}, ids)
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		sort := []domain.SortOrder{{Field: domain.SortPackageID, Descending: true}}
+		query := domain.SearchQuery{Text: "KEY", Mode: domain.SearchPrefix}
+		ids := walk(t, sort, func(opts domain.ListOptions) ([]domain.Package, int64, error) {
+			return repo.Search(ctx, query, opts)
+		})
+		assert.Equal(t, []string{"KEY5", "KEY4", "KEY3", "KEY2", "KEY1"}, ids)
+	})
+
+	t.Run("ties on the first field", func(t *testing.T) {
+		sort := []domain.SortOrder{{Field: domain.SortOrigin}, {Field: domain.SortPackageID}}
+		ids := walk(t, sort, func(opts domain.ListOptions) ([]domain.Package, int64, error) {
+			return repo.FindAll(ctx, domain.PackageFilter{}, opts)
+		})
+		assert.Equal(t, []string{"KEY1", "KEY2", "KEY3", "KEY4", "KEY5"}, ids)
+	})
+
+	t.Run("inserts do not shift later pages", func(t *testing.T) {
+		opts := domain.ListOptions{Page: 1, Size: 2, Sort: domain.DefaultSort}
+		first, _, err := repo.FindAll(ctx, domain.PackageFilter{}, opts)
+		require.NoError(t, err)
+		require.Equal(t, []string{"KEY5", "KEY4"}, packageIDs(first))
+
+		createSequence(t, repo, "KEY6")
+
+		opts.After = domain.SortKey(opts.Sort, &first[len(first)-1])
+		second, _, err := repo.FindAll(ctx, domain.PackageFilter{}, opts)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"KEY3", "KEY2"}, packageIDs(second))
+	})
+}
+
+func testTimestamps(t *testing.T, repo domain.PackageRepository) {
+	ctx := context.Background()
+
+	before := time.Now()
+	pkg := contractPackage("TIME1")
+	require.NoError(t, repo.Create(ctx, pkg))
+
+	assert.False(t, pkg.CreatedAt.IsZero(), "Create sets CreatedAt")
+	assert.WithinDuration(t, before, pkg.CreatedAt, time.Minute)
+	assert.True(t, pkg.CreatedAt.Equal(pkg.UpdatedAt), "CreatedAt and UpdatedAt start equal")
+
+	stored, err := repo.FindByID(ctx, "TIME1")
+	require.NoError(t, err)
+	assert.WithinDuration(t, pkg.CreatedAt, stored.CreatedAt, timestampPrecision)
+	assert.WithinDuration(t, pkg.UpdatedAt, stored.UpdatedAt, timestampPrecision)
+
+	time.Sleep(2 * timestampPrecision)
+	stored.Destination = "Seattle"
+	require.NoError(t, repo.Update(ctx, stored))
+
+	updated, err := repo.FindByID(ctx, "TIME1")
+	require.NoError(t, err)
+	assert.True(t, updated.UpdatedAt.After(pkg.UpdatedAt), "Update bumps UpdatedAt")
+	assert.WithinDuration(t, pkg.CreatedAt, updated.CreatedAt, timestampPrecision, "Update keeps CreatedAt")
+}
+
+func testUpdate(t *testing.T, repo domain.PackageRepository) {
+	ctx := context.Background()
+	require.NoError(t, repo.Create(ctx, contractPackage("UPD1")))
+
+	pkg, err := repo.FindByID(ctx, "UPD1")
+	require.NoError(t, err)
+	pkg.Destination = "Seattle"
+	pkg.CurrentStatus = "IN_TRANSIT"
+	pkg.LatestEvent = &domain.Event{Timestamp: time.Now().UTC().Truncate(time.Millisecond), Location: "Memphis Hub", Status: "IN_TRANSIT"}
+	pkg.EventCount = 2
+	require.NoError(t, repo.Update(ctx, pkg))
+
+	stored, err := repo.FindByID(ctx, "UPD1")
+	require.NoError(t, err)
+	assert.Equal(t, "Seattle", stored.Destination)
+	assert.Equal(t, "IN_TRANSIT", stored.CurrentStatus)
+	assert.Equal(t, 2, stored.EventCount)
+	require.NotNil(t, stored.LatestEvent)
+	assert.Equal(t, "Memphis Hub", stored.LatestEvent.Location)
+
+	err = repo.Update(ctx, contractPackage("MISSING"))
+	assert.ErrorIs(t, err, domain.ErrPackageNotFound)
+
+	_, total, err := repo.FindAll(ctx, domain.PackageFilter{}, domain.ListOptions{Page: 1, Size: 10})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total, "Update never inserts")
+
+	t.Run("preserves CreatedAt", func(t *testing.T) {
+		replacement := contractPackage("UPD1")
+		require.True(t, replacement.CreatedAt.IsZero())
+		require.NoError(t, repo.Update(ctx, replacement))
+
+		stored, err := repo.FindByID(ctx, "UPD1")
+		require.NoError(t, err)
+		assert.True(t, pkg.CreatedAt.Equal(stored.CreatedAt), "CreatedAt %v changed to %v", pkg.CreatedAt, stored.CreatedAt)
+	})
+}
+
+func testPatch(t *testing.T, repo domain.PackageRepository) {
+	ctx := context.Background()
+	require.NoError(t, repo.Create(ctx, contractPackage("PATCH1")))
+	created, err := repo.FindByID(ctx, "PATCH1")
+	require.NoError(t, err)
+	time.Sleep(2 * timestampPrecision)
+
+	destination, recipientName, status := "Seattle", "Maria Lopez", "IN_TRANSIT"
+	patched, err := repo.Patch(ctx, "PATCH1", domain.PackageUpdate{
+		Destination:   &destination,
+		RecipientName: &recipientName,
+		CurrentStatus: &status,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Seattle", patched.Destination)
+	assert.Equal(t, "IN_TRANSIT", patched.CurrentStatus)
+	assert.Equal(t, domain.Address{Name: "Maria Lopez", Address: "456 Oak St"}, patched.Recipient, "untouched nested fields are kept")
+
+	stored, err := repo.FindByID(ctx, "PATCH1")
+	require.NoError(t, err)
+	assert.Equal(t, "Seattle", stored.Destination)
+	assert.Equal(t, "Maria Lopez", stored.Recipient.Name)
+	assert.Equal(t, "456 Oak St", stored.Recipient.Address)
+	assert.Equal(t, created.Sender, stored.Sender)
+	assert.Equal(t, created.Origin, stored.Origin)
+	assert.True(t, created.CreatedAt.Equal(stored.CreatedAt))
+	assert.True(t, stored.UpdatedAt.After(created.UpdatedAt))
+
+	_, err = repo.Patch(ctx, "MISSING", domain.PackageUpdate{Destination: &destination})
+	assert.ErrorIs(t, err, domain.ErrPackageNotFound)
+}
+
+func testVersioning(t *testing.T, repo domain.PackageRepository) {
+	ctx := context.Background()
+	pkg := contractPackage("VER1")
+	require.NoError(t, repo.Create(ctx, pkg))
+	assert.Equal(t, int64(1), pkg.Version, "Create starts at version 1")
+
+	stored, err := repo.FindByID(ctx, "VER1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stored.Version)
+
+	stored.Destination = "Seattle"
+	require.NoError(t, repo.Update(ctx, stored))
+	assert.Equal(t, int64(2), stored.Version, "Update reports the new version")
+
+	destination := "Portland"
+	patched, err := repo.Patch(ctx, "VER1", domain.PackageUpdate{Destination: &destination, Version: 2})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), patched.Version)
+
+	t.Run("stale update", func(t *testing.T) {
+		stale := contractPackage("VER1")
+		stale.Version = 2
+		assert.ErrorIs(t, repo.Update(ctx, stale), domain.ErrVersionConflict)
+
+		_, err := repo.Patch(ctx, "VER1", domain.PackageUpdate{Destination: &destination, Version: 1})
+		assert.ErrorIs(t, err, domain.ErrVersionConflict)
+
+		stored, err := repo.FindByID(ctx, "VER1")
+		require.NoError(t, err)
+		assert.Equal(t, "Portland", stored.Destination, "conflicting writes change nothing")
+		assert.Equal(t, int64(3), stored.Version)
+	})
+
+	t.Run("version zero is unconditional", func(t *testing.T) {
+		replacement := contractPackage("VER1")
+		require.NoError(t, repo.Update(ctx, replacement))
+		assert.Equal(t, int64(4), replacement.Version)
+	})
+
+	t.Run("missing package", func(t *testing.T) {
+		missing := contractPackage("MISSING")
+		missing.Version = 1
+		assert.ErrorIs(t, repo.Update(ctx, missing), domain.ErrPackageNotFound)
+
+		_, err := repo.Patch(ctx, "MISSING", domain.PackageUpdate{Destination: &destination, Version: 1})
+		assert.ErrorIs(t, err, domain.ErrPackageNotFound)
+	})
+
+	t.Run("concurrent conditional updates", func(t *testing.T) {
+		const writers = 10
+		current, err := repo.FindByID(ctx, "VER1")
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, writers)
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				pkg := contractPackage("VER1")
+				pkg.Destination = fmt.Sprintf("Destination %02d", i)
+				pkg.Version = current.Version
+				errs <- repo.Update(ctx, pkg)
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+
+		succeeded := 0
+		for err := range errs {
+			if err == nil {
+				succeeded++
+				continue
+			}
+			assert.ErrorIs(t, err, domain.ErrVersionConflict)
+		}
+		assert.Equal(t, 1, succeeded, "exactly one writer wins")
+	})
+}
+
+func testDelete(t *testing.T, repo domain.PackageRepository) {
+	ctx := context.Background()
+	createSequence(t, repo, "DEL1", "DEL2")
+
+	require.NoError(t, repo.Delete(ctx, "DEL1"))
+
+	_, err := repo.FindByID(ctx, "DEL1")
+	assert.ErrorIs(t, err, domain.ErrPackageNotFound)
+
+	err = repo.Delete(ctx, "DEL1")
+	assert.ErrorIs(t, err, domain.ErrPackageNotFound)
+
+	packages, total, err := repo.FindAll(ctx, domain.PackageFilter{}, domain.ListOptions{Page: 1, Size: 10})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, []string{"DEL2"}, packageIDs(packages))
+}
+
+func testConcurrentWrites(t *testing.T, repo domain.PackageRepository) {
+	ctx := context.Background()
+	const writers = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers*2)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- repo.Create(ctx, contractPackage(fmt.Sprintf("CONC%02d", i)))
+		}(i)
+	}
+	wg.Wait()
+
+	require.NoError(t, repo.Create(ctx, contractPackage("SHARED")))
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pkg := contractPackage("SHARED")
+			pkg.Destination = fmt.Sprintf("Destination %02d", i)
+			errs <- repo.Update(ctx, pkg)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	_, total, err := repo.FindAll(ctx, domain.PackageFilter{}, domain.ListOptions{Page: 1, Size: 100})
+	require.NoError(t, err)
+	assert.Equal(t, int64(writers+1), total)
+
+	shared, err := repo.FindByID(ctx, "SHARED")
+	require.NoError(t, err)
+	assert.Regexp(t, `^Destination \d{2}$`, shared.Destination, "one of the concurrent updates wins")
+}
+
+func testTenantIsolation(t *testing.T, repo domain.PackageRepository) {
+	acme := domain.WithTenant(context.Background(), "acme")
+	globex := domain.WithTenant(context.Background(), "globex")
+	opts := domain.ListOptions{Page: 1, Size: 10}
+
+	pkg := contractPackage("TEN1")
+	require.NoError(t, repo.Create(acme, pkg))
+	assert.Equal(t, "acme", pkg.TenantID)
+
+	other := contractPackage("TEN1")
+	other.Destination = "Seattle"
+	require.NoError(t, repo.Create(globex, other), "package IDs are unique per tenant")
+	assert.ErrorIs(t, repo.Create(globex, contractPackage("TEN1")), domain.ErrPackageExists)
+
+	found, err := repo.FindByID(acme, "TEN1")
+	require.NoError(t, err)
+	assert.Equal(t, "acme", found.TenantID)
+	assert.Equal(t, "Los Angeles", found.Destination)
+
+	_, err = repo.FindByID(context.Background(), "TEN1")
+	assert.ErrorIs(t, err, domain.ErrPackageNotFound, "the default tenant sees neither package")
+
+	require.NoError(t, repo.Create(globex, contractPackage("TEN2")))
+	packages, total, err := repo.FindAll(acme, domain.PackageFilter{}, opts)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, []string{"TEN1"}, packageIDs(packages))
+
+	packages, total, err = repo.Search(acme, domain.SearchQuery{Text: "TEN", Mode: domain.SearchPrefix}, opts)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, []string{"TEN1"}, packageIDs(packages))
+
+	status := "IN_TRANSIT"
+	patched, err := repo.Patch(globex, "TEN1", domain.PackageUpdate{CurrentStatus: &status})
+	require.NoError(t, err)
+	assert.Equal(t, "globex", patched.TenantID)
+
+	found.Origin = "Boston"
+	require.NoError(t, repo.Update(acme, found))
+	stored, err := repo.FindByID(globex, "TEN1")
+	require.NoError(t, err)
+	assert.Equal(t, "New York", stored.Origin, "updates stay within the tenant")
+	stored, err = repo.FindByID(acme, "TEN1")
+	require.NoError(t, err)
+	assert.Equal(t, "CREATED", stored.CurrentStatus, "patches stay within the tenant")
+
+	assert.ErrorIs(t, repo.Delete(acme, "TEN2"), domain.ErrPackageNotFound)
+	require.NoError(t, repo.Delete(globex, "TEN1"))
+	_, err = repo.FindByID(acme, "TEN1")
+	assert.NoError(t, err, "deletes stay within the tenant")
+}
@@ -0,0 +1,147 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+// APIKeyRepository is a thread-safe in-memory domain.APIKeyRepository
+type APIKeyRepository struct {
+	keys map[string]domain.APIKey
+	mu   sync.RWMutex
+}
+
+func NewAPIKeyRepository() *APIKeyRepository {
+	return &APIKeyRepository{
+		keys: make(map[string]domain.APIKey),
+	}
+}
+
+func (r *APIKeyRepository) Create(ctx context.Context, key *domain.APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.keys {
+		if existing.ID == key.ID || existing.Hash == key.Hash {
+			return fmt.Errorf("API key %s already exists", key.ID)
+		}
+	}
+	r.keys[key.ID] = cloneAPIKey(*key)
+	return nil
+}
+
+func (r *APIKeyRepository) FindByID(ctx context.Context, id string) (*domain.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key, exists := r.keys[id]
+	if !exists {
+		return nil, domain.ErrAPIKeyNotFound
+	}
+	clone := cloneAPIKey(key)
+	return &clone, nil
+}
+
+func (r *APIKeyRepository) FindByHash(ctx context.Context, hash string) (*domain.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, key := range r.keys {
+		if key.Hash == hash {
+			clone := cloneAPIKey(key)
+			return &clone, nil
+		}
+	}
+	return nil, domain.ErrAPIKeyNotFound
+}
+
+func (r *APIKeyRepository) FindAll(ctx context.Context) ([]domain.APIKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := make([]domain.APIKey, 0, len(r.keys))
+	for _, key := range r.keys {
+		keys = append(keys, cloneAPIKey(key))
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+func (r *APIKeyRepository) Rotate(ctx context.Context, key *domain.APIKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.keys[key.ID]
+	if !exists {
+		return domain.ErrAPIKeyNotFound
+	}
+	if existing.Revoked() {
+		return domain.ErrAPIKeyRevoked
+	}
+	existing.Hash = key.Hash
+	existing.Prefix = key.Prefix
+	existing.RotatedAt = key.RotatedAt
+	r.keys[key.ID] = cloneAPIKey(existing)
+	return nil
+}
+
+func (r *APIKeyRepository) Revoke(ctx context.Context, id string, revokedAt time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, exists := r.keys[id]
+	if !exists {
+		return domain.ErrAPIKeyNotFound
+	}
+	if !existing.Revoked() {
+		existing.RevokedAt = &revokedAt
+		r.keys[id] = existing
+	}
+	return nil
+}
+
+func cloneAPIKey(key domain.APIKey) domain.APIKey {
+	if key.RotatedAt != nil {
+		rotatedAt := *key.RotatedAt
+		key.RotatedAt = &rotatedAt
+	}
+	if key.RevokedAt != nil {
+		revokedAt := *key.RevokedAt
+		key.RevokedAt = &revokedAt
+	}
+	return key
+}
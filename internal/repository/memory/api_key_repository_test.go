@@ -0,0 +1,81 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := NewAPIKeyRepository()
+	created := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	first := &domain.APIKey{ID: "k1", Name: "ci", Role: domain.RoleOperator, Prefix: "mt_abc", Hash: "h1", CreatedAt: created}
+	second := &domain.APIKey{ID: "k2", Name: "ops", Role: domain.RoleAdmin, Prefix: "mt_def", Hash: "h2", CreatedAt: created.Add(time.Minute)}
+	require.NoError(t, repo.Create(ctx, second))
+	require.NoError(t, repo.Create(ctx, first))
+	assert.Error(t, repo.Create(ctx, &domain.APIKey{ID: "k3", Hash: "h1"}), "hashes are unique")
+
+	found, err := repo.FindByHash(ctx, "h2")
+	require.NoError(t, err)
+	assert.Equal(t, second, found)
+
+	_, err = repo.FindByHash(ctx, "missing")
+	assert.ErrorIs(t, err, domain.ErrAPIKeyNotFound)
+
+	keys, err := repo.FindAll(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "k1", keys[0].ID)
+
+	t.Run("rotate replaces the secret", func(t *testing.T) {
+		rotated := created.Add(time.Hour)
+		require.NoError(t, repo.Rotate(ctx, &domain.APIKey{ID: "k1", Name: "ignored", Prefix: "mt_xyz", Hash: "h3", RotatedAt: &rotated}))
+		rotated = rotated.Add(time.Hour)
+
+		found, err := repo.FindByID(ctx, "k1")
+		require.NoError(t, err)
+		assert.Equal(t, "ci", found.Name)
+		assert.Equal(t, "mt_xyz", found.Prefix)
+		assert.Equal(t, created.Add(time.Hour), *found.RotatedAt, "stored times are copies")
+		assert.False(t, found.Revoked())
+
+		_, err = repo.FindByHash(ctx, "h1")
+		assert.ErrorIs(t, err, domain.ErrAPIKeyNotFound)
+		assert.ErrorIs(t, repo.Rotate(ctx, &domain.APIKey{ID: "missing"}), domain.ErrAPIKeyNotFound)
+	})
+
+	t.Run("revoke keeps the first revocation time", func(t *testing.T) {
+		revoked := created.Add(2 * time.Hour)
+		require.NoError(t, repo.Revoke(ctx, "k2", revoked))
+		require.NoError(t, repo.Revoke(ctx, "k2", revoked.Add(time.Hour)))
+
+		found, err := repo.FindByID(ctx, "k2")
+		require.NoError(t, err)
+		assert.Equal(t, revoked, *found.RevokedAt)
+		assert.ErrorIs(t, repo.Revoke(ctx, "missing", revoked), domain.ErrAPIKeyNotFound)
+	})
+
+	t.Run("revoke between the read and write of a rotation", func(t *testing.T) {
+		key := &domain.APIKey{ID: "k4", Name: "race", Role: domain.RoleViewer, Prefix: "mt_ghi", Hash: "h4", CreatedAt: created}
+		require.NoError(t, repo.Create(ctx, key))
+
+		read, err := repo.FindByID(ctx, "k4")
+		require.NoError(t, err)
+		require.NoError(t, repo.Revoke(ctx, "k4", created.Add(time.Hour)))
+
+		rotated := created.Add(2 * time.Hour)
+		read.Prefix, read.Hash, read.RotatedAt = "mt_jkl", "h5", &rotated
+		assert.ErrorIs(t, repo.Rotate(ctx, read), domain.ErrAPIKeyRevoked)
+
+		found, err := repo.FindByID(ctx, "k4")
+		require.NoError(t, err)
+		assert.True(t, found.Revoked(), "the key stays revoked")
+		assert.Equal(t, "h4", found.Hash, "the new secret was not stored")
+	})
+}
@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+// EventRepository is a thread-safe in-memory domain.EventRepository
+type EventRepository struct {
+	events map[packageKey][]domain.Event
+	mu     sync.RWMutex
+}
+
+func NewEventRepository() *EventRepository {
+	return &EventRepository{
+		events: make(map[packageKey][]domain.Event),
+	}
+}
+
+func (r *EventRepository) Append(ctx context.Context, packageID string, events ...domain.Event) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := keyOf(ctx, packageID)
+	r.events[key] = append(r.events[key], events...)
+	return nil
+}
+
+// FindByPackageID returns the package's full event history ordered by
+// timestamp
+func (r *EventRepository) FindByPackageID(ctx context.Context, packageID string) ([]domain.Event, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	events := append([]domain.Event(nil), r.events[keyOf(ctx, packageID)]...)
+	r.mu.RUnlock()
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp)
+	})
+
+	return events, nil
+}
+
+func (r *EventRepository) DeleteByPackageID(ctx context.Context, packageID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.events, keyOf(ctx, packageID))
+	return nil
+}
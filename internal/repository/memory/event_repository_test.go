@@ -0,0 +1,63 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := NewEventRepository()
+	first := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+
+	require.NoError(t, repo.Append(ctx, "123",
+		domain.Event{Timestamp: first.Add(time.Hour), Location: "Memphis Hub", Status: "IN_TRANSIT"},
+		domain.Event{Timestamp: first, Location: "New York", Status: "PICKED_UP"},
+	))
+	require.NoError(t, repo.Append(ctx, "456", domain.Event{Timestamp: first, Location: "Boston", Status: "PICKED_UP"}))
+
+	t.Run("ordered by timestamp", func(t *testing.T) {
+		events, err := repo.FindByPackageID(ctx, "123")
+		require.NoError(t, err)
+		require.Len(t, events, 2)
+		assert.Equal(t, "PICKED_UP", events[0].Status)
+		assert.Equal(t, "IN_TRANSIT", events[1].Status)
+	})
+
+	t.Run("unknown package", func(t *testing.T) {
+		events, err := repo.FindByPackageID(ctx, "789")
+		require.NoError(t, err)
+		assert.Empty(t, events)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, repo.DeleteByPackageID(ctx, "123"))
+
+		events, err := repo.FindByPackageID(ctx, "123")
+		require.NoError(t, err)
+		assert.Empty(t, events)
+
+		events, err = repo.FindByPackageID(ctx, "456")
+		require.NoError(t, err)
+		assert.Len(t, events, 1)
+	})
+	t.Run("scoped by tenant", func(t *testing.T) {
+		acme := domain.WithTenant(ctx, "acme")
+		require.NoError(t, repo.Append(acme, "456", domain.Event{Timestamp: first, Location: "Denver", Status: "PICKED_UP"}))
+
+		events, err := repo.FindByPackageID(acme, "456")
+		require.NoError(t, err)
+		require.Len(t, events, 1)
+		assert.Equal(t, "Denver", events[0].Location)
+
+		require.NoError(t, repo.DeleteByPackageID(acme, "456"))
+		events, err = repo.FindByPackageID(ctx, "456")
+		require.NoError(t, err)
+		assert.Len(t, events, 1, "the default tenant's events are kept")
+	})
+}
@@ -0,0 +1,101 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+// IdempotencyStore is a thread-safe in-memory domain.IdempotencyStore.
+// Expired records and lapsed reservations are dropped when their key is
+// reserved again.
+type IdempotencyStore struct {
+	records map[string]domain.IdempotencyRecord
+	mu      sync.Mutex
+	now     func() time.Time
+}
+
+func NewIdempotencyStore() *IdempotencyStore {
+	return &IdempotencyStore{
+		records: make(map[string]domain.IdempotencyRecord),
+		now:     time.Now,
+	}
+}
+
+func (s *IdempotencyStore) Reserve(ctx context.Context, record *domain.IdempotencyRecord) (*domain.IdempotencyRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, exists := s.records[record.Key]; exists && s.live(existing) {
+		return cloneRecord(existing), nil
+	}
+
+	s.purgeExpired()
+	pending := *record
+	pending.Completed = false
+	s.records[record.Key] = pending
+	return nil, nil
+}
+
+func (s *IdempotencyStore) Complete(ctx context.Context, record *domain.IdempotencyRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	completed := *cloneRecord(*record)
+	completed.Completed = true
+	s.records[record.Key] = completed
+	return nil
+}
+
+func (s *IdempotencyStore) Release(ctx context.Context, record *domain.IdempotencyRecord) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, exists := s.records[record.Key]; exists && !existing.Completed && existing.LockedUntil.Equal(record.LockedUntil) {
+		delete(s.records, record.Key)
+	}
+	return nil
+}
+
+// live reports whether a record has neither expired nor, while pending,
+// lapsed. Callers must hold the lock.
+func (s *IdempotencyStore) live(record domain.IdempotencyRecord) bool {
+	now := s.now()
+	return now.Before(record.ExpiresAt) && (record.Completed || now.Before(record.LockedUntil))
+}
+
+// purgeExpired drops every record that is no longer live. Callers must hold
+// the lock.
+func (s *IdempotencyStore) purgeExpired() {
+	for key, record := range s.records {
+		if !s.live(record) {
+			delete(s.records, key)
+		}
+	}
+}
+
+func cloneRecord(record domain.IdempotencyRecord) *domain.IdempotencyRecord {
+	clone := record
+	clone.Body = append([]byte(nil), record.Body...)
+	if record.Header != nil {
+		clone.Header = make(map[string]string, len(record.Header))
+		for k, v := range record.Header {
+			clone.Header[k] = v
+		}
+	}
+	return &clone
+}
@@ -0,0 +1,82 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+	store := NewIdempotencyStore()
+	store.now = func() time.Time { return now }
+
+	record := &domain.IdempotencyRecord{Key: "k1", Fingerprint: "abc", LockedUntil: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)}
+	existing, err := store.Reserve(ctx, record)
+	require.NoError(t, err)
+	assert.Nil(t, existing)
+
+	existing, err = store.Reserve(ctx, record)
+	require.NoError(t, err)
+	require.NotNil(t, existing)
+	assert.False(t, existing.Completed, "the first request is still pending")
+
+	record.StatusCode = 201
+	record.Body = []byte(`{"success":true}`)
+	require.NoError(t, store.Complete(ctx, record))
+	record.Body[0] = 'X'
+
+	existing, err = store.Reserve(ctx, &domain.IdempotencyRecord{Key: "k1", Fingerprint: "other"})
+	require.NoError(t, err)
+	require.NotNil(t, existing)
+	assert.True(t, existing.Completed)
+	assert.Equal(t, "abc", existing.Fingerprint)
+	assert.Equal(t, []byte(`{"success":true}`), existing.Body, "stored bodies are copies")
+
+	t.Run("completed keys are not released", func(t *testing.T) {
+		require.NoError(t, store.Release(ctx, record))
+		existing, err := store.Reserve(ctx, record)
+		require.NoError(t, err)
+		assert.NotNil(t, existing)
+	})
+
+	t.Run("released keys can be reserved again", func(t *testing.T) {
+		pending := &domain.IdempotencyRecord{Key: "k3", Fingerprint: "abc", LockedUntil: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)}
+		_, err := store.Reserve(ctx, pending)
+		require.NoError(t, err)
+		require.NoError(t, store.Release(ctx, pending))
+		existing, err := store.Reserve(ctx, pending)
+		require.NoError(t, err)
+		assert.Nil(t, existing)
+	})
+
+	t.Run("lapsed reservations are taken over", func(t *testing.T) {
+		lost := &domain.IdempotencyRecord{Key: "k2", Fingerprint: "abc", LockedUntil: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)}
+		_, err := store.Reserve(ctx, lost)
+		require.NoError(t, err)
+
+		now = now.Add(2 * time.Minute)
+		retry := &domain.IdempotencyRecord{Key: "k2", Fingerprint: "abc", LockedUntil: now.Add(time.Minute), ExpiresAt: now.Add(time.Hour)}
+		existing, err := store.Reserve(ctx, retry)
+		require.NoError(t, err)
+		assert.Nil(t, existing)
+
+		require.NoError(t, store.Release(ctx, lost), "the lost request cannot release the retry's reservation")
+		existing, err = store.Reserve(ctx, retry)
+		require.NoError(t, err)
+		require.NotNil(t, existing)
+		assert.False(t, existing.Completed)
+	})
+
+	t.Run("expired records are replaced", func(t *testing.T) {
+		now = now.Add(2 * time.Hour)
+		existing, err := store.Reserve(ctx, &domain.IdempotencyRecord{Key: "k1", Fingerprint: "new", ExpiresAt: now.Add(time.Hour)})
+		require.NoError(t, err)
+		assert.Nil(t, existing)
+	})
+}
@@ -0,0 +1,298 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+// PackageRepository is a thread-safe in-memory domain.PackageRepository. It
+// mirrors the MongoDB implementation's search, pagination and not-found
+// semantics and is intended for local development and testing.
+type PackageRepository struct {
+	packages map[packageKey]domain.Package
+	mu       sync.RWMutex
+}
+
+// packageKey identifies a package within its tenant
+type packageKey struct {
+	tenant string
+	id     string
+}
+
+func keyOf(ctx context.Context, id string) packageKey {
+	return packageKey{tenant: domain.TenantFromContext(ctx), id: id}
+}
+
+func NewPackageRepository() *PackageRepository {
+	return &PackageRepository{
+		packages: make(map[packageKey]domain.Package),
+	}
+}
+
+func (r *PackageRepository) FindByID(ctx context.Context, id string) (*domain.Package, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	pkg, exists := r.packages[keyOf(ctx, id)]
+	if !exists {
+		return nil, domain.ErrPackageNotFound
+	}
+	return clonePackage(pkg), nil
+}
+
+func (r *PackageRepository) FindAll(ctx context.Context, filter domain.PackageFilter, opts domain.ListOptions) ([]domain.Package, int64, error) {
+	return r.find(ctx, func(pkg domain.Package) bool { return matchFilter(filter, pkg) }, opts)
+}
+
+// matchFilter reports whether pkg satisfies every non-zero field of filter
+func matchFilter(filter domain.PackageFilter, pkg domain.Package) bool {
+	if len(filter.Statuses) > 0 && !containsString(filter.Statuses, pkg.CurrentStatus) {
+		return false
+	}
+	if filter.Origin != "" && pkg.Origin != filter.Origin {
+		return false
+	}
+	if filter.Destination != "" && pkg.Destination != filter.Destination {
+		return false
+	}
+	if filter.SenderName != "" && pkg.Sender.Name != filter.SenderName {
+		return false
+	}
+	if filter.RecipientName != "" && pkg.Recipient.Name != filter.RecipientName {
+		return false
+	}
+	return filter.Created.Contains(pkg.CreatedAt) && filter.Updated.Contains(pkg.UpdatedAt)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *PackageRepository) Search(ctx context.Context, query domain.SearchQuery, opts domain.ListOptions) ([]domain.Package, int64, error) {
+	match, err := searchMatcher(query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return r.find(ctx, func(pkg domain.Package) bool {
+		return match(pkg.PackageID) ||
+			match(pkg.Sender.Name) ||
+			match(pkg.Recipient.Name) ||
+			match(pkg.Origin) ||
+			match(pkg.Destination) ||
+			match(pkg.CurrentStatus)
+	}, opts)
+}
+
+// searchMatcher returns a field matcher with the same semantics as the
+// database backends for the query's mode
+func searchMatcher(query domain.SearchQuery) (func(string) bool, error) {
+	switch query.Mode {
+	case domain.SearchLiteral:
+		text := strings.ToLower(query.Text)
+		return func(field string) bool {
+			return strings.Contains(strings.ToLower(field), text)
+		}, nil
+	case domain.SearchPrefix:
+		return func(field string) bool {
+			return strings.HasPrefix(field, query.Text)
+		}, nil
+	case domain.SearchExact:
+		return func(field string) bool {
+			return field == query.Text
+		}, nil
+	case domain.SearchRegex:
+		pattern, err := regexp.Compile("(?i)" + query.Text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", domain.ErrInvalidSearch, err)
+		}
+		return pattern.MatchString, nil
+	case domain.SearchText:
+		words := query.Words()
+		return func(field string) bool {
+			for _, word := range (domain.SearchQuery{Text: field}).Words() {
+				if slices.Contains(words, word) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown mode %q", domain.ErrInvalidSearch, query.Mode)
+	}
+}
+
+func (r *PackageRepository) Create(ctx context.Context, pkg *domain.Package) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := keyOf(ctx, pkg.PackageID)
+	if _, exists := r.packages[key]; exists {
+		return fmt.Errorf("%w: %s", domain.ErrPackageExists, pkg.PackageID)
+	}
+
+	now := time.Now()
+	pkg.TenantID = key.tenant
+	pkg.CreatedAt = now
+	pkg.UpdatedAt = now
+	pkg.Version = 1
+
+	r.packages[key] = *clonePackage(*pkg)
+	return nil
+}
+
+func (r *PackageRepository) Update(ctx context.Context, pkg *domain.Package) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := keyOf(ctx, pkg.PackageID)
+	stored, exists := r.packages[key]
+	if !exists {
+		return domain.ErrPackageNotFound
+	}
+	if pkg.Version > 0 && pkg.Version != stored.Version {
+		return domain.ErrVersionConflict
+	}
+
+	pkg.TenantID = stored.TenantID
+	pkg.CreatedAt = stored.CreatedAt
+	pkg.UpdatedAt = time.Now()
+	pkg.Version = stored.Version + 1
+
+	r.packages[key] = *clonePackage(*pkg)
+	return nil
+}
+
+func (r *PackageRepository) Patch(ctx context.Context, id string, update domain.PackageUpdate) (*domain.Package, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := keyOf(ctx, id)
+	pkg, exists := r.packages[key]
+	if !exists {
+		return nil, domain.ErrPackageNotFound
+	}
+	if update.Version > 0 && update.Version != pkg.Version {
+		return nil, domain.ErrVersionConflict
+	}
+
+	setString(&pkg.Sender.Name, update.SenderName)
+	setString(&pkg.Sender.Address, update.SenderAddress)
+	setString(&pkg.Recipient.Name, update.RecipientName)
+	setString(&pkg.Recipient.Address, update.RecipientAddress)
+	setString(&pkg.Origin, update.Origin)
+	setString(&pkg.Destination, update.Destination)
+	setString(&pkg.CurrentStatus, update.CurrentStatus)
+	pkg.UpdatedAt = time.Now()
+	pkg.Version++
+
+	r.packages[key] = pkg
+	return clonePackage(pkg), nil
+}
+
+func setString(field *string, value *string) {
+	if value != nil {
+		*field = *value
+	}
+}
+
+func (r *PackageRepository) Delete(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := keyOf(ctx, id)
+	if _, exists := r.packages[key]; !exists {
+		return domain.ErrPackageNotFound
+	}
+
+	delete(r.packages, key)
+	return nil
+}
+
+// find returns the matching packages in sort order, paginated by offset or,
+// when opts.After is set, by keyset
+func (r *PackageRepository) find(ctx context.Context, match func(domain.Package) bool, opts domain.ListOptions) ([]domain.Package, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	tenant := domain.TenantFromContext(ctx)
+
+	r.mu.RLock()
+	var matched []domain.Package
+	for key, pkg := range r.packages {
+		if key.tenant == tenant && match(pkg) {
+			matched = append(matched, *clonePackage(pkg))
+		}
+	}
+	r.mu.RUnlock()
+
+	order := opts.Sort
+	if len(order) == 0 {
+		order = domain.DefaultSort
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return domain.ComparePackages(order, &matched[i], &matched[j]) < 0
+	})
+
+	total := int64(len(matched))
+	start := (opts.Page - 1) * opts.Size
+	if opts.After != nil {
+		start = sort.Search(len(matched), func(i int) bool {
+			return domain.CompareKey(order, &matched[i], opts.After) > 0
+		})
+	}
+	if start < 0 || start >= len(matched) {
+		return nil, total, nil
+	}
+	end := start + opts.Size
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	return matched[start:end], total, nil
+}
+
+// clonePackage copies a package so that callers never share memory with the
+// stored value. The event history is not persisted on packages.
+func clonePackage(pkg domain.Package) *domain.Package {
+	if pkg.LatestEvent != nil {
+		latest := *pkg.LatestEvent
+		pkg.LatestEvent = &latest
+	}
+	pkg.Events = nil
+	return &pkg
+}
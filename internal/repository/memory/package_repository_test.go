@@ -0,0 +1,189 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPackage(id string) *domain.Package {
+	return &domain.Package{
+		PackageID: id,
+		Sender: domain.Address{
+			Name:    "John Doe",
+			Address: "123 Main St",
+		},
+		Recipient: domain.Address{
+			Name:    "Jane Doe",
+			Address: "456 Oak St",
+		},
+		Origin:        "New York",
+		Destination:   "Los Angeles",
+		CurrentStatus: "CREATED",
+	}
+}
+
+func TestPackageRepository_FindByID(t *testing.T) {
+	ctx := context.Background()
+	repo := NewPackageRepository()
+	require.NoError(t, repo.Create(ctx, newTestPackage("123")))
+
+	t.Run("success", func(t *testing.T) {
+		pkg, err := repo.FindByID(ctx, "123")
+		require.NoError(t, err)
+		assert.Equal(t, "123", pkg.PackageID)
+		assert.Equal(t, "John Doe", pkg.Sender.Name)
+		assert.False(t, pkg.CreatedAt.IsZero())
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		pkg, err := repo.FindByID(ctx, "456")
+		assert.ErrorIs(t, err, domain.ErrPackageNotFound)
+		assert.Nil(t, pkg)
+	})
+
+	t.Run("returns a copy", func(t *testing.T) {
+		pkg, err := repo.FindByID(ctx, "123")
+		require.NoError(t, err)
+		pkg.Origin = "Boston"
+
+		stored, err := repo.FindByID(ctx, "123")
+		require.NoError(t, err)
+		assert.Equal(t, "New York", stored.Origin)
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		pkg, err := repo.FindByID(cancelled, "123")
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Nil(t, pkg)
+	})
+}
+
+func TestPackageRepository_FindAll(t *testing.T) {
+	ctx := context.Background()
+	repo := NewPackageRepository()
+	for i := 1; i <= 5; i++ {
+		require.NoError(t, repo.Create(ctx, newTestPackage(fmt.Sprintf("PKG%d", i))))
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Run("newest first", func(t *testing.T) {
+		packages, total, err := repo.FindAll(ctx, domain.PackageFilter{}, domain.ListOptions{Page: 1, Size: 2})
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), total)
+		require.Len(t, packages, 2)
+		assert.Equal(t, "PKG5", packages[0].PackageID)
+		assert.Equal(t, "PKG4", packages[1].PackageID)
+	})
+
+	t.Run("last page", func(t *testing.T) {
+		packages, total, err := repo.FindAll(ctx, domain.PackageFilter{}, domain.ListOptions{Page: 3, Size: 2})
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), total)
+		require.Len(t, packages, 1)
+		assert.Equal(t, "PKG1", packages[0].PackageID)
+	})
+
+	t.Run("past the end", func(t *testing.T) {
+		packages, total, err := repo.FindAll(ctx, domain.PackageFilter{}, domain.ListOptions{Page: 4, Size: 2})
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), total)
+		assert.Empty(t, packages)
+	})
+}
+
+func TestPackageRepository_Search(t *testing.T) {
+	ctx := context.Background()
+	repo := NewPackageRepository()
+	pkg := newTestPackage("ABC123")
+	pkg.Recipient.Name = "Maria Lopez"
+	require.NoError(t, repo.Create(ctx, pkg))
+	require.NoError(t, repo.Create(ctx, newTestPackage("XYZ789")))
+
+	t.Run("case insensitive", func(t *testing.T) {
+		packages, total, err := repo.Search(ctx, domain.SearchQuery{Text: "maria", Mode: domain.SearchLiteral}, domain.ListOptions{Page: 1, Size: 10})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), total)
+		require.Len(t, packages, 1)
+		assert.Equal(t, "ABC123", packages[0].PackageID)
+	})
+
+	t.Run("matches several fields", func(t *testing.T) {
+		packages, total, err := repo.Search(ctx, domain.SearchQuery{Text: "new york", Mode: domain.SearchLiteral}, domain.ListOptions{Page: 1, Size: 10})
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), total)
+		assert.Len(t, packages, 2)
+	})
+
+	t.Run("invalid pattern", func(t *testing.T) {
+		_, _, err := repo.Search(ctx, domain.SearchQuery{Text: "(", Mode: domain.SearchRegex}, domain.ListOptions{Page: 1, Size: 10})
+		assert.ErrorIs(t, err, domain.ErrInvalidSearch)
+	})
+}
+
+func TestPackageRepository_Create(t *testing.T) {
+	ctx := context.Background()
+	repo := NewPackageRepository()
+
+	t.Run("success", func(t *testing.T) {
+		pkg := newTestPackage("123")
+		err := repo.Create(ctx, pkg)
+		assert.NoError(t, err)
+		assert.False(t, pkg.CreatedAt.IsZero())
+		assert.Equal(t, pkg.CreatedAt, pkg.UpdatedAt)
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		err := repo.Create(ctx, newTestPackage("123"))
+		assert.Error(t, err)
+	})
+}
+
+func TestPackageRepository_Update(t *testing.T) {
+	ctx := context.Background()
+	repo := NewPackageRepository()
+	require.NoError(t, repo.Create(ctx, newTestPackage("123")))
+
+	t.Run("success", func(t *testing.T) {
+		pkg := newTestPackage("123")
+		pkg.Destination = "Seattle"
+		err := repo.Update(ctx, pkg)
+		require.NoError(t, err)
+
+		stored, err := repo.FindByID(ctx, "123")
+		require.NoError(t, err)
+		assert.Equal(t, "Seattle", stored.Destination)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		err := repo.Update(ctx, newTestPackage("456"))
+		assert.ErrorIs(t, err, domain.ErrPackageNotFound)
+	})
+}
+
+func TestPackageRepository_Delete(t *testing.T) {
+	ctx := context.Background()
+	repo := NewPackageRepository()
+	require.NoError(t, repo.Create(ctx, newTestPackage("123")))
+
+	t.Run("success", func(t *testing.T) {
+		err := repo.Delete(ctx, "123")
+		require.NoError(t, err)
+
+		_, err = repo.FindByID(ctx, "123")
+		assert.ErrorIs(t, err, domain.ErrPackageNotFound)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		err := repo.Delete(ctx, "123")
+		assert.ErrorIs(t, err, domain.ErrPackageNotFound)
+	})
+}
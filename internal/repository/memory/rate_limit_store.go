@@ -0,0 +1,387 @@
+package memory
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/snavarro/microtracker/internal/lru"
+	"golang.org/x/time/rate"
+)
+
+// rateLimitCleanupInterval is how often idle buckets are removed
+const rateLimitCleanupInterval = 5 * time.Minute
+
+// rateLimitWindow is the window of the sliding and fixed window algorithms
+const rateLimitWindow = time.Minute
+
+// RateLimitStore is a thread-safe in-memory domain.RateLimitStore supporting
+// every rate limit algorithm. Buckets are local to the process, so every
+// replica enforces the limits separately. Buckets idle for longer than their
+// TTL are removed in the background until the store is stopped, and with a
+// maximum number of buckets the least recently used ones are evicted once it
+// is reached. Buckets are split into shards with a lock each, so requests for
+// different keys rarely wait for one another.
+type RateLimitStore struct {
+	buckets    *lru.Cache[*bucket]
+	maxBuckets int
+	exemptions map[string]time.Time
+	mu         sync.Mutex
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+// RateLimitStoreOption configures optional RateLimitStore behaviour
+type RateLimitStoreOption func(*RateLimitStore)
+
+// WithMaxBuckets bounds the number of buckets kept. An evicted bucket starts
+// over as a full one, so the maximum should comfortably exceed the number of
+// active clients and routes.
+func WithMaxBuckets(n int) RateLimitStoreOption {
+	return func(s *RateLimitStore) {
+		s.maxBuckets = n
+	}
+}
+
+// bucket is the state of one key under its limit. It is only accessed while
+// holding the lock of its shard.
+type bucket struct {
+	limiter    limiter
+	limit      domain.RateLimit
+	lastAccess time.Time
+}
+
+// limiter implements a rate limit algorithm
+type limiter interface {
+	take(now time.Time) *domain.RateLimitResult
+	// peek returns the state at now without changing it
+	peek(now time.Time) *domain.RateLimitResult
+}
+
+func NewRateLimitStore(opts ...RateLimitStoreOption) *RateLimitStore {
+	s := &RateLimitStore{
+		exemptions: make(map[string]time.Time),
+		stop:       make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.buckets = lru.New[*bucket](s.maxBuckets, lru.DefaultShards)
+
+	go s.cleanupLoop()
+
+	return s
+}
+
+// Stop stops removing idle buckets in the background. The store remains
+// usable. Stopping a stopped store is a no-op.
+func (s *RateLimitStore) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+func (s *RateLimitStore) Take(ctx context.Context, key string, limit domain.RateLimit, now time.Time) (*domain.RateLimitResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var result *domain.RateLimitResult
+	s.buckets.Update(key, func(b *bucket, exists bool) (*bucket, bool) {
+		// A bucket starts over when its limit changes, such as when the
+		// caller moves to another plan
+		if !exists || b.limit != limit {
+			b = &bucket{limiter: newLimiter(limit), limit: limit}
+		}
+		b.lastAccess = now
+		result = b.limiter.take(now)
+		return b, true
+	})
+	return result, nil
+}
+
+func (s *RateLimitStore) Release(ctx context.Context, key string, limit domain.RateLimit, lease string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.buckets.Update(key, func(b *bucket, exists bool) (*bucket, bool) {
+		if exists && b.limit == limit {
+			if slots, ok := b.limiter.(*concurrencySlots); ok {
+				delete(slots.leases, lease)
+			}
+		}
+		return b, false
+	})
+	return nil
+}
+
+func (s *RateLimitStore) Peek(ctx context.Context, key string, limit domain.RateLimit, now time.Time) (*domain.RateLimitResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var result *domain.RateLimitResult
+	s.buckets.Update(key, func(b *bucket, exists bool) (*bucket, bool) {
+		if exists && b.limit == limit {
+			result = b.limiter.peek(now)
+		}
+		return b, false
+	})
+	if result == nil {
+		result = newLimiter(limit).peek(now)
+	}
+	return result, nil
+}
+
+func (s *RateLimitStore) Reset(ctx context.Context, key string, limit domain.RateLimit, now time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.buckets.Delete(key)
+	return nil
+}
+
+func (s *RateLimitStore) Exempt(ctx context.Context, identity string, until time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if until.IsZero() {
+		delete(s.exemptions, identity)
+	} else {
+		s.exemptions[identity] = until
+	}
+	return nil
+}
+
+func (s *RateLimitStore) Exemption(ctx context.Context, identity string, now time.Time) (time.Time, error) {
+	if err := ctx.Err(); err != nil {
+		return time.Time{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if until, ok := s.exemptions[identity]; ok && until.After(now) {
+		return until, nil
+	}
+	return time.Time{}, nil
+}
+
+// Buckets returns the number of buckets kept
+func (s *RateLimitStore) Buckets() int {
+	return s.buckets.Len()
+}
+
+func newLimiter(limit domain.RateLimit) limiter {
+	switch limit.Algorithm {
+	case domain.AlgorithmSlidingWindow:
+		return &slidingWindow{limit: limit.RequestsPerMinute}
+	case domain.AlgorithmFixedWindow:
+		return &fixedWindow{limit: limit.RequestsPerMinute}
+	case domain.AlgorithmConcurrency:
+		return &concurrencySlots{limit: limit.Burst, ttl: limit.TTL, leases: make(map[string]time.Time)}
+	default:
+		return &tokenBucket{
+			limiter: rate.NewLimiter(rate.Limit(float64(limit.RequestsPerMinute)/60.0), limit.Burst),
+		}
+	}
+}
+
+// tokenBucket implements domain.AlgorithmTokenBucket
+type tokenBucket struct {
+	limiter *rate.Limiter
+}
+
+// take spends a token for a request made at now
+func (b *tokenBucket) take(now time.Time) *domain.RateLimitResult {
+	result := &domain.RateLimitResult{Allowed: true, Limit: b.limiter.Burst()}
+
+	reservation := b.limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		result.Allowed = false
+	} else if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		result.Allowed = false
+		if delay != rate.InfDuration {
+			result.RetryAfter = delay
+		}
+	}
+
+	b.state(result, now)
+	return result
+}
+
+func (b *tokenBucket) peek(now time.Time) *domain.RateLimitResult {
+	result := &domain.RateLimitResult{Limit: b.limiter.Burst()}
+	b.state(result, now)
+	return result
+}
+
+// state sets the remaining tokens and the time until the bucket is full
+func (b *tokenBucket) state(result *domain.RateLimitResult, now time.Time) {
+	tokens := b.limiter.TokensAt(now)
+	result.Remaining = int(math.Max(0, math.Floor(tokens)))
+	if perSecond := float64(b.limiter.Limit()); perSecond > 0 && perSecond != float64(rate.Inf) {
+		missing := float64(result.Limit) - tokens
+		result.Reset = time.Duration(missing / perSecond * float64(time.Second))
+	}
+}
+
+// slidingWindow implements domain.AlgorithmSlidingWindow with a log of the
+// times of the requests allowed in the last window, oldest first
+type slidingWindow struct {
+	limit int
+	log   []time.Time
+}
+
+func (w *slidingWindow) take(now time.Time) *domain.RateLimitResult {
+	result := &domain.RateLimitResult{Limit: w.limit}
+
+	w.log = w.current(now)
+	if len(w.log) < w.limit {
+		w.log = append(w.log, now)
+		result.Allowed = true
+	} else if len(w.log) > 0 {
+		result.RetryAfter = w.log[0].Add(rateLimitWindow).Sub(now)
+	}
+
+	w.state(result, w.log, now)
+	return result
+}
+
+func (w *slidingWindow) peek(now time.Time) *domain.RateLimitResult {
+	result := &domain.RateLimitResult{Limit: w.limit}
+	w.state(result, w.current(now), now)
+	return result
+}
+
+// current returns the requests of the window ending at now
+func (w *slidingWindow) current(now time.Time) []time.Time {
+	start := now.Add(-rateLimitWindow)
+	expired := 0
+	for expired < len(w.log) && !w.log[expired].After(start) {
+		expired++
+	}
+	return w.log[expired:]
+}
+
+func (w *slidingWindow) state(result *domain.RateLimitResult, log []time.Time, now time.Time) {
+	result.Remaining = max(w.limit-len(log), 0)
+	if len(log) > 0 {
+		result.Reset = log[len(log)-1].Add(rateLimitWindow).Sub(now)
+	}
+}
+
+// fixedWindow implements domain.AlgorithmFixedWindow
+type fixedWindow struct {
+	limit int
+	start time.Time
+	count int
+}
+
+func (w *fixedWindow) take(now time.Time) *domain.RateLimitResult {
+	result := &domain.RateLimitResult{Limit: w.limit}
+
+	if start := now.Truncate(rateLimitWindow); !start.Equal(w.start) {
+		w.start = start
+		w.count = 0
+	}
+
+	end := w.start.Add(rateLimitWindow).Sub(now)
+	if w.count < w.limit {
+		w.count++
+		result.Allowed = true
+	} else if w.limit > 0 {
+		result.RetryAfter = end
+	}
+
+	result.Remaining = max(w.limit-w.count, 0)
+	if w.count > 0 {
+		result.Reset = end
+	}
+	return result
+}
+
+func (w *fixedWindow) peek(now time.Time) *domain.RateLimitResult {
+	result := &domain.RateLimitResult{Limit: w.limit, Remaining: w.limit}
+	if start := now.Truncate(rateLimitWindow); start.Equal(w.start) && w.count > 0 {
+		result.Remaining = max(w.limit-w.count, 0)
+		result.Reset = start.Add(rateLimitWindow).Sub(now)
+	}
+	return result
+}
+
+// concurrencySlots implements domain.AlgorithmConcurrency. Leases expire
+// after the TTL in case they are never released.
+type concurrencySlots struct {
+	limit  int
+	ttl    time.Duration
+	leases map[string]time.Time
+	next   uint64
+}
+
+func (s *concurrencySlots) take(now time.Time) *domain.RateLimitResult {
+	result := &domain.RateLimitResult{Limit: s.limit}
+
+	for lease, expiresAt := range s.leases {
+		if !expiresAt.After(now) {
+			delete(s.leases, lease)
+		}
+	}
+
+	if len(s.leases) < s.limit {
+		s.next++
+		result.Lease = strconv.FormatUint(s.next, 10)
+		s.leases[result.Lease] = now.Add(s.ttl)
+		result.Allowed = true
+	} else if s.limit > 0 {
+		result.RetryAfter = domain.ConcurrencyRetryAfter
+	}
+
+	result.Remaining = max(s.limit-len(s.leases), 0)
+	return result
+}
+
+func (s *concurrencySlots) peek(now time.Time) *domain.RateLimitResult {
+	inFlight := 0
+	for _, expiresAt := range s.leases {
+		if expiresAt.After(now) {
+			inFlight++
+		}
+	}
+	return &domain.RateLimitResult{Limit: s.limit, Remaining: max(s.limit-inFlight, 0)}
+}
+
+// cleanupLoop periodically removes idle buckets until the store is stopped
+func (s *RateLimitStore) cleanupLoop() {
+	ticker := time.NewTicker(rateLimitCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.purgeIdle(time.Now())
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *RateLimitStore) purgeIdle(now time.Time) {
+	s.buckets.RemoveIf(func(_ string, b *bucket) bool {
+		return now.Sub(b.lastAccess) > b.limit.TTL
+	})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for identity, until := range s.exemptions {
+		if !until.After(now) {
+			delete(s.exemptions, identity)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	limit := domain.RateLimit{RequestsPerMinute: 6, Burst: 3, TTL: time.Minute}
+	store := NewRateLimitStore()
+
+	for i := 0; i < 3; i++ {
+		result, err := store.Take(ctx, "k1", limit, now)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 3, result.Limit)
+		assert.Equal(t, 2-i, result.Remaining)
+		assertDuration(t, time.Duration(i+1)*10*time.Second, result.Reset)
+	}
+
+	result, err := store.Take(ctx, "k1", limit, now.Add(4*time.Second))
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assertDuration(t, 6*time.Second, result.RetryAfter)
+
+	result, err = store.Take(ctx, "k1", limit, now.Add(10*time.Second))
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "a rejected request spends no token")
+	assertDuration(t, 30*time.Second, result.Reset)
+
+	result, err = store.Take(ctx, "k2", limit, now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Remaining, "keys have separate buckets")
+
+	t.Run("a new limit starts a new bucket", func(t *testing.T) {
+		result, err := store.Take(ctx, "k2", domain.RateLimit{RequestsPerMinute: 60, Burst: 10, TTL: time.Minute}, now)
+		require.NoError(t, err)
+		assert.Equal(t, 9, result.Remaining)
+	})
+
+	t.Run("bucket that never refills", func(t *testing.T) {
+		result, err := store.Take(ctx, "blocked", domain.RateLimit{}, now)
+		require.NoError(t, err)
+		assert.False(t, result.Allowed)
+		assert.Zero(t, result.RetryAfter)
+	})
+
+	t.Run("idle buckets are removed", func(t *testing.T) {
+		store.purgeIdle(now.Add(10*time.Second + time.Minute))
+		assert.Equal(t, 1, store.Buckets())
+		var keys []string
+		store.buckets.Range(func(key string, _ *bucket) { keys = append(keys, key) })
+		assert.Equal(t, []string{"k1"}, keys)
+	})
+}
+
+func TestRateLimitStore_MaxBuckets(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	limit := domain.RateLimit{RequestsPerMinute: 60, Burst: 1, TTL: time.Minute}
+	store := NewRateLimitStore(WithMaxBuckets(100))
+	defer store.Stop()
+
+	for i := 0; i < 1000; i++ {
+		_, err := store.Take(ctx, fmt.Sprintf("ip:198.51.100.%d", i), limit, now)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 100, store.Buckets(), "buckets beyond the maximum are evicted")
+
+	// The bucket in use survives a spray of new keys in its shard
+	_, err := store.Take(ctx, "k1", limit, now)
+	require.NoError(t, err)
+	for i := 0; i < 1000; i++ {
+		_, err := store.Take(ctx, "k1", limit, now)
+		require.NoError(t, err)
+		_, err = store.Take(ctx, fmt.Sprintf("ip:203.0.113.%d", i), limit, now)
+		require.NoError(t, err)
+	}
+	result, err := store.Peek(ctx, "k1", limit, now)
+	require.NoError(t, err)
+	assert.Equal(t, 0, result.Remaining)
+}
+
+func TestRateLimitStore_Stop(t *testing.T) {
+	store := NewRateLimitStore()
+	store.Stop()
+	store.Stop()
+
+	result, err := store.Take(context.Background(), "k1", domain.RateLimit{RequestsPerMinute: 60, Burst: 1}, time.Now())
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "a stopped store is still usable")
+}
+
+func BenchmarkRateLimitStore_Take(b *testing.B) {
+	ctx := context.Background()
+	limit := domain.RateLimit{RequestsPerMinute: 6000, Burst: 100, TTL: time.Minute}
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("GET:/api/v1/packages|ip:10.0.%d.%d", i/256, i%256)
+	}
+
+	b.Run("one key", func(b *testing.B) {
+		store := NewRateLimitStore()
+		defer store.Stop()
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				store.Take(ctx, keys[0], limit, time.Now())
+			}
+		})
+	})
+
+	b.Run("many keys", func(b *testing.B) {
+		store := NewRateLimitStore()
+		defer store.Stop()
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				store.Take(ctx, keys[i%len(keys)], limit, time.Now())
+				i++
+			}
+		})
+	})
+
+	b.Run("evicting", func(b *testing.B) {
+		store := NewRateLimitStore(WithMaxBuckets(1000))
+		defer store.Stop()
+		b.RunParallel(func(pb *testing.PB) {
+			i := 0
+			for pb.Next() {
+				store.Take(ctx, keys[i%len(keys)], limit, time.Now())
+				i++
+			}
+		})
+	})
+}
+
+func TestRateLimitStore_SlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	limit := domain.RateLimit{Algorithm: domain.AlgorithmSlidingWindow, RequestsPerMinute: 3, TTL: time.Minute}
+	store := NewRateLimitStore()
+
+	for i, offset := range []time.Duration{0, 20 * time.Second, 40 * time.Second} {
+		result, err := store.Take(ctx, "k1", limit, now.Add(offset))
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 3, result.Limit)
+		assert.Equal(t, 2-i, result.Remaining)
+		assert.Equal(t, time.Minute, result.Reset)
+	}
+
+	result, err := store.Take(ctx, "k1", limit, now.Add(50*time.Second))
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 10*time.Second, result.RetryAfter, "until the oldest request leaves the window")
+	assert.Equal(t, 50*time.Second, result.Reset)
+
+	result, err = store.Take(ctx, "k1", limit, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "the window slides past the first request")
+	assert.Equal(t, 0, result.Remaining)
+
+	result, err = store.Take(ctx, "k1", limit, now.Add(70*time.Second))
+	require.NoError(t, err)
+	assert.False(t, result.Allowed, "unlike a fixed window, the limit holds across minutes")
+}
+
+func TestRateLimitStore_FixedWindow(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 8, 0, 30, 0, time.UTC)
+	limit := domain.RateLimit{Algorithm: domain.AlgorithmFixedWindow, RequestsPerMinute: 2, TTL: time.Minute}
+	store := NewRateLimitStore()
+
+	for i := 0; i < 2; i++ {
+		result, err := store.Take(ctx, "k1", limit, now)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 1-i, result.Remaining)
+		assert.Equal(t, 30*time.Second, result.Reset, "until the end of the minute")
+	}
+
+	result, err := store.Take(ctx, "k1", limit, now.Add(20*time.Second))
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 10*time.Second, result.RetryAfter)
+
+	result, err = store.Take(ctx, "k1", limit, now.Add(30*time.Second))
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "the next minute starts over")
+	assert.Equal(t, 1, result.Remaining)
+}
+
+func TestRateLimitStore_Concurrency(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	limit := domain.RateLimit{Algorithm: domain.AlgorithmConcurrency, Burst: 2, TTL: time.Minute}
+	store := NewRateLimitStore()
+
+	first, err := store.Take(ctx, "k1", limit, now)
+	require.NoError(t, err)
+	assert.True(t, first.Allowed)
+	assert.NotEmpty(t, first.Lease)
+	assert.Equal(t, 1, first.Remaining)
+
+	second, err := store.Take(ctx, "k1", limit, now)
+	require.NoError(t, err)
+	assert.True(t, second.Allowed)
+	assert.NotEqual(t, first.Lease, second.Lease)
+
+	result, err := store.Take(ctx, "k1", limit, now)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Empty(t, result.Lease)
+	assert.Equal(t, domain.ConcurrencyRetryAfter, result.RetryAfter)
+
+	require.NoError(t, store.Release(ctx, "k1", limit, first.Lease))
+	require.NoError(t, store.Release(ctx, "k1", limit, first.Lease), "releasing twice is a no-op")
+	result, err = store.Take(ctx, "k1", limit, now)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "a released slot is free again")
+
+	result, err = store.Take(ctx, "k1", limit, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "leases that are never released expire")
+	assert.Equal(t, 1, result.Remaining)
+}
+
+// assertDuration allows for the floating point arithmetic of rate.Limiter
+func assertDuration(t *testing.T, expected, actual time.Duration) {
+	t.Helper()
+	assert.InDelta(t, float64(expected), float64(actual), float64(time.Millisecond))
+}
+
+func TestRateLimitStore_PeekAndReset(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	store := NewRateLimitStore()
+
+	limits := map[string]domain.RateLimit{
+		"token":   {RequestsPerMinute: 6, Burst: 3, TTL: time.Minute},
+		"sliding": {Algorithm: domain.AlgorithmSlidingWindow, RequestsPerMinute: 3, TTL: time.Minute},
+		"fixed":   {Algorithm: domain.AlgorithmFixedWindow, RequestsPerMinute: 3, TTL: time.Minute},
+		"slots":   {Algorithm: domain.AlgorithmConcurrency, Burst: 3, TTL: time.Minute},
+	}
+	for key, limit := range limits {
+		t.Run(key, func(t *testing.T) {
+			result, err := store.Peek(ctx, key, limit, now)
+			require.NoError(t, err)
+			assert.Equal(t, 3, result.Remaining, "an unknown bucket is full")
+
+			_, err = store.Take(ctx, key, limit, now)
+			require.NoError(t, err)
+
+			for i := 0; i < 2; i++ {
+				result, err = store.Peek(ctx, key, limit, now)
+				require.NoError(t, err)
+				assert.Equal(t, 3, result.Limit)
+				assert.Equal(t, 2, result.Remaining, "peeking spends nothing")
+			}
+
+			require.NoError(t, store.Reset(ctx, key, limit, now))
+			result, err = store.Peek(ctx, key, limit, now)
+			require.NoError(t, err)
+			assert.Equal(t, 3, result.Remaining)
+		})
+	}
+}
+
+func TestRateLimitStore_Exemption(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	store := NewRateLimitStore()
+
+	until, err := store.Exemption(ctx, "ip:192.0.2.1", now)
+	require.NoError(t, err)
+	assert.True(t, until.IsZero())
+
+	require.NoError(t, store.Exempt(ctx, "ip:192.0.2.1", now.Add(time.Hour)))
+	until, err = store.Exemption(ctx, "ip:192.0.2.1", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(time.Hour), until)
+
+	until, err = store.Exemption(ctx, "ip:192.0.2.1", now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.True(t, until.IsZero(), "exemptions expire")
+
+	require.NoError(t, store.Exempt(ctx, "ip:192.0.2.1", time.Time{}))
+	until, err = store.Exemption(ctx, "ip:192.0.2.1", now)
+	require.NoError(t, err)
+	assert.True(t, until.IsZero(), "a zero time removes the exemption")
+}
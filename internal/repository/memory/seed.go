@@ -0,0 +1,72 @@
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+// Seed loads a JSON array of packages from a fixture file into the
+// repositories. Timestamps present in the fixture are kept, missing ones are
+// set to the current time, and embedded events are moved to the event
+// repository. Packages without a tenantId belong to the default tenant. It
+// returns the number of seeded packages.
+func Seed(ctx context.Context, path string, packages *PackageRepository, events *EventRepository) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("error reading seed file %s: %v", path, err)
+	}
+
+	var fixtures []domain.Package
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return 0, fmt.Errorf("error parsing seed file %s: %v", path, err)
+	}
+
+	packages.mu.Lock()
+	defer packages.mu.Unlock()
+
+	for i, pkg := range fixtures {
+		if pkg.PackageID == "" {
+			return 0, fmt.Errorf("seed package at index %d has no packageId", i)
+		}
+		if pkg.TenantID == "" {
+			pkg.TenantID = domain.DefaultTenant
+		}
+		if !domain.ValidTenant(pkg.TenantID) {
+			return 0, fmt.Errorf("seed package %s has an invalid tenantId %q", pkg.PackageID, pkg.TenantID)
+		}
+		tenantCtx := domain.WithTenant(ctx, pkg.TenantID)
+		key := keyOf(tenantCtx, pkg.PackageID)
+		if _, exists := packages.packages[key]; exists {
+			return 0, fmt.Errorf("package %s already exists", pkg.PackageID)
+		}
+
+		now := time.Now()
+		if pkg.CreatedAt.IsZero() {
+			pkg.CreatedAt = now
+		}
+		if pkg.UpdatedAt.IsZero() {
+			pkg.UpdatedAt = pkg.CreatedAt
+		}
+		if pkg.Version <= 0 {
+			pkg.Version = 1
+		}
+
+		pkg.EventCount = len(pkg.Events)
+		if len(pkg.Events) > 0 {
+			if err := events.Append(tenantCtx, pkg.PackageID, pkg.Events...); err != nil {
+				return 0, err
+			}
+			latest := pkg.Events[len(pkg.Events)-1]
+			pkg.LatestEvent = &latest
+		}
+
+		packages.packages[key] = *clonePackage(pkg)
+	}
+
+	return len(fixtures), nil
+}
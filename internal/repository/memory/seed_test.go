@@ -0,0 +1,97 @@
+package memory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSeed(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("success", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "packages.json")
+		fixture := `[
+			{
+				"packageId": "123",
+				"sender": {"name": "John Doe", "address": "123 Main St"},
+				"recipient": {"name": "Jane Doe", "address": "456 Oak St"},
+				"origin": "New York",
+				"destination": "Los Angeles",
+				"currentStatus": "IN_TRANSIT",
+				"createdAt": "2024-01-01T08:00:00Z",
+				"events": [
+					{"timestamp": "2024-01-01T09:00:00Z", "location": "New York", "status": "PICKED_UP"},
+					{"timestamp": "2024-01-02T09:00:00Z", "location": "Memphis Hub", "status": "IN_TRANSIT"}
+				]
+			},
+			{"packageId": "456", "currentStatus": "CREATED"}
+		]`
+		require.NoError(t, os.WriteFile(path, []byte(fixture), 0o600))
+
+		packages := NewPackageRepository()
+		events := NewEventRepository()
+		seeded, err := Seed(ctx, path, packages, events)
+		require.NoError(t, err)
+		assert.Equal(t, 2, seeded)
+
+		pkg, err := packages.FindByID(ctx, "123")
+		require.NoError(t, err)
+		assert.Equal(t, 2024, pkg.CreatedAt.Year())
+		assert.Equal(t, 2, pkg.EventCount)
+		require.NotNil(t, pkg.LatestEvent)
+		assert.Equal(t, "IN_TRANSIT", pkg.LatestEvent.Status)
+		assert.Empty(t, pkg.Events)
+
+		history, err := events.FindByPackageID(ctx, "123")
+		require.NoError(t, err)
+		assert.Len(t, history, 2)
+
+		pkg, err = packages.FindByID(ctx, "456")
+		require.NoError(t, err)
+		assert.False(t, pkg.CreatedAt.IsZero())
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := Seed(ctx, filepath.Join(t.TempDir(), "missing.json"), NewPackageRepository(), NewEventRepository())
+		assert.Error(t, err)
+	})
+
+	t.Run("missing package ID", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "packages.json")
+		require.NoError(t, os.WriteFile(path, []byte(`[{"origin": "New York"}]`), 0o600))
+
+		_, err := Seed(ctx, path, NewPackageRepository(), NewEventRepository())
+		assert.Error(t, err)
+	})
+	t.Run("tenants", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "packages.json")
+		fixture := `[{"packageId": "123"}, {"tenantId": "acme", "packageId": "123", "origin": "Boston"}]`
+		require.NoError(t, os.WriteFile(path, []byte(fixture), 0o600))
+
+		packages := NewPackageRepository()
+		_, err := Seed(ctx, path, packages, NewEventRepository())
+		require.NoError(t, err)
+
+		pkg, err := packages.FindByID(domain.WithTenant(ctx, "acme"), "123")
+		require.NoError(t, err)
+		assert.Equal(t, "Boston", pkg.Origin)
+
+		pkg, err = packages.FindByID(ctx, "123")
+		require.NoError(t, err)
+		assert.Equal(t, domain.DefaultTenant, pkg.TenantID)
+	})
+
+	t.Run("invalid tenant", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "packages.json")
+		require.NoError(t, os.WriteFile(path, []byte(`[{"tenantId": "a b", "packageId": "123"}]`), 0o600))
+
+		_, err := Seed(ctx, path, NewPackageRepository(), NewEventRepository())
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,113 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+// usagePruneInterval is how often expired counters are looked for
+const usagePruneInterval = time.Hour
+
+// usageKey identifies a usage counter
+type usageKey struct {
+	identity string
+	period   string
+}
+
+// usageCounter is a request count and when it may be deleted
+type usageCounter struct {
+	count     int64
+	expiresAt time.Time
+}
+
+// UsageRepository is a thread-safe in-memory domain.UsageRepository. Counts
+// are lost on restart. Expired counters are deleted, at most once every
+// usagePruneInterval, when a new counter is added.
+type UsageRepository struct {
+	counts    map[usageKey]*usageCounter
+	nextPrune time.Time
+	now       func() time.Time
+	mu        sync.RWMutex
+}
+
+func NewUsageRepository() *UsageRepository {
+	return &UsageRepository{
+		counts: make(map[usageKey]*usageCounter),
+		now:    time.Now,
+	}
+}
+
+func (r *UsageRepository) Increment(ctx context.Context, identity string, expiresAt time.Time, periods ...string) ([]int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	counts := make([]int64, len(periods))
+	for i, period := range periods {
+		key := usageKey{identity: identity, period: period}
+		counter, exists := r.counts[key]
+		if !exists {
+			r.prune()
+			counter = &usageCounter{}
+			r.counts[key] = counter
+		}
+		counter.count++
+		if expiresAt.After(counter.expiresAt) {
+			counter.expiresAt = expiresAt
+		}
+		counts[i] = counter.count
+	}
+	return counts, nil
+}
+
+// prune deletes the expired counters unless it ran within the last
+// usagePruneInterval. The caller must hold the write lock.
+func (r *UsageRepository) prune() {
+	now := r.now()
+	if now.Before(r.nextPrune) {
+		return
+	}
+	r.nextPrune = now.Add(usagePruneInterval)
+	for key, counter := range r.counts {
+		if !now.Before(counter.expiresAt) {
+			delete(r.counts, key)
+		}
+	}
+}
+
+func (r *UsageRepository) FindByPeriod(ctx context.Context, period string, opts domain.ListOptions) ([]domain.Usage, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	r.mu.RLock()
+	usage := []domain.Usage{}
+	for key, counter := range r.counts {
+		if key.period == period {
+			usage = append(usage, domain.Usage{Identity: key.identity, Period: key.period, Count: counter.count})
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Count != usage[j].Count {
+			return usage[i].Count > usage[j].Count
+		}
+		return usage[i].Identity < usage[j].Identity
+	})
+
+	total := int64(len(usage))
+	start := (opts.Page - 1) * opts.Size
+	if start < 0 || start >= len(usage) {
+		return []domain.Usage{}, total, nil
+	}
+	end := min(start+opts.Size, len(usage))
+	return usage[start:end], total, nil
+}
@@ -0,0 +1,78 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := NewUsageRepository()
+	expiresAt := time.Now().Add(time.Hour)
+	firstPage := domain.ListOptions{Page: 1, Size: 10}
+
+	counts, err := repo.Increment(ctx, "ip:192.0.2.1", expiresAt, "2024-05-01", "2024-05")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1, 1}, counts)
+
+	for i := 0; i < 2; i++ {
+		_, err = repo.Increment(ctx, "principal:k1", expiresAt, "2024-05-02", "2024-05")
+		require.NoError(t, err)
+	}
+	counts, err = repo.Increment(ctx, "principal:k1", expiresAt, "2024-05-02", "2024-05")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{3, 3}, counts)
+
+	usage, total, err := repo.FindByPeriod(ctx, "2024-05", firstPage)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, total)
+	assert.Equal(t, []domain.Usage{
+		{Identity: "principal:k1", Period: "2024-05", Count: 3},
+		{Identity: "ip:192.0.2.1", Period: "2024-05", Count: 1},
+	}, usage)
+
+	usage, total, err = repo.FindByPeriod(ctx, "2024-05", domain.ListOptions{Page: 2, Size: 1})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, total)
+	assert.Equal(t, []domain.Usage{{Identity: "ip:192.0.2.1", Period: "2024-05", Count: 1}}, usage)
+
+	usage, _, err = repo.FindByPeriod(ctx, "2024-05", domain.ListOptions{Page: 3, Size: 1})
+	require.NoError(t, err)
+	assert.Empty(t, usage)
+
+	usage, _, err = repo.FindByPeriod(ctx, "2024-05-01", firstPage)
+	require.NoError(t, err)
+	assert.Equal(t, []domain.Usage{{Identity: "ip:192.0.2.1", Period: "2024-05-01", Count: 1}}, usage)
+
+	usage, total, err = repo.FindByPeriod(ctx, "2024-06", firstPage)
+	require.NoError(t, err)
+	assert.Empty(t, usage)
+	assert.Zero(t, total)
+}
+
+func TestUsageRepository_Expiry(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 5, 31, 12, 0, 0, 0, time.UTC)
+	repo := NewUsageRepository()
+	repo.now = func() time.Time { return now }
+	firstPage := domain.ListOptions{Page: 1, Size: 10}
+
+	_, err := repo.Increment(ctx, "principal:k1", now.Add(time.Hour), "2024-05")
+	require.NoError(t, err)
+	_, err = repo.Increment(ctx, "principal:k2", now.Add(3*time.Hour), "2024-05")
+	require.NoError(t, err)
+
+	now = now.Add(2 * time.Hour)
+	_, err = repo.Increment(ctx, "principal:k3", now.Add(time.Hour), "2024-06")
+	require.NoError(t, err)
+
+	usage, _, err := repo.FindByPeriod(ctx, "2024-05", firstPage)
+	require.NoError(t, err)
+	assert.Equal(t, []domain.Usage{{Identity: "principal:k2", Period: "2024-05", Count: 1}}, usage,
+		"expired counters are deleted when a counter is added")
+}
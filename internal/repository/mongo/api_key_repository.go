@@ -0,0 +1,172 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// apiKeyDocument is the stored form of an API key
+type apiKeyDocument struct {
+	ID        string      `bson:"_id"`
+	Name      string      `bson:"name"`
+	Role      domain.Role `bson:"role"`
+	Tenant    string      `bson:"tenant,omitempty"`
+	Plan      string      `bson:"plan,omitempty"`
+	Prefix    string      `bson:"prefix"`
+	Hash      string      `bson:"hash"`
+	CreatedAt time.Time   `bson:"createdAt"`
+	RotatedAt *time.Time  `bson:"rotatedAt,omitempty"`
+	RevokedAt *time.Time  `bson:"revokedAt,omitempty"`
+}
+
+// APIKeyRepository keeps API keys in the api_keys collection. The unique
+// index on hash is created by a migration.
+type APIKeyRepository struct {
+	collection *mongo.Collection
+	timeouts   timeouts
+}
+
+func NewAPIKeyRepository(db *mongo.Database, opts ...Option) *APIKeyRepository {
+	return &APIKeyRepository{
+		collection: db.Collection("api_keys"),
+		timeouts:   newTimeouts(opts),
+	}
+}
+
+func (r *APIKeyRepository) Create(ctx context.Context, key *domain.APIKey) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.write)
+	defer cancel()
+
+	_, err := r.collection.InsertOne(ctx, apiKeyDocument{
+		ID:        key.ID,
+		Name:      key.Name,
+		Role:      key.Role,
+		Tenant:    key.Tenant,
+		Plan:      key.Plan,
+		Prefix:    key.Prefix,
+		Hash:      key.Hash,
+		CreatedAt: key.CreatedAt,
+		RotatedAt: key.RotatedAt,
+		RevokedAt: key.RevokedAt,
+	})
+	return err
+}
+
+func (r *APIKeyRepository) FindByID(ctx context.Context, id string) (*domain.APIKey, error) {
+	return r.findOne(ctx, bson.M{"_id": id})
+}
+
+func (r *APIKeyRepository) FindByHash(ctx context.Context, hash string) (*domain.APIKey, error) {
+	return r.findOne(ctx, bson.M{"hash": hash})
+}
+
+func (r *APIKeyRepository) findOne(ctx context.Context, filter bson.M) (*domain.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.read)
+	defer cancel()
+
+	var doc apiKeyDocument
+	err := r.collection.FindOne(ctx, filter).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return nil, domain.ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return doc.apiKey(), nil
+}
+
+func (r *APIKeyRepository) FindAll(ctx context.Context) ([]domain.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.read)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := r.collection.Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []apiKeyDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+
+	keys := make([]domain.APIKey, len(docs))
+	for i, doc := range docs {
+		keys[i] = *doc.apiKey()
+	}
+	return keys, nil
+}
+
+// Rotate only matches keys without a revocation time, so a key revoked
+// after it was read stays revoked
+func (r *APIKeyRepository) Rotate(ctx context.Context, key *domain.APIKey) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.write)
+	defer cancel()
+
+	result, err := r.collection.UpdateOne(ctx, bson.M{"_id": key.ID, "revokedAt": nil}, bson.M{"$set": bson.M{
+		"prefix":    key.Prefix,
+		"hash":      key.Hash,
+		"rotatedAt": key.RotatedAt,
+	}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return r.unmatched(ctx, key.ID)
+	}
+	return nil
+}
+
+func (r *APIKeyRepository) Revoke(ctx context.Context, id string, revokedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.write)
+	defer cancel()
+
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "revokedAt": nil},
+		bson.M{"$set": bson.M{"revokedAt": revokedAt}},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		if err := r.unmatched(ctx, id); !errors.Is(err, domain.ErrAPIKeyRevoked) {
+			return err
+		}
+	}
+	return nil
+}
+
+// unmatched explains why a write to a key that had to be unrevoked matched
+// nothing: the key is missing or revoked
+func (r *APIKeyRepository) unmatched(ctx context.Context, id string) error {
+	err := r.collection.FindOne(ctx, bson.M{"_id": id}).Err()
+	if err == mongo.ErrNoDocuments {
+		return domain.ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return domain.ErrAPIKeyRevoked
+}
+
+func (d apiKeyDocument) apiKey() *domain.APIKey {
+	return &domain.APIKey{
+		ID:        d.ID,
+		Name:      d.Name,
+		Role:      d.Role,
+		Tenant:    d.Tenant,
+		Plan:      d.Plan,
+		Prefix:    d.Prefix,
+		Hash:      d.Hash,
+		CreatedAt: d.CreatedAt,
+		RotatedAt: d.RotatedAt,
+		RevokedAt: d.RevokedAt,
+	}
+}
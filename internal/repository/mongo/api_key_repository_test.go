@@ -0,0 +1,96 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestAPIKeyRepository(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	created := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	mt.Run("find by hash", func(mt *mtest.T) {
+		repo := NewAPIKeyRepository(mt.DB)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.api_keys", mtest.FirstBatch, bson.D{
+			{Key: "_id", Value: "k1"},
+			{Key: "name", Value: "ci"},
+			{Key: "role", Value: "operator"},
+			{Key: "prefix", Value: "mt_abc"},
+			{Key: "hash", Value: "h1"},
+			{Key: "createdAt", Value: created},
+		}))
+
+		key, err := repo.FindByHash(context.Background(), "h1")
+		require.NoError(mt, err)
+		assert.Equal(mt, &domain.APIKey{
+			ID: "k1", Name: "ci", Role: domain.RoleOperator, Prefix: "mt_abc", Hash: "h1", CreatedAt: created,
+		}, key)
+	})
+
+	mt.Run("unknown hash", func(mt *mtest.T) {
+		repo := NewAPIKeyRepository(mt.DB)
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.api_keys", mtest.FirstBatch))
+
+		_, err := repo.FindByHash(context.Background(), "missing")
+		assert.ErrorIs(mt, err, domain.ErrAPIKeyNotFound)
+	})
+
+	mt.Run("rotate", func(mt *mtest.T) {
+		repo := NewAPIKeyRepository(mt.DB)
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}})
+
+		rotated := created.Add(time.Hour)
+		require.NoError(mt, repo.Rotate(context.Background(), &domain.APIKey{ID: "k1", Prefix: "mt_def", Hash: "h2", RotatedAt: &rotated}))
+
+		update := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(mt, bson.TypeNull, update.Lookup("q", "revokedAt").Type, "only unrevoked keys are rotated")
+		_, err := update.LookupErr("u", "$set", "revokedAt")
+		assert.Error(mt, err, "rotating does not write the revocation time")
+	})
+
+	mt.Run("rotate a key revoked after it was read", func(mt *mtest.T) {
+		repo := NewAPIKeyRepository(mt.DB)
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 0}, {Key: "nModified", Value: 0}},
+			mtest.CreateCursorResponse(0, "foo.api_keys", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: "k1"},
+				{Key: "hash", Value: "h1"},
+				{Key: "revokedAt", Value: created.Add(time.Hour)},
+			}),
+		)
+
+		err := repo.Rotate(context.Background(), &domain.APIKey{ID: "k1", Prefix: "mt_def", Hash: "h2"})
+		assert.ErrorIs(mt, err, domain.ErrAPIKeyRevoked)
+	})
+
+	mt.Run("rotate unknown key", func(mt *mtest.T) {
+		repo := NewAPIKeyRepository(mt.DB)
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 0}, {Key: "nModified", Value: 0}},
+			mtest.CreateCursorResponse(0, "foo.api_keys", mtest.FirstBatch),
+		)
+
+		err := repo.Rotate(context.Background(), &domain.APIKey{ID: "missing"})
+		assert.ErrorIs(mt, err, domain.ErrAPIKeyNotFound)
+	})
+
+	mt.Run("revoke a revoked key", func(mt *mtest.T) {
+		repo := NewAPIKeyRepository(mt.DB)
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 0}, {Key: "nModified", Value: 0}},
+			mtest.CreateCursorResponse(0, "foo.api_keys", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: "k1"},
+				{Key: "revokedAt", Value: created},
+			}),
+		)
+
+		assert.NoError(mt, repo.Revoke(context.Background(), "k1", created.Add(time.Hour)))
+	})
+}
@@ -0,0 +1,54 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/snavarro/microtracker/internal/repository"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// testClient connects to the MongoDB server in MONGO_TEST_URI. Tests using
+// it are skipped when it is not set.
+func testClient(t *testing.T) *mongo.Client {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	require.NoError(t, client.Ping(ctx, nil))
+	return client
+}
+
+// testDatabase returns a fresh database that is dropped after the test
+func testDatabase(t *testing.T, client *mongo.Client) *mongo.Database {
+	db := client.Database(fmt.Sprintf("contract_%d", time.Now().UnixNano()))
+	t.Cleanup(func() { db.Drop(context.Background()) })
+	return db
+}
+
+// TestPackageRepository_Contract runs the shared repository contract against
+// the MongoDB server in MONGO_TEST_URI. Each subtest uses a fresh, migrated
+// database.
+func TestPackageRepository_Contract(t *testing.T) {
+	client := testClient(t)
+
+	repository.RunPackageRepositoryContract(t, func(t *testing.T) domain.PackageRepository {
+		db := testDatabase(t, client)
+		_, err := Migrate(context.Background(), db)
+		require.NoError(t, err)
+		return NewPackageRepository(db)
+	})
+}
@@ -0,0 +1,117 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// legacyPackage is a package document that still embeds its event history
+type legacyPackage struct {
+	ID        interface{}    `bson:"_id"`
+	TenantID  string         `bson:"tenantId"`
+	PackageID string         `bson:"packageId"`
+	Events    []domain.Event `bson:"events"`
+}
+
+// tenant returns the tenant of the package. Packages from before tenants
+// have none and belong to the default tenant.
+func (p legacyPackage) tenant() string {
+	if p.TenantID == "" {
+		return domain.DefaultTenant
+	}
+	return p.TenantID
+}
+
+// SplitEmbeddedEvents moves event histories embedded in package documents
+// into the package_events collection, leaving only the latest event summary
+// on the package. Documents that were already migrated are skipped, so the
+// migration can safely run on every startup. The buckets of a package are
+// written under IDs derived from the package and its events, so a run that
+// stops between writing them and removing the embedded history writes the
+// same buckets again rather than duplicating the events. Each package is
+// rewritten by its _id and its buckets take its own tenant, since package IDs
+// are only unique per tenant. It returns the number of migrated packages.
+func SplitEmbeddedEvents(ctx context.Context, db *mongo.Database) (int, error) {
+	packages := db.Collection("packages")
+	events := db.Collection("package_events")
+
+	cursor, err := packages.Find(ctx, bson.M{"events": bson.M{"$exists": true}})
+	if err != nil {
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var legacy legacyPackage
+		if err := cursor.Decode(&legacy); err != nil {
+			return migrated, err
+		}
+
+		sort.SliceStable(legacy.Events, func(i, j int) bool {
+			return legacy.Events[i].Timestamp.Before(legacy.Events[j].Timestamp)
+		})
+
+		for id, bucket := range migratedBuckets(legacy) {
+			_, err := events.ReplaceOne(ctx, bson.M{"_id": id}, bucket, options.Replace().SetUpsert(true))
+			if err != nil {
+				return migrated, err
+			}
+		}
+
+		set := bson.M{"eventCount": len(legacy.Events)}
+		if len(legacy.Events) > 0 {
+			set["latestEvent"] = legacy.Events[len(legacy.Events)-1]
+		}
+
+		update := bson.M{"$set": set, "$unset": bson.M{"events": ""}}
+		if _, err := packages.UpdateOne(ctx, bson.M{"_id": legacy.ID}, update); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	return migrated, cursor.Err()
+}
+
+// migratedBuckets groups the events of a legacy package, sorted by time, into
+// buckets as Append would, keyed by an ID made of the tenant, the package,
+// the day and the bucket's position within the day
+func migratedBuckets(legacy legacyPackage) map[string]*eventBucket {
+	tenant := legacy.tenant()
+	buckets := make(map[string]*eventBucket)
+	var current *eventBucket
+	position := 0
+	for _, event := range legacy.Events {
+		day := domain.DailyPeriod(event.Timestamp)
+		switch {
+		case current == nil || current.Day != day:
+			position = 0
+		case current.Count >= eventBucketSize:
+			position++
+		default:
+			current.Count++
+			current.LastTimestamp = event.Timestamp
+			current.Events = append(current.Events, event)
+			continue
+		}
+
+		current = &eventBucket{
+			TenantID:       tenant,
+			PackageID:      legacy.PackageID,
+			Day:            day,
+			Count:          1,
+			FirstTimestamp: event.Timestamp,
+			LastTimestamp:  event.Timestamp,
+			Events:         []domain.Event{event},
+		}
+		buckets[fmt.Sprintf("migrated/%s/%s/%s/%d", tenant, legacy.PackageID, day, position)] = current
+	}
+	return buckets
+}
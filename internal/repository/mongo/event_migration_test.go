@@ -0,0 +1,97 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestSplitEmbeddedEvents(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("success", func(mt *mtest.T) {
+		timestamp := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+		id := primitive.NewObjectID()
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(1, "foo.packages", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: id},
+				{Key: "tenantId", Value: "acme"},
+				{Key: "packageId", Value: "123"},
+				{Key: "events", Value: bson.A{
+					bson.D{{Key: "timestamp", Value: timestamp}, {Key: "location", Value: "New York"}, {Key: "status", Value: "PICKED_UP"}},
+				}},
+			}),
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}},
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}},
+			mtest.CreateCursorResponse(0, "foo.packages", mtest.NextBatch),
+		)
+
+		migrated, err := SplitEmbeddedEvents(context.Background(), mt.DB)
+		require.NoError(mt, err)
+		assert.Equal(mt, 1, migrated)
+
+		started := mt.GetAllStartedEvents()
+		require.Greater(mt, len(started), 1)
+		require.Equal(mt, "update", started[1].CommandName)
+		replace := started[1].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(mt, "migrated/acme/123/2024-01-01/0", replace.Lookup("q", "_id").StringValue(),
+			"a rerun replaces the same bucket")
+		assert.True(mt, replace.Lookup("upsert").Boolean())
+		assert.Equal(mt, "2024-01-01", replace.Lookup("u", "day").StringValue())
+		assert.Equal(mt, "acme", replace.Lookup("u", "tenantId").StringValue(), "buckets take the package's tenant")
+
+		update := started[2].Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(mt, id, update.Lookup("q", "_id").ObjectID(), "the package is rewritten by its _id")
+		_, err = update.LookupErr("q", "packageId")
+		assert.Error(mt, err, "package IDs are only unique per tenant")
+	})
+
+	mt.Run("error", func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}})
+
+		migrated, err := SplitEmbeddedEvents(context.Background(), mt.DB)
+		assert.Error(mt, err)
+		assert.Equal(mt, 0, migrated)
+	})
+}
+
+func TestMigratedBuckets(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	legacy := legacyPackage{TenantID: "acme", PackageID: "123"}
+	for i := 0; i < eventBucketSize+1; i++ {
+		legacy.Events = append(legacy.Events, domain.Event{Timestamp: day.Add(time.Duration(i) * time.Minute)})
+	}
+	legacy.Events = append(legacy.Events, domain.Event{Timestamp: day.Add(30 * time.Hour)})
+
+	buckets := migratedBuckets(legacy)
+	require.Len(t, buckets, 3)
+
+	full := buckets["migrated/acme/123/2024-01-01/0"]
+	require.NotNil(t, full)
+	assert.Equal(t, eventBucketSize, full.Count)
+	assert.Len(t, full.Events, eventBucketSize)
+	assert.Equal(t, day, full.FirstTimestamp)
+	assert.Equal(t, day.Add(time.Duration(eventBucketSize-1)*time.Minute), full.LastTimestamp)
+
+	overflow := buckets["migrated/acme/123/2024-01-01/1"]
+	require.NotNil(t, overflow)
+	assert.Equal(t, 1, overflow.Count)
+
+	nextDay := buckets["migrated/acme/123/2024-01-02/0"]
+	require.NotNil(t, nextDay, "events of another day start a bucket of their own")
+	assert.Equal(t, "2024-01-02", nextDay.Day)
+	assert.Equal(t, "acme", nextDay.TenantID)
+
+	assert.Equal(t, buckets, migratedBuckets(legacy), "bucket IDs do not change between runs")
+
+	legacy.TenantID = ""
+	assert.Contains(t, migratedBuckets(legacy), "migrated/default/123/2024-01-01/0", "packages without a tenant belong to the default tenant")
+}
@@ -0,0 +1,114 @@
+package mongo
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// eventBucketSize is the maximum number of events stored in one bucket
+// document. Keeping buckets small bounds document growth for packages with
+// long scan histories.
+const eventBucketSize = 100
+
+// eventBucket groups consecutive events of a single package on one UTC day
+// together with the time range they cover. Bounding buckets by day as well
+// as by size keeps their time ranges from overlapping much, however slowly
+// a package collects events.
+type eventBucket struct {
+	TenantID  string `bson:"tenantId"`
+	PackageID string `bson:"packageId"`
+	// Day is the UTC day (2006-01-02) of the bucket's events
+	Day            string         `bson:"day"`
+	Count          int            `bson:"count"`
+	FirstTimestamp time.Time      `bson:"firstTimestamp"`
+	LastTimestamp  time.Time      `bson:"lastTimestamp"`
+	Events         []domain.Event `bson:"events"`
+}
+
+type EventRepository struct {
+	collection *mongo.Collection
+	timeouts   timeouts
+}
+
+func NewEventRepository(db *mongo.Database, opts ...Option) *EventRepository {
+	return &EventRepository{
+		collection: db.Collection("package_events"),
+		timeouts:   newTimeouts(opts),
+	}
+}
+
+// Append adds events to the package's open bucket for the day of each event,
+// starting a new bucket once that one is full
+func (r *EventRepository) Append(ctx context.Context, packageID string, events ...domain.Event) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.write)
+	defer cancel()
+
+	for _, event := range events {
+		_, err := r.collection.UpdateOne(
+			ctx,
+			bson.M{
+				"tenantId":  domain.TenantFromContext(ctx),
+				"packageId": packageID,
+				"day":       domain.DailyPeriod(event.Timestamp),
+				"count":     bson.M{"$lt": eventBucketSize},
+			},
+			bson.M{
+				"$push": bson.M{"events": event},
+				"$inc":  bson.M{"count": 1},
+				"$min":  bson.M{"firstTimestamp": event.Timestamp},
+				"$max":  bson.M{"lastTimestamp": event.Timestamp},
+			},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FindByPackageID returns the package's full event history ordered by
+// timestamp
+func (r *EventRepository) FindByPackageID(ctx context.Context, packageID string) ([]domain.Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.read)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "firstTimestamp", Value: 1}})
+
+	cursor, err := r.collection.Find(ctx, packageKey(ctx, packageID), opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var buckets []eventBucket
+	if err = cursor.All(ctx, &buckets); err != nil {
+		return nil, err
+	}
+
+	var events []domain.Event
+	for _, bucket := range buckets {
+		events = append(events, bucket.Events...)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp.Before(events[j].Timestamp)
+	})
+
+	return events, nil
+}
+
+func (r *EventRepository) DeleteByPackageID(ctx context.Context, packageID string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.write)
+	defer cancel()
+
+	_, err := r.collection.DeleteMany(ctx, packageKey(ctx, packageID))
+	return err
+}
@@ -0,0 +1,112 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestEventRepository_Append(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("success", func(mt *mtest.T) {
+		repo := NewEventRepository(mt.DB)
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}},
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}},
+		)
+
+		timestamp := time.Date(2024, 1, 1, 23, 30, 0, 0, time.UTC)
+		err := repo.Append(context.Background(), "123",
+			domain.Event{Timestamp: timestamp, Location: "New York", Status: "PICKED_UP"},
+			domain.Event{Timestamp: timestamp.Add(time.Hour), Location: "Memphis Hub", Status: "IN_TRANSIT"},
+		)
+		assert.NoError(mt, err)
+
+		started := mt.GetAllStartedEvents()
+		require.Len(mt, started, 2)
+		for i, day := range []string{"2024-01-01", "2024-01-02"} {
+			query := started[i].Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("q")
+			assert.Equal(mt, day, query.Document().Lookup("day").StringValue(), "buckets are bounded by day")
+		}
+	})
+
+	mt.Run("error", func(mt *mtest.T) {
+		repo := NewEventRepository(mt.DB)
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}})
+
+		err := repo.Append(context.Background(), "123", domain.Event{Timestamp: time.Now(), Location: "New York", Status: "PICKED_UP"})
+		assert.Error(mt, err)
+	})
+}
+
+func TestEventRepository_FindByPackageID(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("success", func(mt *mtest.T) {
+		repo := NewEventRepository(mt.DB)
+		first := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+		second := first.Add(time.Hour)
+		third := second.Add(time.Hour)
+
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(1, "foo.bar", mtest.FirstBatch, bson.D{
+				{Key: "packageId", Value: "123"},
+				{Key: "count", Value: 2},
+				{Key: "events", Value: bson.A{
+					bson.D{{Key: "timestamp", Value: second}, {Key: "location", Value: "Memphis Hub"}, {Key: "status", Value: "IN_TRANSIT"}},
+					bson.D{{Key: "timestamp", Value: first}, {Key: "location", Value: "New York"}, {Key: "status", Value: "PICKED_UP"}},
+				}},
+			}, bson.D{
+				{Key: "packageId", Value: "123"},
+				{Key: "count", Value: 1},
+				{Key: "events", Value: bson.A{
+					bson.D{{Key: "timestamp", Value: third}, {Key: "location", Value: "Los Angeles"}, {Key: "status", Value: "DELIVERED"}},
+				}},
+			}),
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.NextBatch),
+		)
+
+		events, err := repo.FindByPackageID(context.Background(), "123")
+		require.NoError(mt, err)
+		require.Len(mt, events, 3)
+		assert.Equal(mt, "PICKED_UP", events[0].Status)
+		assert.Equal(mt, "IN_TRANSIT", events[1].Status)
+		assert.Equal(mt, "DELIVERED", events[2].Status)
+	})
+
+	mt.Run("error", func(mt *mtest.T) {
+		repo := NewEventRepository(mt.DB)
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}})
+
+		events, err := repo.FindByPackageID(context.Background(), "123")
+		assert.Error(mt, err)
+		assert.Empty(mt, events)
+	})
+}
+
+func TestEventRepository_DeleteByPackageID(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("success", func(mt *mtest.T) {
+		repo := NewEventRepository(mt.DB)
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 2}})
+
+		err := repo.DeleteByPackageID(context.Background(), "123")
+		assert.NoError(mt, err)
+	})
+
+	mt.Run("error", func(mt *mtest.T) {
+		repo := NewEventRepository(mt.DB)
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}})
+
+		err := repo.DeleteByPackageID(context.Background(), "123")
+		assert.Error(mt, err)
+	})
+}
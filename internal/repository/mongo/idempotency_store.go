@@ -0,0 +1,129 @@
+package mongo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// idempotencyDocument is the stored form of an idempotency record. The key is
+// the document ID, so concurrent reservations of one key cannot both succeed.
+type idempotencyDocument struct {
+	Key         string            `bson:"_id"`
+	Fingerprint string            `bson:"fingerprint"`
+	Completed   bool              `bson:"completed"`
+	LockedUntil time.Time         `bson:"lockedUntil"`
+	StatusCode  int               `bson:"statusCode,omitempty"`
+	Header      map[string]string `bson:"header,omitempty"`
+	Body        []byte            `bson:"body,omitempty"`
+	ExpiresAt   time.Time         `bson:"expiresAt"`
+}
+
+// IdempotencyStore keeps idempotency records in the idempotency_keys
+// collection. A TTL index on expiresAt, created by a migration, removes
+// expired records; until the TTL monitor runs they are ignored. Pending
+// records whose reservation has lapsed are replaced when their key is
+// reserved again.
+type IdempotencyStore struct {
+	collection *mongo.Collection
+	timeouts   timeouts
+}
+
+func NewIdempotencyStore(db *mongo.Database, opts ...Option) *IdempotencyStore {
+	return &IdempotencyStore{
+		collection: db.Collection("idempotency_keys"),
+		timeouts:   newTimeouts(opts),
+	}
+}
+
+func (s *IdempotencyStore) Reserve(ctx context.Context, record *domain.IdempotencyRecord) (*domain.IdempotencyRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.write)
+	defer cancel()
+
+	doc := idempotencyDocument{
+		Key:         record.Key,
+		Fingerprint: record.Fingerprint,
+		LockedUntil: record.LockedUntil,
+		ExpiresAt:   record.ExpiresAt,
+	}
+
+	// An expired record that the TTL monitor has not removed yet, or a lapsed
+	// reservation, is replaced
+	for attempt := 0; attempt < 2; attempt++ {
+		_, err := s.collection.InsertOne(ctx, doc)
+		if err == nil {
+			return nil, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, err
+		}
+
+		var existing idempotencyDocument
+		err = s.collection.FindOne(ctx, bson.M{"_id": record.Key}).Decode(&existing)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		if now.Before(existing.ExpiresAt) && (existing.Completed || now.Before(existing.LockedUntil)) {
+			return existing.record(), nil
+		}
+
+		// Only the record that was read, in case another request replaced
+		// or completed it meanwhile
+		_, err = s.collection.DeleteOne(ctx, bson.M{
+			"_id":       record.Key,
+			"expiresAt": existing.ExpiresAt,
+			"completed": existing.Completed,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("idempotency key %q was reserved concurrently", record.Key)
+}
+
+func (s *IdempotencyStore) Complete(ctx context.Context, record *domain.IdempotencyRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.write)
+	defer cancel()
+
+	_, err := s.collection.UpdateOne(ctx, bson.M{"_id": record.Key}, bson.M{"$set": bson.M{
+		"completed":  true,
+		"statusCode": record.StatusCode,
+		"header":     record.Header,
+		"body":       record.Body,
+	}})
+	return err
+}
+
+func (s *IdempotencyStore) Release(ctx context.Context, record *domain.IdempotencyRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.write)
+	defer cancel()
+
+	_, err := s.collection.DeleteOne(ctx, bson.M{
+		"_id":         record.Key,
+		"completed":   false,
+		"lockedUntil": record.LockedUntil,
+	})
+	return err
+}
+
+func (d idempotencyDocument) record() *domain.IdempotencyRecord {
+	return &domain.IdempotencyRecord{
+		Key:         d.Key,
+		Fingerprint: d.Fingerprint,
+		Completed:   d.Completed,
+		LockedUntil: d.LockedUntil,
+		StatusCode:  d.StatusCode,
+		Header:      d.Header,
+		Body:        d.Body,
+		ExpiresAt:   d.ExpiresAt,
+	}
+}
@@ -0,0 +1,121 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestIdempotencyStore_Reserve(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	duplicateKey := mtest.CreateWriteErrorsResponse(mtest.WriteError{Code: 11000, Message: "duplicate key error"})
+
+	mt.Run("new key", func(mt *mtest.T) {
+		store := NewIdempotencyStore(mt.DB)
+		mt.AddMockResponses(mtest.CreateSuccessResponse())
+
+		existing, err := store.Reserve(context.Background(), &domain.IdempotencyRecord{Key: "k1", ExpiresAt: time.Now().Add(time.Hour)})
+		require.NoError(mt, err)
+		assert.Nil(mt, existing)
+	})
+
+	mt.Run("existing key", func(mt *mtest.T) {
+		store := NewIdempotencyStore(mt.DB)
+		mt.AddMockResponses(
+			duplicateKey,
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: "k1"},
+				{Key: "fingerprint", Value: "abc"},
+				{Key: "completed", Value: true},
+				{Key: "statusCode", Value: 201},
+				{Key: "body", Value: []byte(`{"success":true}`)},
+				{Key: "expiresAt", Value: time.Now().Add(time.Hour)},
+			}),
+		)
+
+		existing, err := store.Reserve(context.Background(), &domain.IdempotencyRecord{Key: "k1", ExpiresAt: time.Now().Add(time.Hour)})
+		require.NoError(mt, err)
+		require.NotNil(mt, existing)
+		assert.Equal(mt, "abc", existing.Fingerprint)
+		assert.True(mt, existing.Completed)
+		assert.Equal(mt, 201, existing.StatusCode)
+		assert.Equal(mt, []byte(`{"success":true}`), existing.Body)
+	})
+
+	mt.Run("pending key", func(mt *mtest.T) {
+		store := NewIdempotencyStore(mt.DB)
+		mt.AddMockResponses(
+			duplicateKey,
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: "k1"},
+				{Key: "completed", Value: false},
+				{Key: "lockedUntil", Value: time.Now().Add(time.Minute)},
+				{Key: "expiresAt", Value: time.Now().Add(time.Hour)},
+			}),
+		)
+
+		existing, err := store.Reserve(context.Background(), &domain.IdempotencyRecord{Key: "k1", ExpiresAt: time.Now().Add(time.Hour)})
+		require.NoError(mt, err)
+		require.NotNil(mt, existing)
+		assert.False(mt, existing.Completed)
+	})
+
+	mt.Run("lapsed reservation is replaced", func(mt *mtest.T) {
+		store := NewIdempotencyStore(mt.DB)
+		mt.AddMockResponses(
+			duplicateKey,
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: "k1"},
+				{Key: "completed", Value: false},
+				{Key: "lockedUntil", Value: time.Now().Add(-time.Second)},
+				{Key: "expiresAt", Value: time.Now().Add(time.Hour)},
+			}),
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}},
+			mtest.CreateSuccessResponse(),
+		)
+
+		existing, err := store.Reserve(context.Background(), &domain.IdempotencyRecord{Key: "k1", ExpiresAt: time.Now().Add(time.Hour)})
+		require.NoError(mt, err)
+		assert.Nil(mt, existing)
+	})
+
+	mt.Run("expired key is replaced", func(mt *mtest.T) {
+		store := NewIdempotencyStore(mt.DB)
+		mt.AddMockResponses(
+			duplicateKey,
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{
+				{Key: "_id", Value: "k1"},
+				{Key: "expiresAt", Value: time.Now().Add(-time.Minute)},
+			}),
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}},
+			mtest.CreateSuccessResponse(),
+		)
+
+		existing, err := store.Reserve(context.Background(), &domain.IdempotencyRecord{Key: "k1", ExpiresAt: time.Now().Add(time.Hour)})
+		require.NoError(mt, err)
+		assert.Nil(mt, existing)
+	})
+}
+
+func TestIdempotencyStore_Release(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("only the pending reservation of the record", func(mt *mtest.T) {
+		store := NewIdempotencyStore(mt.DB)
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}})
+		lockedUntil := time.Date(2024, 5, 1, 8, 1, 0, 0, time.UTC)
+
+		require.NoError(mt, store.Release(context.Background(), &domain.IdempotencyRecord{Key: "k1", LockedUntil: lockedUntil}))
+
+		filter := mt.GetStartedEvent().Command.Lookup("deletes").Array().Index(0).Value().Document().Lookup("q").Document()
+		assert.Equal(mt, "k1", filter.Lookup("_id").StringValue())
+		assert.False(mt, filter.Lookup("completed").Boolean())
+		assert.Equal(mt, lockedUntil, filter.Lookup("lockedUntil").Time().UTC())
+	})
+}
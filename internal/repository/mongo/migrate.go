@@ -0,0 +1,265 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// migrationLockID is the document in schema_migrations_lock that
+	// serializes migrations between replicas starting at the same time
+	migrationLockID = "migrations"
+	// migrationLockLease bounds how long a crashed replica can hold the lock
+	migrationLockLease = 10 * time.Minute
+	// migrationLockRenewal is how often the holder extends its lease, often
+	// enough that a failed renewal or two does not let it expire
+	migrationLockRenewal = migrationLockLease / 4
+	// migrationLockPoll is how often a waiting replica retries the lock
+	migrationLockPoll = time.Second
+)
+
+// errMigrationLockLost is the cause of the migration context being canceled
+// when another replica took over the lock, which it only can once the lease
+// expired without being renewed
+var errMigrationLockLost = errors.New("migration lock lost")
+
+type migrationFunc func(ctx context.Context, db *mongo.Database) error
+
+type migration struct {
+	version int
+	name    string
+	up      migrationFunc
+	// down reverts up. It is nil for migrations that cannot be reverted.
+	down migrationFunc
+}
+
+func (m migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.version, m.name)
+}
+
+// appliedMigration is a schema_migrations document
+type appliedMigration struct {
+	Version   int       `bson:"_id"`
+	Name      string    `bson:"name"`
+	AppliedAt time.Time `bson:"appliedAt"`
+}
+
+// MigrationStatus describes a known or applied migration. AppliedAt is nil
+// for pending migrations.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// Migrate applies all pending migrations in version order and records them
+// in schema_migrations. Replicas wait for each other, so only one migrates
+// at a time. It returns the number of applied migrations.
+func Migrate(ctx context.Context, db *mongo.Database) (int, error) {
+	ctx, unlock, err := lockMigrations(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		if err := m.up(ctx, db); err != nil {
+			return count, fmt.Errorf("migration %s failed: %w", m, lockError(ctx, err))
+		}
+		record := appliedMigration{Version: m.version, Name: m.name, AppliedAt: time.Now().UTC()}
+		if _, err := db.Collection("schema_migrations").InsertOne(ctx, record); err != nil {
+			return count, lockError(ctx, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Rollback reverts the most recently applied migrations, up to steps of
+// them, newest first. It returns the number of reverted migrations.
+func Rollback(ctx context.Context, db *mongo.Database, steps int) (int, error) {
+	ctx, unlock, err := lockMigrations(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.version]; !ok {
+			continue
+		}
+		if m.down == nil {
+			return count, fmt.Errorf("migration %s cannot be reverted", m)
+		}
+		if err := m.down(ctx, db); err != nil {
+			return count, fmt.Errorf("reverting migration %s failed: %w", m, lockError(ctx, err))
+		}
+		if _, err := db.Collection("schema_migrations").DeleteOne(ctx, bson.M{"_id": m.version}); err != nil {
+			return count, lockError(ctx, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Status lists the known migrations and any applied migration this binary
+// does not know about, in version order
+func Status(ctx context.Context, db *mongo.Database) ([]MigrationStatus, error) {
+	applied, err := appliedMigrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	var status []MigrationStatus
+	for _, m := range migrations {
+		s := MigrationStatus{Version: m.version, Name: m.name}
+		if record, ok := applied[m.version]; ok {
+			s.AppliedAt = &record.AppliedAt
+			delete(applied, m.version)
+		}
+		status = append(status, s)
+	}
+	for _, record := range applied {
+		appliedAt := record.AppliedAt
+		status = append(status, MigrationStatus{Version: record.Version, Name: record.Name, AppliedAt: &appliedAt})
+	}
+
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Version < status[j].Version
+	})
+	return status, nil
+}
+
+func appliedMigrations(ctx context.Context, db *mongo.Database) (map[int]appliedMigration, error) {
+	cursor, err := db.Collection("schema_migrations").Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var records []appliedMigration
+	if err := cursor.All(ctx, &records); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]appliedMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// lockMigrations waits until it holds the migration lock and returns a
+// context for migrating and a function releasing the lock. The lock is a
+// lease, so a replica that crashes while migrating blocks the others for at
+// most migrationLockLease. The holder renews it every migrationLockRenewal for
+// as long as it migrates; should it still lose the lock, the returned context
+// is canceled with errMigrationLockLost.
+func lockMigrations(ctx context.Context, db *mongo.Database) (context.Context, func(), error) {
+	locks := db.Collection("schema_migrations_lock")
+	owner := primitive.NewObjectID()
+
+	for {
+		now := time.Now()
+		// The filter only matches a missing or expired lock. A live lock makes
+		// the upsert insert a second document with the same ID, which fails.
+		_, err := locks.UpdateOne(ctx,
+			bson.M{"_id": migrationLockID, "expiresAt": bson.M{"$lte": now}},
+			bson.M{"$set": bson.M{"owner": owner, "expiresAt": now.Add(migrationLockLease)}},
+			options.Update().SetUpsert(true),
+		)
+		if err == nil {
+			break
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("waiting for migration lock: %w", ctx.Err())
+		case <-time.After(migrationLockPoll):
+		}
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	stopped := make(chan struct{})
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		ticker := time.NewTicker(migrationLockRenewal)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopped:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			// A failed renewal is retried on the next tick, while the lease
+			// still has time left
+			if held, err := renewMigrationLock(ctx, locks, owner); err == nil && !held {
+				cancel(errMigrationLockLost)
+				return
+			}
+		}
+	}()
+
+	unlock := func() {
+		close(stopped)
+		<-renewed
+		cancel(nil)
+
+		ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+		defer cancel()
+		locks.DeleteOne(ctx, bson.M{"_id": migrationLockID, "owner": owner})
+	}
+	return ctx, unlock, nil
+}
+
+// renewMigrationLock extends the lease of a lock held by owner. It reports
+// false if owner no longer holds the lock.
+func renewMigrationLock(ctx context.Context, locks *mongo.Collection, owner primitive.ObjectID) (bool, error) {
+	result, err := locks.UpdateOne(ctx,
+		bson.M{"_id": migrationLockID, "owner": owner},
+		bson.M{"$set": bson.M{"expiresAt": time.Now().Add(migrationLockLease)}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount == 1, nil
+}
+
+// lockError returns errMigrationLockLost for an error caused by losing the
+// migration lock, and err otherwise
+func lockError(ctx context.Context, err error) error {
+	if cause := context.Cause(ctx); errors.Is(cause, errMigrationLockLost) {
+		return cause
+	}
+	return err
+}
@@ -0,0 +1,179 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestMigrations(t *testing.T) {
+	names := map[string]bool{}
+	for i, m := range migrations {
+		assert.NotEmpty(t, m.name)
+		assert.NotNil(t, m.up, "migration %s has no up step", m)
+		assert.False(t, names[m.name], "migration name %s is reused", m.name)
+		names[m.name] = true
+		if i > 0 {
+			assert.Greater(t, m.version, migrations[i-1].version)
+		}
+	}
+}
+
+func TestLockMigrations(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("free lock", func(mt *mtest.T) {
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "upserted", Value: bson.A{bson.D{{Key: "index", Value: 0}, {Key: "_id", Value: migrationLockID}}}}},
+			bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}},
+		)
+
+		ctx, unlock, err := lockMigrations(context.Background(), mt.DB)
+		require.NoError(mt, err)
+		assert.NoError(mt, ctx.Err())
+		unlock()
+		assert.Error(mt, ctx.Err(), "the migration context ends with the lock")
+	})
+
+	mt.Run("held lock", func(mt *mtest.T) {
+		held := mtest.CreateWriteErrorsResponse(mtest.WriteError{Code: 11000, Message: "duplicate key error"})
+		mt.AddMockResponses(held, held, held)
+
+		ctx, cancel := context.WithTimeout(context.Background(), migrationLockPoll/2)
+		defer cancel()
+
+		_, _, err := lockMigrations(ctx, mt.DB)
+		assert.ErrorIs(mt, err, context.DeadlineExceeded)
+	})
+
+	mt.Run("error", func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}})
+
+		_, _, err := lockMigrations(context.Background(), mt.DB)
+		assert.Error(mt, err)
+	})
+}
+
+func TestRenewMigrationLock(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	owner := primitive.NewObjectID()
+
+	mt.Run("held", func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}, {Key: "nModified", Value: 1}})
+
+		held, err := renewMigrationLock(context.Background(), mt.Coll, owner)
+		require.NoError(mt, err)
+		assert.True(mt, held)
+
+		update := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.Equal(mt, owner, update.Lookup("q", "owner").ObjectID(), "only the owner's lease is renewed")
+	})
+
+	mt.Run("lost", func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 0}, {Key: "nModified", Value: 0}})
+
+		held, err := renewMigrationLock(context.Background(), mt.Coll, owner)
+		require.NoError(mt, err)
+		assert.False(mt, held)
+	})
+
+	mt.Run("error", func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}})
+
+		_, err := renewMigrationLock(context.Background(), mt.Coll, owner)
+		assert.Error(mt, err)
+	})
+}
+
+func TestLockError(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(errMigrationLockLost)
+	assert.ErrorIs(t, lockError(ctx, ctx.Err()), errMigrationLockLost)
+
+	ctx, cancel = context.WithCancelCause(context.Background())
+	cancel(nil)
+	assert.ErrorIs(t, lockError(ctx, ctx.Err()), context.Canceled)
+}
+
+func TestMigrate(t *testing.T) {
+	db := testDatabase(t, testClient(t))
+	ctx := context.Background()
+
+	_, err := db.Collection("packages").InsertOne(ctx, bson.M{"packageId": "LEGACY1"})
+	require.NoError(t, err)
+
+	applied, err := Migrate(ctx, db)
+	require.NoError(t, err)
+	assert.Equal(t, len(migrations), applied)
+
+	applied, err = Migrate(ctx, db)
+	require.NoError(t, err)
+	assert.Equal(t, 0, applied, "a second run is a no-op")
+
+	var legacy bson.M
+	require.NoError(t, db.Collection("packages").FindOne(ctx, bson.M{"packageId": "LEGACY1"}).Decode(&legacy))
+	assert.EqualValues(t, 1, legacy["version"], "existing packages get a version")
+	assert.Equal(t, domain.DefaultTenant, legacy["tenantId"], "existing packages belong to the default tenant")
+
+	_, err = db.Collection("packages").InsertOne(ctx, bson.M{"tenantId": domain.DefaultTenant, "packageId": "LEGACY1"})
+	assert.Error(t, err, "packageId is unique per tenant")
+	_, err = db.Collection("packages").InsertOne(ctx, bson.M{"tenantId": "acme", "packageId": "LEGACY1"})
+	assert.NoError(t, err, "tenants may reuse package IDs")
+	_, err = db.Collection("packages").DeleteOne(ctx, bson.M{"tenantId": "acme"})
+	require.NoError(t, err)
+
+	t.Run("rollback", func(t *testing.T) {
+		// Revert down to and including scope_packages_by_tenant
+		steps := len(migrations) - 6
+		reverted, err := Rollback(ctx, db, steps)
+		require.NoError(t, err)
+		assert.Equal(t, steps, reverted)
+
+		status, err := Status(ctx, db)
+		require.NoError(t, err)
+		require.Len(t, status, len(migrations))
+		assert.NotNil(t, status[0].AppliedAt)
+		for _, s := range status[len(status)-steps:] {
+			assert.Nil(t, s.AppliedAt, "migration %d is pending again", s.Version)
+		}
+
+		_, err = db.Collection("packages").InsertOne(ctx, bson.M{"tenantId": "acme", "packageId": "LEGACY1"})
+		assert.Error(t, err, "packageId is globally unique again")
+
+		// The version backfill after the API key indexes cannot be reverted
+		reverted, err = Rollback(ctx, db, 2)
+		assert.Error(t, err)
+		assert.Equal(t, 1, reverted)
+
+		applied, err := Migrate(ctx, db)
+		require.NoError(t, err)
+		assert.Equal(t, steps+1, applied)
+	})
+
+	t.Run("concurrent replicas", func(t *testing.T) {
+		fresh := testDatabase(t, db.Client())
+		results := make(chan int, 3)
+		for i := 0; i < 3; i++ {
+			go func() {
+				ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+				defer cancel()
+				applied, err := Migrate(ctx, fresh)
+				assert.NoError(t, err)
+				results <- applied
+			}()
+		}
+
+		total := 0
+		for i := 0; i < 3; i++ {
+			total += <-results
+		}
+		assert.Equal(t, len(migrations), total, "each migration runs once")
+	})
+}
@@ -0,0 +1,329 @@
+package mongo
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// migrations are applied in version order. Applied versions are recorded in
+// schema_migrations; a migration that fails part way is retried from the
+// start, so every step must be safe to repeat.
+var migrations = []migration{
+	{
+		version: 1,
+		name:    "split_embedded_events",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := SplitEmbeddedEvents(ctx, db)
+			return err
+		},
+	},
+	{
+		version: 2,
+		name:    "create_package_indexes",
+		up:      createIndexes("packages", packageIndexes),
+		down:    dropIndexes("packages", packageIndexes),
+	},
+	{
+		version: 3,
+		name:    "create_event_indexes",
+		up:      createIndexes("package_events", eventIndexes),
+		down:    dropIndexes("package_events", eventIndexes),
+	},
+	{
+		version: 4,
+		name:    "create_idempotency_ttl_index",
+		up:      createIndexes("idempotency_keys", idempotencyIndexes),
+		down:    dropIndexes("idempotency_keys", idempotencyIndexes),
+	},
+	{
+		version: 5,
+		name:    "backfill_package_versions",
+		up: func(ctx context.Context, db *mongo.Database) error {
+			_, err := db.Collection("packages").UpdateMany(ctx,
+				bson.M{"version": bson.M{"$exists": false}},
+				bson.M{"$set": bson.M{"version": 1}},
+			)
+			return err
+		},
+	},
+	{
+		version: 6,
+		name:    "create_api_key_indexes",
+		up:      createIndexes("api_keys", apiKeyIndexes),
+		down:    dropIndexes("api_keys", apiKeyIndexes),
+	},
+	{
+		version: 7,
+		name:    "scope_packages_by_tenant",
+		up:      scopeByTenant,
+		down:    unscopeByTenant,
+	},
+	{
+		version: 8,
+		name:    "create_usage_indexes",
+		up:      createIndexes("usage", usageIndexes),
+		down:    dropIndexes("usage", usageIndexes),
+	},
+	{
+		version: 9,
+		name:    "expire_usage_counters",
+		up:      expireUsageCounters,
+		down:    dropIndexes("usage", usageTTLIndexes),
+	},
+	{
+		version: 10,
+		name:    "create_package_text_index",
+		up:      createIndexes("packages", packageTextIndexes),
+		down:    dropIndexes("packages", packageTextIndexes),
+	},
+}
+
+// usageBackfillRetention is how long counters written before they had an
+// expiry are kept after migration 9
+const usageBackfillRetention = 90 * 24 * time.Hour
+
+// expireUsageCounters gives the usage counters that have no expiry one, and
+// creates the TTL index that removes them once they expire
+func expireUsageCounters(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("usage").UpdateMany(ctx,
+		bson.M{"expiresAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"expiresAt": time.Now().Add(usageBackfillRetention)}},
+	)
+	if err != nil {
+		return err
+	}
+	return createIndexes("usage", usageTTLIndexes)(ctx, db)
+}
+
+// scopeByTenant assigns existing packages and events to the default tenant
+// and replaces the package and event indexes with tenant-prefixed ones, so
+// package IDs are unique per tenant
+func scopeByTenant(ctx context.Context, db *mongo.Database) error {
+	for _, collection := range []string{"packages", "package_events"} {
+		_, err := db.Collection(collection).UpdateMany(ctx,
+			bson.M{"tenantId": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"tenantId": domain.DefaultTenant}},
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	steps := []migrationFunc{
+		dropIndexes("packages", packageIndexes),
+		createIndexes("packages", tenantPackageIndexes),
+		dropIndexes("package_events", eventIndexes),
+		createIndexes("package_events", tenantEventIndexes),
+	}
+	for _, step := range steps {
+		if err := step(ctx, db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unscopeByTenant restores the global indexes. Tenant IDs are kept on the
+// documents, and restoring the unique package ID index fails while two
+// tenants use the same package ID.
+func unscopeByTenant(ctx context.Context, db *mongo.Database) error {
+	steps := []migrationFunc{
+		dropIndexes("packages", tenantPackageIndexes),
+		createIndexes("packages", packageIndexes),
+		dropIndexes("package_events", tenantEventIndexes),
+		createIndexes("package_events", eventIndexes),
+	}
+	for _, step := range steps {
+		if err := step(ctx, db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// packageIndexes serve FindByID and duplicate detection, the default sort and
+// its keyset pagination, and the list filters. The single-field indexes also
+// serve prefix and exact searches on those fields; literal and regex searches
+// are case-insensitive substring matches that no index can serve, and text
+// searches use packageTextIndexes. Migration
+// scope_packages_by_tenant replaces them with tenantPackageIndexes.
+var packageIndexes = []mongo.IndexModel{
+	{
+		Keys:    bson.D{{Key: "packageId", Value: 1}},
+		Options: options.Index().SetName("packageId_unique").SetUnique(true),
+	},
+	{
+		Keys:    bson.D{{Key: "createdAt", Value: -1}, {Key: "packageId", Value: 1}},
+		Options: options.Index().SetName("createdAt_packageId"),
+	},
+	{
+		Keys:    bson.D{{Key: "currentStatus", Value: 1}, {Key: "createdAt", Value: -1}},
+		Options: options.Index().SetName("currentStatus_createdAt"),
+	},
+	{
+		Keys:    bson.D{{Key: "origin", Value: 1}},
+		Options: options.Index().SetName("origin"),
+	},
+	{
+		Keys:    bson.D{{Key: "destination", Value: 1}},
+		Options: options.Index().SetName("destination"),
+	},
+	{
+		Keys:    bson.D{{Key: "sender.name", Value: 1}},
+		Options: options.Index().SetName("sender_name"),
+	},
+	{
+		Keys:    bson.D{{Key: "recipient.name", Value: 1}},
+		Options: options.Index().SetName("recipient_name"),
+	},
+}
+
+// tenantPackageIndexes are packageIndexes prefixed with the tenant, which
+// every query is scoped to
+var tenantPackageIndexes = []mongo.IndexModel{
+	{
+		Keys:    bson.D{{Key: "tenantId", Value: 1}, {Key: "packageId", Value: 1}},
+		Options: options.Index().SetName("tenantId_packageId_unique").SetUnique(true),
+	},
+	{
+		Keys:    bson.D{{Key: "tenantId", Value: 1}, {Key: "createdAt", Value: -1}, {Key: "packageId", Value: 1}},
+		Options: options.Index().SetName("tenantId_createdAt_packageId"),
+	},
+	{
+		Keys:    bson.D{{Key: "tenantId", Value: 1}, {Key: "currentStatus", Value: 1}, {Key: "createdAt", Value: -1}},
+		Options: options.Index().SetName("tenantId_currentStatus_createdAt"),
+	},
+	{
+		Keys:    bson.D{{Key: "tenantId", Value: 1}, {Key: "origin", Value: 1}},
+		Options: options.Index().SetName("tenantId_origin"),
+	},
+	{
+		Keys:    bson.D{{Key: "tenantId", Value: 1}, {Key: "destination", Value: 1}},
+		Options: options.Index().SetName("tenantId_destination"),
+	},
+	{
+		Keys:    bson.D{{Key: "tenantId", Value: 1}, {Key: "sender.name", Value: 1}},
+		Options: options.Index().SetName("tenantId_sender_name"),
+	},
+	{
+		Keys:    bson.D{{Key: "tenantId", Value: 1}, {Key: "recipient.name", Value: 1}},
+		Options: options.Index().SetName("tenantId_recipient_name"),
+	},
+}
+
+// packageTextIndexes serve text searches. A collection has at most one text
+// index, so it covers every search field. Its words are neither stemmed nor
+// filtered for stop words, like the PostgreSQL search index. Queries must
+// match tenantId exactly to use it, which every query does.
+var packageTextIndexes = []mongo.IndexModel{
+	{
+		Keys: bson.D{
+			{Key: "tenantId", Value: 1},
+			{Key: "packageId", Value: "text"},
+			{Key: "sender.name", Value: "text"},
+			{Key: "recipient.name", Value: "text"},
+			{Key: "origin", Value: "text"},
+			{Key: "destination", Value: "text"},
+			{Key: "currentStatus", Value: "text"},
+		},
+		Options: options.Index().SetName("tenantId_search_text").SetDefaultLanguage("none"),
+	},
+}
+
+// eventIndexes serve loading a package's buckets in order and finding its
+// open bucket
+var eventIndexes = []mongo.IndexModel{
+	{
+		Keys:    bson.D{{Key: "packageId", Value: 1}, {Key: "firstTimestamp", Value: 1}},
+		Options: options.Index().SetName("packageId_firstTimestamp"),
+	},
+}
+
+// tenantEventIndexes are eventIndexes prefixed with the tenant
+var tenantEventIndexes = []mongo.IndexModel{
+	{
+		Keys:    bson.D{{Key: "tenantId", Value: 1}, {Key: "packageId", Value: 1}, {Key: "firstTimestamp", Value: 1}},
+		Options: options.Index().SetName("tenantId_packageId_firstTimestamp"),
+	},
+}
+
+// idempotencyIndexes let MongoDB remove expired idempotency records
+var idempotencyIndexes = []mongo.IndexModel{
+	{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+	},
+}
+
+// apiKeyIndexes serve authenticating requests by the hash of their API key
+var apiKeyIndexes = []mongo.IndexModel{
+	{
+		Keys:    bson.D{{Key: "hash", Value: 1}},
+		Options: options.Index().SetName("hash_unique").SetUnique(true),
+	},
+}
+
+// usageIndexes keep one counter per identity and period and serve listing a
+// period's usage by count
+var usageIndexes = []mongo.IndexModel{
+	{
+		Keys:    bson.D{{Key: "period", Value: 1}, {Key: "identity", Value: 1}},
+		Options: options.Index().SetName("period_identity_unique").SetUnique(true),
+	},
+	{
+		Keys:    bson.D{{Key: "period", Value: 1}, {Key: "count", Value: -1}},
+		Options: options.Index().SetName("period_count"),
+	},
+}
+
+// usageTTLIndexes let MongoDB remove expired usage counters
+var usageTTLIndexes = []mongo.IndexModel{
+	{
+		Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+		Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+	},
+}
+
+// createIndexes creates the indexes on a collection. Creating an index that
+// already exists is a no-op.
+func createIndexes(collection string, models []mongo.IndexModel) migrationFunc {
+	return func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection(collection).Indexes().CreateMany(ctx, models)
+		return err
+	}
+}
+
+// dropIndexes drops the indexes created by createIndexes, ignoring indexes
+// that do not exist
+func dropIndexes(collection string, models []mongo.IndexModel) migrationFunc {
+	return func(ctx context.Context, db *mongo.Database) error {
+		for _, model := range models {
+			_, err := db.Collection(collection).Indexes().DropOne(ctx, *model.Options.Name)
+			if err != nil && !isNotFound(err) {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// isNotFound reports whether err is a server error for a missing index or
+// namespace
+func isNotFound(err error) bool {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		return cmdErr.Code == indexNotFoundCode || cmdErr.Code == namespaceNotFoundCode
+	}
+	return false
+}
+
+const (
+	namespaceNotFoundCode = 26
+	indexNotFoundCode     = 27
+)
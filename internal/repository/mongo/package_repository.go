@@ -2,9 +2,12 @@ package mongo
 
 import (
 	"context"
-	"errors"
+	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
+	"github.com/snavarro/microtracker/config"
 	"github.com/snavarro/microtracker/internal/domain"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -13,26 +16,65 @@ import (
 )
 
 var (
-	ErrPackageNotFound = errors.New("package not found")
+	ErrPackageNotFound = domain.ErrPackageNotFound
 	defaultTimeout     = 5 * time.Second
 )
 
+// timeouts bounds each kind of operation on top of the caller's context
+type timeouts struct {
+	read   time.Duration
+	write  time.Duration
+	search time.Duration
+}
+
+// Option configures optional repository behaviour
+type Option func(*timeouts)
+
+// WithTimeouts sets per-operation timeouts. Zero values keep the default.
+func WithTimeouts(cfg config.TimeoutConfig) Option {
+	return func(t *timeouts) {
+		if cfg.Read > 0 {
+			t.read = cfg.Read
+		}
+		if cfg.Write > 0 {
+			t.write = cfg.Write
+		}
+		if cfg.Search > 0 {
+			t.search = cfg.Search
+		}
+	}
+}
+
+func newTimeouts(opts []Option) timeouts {
+	t := timeouts{
+		read:   defaultTimeout,
+		write:  defaultTimeout,
+		search: defaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(&t)
+	}
+	return t
+}
+
 type PackageRepository struct {
 	collection *mongo.Collection
+	timeouts   timeouts
 }
 
-func NewPackageRepository(db *mongo.Database) *PackageRepository {
+func NewPackageRepository(db *mongo.Database, opts ...Option) *PackageRepository {
 	return &PackageRepository{
 		collection: db.Collection("packages"),
+		timeouts:   newTimeouts(opts),
 	}
 }
 
-func (r *PackageRepository) FindByID(id string) (*domain.Package, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+func (r *PackageRepository) FindByID(ctx context.Context, id string) (*domain.Package, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.read)
 	defer cancel()
 
 	var pkg domain.Package
-	err := r.collection.FindOne(ctx, bson.M{"packageId": id}).Decode(&pkg)
+	err := r.collection.FindOne(ctx, packageKey(ctx, id)).Decode(&pkg)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
 			return nil, ErrPackageNotFound
@@ -42,61 +84,48 @@ func (r *PackageRepository) FindByID(id string) (*domain.Package, error) {
 	return &pkg, nil
 }
 
-func (r *PackageRepository) FindAll(page, size int) ([]domain.Package, int64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+func (r *PackageRepository) FindAll(ctx context.Context, filter domain.PackageFilter, opts domain.ListOptions) ([]domain.Package, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.read)
 	defer cancel()
 
-	skip := int64((page - 1) * size)
-	limit := int64(size)
-
-	opts := options.Find().
-		SetSkip(skip).
-		SetLimit(limit).
-		SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	return r.list(ctx, packageFilter(filter), opts)
+}
 
-	cursor, err := r.collection.Find(ctx, bson.M{}, opts)
+func (r *PackageRepository) Search(ctx context.Context, query domain.SearchQuery, opts domain.ListOptions) ([]domain.Package, int64, error) {
+	filter, err := searchFilter(query)
 	if err != nil {
 		return nil, 0, err
 	}
-	defer cursor.Close(ctx)
-
-	var packages []domain.Package
-	if err = cursor.All(ctx, &packages); err != nil {
-		return nil, 0, err
-	}
 
-	total, err := r.collection.CountDocuments(ctx, bson.M{})
-	if err != nil {
-		return nil, 0, err
-	}
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.search)
+	defer cancel()
 
-	return packages, total, nil
+	return r.list(ctx, filter, opts)
 }
 
-func (r *PackageRepository) Search(query string, page, size int) ([]domain.Package, int64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
-	defer cancel()
+// list returns a page of the tenant's packages matching filter and their
+// total. The page is selected by skip/limit or, when opts.After is set, by a
+// keyset condition on the sort fields.
+func (r *PackageRepository) list(ctx context.Context, filter bson.M, opts domain.ListOptions) ([]domain.Package, int64, error) {
+	filter["tenantId"] = domain.TenantFromContext(ctx)
 
-	skip := int64((page - 1) * size)
-	limit := int64(size)
-
-	filter := bson.M{
-		"$or": []bson.M{
-			{"packageId": primitive.Regex{Pattern: query, Options: "i"}},
-			{"sender.name": primitive.Regex{Pattern: query, Options: "i"}},
-			{"recipient.name": primitive.Regex{Pattern: query, Options: "i"}},
-			{"origin": primitive.Regex{Pattern: query, Options: "i"}},
-			{"destination": primitive.Regex{Pattern: query, Options: "i"}},
-			{"currentStatus": primitive.Regex{Pattern: query, Options: "i"}},
-		},
+	order := opts.Sort
+	if len(order) == 0 {
+		order = domain.DefaultSort
 	}
 
-	opts := options.Find().
-		SetSkip(skip).
-		SetLimit(limit).
-		SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	findOpts := options.Find().
+		SetLimit(int64(opts.Size)).
+		SetSort(sortDocument(order))
+
+	query := filter
+	if opts.After != nil {
+		query = bson.M{"$and": []bson.M{filter, afterFilter(order, opts.After)}}
+	} else {
+		findOpts.SetSkip(int64((opts.Page - 1) * opts.Size))
+	}
 
-	cursor, err := r.collection.Find(ctx, filter, opts)
+	cursor, err := r.collection.Find(ctx, query, findOpts)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -115,44 +144,145 @@ func (r *PackageRepository) Search(query string, page, size int) ([]domain.Packa
 	return packages, total, nil
 }
 
-func (r *PackageRepository) Create(pkg *domain.Package) error {
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+func (r *PackageRepository) Create(ctx context.Context, pkg *domain.Package) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.write)
 	defer cancel()
 
-	pkg.CreatedAt = time.Now()
-	pkg.UpdatedAt = time.Now()
+	now := time.Now()
+	pkg.TenantID = domain.TenantFromContext(ctx)
+	pkg.CreatedAt = now
+	pkg.UpdatedAt = now
+	pkg.Version = 1
 
 	_, err := r.collection.InsertOne(ctx, pkg)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: %s", domain.ErrPackageExists, pkg.PackageID)
+	}
 	return err
 }
 
-func (r *PackageRepository) Update(pkg *domain.Package) error {
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+// Update sets every field except the tenant, package ID and creation time,
+// so a replacement never clears CreatedAt
+func (r *PackageRepository) Update(ctx context.Context, pkg *domain.Package) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.write)
 	defer cancel()
 
-	pkg.UpdatedAt = time.Now()
+	updatedAt := time.Now()
 
-	result, err := r.collection.ReplaceOne(
+	var updated struct {
+		Version int64 `bson:"version"`
+	}
+	err := r.collection.FindOneAndUpdate(
 		ctx,
-		bson.M{"packageId": pkg.PackageID},
-		pkg,
-	)
+		versionFilter(ctx, pkg.PackageID, pkg.Version),
+		bson.M{
+			"$set": bson.M{
+				"sender":        pkg.Sender,
+				"recipient":     pkg.Recipient,
+				"origin":        pkg.Origin,
+				"destination":   pkg.Destination,
+				"currentStatus": pkg.CurrentStatus,
+				"latestEvent":   pkg.LatestEvent,
+				"eventCount":    pkg.EventCount,
+				"updatedAt":     updatedAt,
+			},
+			"$inc": bson.M{"version": 1},
+		},
+		options.FindOneAndUpdate().
+			SetReturnDocument(options.After).
+			SetProjection(bson.M{"version": 1}),
+	).Decode(&updated)
 	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return r.missing(ctx, pkg.PackageID, pkg.Version)
+		}
 		return err
 	}
 
-	if result.MatchedCount == 0 {
+	pkg.UpdatedAt = updatedAt
+	pkg.Version = updated.Version
+	return nil
+}
+
+func (r *PackageRepository) Patch(ctx context.Context, id string, update domain.PackageUpdate) (*domain.Package, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.write)
+	defer cancel()
+
+	set := updateFields(update)
+	set["updatedAt"] = time.Now()
+
+	var pkg domain.Package
+	err := r.collection.FindOneAndUpdate(
+		ctx,
+		versionFilter(ctx, id, update.Version),
+		bson.M{"$set": set, "$inc": bson.M{"version": 1}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&pkg)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, r.missing(ctx, id, update.Version)
+		}
+		return nil, err
+	}
+
+	return &pkg, nil
+}
+
+// packageKey matches the package with the given ID in the context's tenant
+func packageKey(ctx context.Context, id string) bson.M {
+	return bson.M{"tenantId": domain.TenantFromContext(ctx), "packageId": id}
+}
+
+// versionFilter matches the package, and its version when one is expected
+func versionFilter(ctx context.Context, id string, version int64) bson.M {
+	filter := packageKey(ctx, id)
+	if version > 0 {
+		filter["version"] = version
+	}
+	return filter
+}
+
+// missing explains why a conditional write matched no document: the package
+// either does not exist or is at a different version
+func (r *PackageRepository) missing(ctx context.Context, id string, version int64) error {
+	if version == 0 {
+		return ErrPackageNotFound
+	}
+	count, err := r.collection.CountDocuments(ctx, packageKey(ctx, id), options.Count().SetLimit(1))
+	if err != nil {
+		return err
+	}
+	if count == 0 {
 		return ErrPackageNotFound
 	}
+	return domain.ErrVersionConflict
+}
 
-	return nil
+// updateFields maps the non-nil fields of a partial update to document paths
+func updateFields(update domain.PackageUpdate) bson.M {
+	set := bson.M{}
+	fields := map[string]*string{
+		"sender.name":       update.SenderName,
+		"sender.address":    update.SenderAddress,
+		"recipient.name":    update.RecipientName,
+		"recipient.address": update.RecipientAddress,
+		"origin":            update.Origin,
+		"destination":       update.Destination,
+		"currentStatus":     update.CurrentStatus,
+	}
+	for path, value := range fields {
+		if value != nil {
+			set[path] = *value
+		}
+	}
+	return set
 }
 
-func (r *PackageRepository) Delete(id string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+func (r *PackageRepository) Delete(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.write)
 	defer cancel()
 
-	result, err := r.collection.DeleteOne(ctx, bson.M{"packageId": id})
+	result, err := r.collection.DeleteOne(ctx, packageKey(ctx, id))
 	if err != nil {
 		return err
 	}
@@ -163,3 +293,107 @@ func (r *PackageRepository) Delete(id string) error {
 
 	return nil
 }
+
+// packageFilter translates a domain filter into a MongoDB query
+func packageFilter(filter domain.PackageFilter) bson.M {
+	query := bson.M{}
+	if len(filter.Statuses) > 0 {
+		query["currentStatus"] = bson.M{"$in": filter.Statuses}
+	}
+	if filter.Origin != "" {
+		query["origin"] = filter.Origin
+	}
+	if filter.Destination != "" {
+		query["destination"] = filter.Destination
+	}
+	if filter.SenderName != "" {
+		query["sender.name"] = filter.SenderName
+	}
+	if filter.RecipientName != "" {
+		query["recipient.name"] = filter.RecipientName
+	}
+	if r := timeRange(filter.Created); r != nil {
+		query["createdAt"] = r
+	}
+	if r := timeRange(filter.Updated); r != nil {
+		query["updatedAt"] = r
+	}
+	return query
+}
+
+func timeRange(r domain.TimeRange) bson.M {
+	if r.From.IsZero() && r.To.IsZero() {
+		return nil
+	}
+	bounds := bson.M{}
+	if !r.From.IsZero() {
+		bounds["$gte"] = r.From
+	}
+	if !r.To.IsZero() {
+		bounds["$lt"] = r.To
+	}
+	return bounds
+}
+
+func sortDocument(order []domain.SortOrder) bson.D {
+	sort := make(bson.D, len(order))
+	for i, o := range order {
+		direction := 1
+		if o.Descending {
+			direction = -1
+		}
+		sort[i] = bson.E{Key: string(o.Field), Value: direction}
+	}
+	return sort
+}
+
+// afterFilter matches documents that sort after the key: those equal on the
+// first i fields and past the key on field i, for any i
+func afterFilter(order []domain.SortOrder, key []interface{}) bson.M {
+	var branches []bson.M
+	for i, o := range order {
+		branch := bson.M{}
+		for j := 0; j < i; j++ {
+			branch[string(order[j].Field)] = key[j]
+		}
+		operator := "$gt"
+		if o.Descending {
+			operator = "$lt"
+		}
+		branch[string(o.Field)] = bson.M{operator: key[i]}
+		branches = append(branches, branch)
+	}
+	return bson.M{"$or": branches}
+}
+
+// searchFields are the document fields matched by Search
+var searchFields = []string{"packageId", "sender.name", "recipient.name", "origin", "destination", "currentStatus"}
+
+// searchFilter builds a filter matching the query against any of the search
+// fields. Literal and prefix queries are escaped so user input is never
+// interpreted as a regular expression. Text queries use the text index of
+// migration create_package_text_index; only their words are passed on, so
+// they cannot contain phrases or negations.
+func searchFilter(query domain.SearchQuery) (bson.M, error) {
+	var value interface{}
+	switch query.Mode {
+	case domain.SearchText:
+		return bson.M{"$text": bson.M{"$search": strings.Join(query.Words(), " ")}}, nil
+	case domain.SearchLiteral:
+		value = primitive.Regex{Pattern: regexp.QuoteMeta(query.Text), Options: "i"}
+	case domain.SearchPrefix:
+		value = primitive.Regex{Pattern: "^" + regexp.QuoteMeta(query.Text)}
+	case domain.SearchExact:
+		value = query.Text
+	case domain.SearchRegex:
+		value = primitive.Regex{Pattern: query.Text, Options: "i"}
+	default:
+		return nil, fmt.Errorf("%w: unknown mode %q", domain.ErrInvalidSearch, query.Mode)
+	}
+
+	conditions := make([]bson.M, len(searchFields))
+	for i, field := range searchFields {
+		conditions[i] = bson.M{field: value}
+	}
+	return bson.M{"$or": conditions}, nil
+}
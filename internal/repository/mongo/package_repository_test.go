@@ -1,6 +1,7 @@
 package mongo
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -8,6 +9,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
 )
 
@@ -36,19 +38,29 @@ func TestPackageRepository_FindByID(t *testing.T) {
 			{Key: "updatedAt", Value: expectedPkg.UpdatedAt},
 		}))
 
-		pkg, err := repo.FindByID("123")
-		require.NoError(t, err)
-		assert.Equal(t, expectedPkg.PackageID, pkg.PackageID)
-		assert.Equal(t, expectedPkg.Sender, pkg.Sender)
+		pkg, err := repo.FindByID(context.Background(), "123")
+		require.NoError(mt, err)
+		assert.Equal(mt, expectedPkg.PackageID, pkg.PackageID)
+		assert.Equal(mt, expectedPkg.Sender, pkg.Sender)
 	})
 
 	mt.Run("not found", func(mt *mtest.T) {
 		repo := NewPackageRepository(mt.DB)
 		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
 
-		pkg, err := repo.FindByID("456")
-		assert.Error(t, err)
-		assert.Nil(t, pkg)
+		pkg, err := repo.FindByID(context.Background(), "456")
+		assert.Error(mt, err)
+		assert.Nil(mt, pkg)
+	})
+
+	mt.Run("cancelled context", func(mt *mtest.T) {
+		repo := NewPackageRepository(mt.DB)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		pkg, err := repo.FindByID(ctx, "123")
+		assert.ErrorIs(mt, err, context.Canceled)
+		assert.Nil(mt, pkg)
 	})
 }
 
@@ -89,22 +101,23 @@ func TestPackageRepository_FindAll(t *testing.T) {
 				}},
 			}),
 			mtest.CreateCursorResponse(0, "foo.bar", mtest.NextBatch),
+			mtest.CreateCursorResponse(1, "foo.bar", mtest.FirstBatch, bson.D{{Key: "n", Value: 2}}),
 		)
 
-		packages, total, err := repo.FindAll(1, 10)
-		require.NoError(t, err)
-		assert.Equal(t, expectedPackages, packages)
-		assert.Equal(t, int64(2), total)
+		packages, total, err := repo.FindAll(context.Background(), domain.PackageFilter{}, domain.ListOptions{Page: 1, Size: 10})
+		require.NoError(mt, err)
+		assert.Equal(mt, expectedPackages, packages)
+		assert.Equal(mt, int64(2), total)
 	})
 
 	mt.Run("error", func(mt *mtest.T) {
 		repo := NewPackageRepository(mt.DB)
 		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}})
 
-		packages, total, err := repo.FindAll(1, 10)
-		assert.Error(t, err)
-		assert.Empty(t, packages)
-		assert.Equal(t, int64(0), total)
+		packages, total, err := repo.FindAll(context.Background(), domain.PackageFilter{}, domain.ListOptions{Page: 1, Size: 10})
+		assert.Error(mt, err)
+		assert.Empty(mt, packages)
+		assert.Equal(mt, int64(0), total)
 	})
 }
 
@@ -132,22 +145,89 @@ func TestPackageRepository_Search(t *testing.T) {
 				}},
 			}),
 			mtest.CreateCursorResponse(0, "foo.bar", mtest.NextBatch),
+			mtest.CreateCursorResponse(1, "foo.bar", mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}),
 		)
 
-		packages, total, err := repo.Search("John", 1, 10)
-		require.NoError(t, err)
-		assert.Equal(t, expectedPackages, packages)
-		assert.Equal(t, int64(1), total)
+		packages, total, err := repo.Search(context.Background(), domain.SearchQuery{Text: "John", Mode: domain.SearchLiteral}, domain.ListOptions{Page: 1, Size: 10})
+		require.NoError(mt, err)
+		assert.Equal(mt, expectedPackages, packages)
+		assert.Equal(mt, int64(1), total)
 	})
 
 	mt.Run("error", func(mt *mtest.T) {
 		repo := NewPackageRepository(mt.DB)
 		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}})
 
-		packages, total, err := repo.Search("test", 1, 10)
-		assert.Error(t, err)
-		assert.Empty(t, packages)
-		assert.Equal(t, int64(0), total)
+		packages, total, err := repo.Search(context.Background(), domain.SearchQuery{Text: "test", Mode: domain.SearchLiteral}, domain.ListOptions{Page: 1, Size: 10})
+		assert.Error(mt, err)
+		assert.Empty(mt, packages)
+		assert.Equal(mt, int64(0), total)
+	})
+}
+
+func TestPackageFilter(t *testing.T) {
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 7)
+
+	assert.Equal(t, bson.M{}, packageFilter(domain.PackageFilter{}))
+	assert.Equal(t, bson.M{
+		"currentStatus": bson.M{"$in": []string{"IN_TRANSIT", "DELIVERED"}},
+		"origin":        "New York",
+		"sender.name":   "John Doe",
+		"createdAt":     bson.M{"$gte": from, "$lt": to},
+		"updatedAt":     bson.M{"$lt": to},
+	}, packageFilter(domain.PackageFilter{
+		Statuses:   []string{"IN_TRANSIT", "DELIVERED"},
+		Origin:     "New York",
+		SenderName: "John Doe",
+		Created:    domain.TimeRange{From: from, To: to},
+		Updated:    domain.TimeRange{To: to},
+	}))
+}
+
+func TestAfterFilter(t *testing.T) {
+	created := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, bson.D{{Key: "createdAt", Value: -1}, {Key: "packageId", Value: 1}}, sortDocument(domain.DefaultSort))
+	assert.Equal(t, bson.M{"$or": []bson.M{
+		{"createdAt": bson.M{"$lt": created}},
+		{"createdAt": created, "packageId": bson.M{"$gt": "PKG1"}},
+	}}, afterFilter(domain.DefaultSort, []interface{}{created, "PKG1"}))
+}
+
+func TestSearchFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		query domain.SearchQuery
+		want  interface{}
+	}{
+		{"literal is escaped", domain.SearchQuery{Text: "a+(b)", Mode: domain.SearchLiteral}, primitive.Regex{Pattern: `a\+\(b\)`, Options: "i"}},
+		{"prefix is anchored", domain.SearchQuery{Text: "PKG.", Mode: domain.SearchPrefix}, primitive.Regex{Pattern: `^PKG\.`}},
+		{"exact", domain.SearchQuery{Text: "PKG-1", Mode: domain.SearchExact}, "PKG-1"},
+		{"regex", domain.SearchQuery{Text: "^pkg", Mode: domain.SearchRegex}, primitive.Regex{Pattern: "^pkg", Options: "i"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter, err := searchFilter(tt.query)
+			require.NoError(t, err)
+			conditions := filter["$or"].([]bson.M)
+			require.Len(t, conditions, len(searchFields))
+			for i, field := range searchFields {
+				assert.Equal(t, tt.want, conditions[i][field])
+			}
+		})
+	}
+
+	t.Run("text passes on only words", func(t *testing.T) {
+		filter, err := searchFilter(domain.SearchQuery{Text: `Zebra -"Town"`, Mode: domain.SearchText})
+		require.NoError(t, err)
+		assert.Equal(t, bson.M{"$text": bson.M{"$search": "zebra town"}}, filter)
+	})
+
+	t.Run("unknown mode", func(t *testing.T) {
+		_, err := searchFilter(domain.SearchQuery{Text: "a", Mode: "fuzzy"})
+		assert.ErrorIs(t, err, domain.ErrInvalidSearch)
 	})
 }
 
@@ -166,8 +246,8 @@ func TestPackageRepository_Create(t *testing.T) {
 
 		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}})
 
-		err := repo.Create(pkg)
-		assert.NoError(t, err)
+		err := repo.Create(context.Background(), pkg)
+		assert.NoError(mt, err)
 	})
 
 	mt.Run("error", func(mt *mtest.T) {
@@ -178,8 +258,24 @@ func TestPackageRepository_Create(t *testing.T) {
 
 		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}})
 
-		err := repo.Create(pkg)
-		assert.Error(t, err)
+		err := repo.Create(context.Background(), pkg)
+		assert.Error(mt, err)
+	})
+
+	mt.Run("duplicate package ID", func(mt *mtest.T) {
+		repo := NewPackageRepository(mt.DB)
+		pkg := &domain.Package{
+			PackageID: "123",
+		}
+
+		mt.AddMockResponses(mtest.CreateWriteErrorsResponse(mtest.WriteError{
+			Index:   0,
+			Code:    11000,
+			Message: "duplicate key error",
+		}))
+
+		err := repo.Create(context.Background(), pkg)
+		assert.ErrorIs(mt, err, domain.ErrPackageExists)
 	})
 }
 
@@ -194,12 +290,43 @@ func TestPackageRepository_Update(t *testing.T) {
 				Name:    "John Doe",
 				Address: "123 Main St",
 			},
+			Version: 2,
 		}
 
-		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}})
+		mt.AddMockResponses(bson.D{
+			{Key: "ok", Value: 1},
+			{Key: "value", Value: bson.D{{Key: "version", Value: int64(3)}}},
+		})
+
+		err := repo.Update(context.Background(), pkg)
+		require.NoError(mt, err)
+		assert.Equal(mt, int64(3), pkg.Version)
+	})
+
+	mt.Run("version conflict", func(mt *mtest.T) {
+		repo := NewPackageRepository(mt.DB)
+		pkg := &domain.Package{PackageID: "123", Version: 2}
+
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: nil}},
+			mtest.CreateCursorResponse(1, "foo.bar", mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}),
+		)
+
+		err := repo.Update(context.Background(), pkg)
+		assert.ErrorIs(mt, err, domain.ErrVersionConflict)
+	})
+
+	mt.Run("not found", func(mt *mtest.T) {
+		repo := NewPackageRepository(mt.DB)
+		pkg := &domain.Package{PackageID: "123", Version: 2}
+
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: nil}},
+			mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch),
+		)
 
-		err := repo.Update(pkg)
-		assert.NoError(t, err)
+		err := repo.Update(context.Background(), pkg)
+		assert.ErrorIs(mt, err, ErrPackageNotFound)
 	})
 
 	mt.Run("error", func(mt *mtest.T) {
@@ -210,9 +337,64 @@ func TestPackageRepository_Update(t *testing.T) {
 
 		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}})
 
-		err := repo.Update(pkg)
-		assert.Error(t, err)
+		err := repo.Update(context.Background(), pkg)
+		assert.Error(mt, err)
+	})
+}
+
+func TestPackageRepository_Patch(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	destination := "Seattle"
+
+	mt.Run("success", func(mt *mtest.T) {
+		repo := NewPackageRepository(mt.DB)
+		mt.AddMockResponses(bson.D{
+			{Key: "ok", Value: 1},
+			{Key: "value", Value: bson.D{
+				{Key: "packageId", Value: "123"},
+				{Key: "destination", Value: destination},
+			}},
+		})
+
+		pkg, err := repo.Patch(context.Background(), "123", domain.PackageUpdate{Destination: &destination})
+		require.NoError(mt, err)
+		assert.Equal(mt, "123", pkg.PackageID)
+		assert.Equal(mt, "Seattle", pkg.Destination)
 	})
+
+	mt.Run("not found", func(mt *mtest.T) {
+		repo := NewPackageRepository(mt.DB)
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: nil}})
+
+		pkg, err := repo.Patch(context.Background(), "123", domain.PackageUpdate{Destination: &destination})
+		assert.ErrorIs(mt, err, ErrPackageNotFound)
+		assert.Nil(mt, pkg)
+	})
+
+	mt.Run("version conflict", func(mt *mtest.T) {
+		repo := NewPackageRepository(mt.DB)
+		mt.AddMockResponses(
+			bson.D{{Key: "ok", Value: 1}, {Key: "value", Value: nil}},
+			mtest.CreateCursorResponse(1, "foo.bar", mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}),
+		)
+
+		pkg, err := repo.Patch(context.Background(), "123", domain.PackageUpdate{Destination: &destination, Version: 4})
+		assert.ErrorIs(mt, err, domain.ErrVersionConflict)
+		assert.Nil(mt, pkg)
+	})
+}
+
+func TestVersionFilter(t *testing.T) {
+	ctx := context.Background()
+	assert.Equal(t, bson.M{"tenantId": domain.DefaultTenant, "packageId": "123"}, versionFilter(ctx, "123", 0))
+	assert.Equal(t, bson.M{"tenantId": "acme", "packageId": "123", "version": int64(4)},
+		versionFilter(domain.WithTenant(ctx, "acme"), "123", 4))
+}
+
+func TestUpdateFields(t *testing.T) {
+	name, status := "Maria Lopez", "IN_TRANSIT"
+	assert.Equal(t, bson.M{"recipient.name": name, "currentStatus": status},
+		updateFields(domain.PackageUpdate{RecipientName: &name, CurrentStatus: &status}))
 }
 
 func TestPackageRepository_Delete(t *testing.T) {
@@ -220,17 +402,17 @@ func TestPackageRepository_Delete(t *testing.T) {
 
 	mt.Run("success", func(mt *mtest.T) {
 		repo := NewPackageRepository(mt.DB)
-		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}})
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 1}})
 
-		err := repo.Delete("123")
-		assert.NoError(t, err)
+		err := repo.Delete(context.Background(), "123")
+		assert.NoError(mt, err)
 	})
 
 	mt.Run("error", func(mt *mtest.T) {
 		repo := NewPackageRepository(mt.DB)
 		mt.AddMockResponses(bson.D{{Key: "ok", Value: 0}})
 
-		err := repo.Delete("123")
-		assert.Error(t, err)
+		err := repo.Delete(context.Background(), "123")
+		assert.Error(mt, err)
 	})
 }
@@ -0,0 +1,89 @@
+package mongo
+
+import (
+	"context"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// usageDocument is the stored form of a usage counter
+type usageDocument struct {
+	Identity string `bson:"identity"`
+	Period   string `bson:"period"`
+	Count    int64  `bson:"count"`
+	// ExpiresAt is when the TTL index removes the counter
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// UsageRepository keeps request counts in the usage collection, one document
+// per identity and period. A unique index on period and identity, created by
+// a migration, keeps concurrent upserts from creating duplicates, and a TTL
+// index on expiresAt removes counters once they expire.
+type UsageRepository struct {
+	collection *mongo.Collection
+	timeouts   timeouts
+}
+
+func NewUsageRepository(db *mongo.Database, opts ...Option) *UsageRepository {
+	return &UsageRepository{
+		collection: db.Collection("usage"),
+		timeouts:   newTimeouts(opts),
+	}
+}
+
+func (r *UsageRepository) Increment(ctx context.Context, identity string, expiresAt time.Time, periods ...string) ([]int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.write)
+	defer cancel()
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	counts := make([]int64, 0, len(periods))
+	for _, period := range periods {
+		var doc usageDocument
+		err := r.collection.FindOneAndUpdate(ctx,
+			bson.M{"period": period, "identity": identity},
+			bson.M{"$inc": bson.M{"count": 1}, "$max": bson.M{"expiresAt": expiresAt}},
+			opts,
+		).Decode(&doc)
+		if err != nil {
+			return nil, err
+		}
+		counts = append(counts, doc.Count)
+	}
+	return counts, nil
+}
+
+func (r *UsageRepository) FindByPeriod(ctx context.Context, period string, opts domain.ListOptions) ([]domain.Usage, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.read)
+	defer cancel()
+
+	filter := bson.M{"period": period}
+	findOpts := options.Find().
+		SetSort(bson.D{{Key: "count", Value: -1}, {Key: "identity", Value: 1}}).
+		SetSkip(int64((opts.Page - 1) * opts.Size)).
+		SetLimit(int64(opts.Size))
+	cursor, err := r.collection.Find(ctx, filter, findOpts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	var docs []usageDocument
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, 0, err
+	}
+
+	total, err := r.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	usage := make([]domain.Usage, 0, len(docs))
+	for _, doc := range docs {
+		usage = append(usage, domain.Usage{Identity: doc.Identity, Period: doc.Period, Count: doc.Count})
+	}
+	return usage, total, nil
+}
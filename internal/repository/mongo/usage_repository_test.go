@@ -0,0 +1,69 @@
+package mongo
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestUsageRepository(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	counter := func(period string, count int64) bson.D {
+		return bson.D{
+			{Key: "ok", Value: 1},
+			{Key: "value", Value: bson.D{
+				{Key: "identity", Value: "principal:k1"},
+				{Key: "period", Value: period},
+				{Key: "count", Value: count},
+			}},
+		}
+	}
+
+	mt.Run("increment", func(mt *mtest.T) {
+		repo := NewUsageRepository(mt.DB)
+		mt.AddMockResponses(counter("2024-05-01", 3), counter("2024-05", 42))
+
+		expiresAt := time.Date(2024, 9, 1, 0, 0, 0, 0, time.UTC)
+		counts, err := repo.Increment(context.Background(), "principal:k1", expiresAt, "2024-05-01", "2024-05")
+		require.NoError(mt, err)
+		assert.Equal(mt, []int64{3, 42}, counts)
+
+		started := mt.GetAllStartedEvents()
+		require.Len(mt, started, 2)
+		command := started[0].Command
+		assert.Equal(mt, "2024-05-01", command.Lookup("query", "period").StringValue())
+		assert.True(mt, command.Lookup("upsert").Boolean())
+		assert.Equal(mt, expiresAt, command.Lookup("update", "$max", "expiresAt").Time().UTC(),
+			"counters are kept until the latest expiry")
+	})
+
+	mt.Run("find by period", func(mt *mtest.T) {
+		repo := NewUsageRepository(mt.DB)
+		mt.AddMockResponses(
+			mtest.CreateCursorResponse(0, "foo.usage", mtest.FirstBatch,
+				bson.D{{Key: "identity", Value: "principal:k1"}, {Key: "period", Value: "2024-05"}, {Key: "count", Value: int64(42)}},
+				bson.D{{Key: "identity", Value: "ip:192.0.2.1"}, {Key: "period", Value: "2024-05"}, {Key: "count", Value: int64(7)}},
+			),
+			mtest.CreateCursorResponse(0, "foo.usage", mtest.FirstBatch, bson.D{{Key: "n", Value: int32(12)}}),
+		)
+
+		usage, total, err := repo.FindByPeriod(context.Background(), "2024-05", domain.ListOptions{Page: 3, Size: 2})
+		require.NoError(mt, err)
+		assert.Equal(mt, []domain.Usage{
+			{Identity: "principal:k1", Period: "2024-05", Count: 42},
+			{Identity: "ip:192.0.2.1", Period: "2024-05", Count: 7},
+		}, usage)
+		assert.EqualValues(mt, 12, total)
+
+		find := mt.GetStartedEvent().Command
+		assert.EqualValues(mt, 4, find.Lookup("skip").AsInt64())
+		assert.EqualValues(mt, 2, find.Lookup("limit").AsInt64())
+	})
+}
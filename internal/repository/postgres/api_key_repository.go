@@ -0,0 +1,145 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+const apiKeyColumns = `id, name, role, tenant, plan, prefix, hash, created_at, rotated_at, revoked_at`
+
+// APIKeyRepository keeps API keys in the api_keys table
+type APIKeyRepository struct {
+	db       *sql.DB
+	timeouts timeouts
+}
+
+func NewAPIKeyRepository(db *sql.DB, opts ...Option) *APIKeyRepository {
+	return &APIKeyRepository{
+		db:       db,
+		timeouts: newTimeouts(opts),
+	}
+}
+
+func (r *APIKeyRepository) Create(ctx context.Context, key *domain.APIKey) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.write)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO api_keys (`+apiKeyColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		key.ID, key.Name, key.Role, key.Tenant, key.Plan, key.Prefix, key.Hash, key.CreatedAt, key.RotatedAt, key.RevokedAt,
+	)
+	return err
+}
+
+func (r *APIKeyRepository) FindByID(ctx context.Context, id string) (*domain.APIKey, error) {
+	return r.findOne(ctx, `id = $1`, id)
+}
+
+func (r *APIKeyRepository) FindByHash(ctx context.Context, hash string) (*domain.APIKey, error) {
+	return r.findOne(ctx, `hash = $1`, hash)
+}
+
+func (r *APIKeyRepository) findOne(ctx context.Context, where string, arg interface{}) (*domain.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.read)
+	defer cancel()
+
+	key, err := scanAPIKey(r.db.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE `+where, arg))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, domain.ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+func (r *APIKeyRepository) FindAll(ctx context.Context) ([]domain.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.read)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []domain.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, *key)
+	}
+	return keys, rows.Err()
+}
+
+// Rotate only updates keys without a revocation time, so a key revoked after
+// it was read stays revoked
+func (r *APIKeyRepository) Rotate(ctx context.Context, key *domain.APIKey) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.write)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE api_keys SET prefix = $2, hash = $3, rotated_at = $4 WHERE id = $1 AND revoked_at IS NULL`,
+		key.ID, key.Prefix, key.Hash, key.RotatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return r.unmatched(ctx, key.ID)
+	}
+	return nil
+}
+
+func (r *APIKeyRepository) Revoke(ctx context.Context, id string, revokedAt time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.write)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx,
+		`UPDATE api_keys SET revoked_at = $2 WHERE id = $1 AND revoked_at IS NULL`,
+		id, revokedAt,
+	)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		if err := r.unmatched(ctx, id); !errors.Is(err, domain.ErrAPIKeyRevoked) {
+			return err
+		}
+	}
+	return nil
+}
+
+// unmatched explains why a write to a key that had to be unrevoked updated
+// nothing: the key is missing or revoked
+func (r *APIKeyRepository) unmatched(ctx context.Context, id string) error {
+	var exists bool
+	err := r.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM api_keys WHERE id = $1)`, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return domain.ErrAPIKeyNotFound
+	}
+	return domain.ErrAPIKeyRevoked
+}
+
+func scanAPIKey(row interface{ Scan(...interface{}) error }) (*domain.APIKey, error) {
+	var key domain.APIKey
+	err := row.Scan(&key.ID, &key.Name, &key.Role, &key.Tenant, &key.Plan, &key.Prefix, &key.Hash, &key.CreatedAt, &key.RotatedAt, &key.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
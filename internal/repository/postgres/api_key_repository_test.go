@@ -0,0 +1,62 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyRepository(t *testing.T) {
+	repo := NewAPIKeyRepository(newTestDB(t))
+	ctx := context.Background()
+	created := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+
+	key := &domain.APIKey{ID: "k1", Name: "ci", Role: domain.RoleOperator, Prefix: "mt_abc", Hash: "h1", CreatedAt: created}
+	require.NoError(t, repo.Create(ctx, key))
+	assert.Error(t, repo.Create(ctx, &domain.APIKey{ID: "k2", Name: "dup", Role: domain.RoleViewer, Hash: "h1", CreatedAt: created}))
+
+	found, err := repo.FindByHash(ctx, "h1")
+	require.NoError(t, err)
+	assert.Equal(t, "k1", found.ID)
+	assert.Equal(t, domain.RoleOperator, found.Role)
+	assert.True(t, created.Equal(found.CreatedAt))
+	assert.Nil(t, found.RevokedAt)
+
+	rotated := created.Add(time.Hour)
+	key.Prefix, key.Hash, key.RotatedAt = "mt_def", "h2", &rotated
+	require.NoError(t, repo.Rotate(ctx, key))
+
+	found, err = repo.FindByID(ctx, "k1")
+	require.NoError(t, err)
+	assert.Equal(t, "h2", found.Hash)
+	assert.False(t, found.Revoked())
+
+	revoked := created.Add(2 * time.Hour)
+	require.NoError(t, repo.Revoke(ctx, "k1", revoked))
+	require.NoError(t, repo.Revoke(ctx, "k1", revoked.Add(time.Hour)))
+
+	found, err = repo.FindByID(ctx, "k1")
+	require.NoError(t, err)
+	assert.True(t, revoked.Equal(*found.RevokedAt), "a revoked key keeps its revocation time")
+
+	// found was read before this rotation, like a rotation racing a revoke
+	found.Prefix, found.Hash = "mt_ghi", "h3"
+	assert.ErrorIs(t, repo.Rotate(ctx, found), domain.ErrAPIKeyRevoked)
+	found, err = repo.FindByID(ctx, "k1")
+	require.NoError(t, err)
+	assert.Equal(t, "h2", found.Hash, "a revoked key is not rotated")
+	assert.True(t, found.Revoked())
+
+	keys, err := repo.FindAll(ctx)
+	require.NoError(t, err)
+	assert.Len(t, keys, 1)
+
+	_, err = repo.FindByHash(ctx, "missing")
+	assert.ErrorIs(t, err, domain.ErrAPIKeyNotFound)
+	assert.ErrorIs(t, repo.Rotate(ctx, &domain.APIKey{ID: "missing"}), domain.ErrAPIKeyNotFound)
+	assert.ErrorIs(t, repo.Revoke(ctx, "missing", revoked), domain.ErrAPIKeyNotFound)
+}
@@ -0,0 +1,14 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/snavarro/microtracker/internal/repository"
+)
+
+func TestPackageRepository_Contract(t *testing.T) {
+	repository.RunPackageRepositoryContract(t, func(t *testing.T) domain.PackageRepository {
+		return NewPackageRepository(newTestDB(t))
+	})
+}
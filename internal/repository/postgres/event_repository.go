@@ -0,0 +1,83 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+type EventRepository struct {
+	db       *sql.DB
+	timeouts timeouts
+}
+
+func NewEventRepository(db *sql.DB, opts ...Option) *EventRepository {
+	return &EventRepository{
+		db:       db,
+		timeouts: newTimeouts(opts),
+	}
+}
+
+func (r *EventRepository) Append(ctx context.Context, packageID string, events ...domain.Event) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.write)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	tenant := domain.TenantFromContext(ctx)
+	for _, event := range events {
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO package_events (tenant_id, package_id, occurred_at, location, status)
+			VALUES ($1, $2, $3, $4, $5)`,
+			tenant, packageID, event.Timestamp, event.Location, event.Status,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// FindByPackageID returns the package's full event history ordered by
+// timestamp
+func (r *EventRepository) FindByPackageID(ctx context.Context, packageID string) ([]domain.Event, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.read)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT occurred_at, location, status FROM package_events
+		WHERE tenant_id = $1 AND package_id = $2 ORDER BY occurred_at, id`,
+		domain.TenantFromContext(ctx), packageID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []domain.Event
+	for rows.Next() {
+		var event domain.Event
+		if err := rows.Scan(&event.Timestamp, &event.Location, &event.Status); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (r *EventRepository) DeleteByPackageID(ctx context.Context, packageID string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.write)
+	defer cancel()
+
+	_, err := r.db.ExecContext(ctx,
+		`DELETE FROM package_events WHERE tenant_id = $1 AND package_id = $2`,
+		domain.TenantFromContext(ctx), packageID,
+	)
+	return err
+}
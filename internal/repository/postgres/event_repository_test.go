@@ -0,0 +1,34 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := NewEventRepository(newTestDB(t))
+	first := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+
+	require.NoError(t, repo.Append(ctx, "123",
+		domain.Event{Timestamp: first.Add(time.Hour), Location: "Memphis Hub", Status: "IN_TRANSIT"},
+		domain.Event{Timestamp: first, Location: "New York", Status: "PICKED_UP"},
+	))
+
+	events, err := repo.FindByPackageID(ctx, "123")
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "PICKED_UP", events[0].Status)
+	assert.True(t, first.Equal(events[0].Timestamp))
+	assert.Equal(t, "IN_TRANSIT", events[1].Status)
+
+	require.NoError(t, repo.DeleteByPackageID(ctx, "123"))
+	events, err = repo.FindByPackageID(ctx, "123")
+	require.NoError(t, err)
+	assert.Empty(t, events)
+}
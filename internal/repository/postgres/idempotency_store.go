@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+// IdempotencyStore keeps idempotency records in the idempotency_keys table.
+// Expired rows and lapsed reservations are deleted whenever a key is
+// reserved.
+type IdempotencyStore struct {
+	db       *sql.DB
+	timeouts timeouts
+}
+
+func NewIdempotencyStore(db *sql.DB, opts ...Option) *IdempotencyStore {
+	return &IdempotencyStore{
+		db:       db,
+		timeouts: newTimeouts(opts),
+	}
+}
+
+func (s *IdempotencyStore) Reserve(ctx context.Context, record *domain.IdempotencyRecord) (*domain.IdempotencyRecord, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.write)
+	defer cancel()
+
+	if _, err := s.db.ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE expires_at <= $1 OR (NOT completed AND locked_until <= $1)`, time.Now(),
+	); err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.ExecContext(ctx,
+		`INSERT INTO idempotency_keys (key, fingerprint, locked_until, expires_at) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (key) DO NOTHING`,
+		record.Key, record.Fingerprint, record.LockedUntil, record.ExpiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if inserted, err := result.RowsAffected(); err != nil || inserted == 1 {
+		return nil, err
+	}
+
+	var (
+		existing   domain.IdempotencyRecord
+		statusCode sql.NullInt64
+		header     []byte
+	)
+	err = s.db.QueryRowContext(ctx,
+		`SELECT key, fingerprint, completed, locked_until, status_code, header, body, expires_at
+		FROM idempotency_keys WHERE key = $1`,
+		record.Key,
+	).Scan(&existing.Key, &existing.Fingerprint, &existing.Completed, &existing.LockedUntil, &statusCode, &header, &existing.Body, &existing.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Released between the insert and the select
+		return s.Reserve(ctx, record)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	existing.StatusCode = int(statusCode.Int64)
+	if header != nil {
+		if err := json.Unmarshal(header, &existing.Header); err != nil {
+			return nil, err
+		}
+	}
+	return &existing, nil
+}
+
+func (s *IdempotencyStore) Complete(ctx context.Context, record *domain.IdempotencyRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.write)
+	defer cancel()
+
+	header, err := json.Marshal(record.Header)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.ExecContext(ctx,
+		`UPDATE idempotency_keys SET completed = true, status_code = $2, header = $3, body = $4 WHERE key = $1`,
+		record.Key, record.StatusCode, header, record.Body,
+	)
+	return err
+}
+
+func (s *IdempotencyStore) Release(ctx context.Context, record *domain.IdempotencyRecord) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeouts.write)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE key = $1 AND NOT completed AND locked_until = $2`,
+		record.Key, record.LockedUntil,
+	)
+	return err
+}
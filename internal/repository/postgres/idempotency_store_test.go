@@ -0,0 +1,82 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdempotencyStore(t *testing.T) {
+	store := NewIdempotencyStore(newTestDB(t))
+	ctx := context.Background()
+	record := &domain.IdempotencyRecord{Key: "k1", Fingerprint: "abc", LockedUntil: time.Now().Add(time.Minute), ExpiresAt: time.Now().Add(time.Hour)}
+
+	existing, err := store.Reserve(ctx, record)
+	require.NoError(t, err)
+	assert.Nil(t, existing)
+
+	existing, err = store.Reserve(ctx, record)
+	require.NoError(t, err)
+	require.NotNil(t, existing)
+	assert.False(t, existing.Completed)
+
+	record.StatusCode = 201
+	record.Header = map[string]string{"Content-Type": "application/json"}
+	record.Body = []byte(`{"success":true}`)
+	require.NoError(t, store.Complete(ctx, record))
+
+	existing, err = store.Reserve(ctx, record)
+	require.NoError(t, err)
+	require.NotNil(t, existing)
+	assert.True(t, existing.Completed)
+	assert.Equal(t, "abc", existing.Fingerprint)
+	assert.Equal(t, 201, existing.StatusCode)
+	assert.Equal(t, record.Header, existing.Header)
+	assert.Equal(t, record.Body, existing.Body)
+
+	require.NoError(t, store.Release(ctx, record))
+	existing, err = store.Reserve(ctx, record)
+	require.NoError(t, err)
+	assert.NotNil(t, existing, "completed keys are not released")
+
+	t.Run("released keys can be reserved again", func(t *testing.T) {
+		pending := &domain.IdempotencyRecord{Key: "k3", Fingerprint: "abc", LockedUntil: time.Now().Add(time.Minute), ExpiresAt: time.Now().Add(time.Hour)}
+		_, err := store.Reserve(ctx, pending)
+		require.NoError(t, err)
+		require.NoError(t, store.Release(ctx, pending))
+		existing, err := store.Reserve(ctx, pending)
+		require.NoError(t, err)
+		assert.Nil(t, existing)
+	})
+
+	t.Run("lapsed reservations are taken over", func(t *testing.T) {
+		lost := &domain.IdempotencyRecord{Key: "k4", Fingerprint: "abc", LockedUntil: time.Now().Add(-time.Second), ExpiresAt: time.Now().Add(time.Hour)}
+		_, err := store.Reserve(ctx, lost)
+		require.NoError(t, err)
+
+		retry := &domain.IdempotencyRecord{Key: "k4", Fingerprint: "abc", LockedUntil: time.Now().Add(time.Minute), ExpiresAt: time.Now().Add(time.Hour)}
+		existing, err := store.Reserve(ctx, retry)
+		require.NoError(t, err)
+		assert.Nil(t, existing)
+
+		require.NoError(t, store.Release(ctx, lost), "the lost request cannot release the retry's reservation")
+		existing, err = store.Reserve(ctx, retry)
+		require.NoError(t, err)
+		require.NotNil(t, existing)
+		assert.False(t, existing.Completed)
+	})
+
+	t.Run("expired records are replaced", func(t *testing.T) {
+		expired := &domain.IdempotencyRecord{Key: "k2", Fingerprint: "old", ExpiresAt: time.Now().Add(-time.Minute)}
+		_, err := store.Reserve(ctx, expired)
+		require.NoError(t, err)
+
+		existing, err := store.Reserve(ctx, &domain.IdempotencyRecord{Key: "k2", Fingerprint: "new", ExpiresAt: time.Now().Add(time.Hour)})
+		require.NoError(t, err)
+		assert.Nil(t, existing)
+	})
+}
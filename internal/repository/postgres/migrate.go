@@ -0,0 +1,257 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the advisory lock key that serializes migrations
+// between replicas starting at the same time
+const migrationLockID = 7240317
+
+type migration struct {
+	version int
+	name    string
+	up      string
+	// down reverts up. It is empty for migrations without a down file.
+	down string
+}
+
+func (m migration) String() string {
+	return fmt.Sprintf("%04d_%s", m.version, m.name)
+}
+
+// MigrationStatus describes a known or applied migration. AppliedAt is nil
+// for pending migrations.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time
+}
+
+// Migrate applies all pending embedded migrations in version order inside a
+// single transaction. Applied versions are recorded in schema_migrations. It
+// returns the number of applied migrations.
+func Migrate(ctx context.Context, db *sql.DB) (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := beginMigration(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	applied, err := appliedMigrations(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+
+		if _, err := tx.ExecContext(ctx, m.up); err != nil {
+			return count, fmt.Errorf("migration %s failed: %v", m, err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.version, m.name,
+		); err != nil {
+			return count, err
+		}
+		count++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Rollback reverts the most recently applied migrations, up to steps of
+// them, newest first, inside a single transaction. It returns the number of
+// reverted migrations.
+func Rollback(ctx context.Context, db *sql.DB, steps int) (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := beginMigration(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	applied, err := appliedMigrations(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.version]; !ok {
+			continue
+		}
+		if m.down == "" {
+			return 0, fmt.Errorf("migration %s cannot be reverted", m)
+		}
+
+		if _, err := tx.ExecContext(ctx, m.down); err != nil {
+			return 0, fmt.Errorf("reverting migration %s failed: %v", m, err)
+		}
+		if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.version); err != nil {
+			return 0, err
+		}
+		count++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Status lists the embedded migrations and any applied migration this
+// binary does not know about, in version order
+func Status(ctx context.Context, db *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, `SELECT to_regclass('schema_migrations') IS NOT NULL`).Scan(&exists); err != nil {
+		return nil, err
+	}
+	applied := map[int]MigrationStatus{}
+	if exists {
+		if applied, err = appliedMigrations(ctx, tx); err != nil {
+			return nil, err
+		}
+	}
+
+	var status []MigrationStatus
+	for _, m := range migrations {
+		s := MigrationStatus{Version: m.version, Name: m.name}
+		if record, ok := applied[m.version]; ok {
+			s.AppliedAt = record.AppliedAt
+			delete(applied, m.version)
+		}
+		status = append(status, s)
+	}
+	for _, record := range applied {
+		status = append(status, record)
+	}
+
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Version < status[j].Version
+	})
+	return status, nil
+}
+
+// beginMigration starts a transaction holding the migration lock, creating
+// the schema_migrations table if needed
+func beginMigration(ctx context.Context, db *sql.DB) (*sql.Tx, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		name       TEXT NOT NULL,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	return tx, nil
+}
+
+func appliedMigrations(ctx context.Context, tx *sql.Tx) (map[int]MigrationStatus, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT version, name, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]MigrationStatus{}
+	for rows.Next() {
+		var (
+			record    MigrationStatus
+			appliedAt time.Time
+		)
+		if err := rows.Scan(&record.Version, &record.Name, &appliedAt); err != nil {
+			return nil, err
+		}
+		record.AppliedAt = &appliedAt
+		applied[record.Version] = record
+	}
+	return applied, rows.Err()
+}
+
+// loadMigrations reads the embedded "<version>_<name>.up.sql" files and their
+// optional ".down.sql" counterparts
+func loadMigrations() ([]migration, error) {
+	paths, err := fs.Glob(migrationFiles, "migrations/*.up.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	for _, path := range paths {
+		base := strings.TrimSuffix(strings.TrimPrefix(path, "migrations/"), ".up.sql")
+		versionPart, name, found := strings.Cut(base, "_")
+		if !found {
+			return nil, fmt.Errorf("invalid migration file name %s", path)
+		}
+		version, err := strconv.Atoi(versionPart)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %v", path, err)
+		}
+
+		up, err := migrationFiles.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		down, err := migrationFiles.ReadFile("migrations/" + base + ".down.sql")
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		migrations = append(migrations, migration{version: version, name: name, up: string(up), down: string(down)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS package_events;
+DROP TABLE IF EXISTS packages;
@@ -0,0 +1,24 @@
+CREATE TABLE packages (
+    package_id     TEXT PRIMARY KEY,
+    sender         JSONB NOT NULL,
+    recipient      JSONB NOT NULL,
+    origin         TEXT NOT NULL,
+    destination    TEXT NOT NULL,
+    current_status TEXT NOT NULL,
+    latest_event   JSONB,
+    event_count    INTEGER NOT NULL DEFAULT 0,
+    created_at     TIMESTAMPTZ NOT NULL,
+    updated_at     TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX packages_created_at_idx ON packages (created_at DESC, package_id);
+
+CREATE TABLE package_events (
+    id          BIGSERIAL PRIMARY KEY,
+    package_id  TEXT NOT NULL,
+    occurred_at TIMESTAMPTZ NOT NULL,
+    location    TEXT NOT NULL,
+    status      TEXT NOT NULL
+);
+
+CREATE INDEX package_events_package_id_idx ON package_events (package_id, occurred_at);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS packages_destination_idx;
+DROP INDEX IF EXISTS packages_origin_idx;
+DROP INDEX IF EXISTS packages_current_status_idx;
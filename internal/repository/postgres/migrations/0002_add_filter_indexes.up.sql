@@ -0,0 +1,3 @@
+CREATE INDEX packages_current_status_idx ON packages (current_status, created_at DESC);
+CREATE INDEX packages_origin_idx ON packages (origin);
+CREATE INDEX packages_destination_idx ON packages (destination);
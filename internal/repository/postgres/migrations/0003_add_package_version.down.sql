@@ -0,0 +1 @@
+ALTER TABLE packages DROP COLUMN IF EXISTS version;
@@ -0,0 +1 @@
+ALTER TABLE packages ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
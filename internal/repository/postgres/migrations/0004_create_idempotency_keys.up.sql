@@ -0,0 +1,11 @@
+CREATE TABLE idempotency_keys (
+    key         TEXT PRIMARY KEY,
+    fingerprint TEXT NOT NULL,
+    completed   BOOLEAN NOT NULL DEFAULT false,
+    status_code INTEGER,
+    header      JSONB,
+    body        BYTEA,
+    expires_at  TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
@@ -0,0 +1,19 @@
+-- Fails while two tenants use the same package ID
+DROP INDEX package_events_package_id_idx;
+DROP INDEX packages_destination_idx;
+DROP INDEX packages_origin_idx;
+DROP INDEX packages_current_status_idx;
+DROP INDEX packages_created_at_idx;
+
+CREATE INDEX packages_created_at_idx ON packages (created_at DESC, package_id);
+CREATE INDEX packages_current_status_idx ON packages (current_status, created_at DESC);
+CREATE INDEX packages_origin_idx ON packages (origin);
+CREATE INDEX packages_destination_idx ON packages (destination);
+CREATE INDEX package_events_package_id_idx ON package_events (package_id, occurred_at);
+
+ALTER TABLE packages DROP CONSTRAINT packages_pkey;
+ALTER TABLE packages ADD PRIMARY KEY (package_id);
+
+ALTER TABLE api_keys DROP COLUMN tenant;
+ALTER TABLE package_events DROP COLUMN tenant_id;
+ALTER TABLE packages DROP COLUMN tenant_id;
@@ -0,0 +1,20 @@
+-- Existing packages and events belong to the default tenant
+ALTER TABLE packages ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE package_events ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE api_keys ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+
+-- Package IDs are unique per tenant and every query is scoped to a tenant
+ALTER TABLE packages DROP CONSTRAINT packages_pkey;
+ALTER TABLE packages ADD PRIMARY KEY (tenant_id, package_id);
+
+DROP INDEX packages_created_at_idx;
+DROP INDEX packages_current_status_idx;
+DROP INDEX packages_origin_idx;
+DROP INDEX packages_destination_idx;
+DROP INDEX package_events_package_id_idx;
+
+CREATE INDEX packages_created_at_idx ON packages (tenant_id, created_at DESC, package_id);
+CREATE INDEX packages_current_status_idx ON packages (tenant_id, current_status, created_at DESC);
+CREATE INDEX packages_origin_idx ON packages (tenant_id, origin);
+CREATE INDEX packages_destination_idx ON packages (tenant_id, destination);
+CREATE INDEX package_events_package_id_idx ON package_events (tenant_id, package_id, occurred_at);
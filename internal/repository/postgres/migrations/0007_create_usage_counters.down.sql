@@ -0,0 +1,3 @@
+DROP TABLE usage_counters;
+
+ALTER TABLE api_keys DROP COLUMN plan;
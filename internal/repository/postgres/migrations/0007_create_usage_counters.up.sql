@@ -0,0 +1,10 @@
+ALTER TABLE api_keys ADD COLUMN plan TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE usage_counters (
+    identity TEXT NOT NULL,
+    period   TEXT NOT NULL,
+    count    BIGINT NOT NULL,
+    PRIMARY KEY (identity, period)
+);
+
+CREATE INDEX usage_counters_period_count_idx ON usage_counters (period, count DESC);
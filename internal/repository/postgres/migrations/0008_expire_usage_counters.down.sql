@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS usage_counters_expires_at_idx;
+
+ALTER TABLE usage_counters DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,5 @@
+-- Counters written before they had an expiry are kept for 90 days
+ALTER TABLE usage_counters ADD COLUMN expires_at TIMESTAMPTZ NOT NULL DEFAULT now() + INTERVAL '90 days';
+ALTER TABLE usage_counters ALTER COLUMN expires_at DROP DEFAULT;
+
+CREATE INDEX usage_counters_expires_at_idx ON usage_counters (expires_at);
@@ -0,0 +1,3 @@
+-- Requests pending while this runs keep their keys for another minute
+ALTER TABLE idempotency_keys ADD COLUMN locked_until TIMESTAMPTZ NOT NULL DEFAULT now() + INTERVAL '1 minute';
+ALTER TABLE idempotency_keys ALTER COLUMN locked_until DROP DEFAULT;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS packages_search_idx;
@@ -0,0 +1,5 @@
+-- Serves text searches; the expression must match searchDocument in query.go
+CREATE INDEX packages_search_idx ON packages USING GIN (
+    to_tsvector('simple', package_id || ' ' || coalesce(sender->>'name', '') || ' ' ||
+        coalesce(recipient->>'name', '') || ' ' || origin || ' ' || destination || ' ' || current_status)
+);
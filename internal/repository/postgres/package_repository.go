@@ -0,0 +1,348 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/snavarro/microtracker/config"
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+var defaultTimeout = 5 * time.Second
+
+// uniqueViolation is the PostgreSQL error code for duplicate keys
+const uniqueViolation = "23505"
+
+// timeouts bounds each kind of operation on top of the caller's context
+type timeouts struct {
+	read   time.Duration
+	write  time.Duration
+	search time.Duration
+}
+
+// Option configures optional repository behaviour
+type Option func(*timeouts)
+
+// WithTimeouts sets per-operation timeouts. Zero values keep the default.
+func WithTimeouts(cfg config.TimeoutConfig) Option {
+	return func(t *timeouts) {
+		if cfg.Read > 0 {
+			t.read = cfg.Read
+		}
+		if cfg.Write > 0 {
+			t.write = cfg.Write
+		}
+		if cfg.Search > 0 {
+			t.search = cfg.Search
+		}
+	}
+}
+
+func newTimeouts(opts []Option) timeouts {
+	t := timeouts{
+		read:   defaultTimeout,
+		write:  defaultTimeout,
+		search: defaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(&t)
+	}
+	return t
+}
+
+const packageColumns = `tenant_id, package_id, sender, recipient, origin, destination, current_status,
+	latest_event, event_count, created_at, updated_at, version`
+
+type PackageRepository struct {
+	db       *sql.DB
+	timeouts timeouts
+}
+
+func NewPackageRepository(db *sql.DB, opts ...Option) *PackageRepository {
+	return &PackageRepository{
+		db:       db,
+		timeouts: newTimeouts(opts),
+	}
+}
+
+func (r *PackageRepository) FindByID(ctx context.Context, id string) (*domain.Package, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.read)
+	defer cancel()
+
+	row := r.db.QueryRowContext(ctx,
+		`SELECT `+packageColumns+` FROM packages WHERE tenant_id = $1 AND package_id = $2`,
+		domain.TenantFromContext(ctx), id,
+	)
+	pkg, err := scanPackage(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrPackageNotFound
+		}
+		return nil, err
+	}
+	return pkg, nil
+}
+
+func (r *PackageRepository) FindAll(ctx context.Context, filter domain.PackageFilter, opts domain.ListOptions) ([]domain.Package, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.read)
+	defer cancel()
+
+	where := tenantCondition(ctx)
+	filterCondition(&where, filter)
+	return r.list(ctx, where, opts)
+}
+
+func (r *PackageRepository) Search(ctx context.Context, query domain.SearchQuery, opts domain.ListOptions) ([]domain.Package, int64, error) {
+	where := tenantCondition(ctx)
+	if err := searchCondition(&where, query); err != nil {
+		return nil, 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.search)
+	defer cancel()
+
+	return r.list(ctx, where, opts)
+}
+
+// list returns a page of the packages matching where and their total. The
+// page is selected by offset or, when opts.After is set, by a keyset
+// condition on the sort columns.
+func (r *PackageRepository) list(ctx context.Context, where whereClause, opts domain.ListOptions) ([]domain.Package, int64, error) {
+	order := opts.Sort
+	if len(order) == 0 {
+		order = domain.DefaultSort
+	}
+
+	var total int64
+	if err := r.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM packages`+where.String(), where.args...,
+	).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	page := where.clone()
+	limit := " LIMIT " + page.arg(opts.Size)
+	if opts.After != nil {
+		afterCondition(&page, order, opts.After)
+	} else {
+		limit += " OFFSET " + page.arg((opts.Page-1)*opts.Size)
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT `+packageColumns+` FROM packages`+page.String()+orderBy(order)+limit,
+		page.args...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	packages, err := scanPackages(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return packages, total, nil
+}
+
+func (r *PackageRepository) Create(ctx context.Context, pkg *domain.Package) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.write)
+	defer cancel()
+
+	// PostgreSQL stores microseconds, truncate so callers see stored values
+	now := time.Now().UTC().Truncate(time.Microsecond)
+	pkg.TenantID = domain.TenantFromContext(ctx)
+	pkg.CreatedAt = now
+	pkg.UpdatedAt = now
+	pkg.Version = 1
+
+	sender, recipient, latest, err := marshalPackage(pkg)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx,
+		`INSERT INTO packages (`+packageColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		pkg.TenantID, pkg.PackageID, sender, recipient, pkg.Origin, pkg.Destination, pkg.CurrentStatus,
+		latest, pkg.EventCount, pkg.CreatedAt, pkg.UpdatedAt, pkg.Version,
+	)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return fmt.Errorf("%w: %s", domain.ErrPackageExists, pkg.PackageID)
+	}
+	return err
+}
+
+func (r *PackageRepository) Update(ctx context.Context, pkg *domain.Package) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.write)
+	defer cancel()
+
+	updatedAt := time.Now().UTC().Truncate(time.Microsecond)
+
+	sender, recipient, latest, err := marshalPackage(pkg)
+	if err != nil {
+		return err
+	}
+
+	var version int64
+	err = r.db.QueryRowContext(ctx,
+		`UPDATE packages SET sender = $2, recipient = $3, origin = $4, destination = $5,
+			current_status = $6, latest_event = $7, event_count = $8, updated_at = $9,
+			version = version + 1
+		WHERE package_id = $1 AND ($10::bigint = 0 OR version = $10) AND tenant_id = $11
+		RETURNING tenant_id, version`,
+		pkg.PackageID, sender, recipient, pkg.Origin, pkg.Destination, pkg.CurrentStatus,
+		latest, pkg.EventCount, updatedAt, pkg.Version, domain.TenantFromContext(ctx),
+	).Scan(&pkg.TenantID, &version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return r.missing(ctx, pkg.PackageID, pkg.Version)
+		}
+		return err
+	}
+
+	pkg.UpdatedAt = updatedAt
+	pkg.Version = version
+	return nil
+}
+
+func (r *PackageRepository) Patch(ctx context.Context, id string, update domain.PackageUpdate) (*domain.Package, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.write)
+	defer cancel()
+
+	var set setClause
+	set.add("updated_at", time.Now().UTC().Truncate(time.Microsecond))
+	updateColumns(&set, update)
+	set.assignments = append(set.assignments, "version = version + 1")
+	where := `tenant_id = ` + set.arg(domain.TenantFromContext(ctx)) + ` AND package_id = ` + set.arg(id)
+	if update.Version > 0 {
+		where += ` AND version = ` + set.arg(update.Version)
+	}
+
+	row := r.db.QueryRowContext(ctx,
+		`UPDATE packages SET `+set.String()+` WHERE `+where+` RETURNING `+packageColumns,
+		set.args...,
+	)
+	pkg, err := scanPackage(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, r.missing(ctx, id, update.Version)
+		}
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// missing explains why a conditional write matched no row: the package
+// either does not exist or is at a different version
+func (r *PackageRepository) missing(ctx context.Context, id string, version int64) error {
+	if version == 0 {
+		return domain.ErrPackageNotFound
+	}
+	var exists bool
+	if err := r.db.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM packages WHERE tenant_id = $1 AND package_id = $2)`,
+		domain.TenantFromContext(ctx), id,
+	).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return domain.ErrPackageNotFound
+	}
+	return domain.ErrVersionConflict
+}
+
+func (r *PackageRepository) Delete(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.write)
+	defer cancel()
+
+	result, err := r.db.ExecContext(ctx,
+		`DELETE FROM packages WHERE tenant_id = $1 AND package_id = $2`,
+		domain.TenantFromContext(ctx), id,
+	)
+	if err != nil {
+		return err
+	}
+
+	return requireAffected(result)
+}
+
+// requireAffected maps statements that touched no rows to ErrPackageNotFound
+func requireAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return domain.ErrPackageNotFound
+	}
+	return nil
+}
+
+func marshalPackage(pkg *domain.Package) (sender, recipient, latest []byte, err error) {
+	if sender, err = json.Marshal(pkg.Sender); err != nil {
+		return nil, nil, nil, err
+	}
+	if recipient, err = json.Marshal(pkg.Recipient); err != nil {
+		return nil, nil, nil, err
+	}
+	if pkg.LatestEvent != nil {
+		if latest, err = json.Marshal(pkg.LatestEvent); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	return sender, recipient, latest, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPackage(row rowScanner) (*domain.Package, error) {
+	var (
+		pkg                       domain.Package
+		sender, recipient, latest []byte
+	)
+
+	err := row.Scan(
+		&pkg.TenantID, &pkg.PackageID, &sender, &recipient, &pkg.Origin, &pkg.Destination, &pkg.CurrentStatus,
+		&latest, &pkg.EventCount, &pkg.CreatedAt, &pkg.UpdatedAt, &pkg.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(sender, &pkg.Sender); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(recipient, &pkg.Recipient); err != nil {
+		return nil, err
+	}
+	if latest != nil {
+		pkg.LatestEvent = &domain.Event{}
+		if err := json.Unmarshal(latest, pkg.LatestEvent); err != nil {
+			return nil, err
+		}
+	}
+
+	return &pkg, nil
+}
+
+func scanPackages(rows *sql.Rows) ([]domain.Package, error) {
+	defer rows.Close()
+
+	var packages []domain.Package
+	for rows.Next() {
+		pkg, err := scanPackage(rows)
+		if err != nil {
+			return nil, err
+		}
+		packages = append(packages, *pkg)
+	}
+	return packages, rows.Err()
+}
@@ -0,0 +1,117 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPackage(id string) *domain.Package {
+	return &domain.Package{
+		PackageID: id,
+		Sender: domain.Address{
+			Name:    "John Doe",
+			Address: "123 Main St",
+		},
+		Recipient: domain.Address{
+			Name:    "Jane Doe",
+			Address: "456 Oak St",
+		},
+		Origin:        "New York",
+		Destination:   "Los Angeles",
+		CurrentStatus: "CREATED",
+	}
+}
+
+func TestPackageRepository_FindByID(t *testing.T) {
+	ctx := context.Background()
+	repo := NewPackageRepository(newTestDB(t))
+
+	pkg := newTestPackage("123")
+	pkg.LatestEvent = &domain.Event{Timestamp: time.Now().UTC().Truncate(time.Millisecond), Location: "New York", Status: "CREATED"}
+	pkg.EventCount = 1
+	require.NoError(t, repo.Create(ctx, pkg))
+
+	t.Run("success", func(t *testing.T) {
+		found, err := repo.FindByID(ctx, "123")
+		require.NoError(t, err)
+		assert.Equal(t, pkg.Sender, found.Sender)
+		assert.Equal(t, pkg.Recipient, found.Recipient)
+		assert.True(t, pkg.CreatedAt.Equal(found.CreatedAt))
+		require.NotNil(t, found.LatestEvent)
+		assert.Equal(t, "New York", found.LatestEvent.Location)
+		assert.Equal(t, 1, found.EventCount)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		found, err := repo.FindByID(ctx, "456")
+		assert.ErrorIs(t, err, domain.ErrPackageNotFound)
+		assert.Nil(t, found)
+	})
+}
+
+func TestPackageRepository_FindAll(t *testing.T) {
+	ctx := context.Background()
+	repo := NewPackageRepository(newTestDB(t))
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, repo.Create(ctx, newTestPackage(fmt.Sprintf("PKG%d", i))))
+		time.Sleep(time.Millisecond)
+	}
+
+	packages, total, err := repo.FindAll(ctx, domain.PackageFilter{}, domain.ListOptions{Page: 1, Size: 2})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	require.Len(t, packages, 2)
+	assert.Equal(t, "PKG3", packages[0].PackageID)
+	assert.Equal(t, "PKG2", packages[1].PackageID)
+}
+
+func TestPackageRepository_Search(t *testing.T) {
+	ctx := context.Background()
+	repo := NewPackageRepository(newTestDB(t))
+	pkg := newTestPackage("ABC123")
+	pkg.Recipient.Name = "Maria Lopez"
+	require.NoError(t, repo.Create(ctx, pkg))
+	require.NoError(t, repo.Create(ctx, newTestPackage("XYZ789")))
+
+	packages, total, err := repo.Search(ctx, domain.SearchQuery{Text: "maria", Mode: domain.SearchLiteral}, domain.ListOptions{Page: 1, Size: 10})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	require.Len(t, packages, 1)
+	assert.Equal(t, "ABC123", packages[0].PackageID)
+}
+
+func TestPackageRepository_Update(t *testing.T) {
+	ctx := context.Background()
+	repo := NewPackageRepository(newTestDB(t))
+	require.NoError(t, repo.Create(ctx, newTestPackage("123")))
+
+	t.Run("success", func(t *testing.T) {
+		pkg := newTestPackage("123")
+		pkg.Destination = "Seattle"
+		require.NoError(t, repo.Update(ctx, pkg))
+
+		found, err := repo.FindByID(ctx, "123")
+		require.NoError(t, err)
+		assert.Equal(t, "Seattle", found.Destination)
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		err := repo.Update(ctx, newTestPackage("456"))
+		assert.ErrorIs(t, err, domain.ErrPackageNotFound)
+	})
+}
+
+func TestPackageRepository_Delete(t *testing.T) {
+	ctx := context.Background()
+	repo := NewPackageRepository(newTestDB(t))
+	require.NoError(t, repo.Create(ctx, newTestPackage("123")))
+
+	require.NoError(t, repo.Delete(ctx, "123"))
+	assert.ErrorIs(t, repo.Delete(ctx, "123"), domain.ErrPackageNotFound)
+}
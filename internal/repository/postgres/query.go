@@ -0,0 +1,227 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+// whereClause accumulates conditions joined with AND and their positional
+// arguments
+type whereClause struct {
+	conditions []string
+	args       []interface{}
+}
+
+// arg adds a positional argument and returns its placeholder
+func (w *whereClause) arg(value interface{}) string {
+	w.args = append(w.args, value)
+	return fmt.Sprintf("$%d", len(w.args))
+}
+
+func (w *whereClause) add(condition string) {
+	w.conditions = append(w.conditions, condition)
+}
+
+func (w whereClause) clone() whereClause {
+	return whereClause{
+		conditions: append([]string(nil), w.conditions...),
+		args:       append([]interface{}(nil), w.args...),
+	}
+}
+
+// String returns the WHERE clause, empty when there are no conditions
+func (w whereClause) String() string {
+	if len(w.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.conditions, " AND ")
+}
+
+// tenantCondition starts a WHERE clause scoped to the context's tenant
+func tenantCondition(ctx context.Context) whereClause {
+	var w whereClause
+	w.add("tenant_id = " + w.arg(domain.TenantFromContext(ctx)))
+	return w
+}
+
+// filterCondition adds the conditions for every non-zero filter field
+func filterCondition(w *whereClause, filter domain.PackageFilter) {
+	if len(filter.Statuses) > 0 {
+		placeholders := make([]string, len(filter.Statuses))
+		for i, status := range filter.Statuses {
+			placeholders[i] = w.arg(status)
+		}
+		w.add("current_status IN (" + strings.Join(placeholders, ", ") + ")")
+	}
+	if filter.Origin != "" {
+		w.add("origin = " + w.arg(filter.Origin))
+	}
+	if filter.Destination != "" {
+		w.add("destination = " + w.arg(filter.Destination))
+	}
+	if filter.SenderName != "" {
+		w.add("sender->>'name' = " + w.arg(filter.SenderName))
+	}
+	if filter.RecipientName != "" {
+		w.add("recipient->>'name' = " + w.arg(filter.RecipientName))
+	}
+	if !filter.Created.From.IsZero() {
+		w.add("created_at >= " + w.arg(filter.Created.From))
+	}
+	if !filter.Created.To.IsZero() {
+		w.add("created_at < " + w.arg(filter.Created.To))
+	}
+	if !filter.Updated.From.IsZero() {
+		w.add("updated_at >= " + w.arg(filter.Updated.From))
+	}
+	if !filter.Updated.To.IsZero() {
+		w.add("updated_at < " + w.arg(filter.Updated.To))
+	}
+}
+
+// searchFields are matched by Search, the same fields as the MongoDB
+// implementation
+var searchFields = []string{
+	"package_id", "sender->>'name'", "recipient->>'name'", "origin", "destination", "current_status",
+}
+
+// searchDocument is the text searched by text queries, the expression
+// indexed by migration 0010_add_search_index
+const searchDocument = `to_tsvector('simple', package_id || ' ' || coalesce(sender->>'name', '') || ' ' || ` +
+	`coalesce(recipient->>'name', '') || ' ' || origin || ' ' || destination || ' ' || current_status)`
+
+// likeEscaper escapes LIKE wildcards so literal and prefix searches match
+// the query text as-is
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// searchCondition adds a condition matching the query against any of the
+// search fields in the query's mode
+func searchCondition(w *whereClause, query domain.SearchQuery) error {
+	var operator, arg string
+	switch query.Mode {
+	case domain.SearchText:
+		// Words only hold letters and digits, so they need no quoting in a
+		// tsquery
+		w.add(searchDocument + " @@ to_tsquery('simple', " + w.arg(strings.Join(query.Words(), " | ")) + ")")
+		return nil
+	case domain.SearchLiteral:
+		operator, arg = "ILIKE", "%"+likeEscaper.Replace(query.Text)+"%"
+	case domain.SearchPrefix:
+		operator, arg = "LIKE", likeEscaper.Replace(query.Text)+"%"
+	case domain.SearchExact:
+		operator, arg = "=", query.Text
+	case domain.SearchRegex:
+		operator, arg = "~*", query.Text
+	default:
+		return fmt.Errorf("%w: unknown mode %q", domain.ErrInvalidSearch, query.Mode)
+	}
+
+	placeholder := w.arg(arg)
+	conditions := make([]string, len(searchFields))
+	for i, field := range searchFields {
+		conditions[i] = field + " " + operator + " " + placeholder
+	}
+	w.add("(" + strings.Join(conditions, " OR ") + ")")
+	return nil
+}
+
+// sortColumns maps sort fields to their columns
+var sortColumns = map[domain.SortField]string{
+	domain.SortCreatedAt:     "created_at",
+	domain.SortUpdatedAt:     "updated_at",
+	domain.SortPackageID:     "package_id",
+	domain.SortOrigin:        "origin",
+	domain.SortDestination:   "destination",
+	domain.SortCurrentStatus: "current_status",
+}
+
+func orderBy(order []domain.SortOrder) string {
+	columns := make([]string, len(order))
+	for i, o := range order {
+		columns[i] = sortColumns[o.Field]
+		if o.Descending {
+			columns[i] += " DESC"
+		}
+	}
+	return " ORDER BY " + strings.Join(columns, ", ")
+}
+
+// afterCondition adds a keyset condition matching rows that sort after the
+// key: those equal on the first i columns and past the key on column i, for
+// any i
+func afterCondition(w *whereClause, order []domain.SortOrder, key []interface{}) {
+	placeholders := make([]string, len(order))
+	for i := range order {
+		placeholders[i] = w.arg(key[i])
+	}
+
+	branches := make([]string, len(order))
+	for i, o := range order {
+		var terms []string
+		for j := 0; j < i; j++ {
+			terms = append(terms, sortColumns[order[j].Field]+" = "+placeholders[j])
+		}
+		operator := ">"
+		if o.Descending {
+			operator = "<"
+		}
+		terms = append(terms, sortColumns[o.Field]+" "+operator+" "+placeholders[i])
+		branches[i] = "(" + strings.Join(terms, " AND ") + ")"
+	}
+	w.add("(" + strings.Join(branches, " OR ") + ")")
+}
+
+// setClause accumulates the assignments of an UPDATE statement and their
+// positional arguments
+type setClause struct {
+	assignments []string
+	args        []interface{}
+}
+
+// arg adds a positional argument and returns its placeholder
+func (s *setClause) arg(value interface{}) string {
+	s.args = append(s.args, value)
+	return fmt.Sprintf("$%d", len(s.args))
+}
+
+// add assigns value to column
+func (s *setClause) add(column string, value interface{}) {
+	s.assignments = append(s.assignments, column+" = "+s.arg(value))
+}
+
+func (s setClause) String() string {
+	return strings.Join(s.assignments, ", ")
+}
+
+// updateColumns adds an assignment for every non-nil field of a partial
+// update. Assignments to the same JSONB column are chained because
+// PostgreSQL allows a column to be set only once per statement.
+func updateColumns(s *setClause, update domain.PackageUpdate) {
+	addAddress := func(column string, name, address *string) {
+		expr := column
+		if name != nil {
+			expr = fmt.Sprintf("jsonb_set(%s, '{name}', to_jsonb(%s::text))", expr, s.arg(*name))
+		}
+		if address != nil {
+			expr = fmt.Sprintf("jsonb_set(%s, '{address}', to_jsonb(%s::text))", expr, s.arg(*address))
+		}
+		if expr != column {
+			s.assignments = append(s.assignments, column+" = "+expr)
+		}
+	}
+
+	addAddress("sender", update.SenderName, update.SenderAddress)
+	addAddress("recipient", update.RecipientName, update.RecipientAddress)
+	if update.Origin != nil {
+		s.add("origin", *update.Origin)
+	}
+	if update.Destination != nil {
+		s.add("destination", *update.Destination)
+	}
+	if update.CurrentStatus != nil {
+		s.add("current_status", *update.CurrentStatus)
+	}
+}
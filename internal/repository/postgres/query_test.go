@@ -0,0 +1,90 @@
+package postgres
+
+import (
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilterCondition(t *testing.T) {
+	var empty whereClause
+	filterCondition(&empty, domain.PackageFilter{})
+	assert.Empty(t, empty.String())
+	assert.Empty(t, empty.args)
+
+	from := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	var where whereClause
+	filterCondition(&where, domain.PackageFilter{
+		Statuses:      []string{"IN_TRANSIT", "DELIVERED"},
+		RecipientName: "Jane Doe",
+		Created:       domain.TimeRange{From: from},
+	})
+	assert.Equal(t, " WHERE current_status IN ($1, $2) AND recipient->>'name' = $3 AND created_at >= $4", where.String())
+	assert.Equal(t, []interface{}{"IN_TRANSIT", "DELIVERED", "Jane Doe", from}, where.args)
+}
+
+func TestSearchCondition(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    domain.SearchQuery
+		operator string
+		arg      string
+	}{
+		{"literal escapes wildcards", domain.SearchQuery{Text: `50%_off\`, Mode: domain.SearchLiteral}, "ILIKE", `%50\%\_off\\%`},
+		{"prefix", domain.SearchQuery{Text: "PKG_", Mode: domain.SearchPrefix}, "LIKE", `PKG\_%`},
+		{"exact", domain.SearchQuery{Text: "PKG-1", Mode: domain.SearchExact}, "=", "PKG-1"},
+		{"regex", domain.SearchQuery{Text: "^pkg", Mode: domain.SearchRegex}, "~*", "^pkg"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var where whereClause
+			require.NoError(t, searchCondition(&where, tt.query))
+			assert.Contains(t, where.String(), "origin "+tt.operator+" $1")
+			assert.Equal(t, []interface{}{tt.arg}, where.args)
+		})
+	}
+
+	t.Run("text", func(t *testing.T) {
+		var where whereClause
+		require.NoError(t, searchCondition(&where, domain.SearchQuery{Text: `Zebra & !Town`, Mode: domain.SearchText}))
+		assert.Equal(t, " WHERE "+searchDocument+" @@ to_tsquery('simple', $1)", where.String())
+		assert.Equal(t, []interface{}{"zebra | town"}, where.args, "operators in the query are not passed on")
+	})
+
+	t.Run("unknown mode", func(t *testing.T) {
+		var where whereClause
+		err := searchCondition(&where, domain.SearchQuery{Text: "a", Mode: "fuzzy"})
+		assert.ErrorIs(t, err, domain.ErrInvalidSearch)
+	})
+}
+
+func TestAfterCondition(t *testing.T) {
+	created := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	where := whereClause{}
+	where.add("origin = " + where.arg("Chicago"))
+
+	afterCondition(&where, domain.DefaultSort, []interface{}{created, "PKG1"})
+
+	assert.Equal(t, " WHERE origin = $1 AND ((created_at < $2) OR (created_at = $2 AND package_id > $3))", where.String())
+	assert.Equal(t, []interface{}{"Chicago", created, "PKG1"}, where.args)
+	assert.Equal(t, " ORDER BY created_at DESC, package_id", orderBy(domain.DefaultSort))
+}
+
+func TestUpdateColumns(t *testing.T) {
+	name, address, status := "Maria Lopez", "1 Pine St", "IN_TRANSIT"
+	var set setClause
+	updateColumns(&set, domain.PackageUpdate{
+		RecipientName:    &name,
+		RecipientAddress: &address,
+		CurrentStatus:    &status,
+	})
+
+	assert.Equal(t,
+		"recipient = jsonb_set(jsonb_set(recipient, '{name}', to_jsonb($1::text)), '{address}', to_jsonb($2::text)), current_status = $3",
+		set.String())
+	assert.Equal(t, []interface{}{name, address, status}, set.args)
+}
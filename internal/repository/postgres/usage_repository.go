@@ -0,0 +1,108 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+// UsageRepository keeps request counts in the usage_counters table, one row
+// per identity and period. Expired rows are deleted whenever a counter is
+// added.
+type UsageRepository struct {
+	db       *sql.DB
+	timeouts timeouts
+}
+
+func NewUsageRepository(db *sql.DB, opts ...Option) *UsageRepository {
+	return &UsageRepository{
+		db:       db,
+		timeouts: newTimeouts(opts),
+	}
+}
+
+// Increment upserts the counters of every period in a single statement
+func (r *UsageRepository) Increment(ctx context.Context, identity string, expiresAt time.Time, periods ...string) ([]int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.write)
+	defer cancel()
+
+	rows, err := r.db.QueryContext(ctx,
+		`INSERT INTO usage_counters (identity, period, count, expires_at)
+		SELECT $1, period, 1, $3 FROM unnest($2::text[]) AS period
+		ON CONFLICT (identity, period) DO UPDATE
+		SET count = usage_counters.count + 1, expires_at = GREATEST(usage_counters.expires_at, EXCLUDED.expires_at)
+		RETURNING period, count`,
+		identity, periods, expiresAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int64, len(periods))
+	for rows.Next() {
+		var (
+			period string
+			count  int64
+		)
+		if err := rows.Scan(&period, &count); err != nil {
+			return nil, err
+		}
+		counts[period] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]int64, len(periods))
+	added := false
+	for i, period := range periods {
+		result[i] = counts[period]
+		added = added || result[i] == 1
+	}
+
+	// Counters are added once per identity and period, which is often
+	// enough to keep expired ones from piling up
+	if added {
+		if _, err := r.db.ExecContext(ctx,
+			`DELETE FROM usage_counters WHERE expires_at <= $1`, time.Now(),
+		); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func (r *UsageRepository) FindByPeriod(ctx context.Context, period string, opts domain.ListOptions) ([]domain.Usage, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.read)
+	defer cancel()
+
+	var total int64
+	if err := r.db.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM usage_counters WHERE period = $1`, period,
+	).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT identity, period, count FROM usage_counters WHERE period = $1
+		ORDER BY count DESC, identity LIMIT $2 OFFSET $3`,
+		period, opts.Size, (opts.Page-1)*opts.Size,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	usage := []domain.Usage{}
+	for rows.Next() {
+		var u domain.Usage
+		if err := rows.Scan(&u.Identity, &u.Period, &u.Count); err != nil {
+			return nil, 0, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, total, rows.Err()
+}
@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageRepository(t *testing.T) {
+	repo := NewUsageRepository(newTestDB(t))
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+	firstPage := domain.ListOptions{Page: 1, Size: 10}
+
+	counts, err := repo.Increment(ctx, "principal:k1", expiresAt, "2024-05-01", "2024-05")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1, 1}, counts)
+
+	counts, err = repo.Increment(ctx, "principal:k1", expiresAt, "2024-05-01", "2024-05")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{2, 2}, counts)
+
+	counts, err = repo.Increment(ctx, "ip:192.0.2.1", expiresAt, "2024-05-02", "2024-05")
+	require.NoError(t, err)
+	assert.Equal(t, []int64{1, 1}, counts)
+
+	usage, total, err := repo.FindByPeriod(ctx, "2024-05", firstPage)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, total)
+	assert.Equal(t, []domain.Usage{
+		{Identity: "principal:k1", Period: "2024-05", Count: 2},
+		{Identity: "ip:192.0.2.1", Period: "2024-05", Count: 1},
+	}, usage)
+
+	usage, total, err = repo.FindByPeriod(ctx, "2024-05", domain.ListOptions{Page: 2, Size: 1})
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, total)
+	assert.Equal(t, []domain.Usage{{Identity: "ip:192.0.2.1", Period: "2024-05", Count: 1}}, usage)
+
+	usage, _, err = repo.FindByPeriod(ctx, "2024-06", firstPage)
+	require.NoError(t, err)
+	assert.Empty(t, usage)
+
+	_, err = repo.Increment(ctx, "principal:k2", time.Now().Add(-time.Hour), "2024-04")
+	require.NoError(t, err)
+	_, err = repo.Increment(ctx, "principal:k3", expiresAt, "2024-04")
+	require.NoError(t, err)
+	usage, _, err = repo.FindByPeriod(ctx, "2024-04", firstPage)
+	require.NoError(t, err)
+	assert.Equal(t, []domain.Usage{{Identity: "principal:k3", Period: "2024-04", Count: 1}}, usage,
+		"expired counters are deleted when a counter is added")
+}
@@ -0,0 +1,383 @@
+// Package redis keeps rate limit buckets in Redis, or any server speaking the
+// Redis protocol, so that replicas share them.
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+// keyPrefix namespaces the bucket keys. Keys of algorithms other than the
+// token bucket are further prefixed with the algorithm.
+const keyPrefix = "ratelimit:"
+
+// window is the window of the sliding and fixed window algorithms
+const window = time.Minute
+
+// exemptPrefix namespaces the keys of exempted identities, which expire with
+// the exemption
+const exemptPrefix = keyPrefix + "exempt:"
+
+// gcra implements the generic cell rate algorithm. A bucket is a single key
+// holding its theoretical arrival time (TAT) in milliseconds: the time at
+// which the bucket would be full again had every allowed request arrived on
+// schedule. A request is allowed when the new TAT is at most the burst's
+// worth of emission intervals ahead of now. The key expires once the bucket
+// is full, so idle clients cost nothing.
+//
+// KEYS[1] is the bucket key, ARGV the current time in milliseconds, the
+// emission interval in milliseconds and the burst size. It returns whether
+// the request was allowed, the remaining requests, the milliseconds until the
+// bucket is full again and the milliseconds until the next request is allowed.
+var gcra = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local emission = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local tolerance = emission * burst
+
+local tat = tonumber(redis.call("GET", KEYS[1]))
+if not tat or tat < now then
+	tat = now
+end
+
+local new_tat = tat + emission
+local allowed_at = new_tat - tolerance
+if now < allowed_at then
+	return {0, math.floor((now - (tat - tolerance)) / emission), math.ceil(tat - now), math.ceil(allowed_at - now)}
+end
+
+redis.call("SET", KEYS[1], string.format("%.3f", new_tat), "PX", math.ceil(new_tat - now))
+return {1, math.floor((now - allowed_at) / emission), math.ceil(new_tat - now), 0}
+`)
+
+// slidingLog implements the sliding window algorithm with a sorted set of
+// the requests of the last window, scored by their time in milliseconds.
+//
+// KEYS[1] is the log key, ARGV the current time and the window in
+// milliseconds, the limit and a unique member for the request. It returns
+// the same values as gcra.
+var slidingLog = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+if count >= limit then
+	local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+	local newest = redis.call("ZRANGE", KEYS[1], -1, -1, "WITHSCORES")
+	return {0, 0, tonumber(newest[2]) + window - now, tonumber(oldest[2]) + window - now}
+end
+
+redis.call("ZADD", KEYS[1], now, ARGV[4])
+redis.call("PEXPIRE", KEYS[1], window)
+return {1, limit - count - 1, window, 0}
+`)
+
+// fixedWindow implements the fixed window algorithm with a counter per
+// window, which expires with the window. Rejected requests are not counted.
+//
+// KEYS[1] is the counter key of the current window, ARGV the milliseconds
+// until the window ends and the limit. It returns the same values as gcra.
+var fixedWindow = redis.NewScript(`
+local remaining_ms = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+
+local count = tonumber(redis.call("GET", KEYS[1]) or "0")
+if count >= limit then
+	return {0, 0, remaining_ms, remaining_ms}
+end
+
+redis.call("SET", KEYS[1], count + 1, "PX", remaining_ms)
+return {1, limit - count - 1, remaining_ms, 0}
+`)
+
+// concurrency implements the concurrency algorithm with a sorted set of the
+// leases of requests in flight, scored by when they expire in milliseconds.
+//
+// KEYS[1] is the lease key, ARGV the current time in milliseconds, the limit,
+// the new lease and its TTL in milliseconds. It returns whether the request
+// was allowed and the remaining slots.
+var concurrency = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local ttl = tonumber(ARGV[4])
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+local count = redis.call("ZCARD", KEYS[1])
+if count >= limit then
+	return {0, 0}
+end
+
+redis.call("ZADD", KEYS[1], now + ttl, ARGV[3])
+redis.call("PEXPIRE", KEYS[1], ttl)
+return {1, limit - count - 1}
+`)
+
+// RateLimitStore is a domain.RateLimitStore that keeps buckets in Redis.
+// Every request runs one script, so concurrent requests from any replica are
+// counted exactly once. Times come from the replicas, whose clocks should be
+// kept in sync.
+type RateLimitStore struct {
+	client redis.Cmdable
+}
+
+func NewRateLimitStore(client redis.Cmdable) *RateLimitStore {
+	return &RateLimitStore{
+		client: client,
+	}
+}
+
+func (s *RateLimitStore) Take(ctx context.Context, key string, limit domain.RateLimit, now time.Time) (*domain.RateLimitResult, error) {
+	switch limit.Algorithm {
+	case domain.AlgorithmSlidingWindow:
+		return s.takeSlidingWindow(ctx, key, limit, now)
+	case domain.AlgorithmFixedWindow:
+		return s.takeFixedWindow(ctx, key, limit, now)
+	case domain.AlgorithmConcurrency:
+		return s.takeConcurrency(ctx, key, limit, now)
+	}
+
+	result := &domain.RateLimitResult{Limit: limit.Burst}
+	if limit.RequestsPerMinute <= 0 || limit.Burst <= 0 {
+		return result, nil
+	}
+
+	emission := float64(time.Minute.Milliseconds()) / float64(limit.RequestsPerMinute)
+	values, err := run(ctx, s.client, gcra, bucketKey(key, limit), 4,
+		now.UnixMilli(), emission, limit.Burst,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return windowResult(result, values), nil
+}
+
+func (s *RateLimitStore) takeSlidingWindow(ctx context.Context, key string, limit domain.RateLimit, now time.Time) (*domain.RateLimitResult, error) {
+	result := &domain.RateLimitResult{Limit: limit.RequestsPerMinute}
+	if limit.RequestsPerMinute <= 0 {
+		return result, nil
+	}
+
+	member, err := newLease()
+	if err != nil {
+		return nil, err
+	}
+	values, err := run(ctx, s.client, slidingLog, bucketKey(key, limit), 4,
+		now.UnixMilli(), window.Milliseconds(), limit.RequestsPerMinute, member,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return windowResult(result, values), nil
+}
+
+func (s *RateLimitStore) takeFixedWindow(ctx context.Context, key string, limit domain.RateLimit, now time.Time) (*domain.RateLimitResult, error) {
+	result := &domain.RateLimitResult{Limit: limit.RequestsPerMinute}
+	if limit.RequestsPerMinute <= 0 {
+		return result, nil
+	}
+
+	remaining := now.Truncate(window).Add(window).Sub(now).Milliseconds()
+	values, err := run(ctx, s.client, fixedWindow, fixedWindowKey(key, limit, now), 4,
+		max(remaining, 1), limit.RequestsPerMinute,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return windowResult(result, values), nil
+}
+
+func (s *RateLimitStore) takeConcurrency(ctx context.Context, key string, limit domain.RateLimit, now time.Time) (*domain.RateLimitResult, error) {
+	result := &domain.RateLimitResult{Limit: limit.Burst}
+	if limit.Burst <= 0 {
+		return result, nil
+	}
+
+	lease, err := newLease()
+	if err != nil {
+		return nil, err
+	}
+	values, err := run(ctx, s.client, concurrency, bucketKey(key, limit), 2,
+		now.UnixMilli(), limit.Burst, lease, max(limit.TTL.Milliseconds(), 1),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Allowed = values[0] == 1
+	result.Remaining = int(max(values[1], 0))
+	if result.Allowed {
+		result.Lease = lease
+	} else {
+		result.RetryAfter = domain.ConcurrencyRetryAfter
+	}
+	return result, nil
+}
+
+// Release removes the lease of a request allowed by a concurrency limit
+func (s *RateLimitStore) Release(ctx context.Context, key string, limit domain.RateLimit, lease string) error {
+	if limit.Algorithm != domain.AlgorithmConcurrency || lease == "" {
+		return nil
+	}
+	if err := s.client.ZRem(ctx, bucketKey(key, limit), lease).Err(); err != nil {
+		return fmt.Errorf("failed to release rate limit lease: %w", err)
+	}
+	return nil
+}
+
+// Peek reads the state of a bucket without running a script, as nothing is
+// written
+func (s *RateLimitStore) Peek(ctx context.Context, key string, limit domain.RateLimit, now time.Time) (*domain.RateLimitResult, error) {
+	nowMs := now.UnixMilli()
+	switch limit.Algorithm {
+	case domain.AlgorithmSlidingWindow:
+		result := &domain.RateLimitResult{Limit: limit.RequestsPerMinute}
+		entries, err := s.client.ZRangeByScoreWithScores(ctx, bucketKey(key, limit), &redis.ZRangeBy{
+			Min: "(" + strconv.FormatInt(nowMs-window.Milliseconds(), 10),
+			Max: "+inf",
+		}).Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rate limit bucket: %w", err)
+		}
+		result.Remaining = max(limit.RequestsPerMinute-len(entries), 0)
+		if len(entries) > 0 {
+			newest := int64(entries[len(entries)-1].Score)
+			result.Reset = time.Duration(newest+window.Milliseconds()-nowMs) * time.Millisecond
+		}
+		return result, nil
+
+	case domain.AlgorithmFixedWindow:
+		result := &domain.RateLimitResult{Limit: limit.RequestsPerMinute, Remaining: limit.RequestsPerMinute}
+		count, err := s.client.Get(ctx, fixedWindowKey(key, limit, now)).Int()
+		if errors.Is(err, redis.Nil) {
+			return result, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rate limit bucket: %w", err)
+		}
+		result.Remaining = max(limit.RequestsPerMinute-count, 0)
+		result.Reset = now.Truncate(window).Add(window).Sub(now)
+		return result, nil
+
+	case domain.AlgorithmConcurrency:
+		inFlight, err := s.client.ZCount(ctx, bucketKey(key, limit), "("+strconv.FormatInt(nowMs, 10), "+inf").Result()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rate limit bucket: %w", err)
+		}
+		return &domain.RateLimitResult{Limit: limit.Burst, Remaining: max(limit.Burst-int(inFlight), 0)}, nil
+	}
+
+	result := &domain.RateLimitResult{Limit: limit.Burst, Remaining: limit.Burst}
+	tat, err := s.client.Get(ctx, bucketKey(key, limit)).Float64()
+	if errors.Is(err, redis.Nil) || limit.RequestsPerMinute <= 0 {
+		return result, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rate limit bucket: %w", err)
+	}
+	if ahead := tat - float64(nowMs); ahead > 0 {
+		emission := float64(time.Minute.Milliseconds()) / float64(limit.RequestsPerMinute)
+		result.Remaining = max(int(math.Floor((float64(limit.Burst)*emission-ahead)/emission)), 0)
+		result.Reset = time.Duration(math.Ceil(ahead)) * time.Millisecond
+	}
+	return result, nil
+}
+
+// Reset deletes the key of a bucket
+func (s *RateLimitStore) Reset(ctx context.Context, key string, limit domain.RateLimit, now time.Time) error {
+	redisKey := bucketKey(key, limit)
+	if limit.Algorithm == domain.AlgorithmFixedWindow {
+		redisKey = fixedWindowKey(key, limit, now)
+	}
+	if err := s.client.Del(ctx, redisKey).Err(); err != nil {
+		return fmt.Errorf("failed to reset rate limit bucket: %w", err)
+	}
+	return nil
+}
+
+// Exempt stores an exemption as a key that expires when it ends
+func (s *RateLimitStore) Exempt(ctx context.Context, identity string, until time.Time) error {
+	var err error
+	if until.IsZero() {
+		err = s.client.Del(ctx, exemptPrefix+identity).Err()
+	} else {
+		_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, exemptPrefix+identity, until.UnixMilli(), 0)
+			pipe.PExpireAt(ctx, exemptPrefix+identity, until)
+			return nil
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store rate limit exemption: %w", err)
+	}
+	return nil
+}
+
+func (s *RateLimitStore) Exemption(ctx context.Context, identity string, now time.Time) (time.Time, error) {
+	ms, err := s.client.Get(ctx, exemptPrefix+identity).Int64()
+	if errors.Is(err, redis.Nil) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to read rate limit exemption: %w", err)
+	}
+	if until := time.UnixMilli(ms).UTC(); until.After(now) {
+		return until, nil
+	}
+	return time.Time{}, nil
+}
+
+// bucketKey returns the Redis key of a bucket
+func bucketKey(key string, limit domain.RateLimit) string {
+	if limit.Algorithm == "" || limit.Algorithm == domain.AlgorithmTokenBucket {
+		return keyPrefix + key
+	}
+	return keyPrefix + string(limit.Algorithm) + ":" + key
+}
+
+// fixedWindowKey returns the Redis key of the counter of the window
+// containing now
+func fixedWindowKey(key string, limit domain.RateLimit, now time.Time) string {
+	return fmt.Sprintf("%s:%d", bucketKey(key, limit), now.Truncate(window).Unix())
+}
+
+// run runs a rate limit script on key, which returns n integers
+func run(ctx context.Context, client redis.Scripter, script *redis.Script, key string, n int, args ...interface{}) ([]int64, error) {
+	values, err := script.Run(ctx, client, []string{key}, args...).Int64Slice()
+	if err != nil {
+		return nil, fmt.Errorf("failed to run rate limit script: %w", err)
+	}
+	if len(values) != n {
+		return nil, fmt.Errorf("unexpected rate limit script result %v", values)
+	}
+	return values, nil
+}
+
+// windowResult fills result with the allowed flag, remaining requests, reset
+// and retry milliseconds returned by a script
+func windowResult(result *domain.RateLimitResult, values []int64) *domain.RateLimitResult {
+	result.Allowed = values[0] == 1
+	result.Remaining = int(max(values[1], 0))
+	result.Reset = time.Duration(values[2]) * time.Millisecond
+	result.RetryAfter = time.Duration(values[3]) * time.Millisecond
+	return result
+}
+
+// newLease returns a random ID for a lease or a sliding log entry
+func newLease() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,253 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestClient returns a client of an in-process Redis stand-in
+func newTestClient(t *testing.T) (*redis.Client, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return client, server
+}
+
+func TestRateLimitStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	limit := domain.RateLimit{RequestsPerMinute: 6, Burst: 3}
+	client, server := newTestClient(t)
+
+	// Two replicas share the bucket
+	replicas := []*RateLimitStore{NewRateLimitStore(client), NewRateLimitStore(client)}
+	for i := 0; i < 3; i++ {
+		result, err := replicas[i%2].Take(ctx, "k1", limit, now)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 3, result.Limit)
+		assert.Equal(t, 2-i, result.Remaining)
+		assert.Equal(t, time.Duration(i+1)*10*time.Second, result.Reset)
+		assert.Zero(t, result.RetryAfter)
+	}
+
+	store := replicas[0]
+	result, err := store.Take(ctx, "k1", limit, now.Add(4*time.Second))
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, 6*time.Second, result.RetryAfter)
+	assert.Equal(t, 26*time.Second, result.Reset)
+
+	result, err = store.Take(ctx, "k1", limit, now.Add(10*time.Second))
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "a rejected request spends no token")
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, 30*time.Second, result.Reset)
+
+	result, err = store.Take(ctx, "k2", limit, now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Remaining, "keys have separate buckets")
+
+	t.Run("keys expire once the bucket is full", func(t *testing.T) {
+		assert.Equal(t, 30*time.Second, server.TTL(keyPrefix+"k1"))
+		server.FastForward(31 * time.Second)
+		assert.False(t, server.Exists(keyPrefix+"k1"))
+	})
+
+	t.Run("fractional emission intervals", func(t *testing.T) {
+		limit := domain.RateLimit{RequestsPerMinute: 7, Burst: 1}
+		result, err := store.Take(ctx, "k3", limit, now)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+
+		result, err = store.Take(ctx, "k3", limit, now.Add(8571*time.Millisecond))
+		require.NoError(t, err)
+		assert.False(t, result.Allowed)
+		assert.Equal(t, time.Millisecond, result.RetryAfter)
+
+		result, err = store.Take(ctx, "k3", limit, now.Add(8572*time.Millisecond))
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+	})
+
+	t.Run("zero limit", func(t *testing.T) {
+		result, err := store.Take(ctx, "k4", domain.RateLimit{}, now)
+		require.NoError(t, err)
+		assert.False(t, result.Allowed)
+	})
+
+	t.Run("unavailable server", func(t *testing.T) {
+		server.Close()
+		_, err := store.Take(ctx, "k1", limit, now)
+		assert.Error(t, err)
+	})
+}
+
+func TestRateLimitStore_SlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	limit := domain.RateLimit{Algorithm: domain.AlgorithmSlidingWindow, RequestsPerMinute: 3}
+	client, server := newTestClient(t)
+	store := NewRateLimitStore(client)
+
+	for i, offset := range []time.Duration{0, 20 * time.Second, 40 * time.Second} {
+		result, err := store.Take(ctx, "k1", limit, now.Add(offset))
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 3, result.Limit)
+		assert.Equal(t, 2-i, result.Remaining)
+		assert.Equal(t, time.Minute, result.Reset)
+	}
+
+	result, err := store.Take(ctx, "k1", limit, now.Add(50*time.Second))
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 10*time.Second, result.RetryAfter, "until the oldest request leaves the window")
+	assert.Equal(t, 50*time.Second, result.Reset)
+
+	result, err = store.Take(ctx, "k1", limit, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "the window slides past the first request")
+
+	result, err = store.Take(ctx, "k1", limit, now.Add(70*time.Second))
+	require.NoError(t, err)
+	assert.False(t, result.Allowed, "unlike a fixed window, the limit holds across minutes")
+
+	assert.True(t, server.Exists(keyPrefix+"sliding_window:k1"))
+	assert.False(t, server.Exists(keyPrefix+"k1"), "algorithms do not share keys")
+}
+
+func TestRateLimitStore_FixedWindow(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 8, 0, 30, 0, time.UTC)
+	limit := domain.RateLimit{Algorithm: domain.AlgorithmFixedWindow, RequestsPerMinute: 2}
+	client, server := newTestClient(t)
+	store := NewRateLimitStore(client)
+
+	for i := 0; i < 2; i++ {
+		result, err := store.Take(ctx, "k1", limit, now)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 1-i, result.Remaining)
+		assert.Equal(t, 30*time.Second, result.Reset, "until the end of the minute")
+	}
+
+	result, err := store.Take(ctx, "k1", limit, now.Add(20*time.Second))
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 10*time.Second, result.RetryAfter)
+
+	result, err = store.Take(ctx, "k1", limit, now.Add(30*time.Second))
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "the next minute starts over")
+	assert.Equal(t, 1, result.Remaining)
+
+	key := fmt.Sprintf("%sfixed_window:k1:%d", keyPrefix, now.Truncate(time.Minute).Unix())
+	assert.Equal(t, 30*time.Second, server.TTL(key), "counters expire with their window")
+}
+
+func TestRateLimitStore_Concurrency(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	limit := domain.RateLimit{Algorithm: domain.AlgorithmConcurrency, Burst: 2, TTL: time.Minute}
+	client, _ := newTestClient(t)
+
+	// Two replicas share the slots
+	replicas := []*RateLimitStore{NewRateLimitStore(client), NewRateLimitStore(client)}
+	first, err := replicas[0].Take(ctx, "k1", limit, now)
+	require.NoError(t, err)
+	assert.True(t, first.Allowed)
+	assert.NotEmpty(t, first.Lease)
+	assert.Equal(t, 1, first.Remaining)
+
+	second, err := replicas[1].Take(ctx, "k1", limit, now)
+	require.NoError(t, err)
+	assert.True(t, second.Allowed)
+
+	result, err := replicas[0].Take(ctx, "k1", limit, now)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Empty(t, result.Lease)
+	assert.Equal(t, domain.ConcurrencyRetryAfter, result.RetryAfter)
+
+	require.NoError(t, replicas[1].Release(ctx, "k1", limit, first.Lease))
+	result, err = replicas[0].Take(ctx, "k1", limit, now)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "a released slot is free again")
+
+	result, err = replicas[0].Take(ctx, "k1", limit, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, result.Allowed, "leases that are never released expire")
+	assert.Equal(t, 1, result.Remaining)
+}
+
+func TestRateLimitStore_PeekAndReset(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 8, 0, 30, 0, time.UTC)
+	client, _ := newTestClient(t)
+	store := NewRateLimitStore(client)
+
+	limits := map[string]domain.RateLimit{
+		"token":   {RequestsPerMinute: 6, Burst: 3},
+		"sliding": {Algorithm: domain.AlgorithmSlidingWindow, RequestsPerMinute: 3},
+		"fixed":   {Algorithm: domain.AlgorithmFixedWindow, RequestsPerMinute: 3},
+		"slots":   {Algorithm: domain.AlgorithmConcurrency, Burst: 3, TTL: time.Minute},
+	}
+	for key, limit := range limits {
+		t.Run(key, func(t *testing.T) {
+			result, err := store.Peek(ctx, key, limit, now)
+			require.NoError(t, err)
+			assert.Equal(t, 3, result.Remaining, "an unknown bucket is full")
+			assert.Zero(t, result.Reset)
+
+			taken, err := store.Take(ctx, key, limit, now)
+			require.NoError(t, err)
+
+			for i := 0; i < 2; i++ {
+				result, err = store.Peek(ctx, key, limit, now)
+				require.NoError(t, err)
+				assert.Equal(t, 3, result.Limit)
+				assert.Equal(t, 2, result.Remaining, "peeking spends nothing")
+				assert.Equal(t, taken.Reset, result.Reset)
+			}
+
+			require.NoError(t, store.Reset(ctx, key, limit, now))
+			result, err = store.Peek(ctx, key, limit, now)
+			require.NoError(t, err)
+			assert.Equal(t, 3, result.Remaining)
+		})
+	}
+}
+
+func TestRateLimitStore_Exemption(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)
+	client, server := newTestClient(t)
+	server.SetTime(now)
+	store := NewRateLimitStore(client)
+
+	until, err := store.Exemption(ctx, "ip:192.0.2.1", now)
+	require.NoError(t, err)
+	assert.True(t, until.IsZero())
+
+	// Two replicas share exemptions
+	require.NoError(t, store.Exempt(ctx, "ip:192.0.2.1", now.Add(time.Hour)))
+	until, err = NewRateLimitStore(client).Exemption(ctx, "ip:192.0.2.1", now)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(time.Hour), until)
+	assert.Equal(t, time.Hour, server.TTL(exemptPrefix+"ip:192.0.2.1"), "the key expires with the exemption")
+
+	require.NoError(t, store.Exempt(ctx, "ip:192.0.2.1", time.Time{}))
+	until, err = store.Exemption(ctx, "ip:192.0.2.1", now)
+	require.NoError(t, err)
+	assert.True(t, until.IsZero(), "a zero time removes the exemption")
+}
@@ -0,0 +1,234 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+var ErrInvalidAPIKey = errors.New("invalid API key data")
+
+const (
+	// apiKeyPrefix starts every secret so that leaked keys are easy to
+	// recognize
+	apiKeyPrefix = "mt_"
+	// apiKeyDisplayLength is how much of the secret is kept as its prefix
+	apiKeyDisplayLength = len(apiKeyPrefix) + 8
+)
+
+// IssuedAPIKey is a newly issued or rotated API key together with its
+// secret, which is not stored and cannot be retrieved later
+type IssuedAPIKey struct {
+	domain.APIKey
+	Secret string `json:"secret"`
+}
+
+// APIKeyService issues API keys and authenticates requests made with them.
+// Secrets are random, so a SHA-256 hash is enough to store them safely and
+// lets keys be looked up by hash.
+type APIKeyService struct {
+	repo  domain.APIKeyRepository
+	plans map[string]bool
+	now   func() time.Time
+}
+
+// APIKeyOption configures optional APIKeyService behaviour
+type APIKeyOption func(*APIKeyService)
+
+// WithPlans sets the rate limit plans keys can be issued with. Without it
+// keys cannot have a plan.
+func WithPlans(plans ...string) APIKeyOption {
+	return func(s *APIKeyService) {
+		for _, plan := range plans {
+			s.plans[plan] = true
+		}
+	}
+}
+
+func NewAPIKeyService(repo domain.APIKeyRepository, opts ...APIKeyOption) *APIKeyService {
+	s := &APIKeyService{
+		repo:  repo,
+		plans: make(map[string]bool),
+		now:   time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// IssueAPIKey creates a key with the given role. A key with a tenant can only
+// reach that tenant's data; a key without one is a platform key that picks
+// the tenant per request. The plan selects the key's rate limits and quotas
+// and may be empty for the default plan.
+func (s *APIKeyService) IssueAPIKey(ctx context.Context, name string, role domain.Role, tenant, plan string) (*IssuedAPIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("%w: name is required", ErrInvalidAPIKey)
+	}
+	if !role.Valid() {
+		return nil, fmt.Errorf("%w: unknown role %q", ErrInvalidAPIKey, role)
+	}
+	tenant = strings.TrimSpace(tenant)
+	if tenant != "" && !domain.ValidTenant(tenant) {
+		return nil, fmt.Errorf("%w: invalid tenant %q", ErrInvalidAPIKey, tenant)
+	}
+	plan = strings.TrimSpace(plan)
+	if plan != "" && !s.plans[plan] {
+		return nil, fmt.Errorf("%w: unknown plan %q", ErrInvalidAPIKey, plan)
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	secret, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	key := domain.APIKey{
+		ID:        id,
+		Name:      name,
+		Role:      role,
+		Tenant:    tenant,
+		Plan:      plan,
+		Prefix:    secret[:apiKeyDisplayLength],
+		Hash:      hashSecret(secret),
+		CreatedAt: s.now().UTC(),
+	}
+	if err := s.repo.Create(ctx, &key); err != nil {
+		return nil, err
+	}
+	return &IssuedAPIKey{APIKey: key, Secret: secret}, nil
+}
+
+func (s *APIKeyService) ListAPIKeys(ctx context.Context) ([]domain.APIKey, error) {
+	return s.repo.FindAll(ctx)
+}
+
+// RotateAPIKey replaces the secret of a key. The previous secret stops
+// working immediately. Revoked keys, including keys revoked while rotating,
+// cannot be rotated.
+func (s *APIKeyService) RotateAPIKey(ctx context.Context, id string) (*IssuedAPIKey, error) {
+	key, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if key.Revoked() {
+		return nil, domain.ErrAPIKeyRevoked
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+	now := s.now().UTC()
+	key.Prefix = secret[:apiKeyDisplayLength]
+	key.Hash = hashSecret(secret)
+	key.RotatedAt = &now
+	if err := s.repo.Rotate(ctx, key); err != nil {
+		return nil, err
+	}
+	return &IssuedAPIKey{APIKey: *key, Secret: secret}, nil
+}
+
+// RevokeAPIKey permanently disables a key. Revoking a revoked key keeps its
+// original revocation time.
+func (s *APIKeyService) RevokeAPIKey(ctx context.Context, id string) (*domain.APIKey, error) {
+	key, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if key.Revoked() {
+		return key, nil
+	}
+
+	if err := s.repo.Revoke(ctx, id, s.now().UTC()); err != nil {
+		return nil, err
+	}
+	// Reload the key, whose revocation time is that of a concurrent revoke
+	// if one came first
+	return s.repo.FindByID(ctx, id)
+}
+
+// Authenticate returns the principal of the key with the given secret. It
+// fails with domain.ErrUnauthorized for unknown and revoked keys.
+func (s *APIKeyService) Authenticate(ctx context.Context, secret string) (*domain.Principal, error) {
+	if !strings.HasPrefix(secret, apiKeyPrefix) {
+		return nil, domain.ErrUnauthorized
+	}
+
+	key, err := s.repo.FindByHash(ctx, hashSecret(secret))
+	if errors.Is(err, domain.ErrAPIKeyNotFound) {
+		return nil, domain.ErrUnauthorized
+	}
+	if err != nil {
+		return nil, err
+	}
+	if key.Revoked() {
+		return nil, domain.ErrUnauthorized
+	}
+	return &domain.Principal{ID: key.ID, Name: key.Name, Role: key.Role, Tenant: key.Tenant, Plan: key.Plan}, nil
+}
+
+// BootstrapAPIKey makes sure an admin key with the given secret exists, so
+// that the first keys can be issued through the API. It reports whether the
+// key was created. A revoked bootstrap key stays revoked.
+func (s *APIKeyService) BootstrapAPIKey(ctx context.Context, secret string) (bool, error) {
+	if !strings.HasPrefix(secret, apiKeyPrefix) || len(secret) < apiKeyDisplayLength+16 {
+		return false, fmt.Errorf("%w: bootstrap key must start with %s and be at least %d characters",
+			ErrInvalidAPIKey, apiKeyPrefix, apiKeyDisplayLength+16)
+	}
+
+	_, err := s.repo.FindByHash(ctx, hashSecret(secret))
+	if err == nil {
+		return false, nil
+	}
+	if !errors.Is(err, domain.ErrAPIKeyNotFound) {
+		return false, err
+	}
+
+	id, err := randomID()
+	if err != nil {
+		return false, err
+	}
+	err = s.repo.Create(ctx, &domain.APIKey{
+		ID:        id,
+		Name:      "bootstrap",
+		Role:      domain.RoleAdmin,
+		Prefix:    secret[:apiKeyDisplayLength],
+		Hash:      hashSecret(secret),
+		CreatedAt: s.now().UTC(),
+	})
+	return err == nil, err
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func randomID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
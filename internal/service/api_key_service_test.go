@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockAPIKeyRepository is a mock implementation of domain.APIKeyRepository
+type MockAPIKeyRepository struct {
+	mock.Mock
+}
+
+func (m *MockAPIKeyRepository) Create(ctx context.Context, key *domain.APIKey) error {
+	args := m.Called(ctx, key)
+	return args.Error(0)
+}
+
+func (m *MockAPIKeyRepository) FindByID(ctx context.Context, id string) (*domain.APIKey, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyRepository) FindByHash(ctx context.Context, hash string) (*domain.APIKey, error) {
+	args := m.Called(ctx, hash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyRepository) FindAll(ctx context.Context) ([]domain.APIKey, error) {
+	args := m.Called(ctx)
+	return args.Get(0).([]domain.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyRepository) Rotate(ctx context.Context, key *domain.APIKey) error {
+	args := m.Called(ctx, key)
+	return args.Error(0)
+}
+
+func (m *MockAPIKeyRepository) Revoke(ctx context.Context, id string, revokedAt time.Time) error {
+	args := m.Called(ctx, id, revokedAt)
+	return args.Error(0)
+}
+
+func newTestAPIKeyService(repo domain.APIKeyRepository) *APIKeyService {
+	s := NewAPIKeyService(repo, WithPlans("free", "pro"))
+	s.now = func() time.Time { return time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC) }
+	return s
+}
+
+func TestAPIKeyService_IssueAPIKey(t *testing.T) {
+	t.Run("stores only the hash", func(t *testing.T) {
+		repo := new(MockAPIKeyRepository)
+		s := newTestAPIKeyService(repo)
+		repo.On("Create", mock.Anything, mock.AnythingOfType("*domain.APIKey")).Return(nil)
+
+		issued, err := s.IssueAPIKey(context.Background(), " ci ", domain.RoleOperator, "", "")
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(issued.Secret, apiKeyPrefix))
+		assert.Equal(t, "ci", issued.Name)
+		assert.Equal(t, issued.Secret[:apiKeyDisplayLength], issued.Prefix)
+
+		stored := repo.Calls[0].Arguments.Get(1).(*domain.APIKey)
+		assert.Equal(t, hashSecret(issued.Secret), stored.Hash)
+		assert.NotContains(t, stored.Hash, issued.Secret)
+		assert.Equal(t, domain.RoleOperator, stored.Role)
+		assert.Empty(t, stored.Tenant)
+		assert.Empty(t, stored.Plan)
+	})
+
+	t.Run("binds the key to a tenant", func(t *testing.T) {
+		repo := new(MockAPIKeyRepository)
+		s := newTestAPIKeyService(repo)
+		repo.On("Create", mock.Anything, mock.AnythingOfType("*domain.APIKey")).Return(nil)
+
+		issued, err := s.IssueAPIKey(context.Background(), "ci", domain.RoleViewer, " acme ", "")
+		require.NoError(t, err)
+		assert.Equal(t, "acme", issued.Tenant)
+	})
+
+	t.Run("assigns a plan", func(t *testing.T) {
+		repo := new(MockAPIKeyRepository)
+		s := newTestAPIKeyService(repo)
+		repo.On("Create", mock.Anything, mock.AnythingOfType("*domain.APIKey")).Return(nil)
+
+		issued, err := s.IssueAPIKey(context.Background(), "ci", domain.RoleViewer, "", " pro ")
+		require.NoError(t, err)
+		assert.Equal(t, "pro", issued.Plan)
+	})
+
+	t.Run("invalid data", func(t *testing.T) {
+		s := newTestAPIKeyService(new(MockAPIKeyRepository))
+		_, err := s.IssueAPIKey(context.Background(), "ci", "root", "", "")
+		assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+		_, err = s.IssueAPIKey(context.Background(), " ", domain.RoleViewer, "", "")
+		assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+		_, err = s.IssueAPIKey(context.Background(), "ci", domain.RoleViewer, "acme corp", "")
+		assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+		_, err = s.IssueAPIKey(context.Background(), "ci", domain.RoleViewer, "", "enterprise")
+		assert.ErrorIs(t, err, ErrInvalidAPIKey, "unknown plan")
+	})
+}
+
+func TestAPIKeyService_RotateAPIKey(t *testing.T) {
+	t.Run("replaces the secret", func(t *testing.T) {
+		repo := new(MockAPIKeyRepository)
+		s := newTestAPIKeyService(repo)
+		repo.On("FindByID", mock.Anything, "k1").Return(&domain.APIKey{ID: "k1", Role: domain.RoleAdmin, Hash: "old"}, nil)
+		repo.On("Rotate", mock.Anything, mock.MatchedBy(func(key *domain.APIKey) bool {
+			return key.Hash != "old" && key.RotatedAt != nil
+		})).Return(nil)
+
+		issued, err := s.RotateAPIKey(context.Background(), "k1")
+		require.NoError(t, err)
+		assert.Equal(t, hashSecret(issued.Secret), issued.Hash)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("revoked keys cannot be rotated", func(t *testing.T) {
+		repo := new(MockAPIKeyRepository)
+		s := newTestAPIKeyService(repo)
+		revoked := time.Now()
+		repo.On("FindByID", mock.Anything, "k1").Return(&domain.APIKey{ID: "k1", RevokedAt: &revoked}, nil)
+
+		_, err := s.RotateAPIKey(context.Background(), "k1")
+		assert.ErrorIs(t, err, domain.ErrAPIKeyRevoked)
+		repo.AssertNotCalled(t, "Rotate", mock.Anything, mock.Anything)
+	})
+
+	t.Run("keys revoked while rotating are not rotated", func(t *testing.T) {
+		repo := new(MockAPIKeyRepository)
+		s := newTestAPIKeyService(repo)
+		repo.On("FindByID", mock.Anything, "k1").Return(&domain.APIKey{ID: "k1", Hash: "old"}, nil)
+		repo.On("Rotate", mock.Anything, mock.Anything).Return(domain.ErrAPIKeyRevoked)
+
+		_, err := s.RotateAPIKey(context.Background(), "k1")
+		assert.ErrorIs(t, err, domain.ErrAPIKeyRevoked)
+	})
+}
+
+func TestAPIKeyService_RevokeAPIKey(t *testing.T) {
+	repo := new(MockAPIKeyRepository)
+	s := newTestAPIKeyService(repo)
+	revoked := time.Now()
+	repo.On("FindByID", mock.Anything, "k1").Return(&domain.APIKey{ID: "k1"}, nil).Once()
+	repo.On("FindByID", mock.Anything, "k1").Return(&domain.APIKey{ID: "k1", RevokedAt: &revoked}, nil).Once()
+	repo.On("FindByID", mock.Anything, "missing").Return(nil, domain.ErrAPIKeyNotFound)
+	repo.On("Revoke", mock.Anything, "k1", mock.AnythingOfType("time.Time")).Return(nil)
+
+	key, err := s.RevokeAPIKey(context.Background(), "k1")
+	require.NoError(t, err)
+	assert.Equal(t, &revoked, key.RevokedAt, "the stored revocation is returned")
+
+	_, err = s.RevokeAPIKey(context.Background(), "missing")
+	assert.ErrorIs(t, err, domain.ErrAPIKeyNotFound)
+	repo.AssertExpectations(t)
+}
+
+func TestAPIKeyService_Authenticate(t *testing.T) {
+	repo := new(MockAPIKeyRepository)
+	s := newTestAPIKeyService(repo)
+	revoked := time.Now()
+	repo.On("FindByHash", mock.Anything, hashSecret("mt_valid")).Return(&domain.APIKey{ID: "k1", Name: "ci", Role: domain.RoleViewer, Tenant: "acme", Plan: "pro"}, nil)
+	repo.On("FindByHash", mock.Anything, hashSecret("mt_revoked")).Return(&domain.APIKey{ID: "k2", RevokedAt: &revoked}, nil)
+	repo.On("FindByHash", mock.Anything, hashSecret("mt_unknown")).Return(nil, domain.ErrAPIKeyNotFound)
+	repo.On("FindByHash", mock.Anything, hashSecret("mt_broken")).Return(nil, errors.New("connection refused"))
+
+	principal, err := s.Authenticate(context.Background(), "mt_valid")
+	require.NoError(t, err)
+	assert.Equal(t, &domain.Principal{ID: "k1", Name: "ci", Role: domain.RoleViewer, Tenant: "acme", Plan: "pro"}, principal)
+
+	for _, secret := range []string{"mt_revoked", "mt_unknown", "valid"} {
+		_, err := s.Authenticate(context.Background(), secret)
+		assert.ErrorIs(t, err, domain.ErrUnauthorized, secret)
+	}
+
+	_, err = s.Authenticate(context.Background(), "mt_broken")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, domain.ErrUnauthorized, "storage errors are not reported as bad credentials")
+}
+
+func TestAPIKeyService_BootstrapAPIKey(t *testing.T) {
+	secret := apiKeyPrefix + strings.Repeat("a", 32)
+
+	repo := new(MockAPIKeyRepository)
+	s := newTestAPIKeyService(repo)
+	repo.On("FindByHash", mock.Anything, hashSecret(secret)).Return(nil, domain.ErrAPIKeyNotFound).Once()
+	repo.On("Create", mock.Anything, mock.MatchedBy(func(key *domain.APIKey) bool {
+		return key.Role == domain.RoleAdmin && key.Hash == hashSecret(secret)
+	})).Return(nil).Once()
+
+	created, err := s.BootstrapAPIKey(context.Background(), secret)
+	require.NoError(t, err)
+	assert.True(t, created)
+
+	repo.On("FindByHash", mock.Anything, hashSecret(secret)).Return(&domain.APIKey{ID: "k1"}, nil)
+	created, err = s.BootstrapAPIKey(context.Background(), secret)
+	require.NoError(t, err)
+	assert.False(t, created, "existing keys are kept")
+
+	_, err = s.BootstrapAPIKey(context.Background(), "short")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+	repo.AssertExpectations(t)
+}
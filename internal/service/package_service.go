@@ -1,8 +1,11 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/snavarro/microtracker/internal/domain"
 )
@@ -10,70 +13,272 @@ import (
 var (
 	ErrInvalidPackage = errors.New("invalid package data")
 	ErrEmptyPackageID = errors.New("package ID cannot be empty")
+	ErrInvalidEvent   = errors.New("invalid event data")
 )
 
 type PackageService struct {
-	repo domain.PackageRepository
+	repo     domain.PackageRepository
+	events   domain.EventRepository
+	statuses *StatusMachine
 }
 
-func NewPackageService(repo domain.PackageRepository) *PackageService {
-	return &PackageService{
-		repo: repo,
+// Option configures optional PackageService behaviour
+type Option func(*PackageService)
+
+// WithStatusMachine replaces the default shipment status machine
+func WithStatusMachine(statuses *StatusMachine) Option {
+	return func(s *PackageService) {
+		s.statuses = statuses
+	}
+}
+
+func NewPackageService(repo domain.PackageRepository, events domain.EventRepository, opts ...Option) *PackageService {
+	s := &PackageService{
+		repo:     repo,
+		events:   events,
+		statuses: DefaultStatusMachine(),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
-func (s *PackageService) GetPackage(id string) (*domain.Package, error) {
+func (s *PackageService) GetPackage(ctx context.Context, id string) (*domain.Package, error) {
 	if strings.TrimSpace(id) == "" {
 		return nil, ErrEmptyPackageID
 	}
-	return s.repo.FindByID(id)
+
+	pkg, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := s.events.FindByPackageID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	pkg.Events = events
+
+	return pkg, nil
 }
 
-func (s *PackageService) ListPackages(page, size int) ([]domain.Package, int64, error) {
-	if page < 1 {
-		page = 1
+// ListPackages returns a page of the packages matching filter. Statuses are
+// normalized to their canonical form before filtering.
+func (s *PackageService) ListPackages(ctx context.Context, filter domain.PackageFilter, req domain.PageRequest) (*domain.PackagePage, error) {
+	if err := s.normalizeFilter(&filter); err != nil {
+		return nil, err
 	}
-	if size < 1 {
-		size = 10
+
+	opts, err := listOptions(req)
+	if err != nil {
+		return nil, err
 	}
-	if size > 100 {
-		size = 100
+
+	packages, total, err := s.repo.FindAll(ctx, filter, opts)
+	if err != nil {
+		return nil, err
 	}
-	return s.repo.FindAll(page, size)
+	return newPackagePage(packages, total, opts), nil
 }
 
-func (s *PackageService) SearchPackages(query string, page, size int) ([]domain.Package, int64, error) {
-	if page < 1 {
-		page = 1
+func (s *PackageService) normalizeFilter(filter *domain.PackageFilter) error {
+	for i, raw := range filter.Statuses {
+		status, err := s.statuses.Parse(raw)
+		if err != nil {
+			return err
+		}
+		filter.Statuses[i] = string(status)
 	}
-	if size < 1 {
-		size = 10
+
+	if err := validateRange("created", filter.Created); err != nil {
+		return err
 	}
-	if size > 100 {
-		size = 100
+	return validateRange("updated", filter.Updated)
+}
+
+func validateRange(name string, r domain.TimeRange) error {
+	if !r.From.IsZero() && !r.To.IsZero() && !r.From.Before(r.To) {
+		return fmt.Errorf("%w: %sFrom must be before %sTo", domain.ErrInvalidFilter, name, name)
 	}
-	return s.repo.Search(query, page, size)
+	return nil
 }
 
-func (s *PackageService) CreatePackage(pkg *domain.Package) error {
-	if err := validatePackage(pkg); err != nil {
+// SearchPackages searches packages in the query's mode, defaulting to a
+// literal substring search. Invalid queries return domain.ErrInvalidSearch.
+func (s *PackageService) SearchPackages(ctx context.Context, query domain.SearchQuery, req domain.PageRequest) (*domain.PackagePage, error) {
+	if query.Mode == "" {
+		query.Mode = domain.SearchLiteral
+	}
+	if err := validateSearch(query); err != nil {
+		return nil, err
+	}
+
+	opts, err := listOptions(req)
+	if err != nil {
+		return nil, err
+	}
+
+	packages, total, err := s.repo.Search(ctx, query, opts)
+	if err != nil {
+		return nil, err
+	}
+	return newPackagePage(packages, total, opts), nil
+}
+
+// CreatePackage stores a new package without events. Events are rejected,
+// since they are only added through AddEvent, which checks each transition
+// and derives the current status from it.
+func (s *PackageService) CreatePackage(ctx context.Context, pkg *domain.Package) error {
+	if err := s.validatePackage(pkg); err != nil {
 		return err
 	}
-	return s.repo.Create(pkg)
+	if len(pkg.Events) > 0 {
+		return fmt.Errorf("%w: events can only be added to an existing package", ErrInvalidPackage)
+	}
+
+	pkg.EventCount = 0
+	pkg.LatestEvent = nil
+	return s.repo.Create(ctx, pkg)
 }
 
-func (s *PackageService) UpdatePackage(pkg *domain.Package) error {
-	if err := validatePackage(pkg); err != nil {
+// UpdatePackage replaces a package's details. The creation time and the
+// event summary, which is managed by AddEvent, are carried over from the
+// stored package, and the status must match the stored status, since it only
+// changes through AddEvent. A non-zero pkg.Version must match the stored
+// version or ErrVersionConflict is returned. The write is always conditional
+// on the version that was read, so concurrent updates never overwrite each
+// other silently.
+func (s *PackageService) UpdatePackage(ctx context.Context, pkg *domain.Package) error {
+	if err := s.validatePackage(pkg); err != nil {
 		return err
 	}
-	return s.repo.Update(pkg)
+
+	existing, err := s.repo.FindByID(ctx, pkg.PackageID)
+	if err != nil {
+		return err
+	}
+	if err := checkVersion(existing, pkg.Version); err != nil {
+		return err
+	}
+	if pkg.CurrentStatus != existing.CurrentStatus {
+		return fmt.Errorf("%w: current status can only change by adding an event", ErrInvalidPackage)
+	}
+	pkg.CreatedAt = existing.CreatedAt
+	pkg.LatestEvent = existing.LatestEvent
+	pkg.EventCount = existing.EventCount
+	pkg.Version = existing.Version
+
+	return s.repo.Update(ctx, pkg)
+}
+
+// checkVersion reports ErrVersionConflict when an expected version is given
+// and differs from the stored one
+func checkVersion(pkg *domain.Package, expected int64) error {
+	if expected != 0 && expected != pkg.Version {
+		return fmt.Errorf("%w: expected version %d, found %d", domain.ErrVersionConflict, expected, pkg.Version)
+	}
+	return nil
+}
+
+// maxEventAttempts bounds how often AddEvent retries after losing a race
+// with a concurrent write to the same package
+const maxEventAttempts = 3
+
+// AddEvent appends a tracking event to a package and derives the package's
+// current status from it. Transitions not allowed by the status machine are
+// rejected with ErrInvalidTransition.
+func (s *PackageService) AddEvent(ctx context.Context, id string, event *domain.Event) (*domain.Package, error) {
+	if strings.TrimSpace(id) == "" {
+		return nil, ErrEmptyPackageID
+	}
+
+	if event == nil {
+		return nil, ErrInvalidEvent
+	}
+
+	if strings.TrimSpace(event.Location) == "" {
+		return nil, fmt.Errorf("%w: location is required", ErrInvalidEvent)
+	}
+
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	appended := false
+	for attempt := 1; ; attempt++ {
+		pkg, err := s.addEvent(ctx, id, *event, &appended)
+		if errors.Is(err, domain.ErrVersionConflict) && attempt < maxEventAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		*event = *pkg.LatestEvent
+		return pkg, nil
+	}
+}
+
+// addEvent stores the event, then updates the package summary conditionally
+// on the version it read, so a failed append leaves the package unchanged.
+// The transition is checked against the status read, so a conflict means it
+// has to be checked again. appended records that an attempt stored the
+// event, which a retry must not store twice.
+func (s *PackageService) addEvent(ctx context.Context, id string, event domain.Event, appended *bool) (*domain.Package, error) {
+	pkg, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := s.statuses.Transition(pkg.CurrentStatus, event.Status)
+	if err != nil {
+		return nil, err
+	}
+	event.Status = string(status)
+
+	if !*appended {
+		if err := s.events.Append(ctx, id, event); err != nil {
+			return nil, err
+		}
+		*appended = true
+	}
+
+	pkg.LatestEvent = &event
+	pkg.EventCount++
+	pkg.CurrentStatus = string(status)
+
+	if err := s.repo.Update(ctx, pkg); err != nil {
+		return nil, err
+	}
+	return pkg, nil
 }
 
-func (s *PackageService) DeletePackage(id string) error {
+func (s *PackageService) DeletePackage(ctx context.Context, id string) error {
 	if strings.TrimSpace(id) == "" {
 		return ErrEmptyPackageID
 	}
-	return s.repo.Delete(id)
+	// Events go first, so a failure leaves a package that can be deleted
+	// again rather than events no package leads to
+	if err := s.events.DeleteByPackageID(ctx, id); err != nil {
+		return err
+	}
+	return s.repo.Delete(ctx, id)
+}
+
+// validatePackage checks the required fields and normalizes the current
+// status to its canonical form
+func (s *PackageService) validatePackage(pkg *domain.Package) error {
+	if err := validatePackage(pkg); err != nil {
+		return err
+	}
+
+	status, err := s.statuses.Parse(pkg.CurrentStatus)
+	if err != nil {
+		return err
+	}
+	pkg.CurrentStatus = string(status)
+
+	return nil
 }
 
 func validatePackage(pkg *domain.Package) error {
@@ -86,23 +291,23 @@ func validatePackage(pkg *domain.Package) error {
 	}
 
 	if strings.TrimSpace(pkg.Sender.Name) == "" || strings.TrimSpace(pkg.Sender.Address) == "" {
-		return errors.New("sender name and address are required")
+		return fmt.Errorf("%w: sender name and address are required", ErrInvalidPackage)
 	}
 
 	if strings.TrimSpace(pkg.Recipient.Name) == "" || strings.TrimSpace(pkg.Recipient.Address) == "" {
-		return errors.New("recipient name and address are required")
+		return fmt.Errorf("%w: recipient name and address are required", ErrInvalidPackage)
 	}
 
 	if strings.TrimSpace(pkg.Origin) == "" {
-		return errors.New("origin is required")
+		return fmt.Errorf("%w: origin is required", ErrInvalidPackage)
 	}
 
 	if strings.TrimSpace(pkg.Destination) == "" {
-		return errors.New("destination is required")
+		return fmt.Errorf("%w: destination is required", ErrInvalidPackage)
 	}
 
 	if strings.TrimSpace(pkg.CurrentStatus) == "" {
-		return errors.New("current status is required")
+		return fmt.Errorf("%w: current status is required", ErrInvalidPackage)
 	}
 
 	return nil
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"testing"
 	"time"
@@ -15,42 +16,71 @@ type MockPackageRepository struct {
 	mock.Mock
 }
 
-func (m *MockPackageRepository) FindByID(id string) (*domain.Package, error) {
-	args := m.Called(id)
+func (m *MockPackageRepository) FindByID(ctx context.Context, id string) (*domain.Package, error) {
+	args := m.Called(ctx, id)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
 	return args.Get(0).(*domain.Package), args.Error(1)
 }
 
-func (m *MockPackageRepository) FindAll(page, size int) ([]domain.Package, int64, error) {
-	args := m.Called(page, size)
+func (m *MockPackageRepository) FindAll(ctx context.Context, filter domain.PackageFilter, opts domain.ListOptions) ([]domain.Package, int64, error) {
+	args := m.Called(ctx, filter, opts)
 	return args.Get(0).([]domain.Package), args.Get(1).(int64), args.Error(2)
 }
 
-func (m *MockPackageRepository) Search(query string, page, size int) ([]domain.Package, int64, error) {
-	args := m.Called(query, page, size)
+func (m *MockPackageRepository) Search(ctx context.Context, query domain.SearchQuery, opts domain.ListOptions) ([]domain.Package, int64, error) {
+	args := m.Called(ctx, query, opts)
 	return args.Get(0).([]domain.Package), args.Get(1).(int64), args.Error(2)
 }
 
-func (m *MockPackageRepository) Create(pkg *domain.Package) error {
-	args := m.Called(pkg)
+func (m *MockPackageRepository) Create(ctx context.Context, pkg *domain.Package) error {
+	args := m.Called(ctx, pkg)
 	return args.Error(0)
 }
 
-func (m *MockPackageRepository) Update(pkg *domain.Package) error {
-	args := m.Called(pkg)
+func (m *MockPackageRepository) Update(ctx context.Context, pkg *domain.Package) error {
+	args := m.Called(ctx, pkg)
 	return args.Error(0)
 }
 
-func (m *MockPackageRepository) Delete(id string) error {
-	args := m.Called(id)
+func (m *MockPackageRepository) Patch(ctx context.Context, id string, update domain.PackageUpdate) (*domain.Package, error) {
+	args := m.Called(ctx, id, update)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Package), args.Error(1)
+}
+
+func (m *MockPackageRepository) Delete(ctx context.Context, id string) error {
+	args := m.Called(ctx, id)
+	return args.Error(0)
+}
+
+// MockEventRepository is a mock implementation of domain.EventRepository
+type MockEventRepository struct {
+	mock.Mock
+}
+
+func (m *MockEventRepository) Append(ctx context.Context, packageID string, events ...domain.Event) error {
+	args := m.Called(ctx, packageID, events)
+	return args.Error(0)
+}
+
+func (m *MockEventRepository) FindByPackageID(ctx context.Context, packageID string) ([]domain.Event, error) {
+	args := m.Called(ctx, packageID)
+	return args.Get(0).([]domain.Event), args.Error(1)
+}
+
+func (m *MockEventRepository) DeleteByPackageID(ctx context.Context, packageID string) error {
+	args := m.Called(ctx, packageID)
 	return args.Error(0)
 }
 
 func TestPackageService_GetPackage(t *testing.T) {
 	mockRepo := new(MockPackageRepository)
-	service := NewPackageService(mockRepo)
+	mockEvents := new(MockEventRepository)
+	service := NewPackageService(mockRepo, mockEvents)
 
 	t.Run("successful get", func(t *testing.T) {
 		expectedPkg := &domain.Package{
@@ -63,19 +93,24 @@ func TestPackageService_GetPackage(t *testing.T) {
 			UpdatedAt: time.Now(),
 		}
 
-		mockRepo.On("FindByID", "123").Return(expectedPkg, nil)
+		events := []domain.Event{{Location: "Memphis Hub", Status: "IN_TRANSIT"}}
+
+		mockRepo.On("FindByID", mock.Anything, "123").Return(expectedPkg, nil)
+		mockEvents.On("FindByPackageID", mock.Anything, "123").Return(events, nil)
 
-		pkg, err := service.GetPackage("123")
+		pkg, err := service.GetPackage(context.Background(), "123")
 
 		assert.NoError(t, err)
 		assert.Equal(t, expectedPkg, pkg)
+		assert.Equal(t, events, pkg.Events)
 		mockRepo.AssertExpectations(t)
+		mockEvents.AssertExpectations(t)
 	})
 
 	t.Run("not found", func(t *testing.T) {
-		mockRepo.On("FindByID", "456").Return(nil, errors.New("package not found"))
+		mockRepo.On("FindByID", mock.Anything, "456").Return(nil, errors.New("package not found"))
 
-		pkg, err := service.GetPackage("456")
+		pkg, err := service.GetPackage(context.Background(), "456")
 
 		assert.Error(t, err)
 		assert.Nil(t, pkg)
@@ -83,9 +118,14 @@ func TestPackageService_GetPackage(t *testing.T) {
 	})
 }
 
+// defaultListOptions are the repository options for the first page with
+// the default size and sort
+var defaultListOptions = domain.ListOptions{Page: 1, Size: 10, Sort: domain.DefaultSort}
+
 func TestPackageService_ListPackages(t *testing.T) {
 	mockRepo := new(MockPackageRepository)
-	service := NewPackageService(mockRepo)
+	mockEvents := new(MockEventRepository)
+	service := NewPackageService(mockRepo, mockEvents)
 
 	t.Run("successful list", func(t *testing.T) {
 		expectedPackages := []domain.Package{
@@ -105,31 +145,113 @@ func TestPackageService_ListPackages(t *testing.T) {
 			},
 		}
 
-		mockRepo.On("FindAll", 1, 10).Return(expectedPackages, int64(2), nil)
+		mockRepo.On("FindAll", mock.Anything, domain.PackageFilter{}, defaultListOptions).Return(expectedPackages, int64(2), nil).Once()
 
-		packages, total, err := service.ListPackages(1, 10)
+		page, err := service.ListPackages(context.Background(), domain.PackageFilter{}, domain.PageRequest{Page: 1, Size: 10})
 
 		assert.NoError(t, err)
-		assert.Equal(t, expectedPackages, packages)
-		assert.Equal(t, int64(2), total)
+		assert.Equal(t, expectedPackages, page.Packages)
+		assert.Equal(t, int64(2), page.Total)
+		assert.Empty(t, page.NextCursor, "a partial page has no next cursor")
 		mockRepo.AssertExpectations(t)
 	})
 
 	t.Run("error case", func(t *testing.T) {
-		mockRepo.On("FindAll", 1, 10).Return([]domain.Package{}, int64(0), errors.New("database error"))
+		mockRepo.On("FindAll", mock.Anything, domain.PackageFilter{}, defaultListOptions).Return([]domain.Package{}, int64(0), errors.New("database error"))
 
-		packages, total, err := service.ListPackages(1, 10)
+		page, err := service.ListPackages(context.Background(), domain.PackageFilter{}, domain.PageRequest{Page: 1, Size: 10})
 
 		assert.Error(t, err)
-		assert.Empty(t, packages)
-		assert.Equal(t, int64(0), total)
+		assert.Nil(t, page)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("normalizes statuses", func(t *testing.T) {
+		expected := domain.PackageFilter{Statuses: []string{"IN_TRANSIT", "DELIVERED"}}
+		mockRepo.On("FindAll", mock.Anything, expected, defaultListOptions).Return([]domain.Package{}, int64(0), nil).Once()
+
+		_, err := service.ListPackages(context.Background(), domain.PackageFilter{Statuses: []string{"in transit", "delivered"}}, domain.PageRequest{Page: 1, Size: 10})
+
+		assert.NoError(t, err)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("invalid status", func(t *testing.T) {
+		_, err := service.ListPackages(context.Background(), domain.PackageFilter{Statuses: []string{"LOST"}}, domain.PageRequest{Page: 1, Size: 10})
+
+		assert.ErrorIs(t, err, ErrInvalidStatus)
+	})
+
+	t.Run("full page returns a cursor for the next page", func(t *testing.T) {
+		created := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+		first := []domain.Package{
+			{PackageID: "PKG2", CreatedAt: created.Add(time.Hour)},
+			{PackageID: "PKG1", CreatedAt: created},
+		}
+		opts := domain.ListOptions{Page: 1, Size: 2, Sort: domain.DefaultSort}
+		mockRepo.On("FindAll", mock.Anything, domain.PackageFilter{}, opts).Return(first, int64(3), nil).Once()
+
+		page, err := service.ListPackages(context.Background(), domain.PackageFilter{}, domain.PageRequest{Size: 2})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, page.NextCursor)
+
+		opts.After = []interface{}{created, "PKG1"}
+		mockRepo.On("FindAll", mock.Anything, domain.PackageFilter{}, opts).Return([]domain.Package{{PackageID: "PKG0"}}, int64(3), nil).Once()
+
+		page, err = service.ListPackages(context.Background(), domain.PackageFilter{}, domain.PageRequest{Size: 2, Cursor: page.NextCursor})
+		assert.NoError(t, err)
+		assert.Equal(t, "PKG0", page.Packages[0].PackageID)
+		assert.Empty(t, page.NextCursor)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("invalid sort", func(t *testing.T) {
+		_, err := service.ListPackages(context.Background(), domain.PackageFilter{}, domain.PageRequest{Sort: "sender"})
+
+		assert.ErrorIs(t, err, domain.ErrInvalidSort)
+	})
+
+	t.Run("empty date range", func(t *testing.T) {
+		day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+		filter := domain.PackageFilter{Created: domain.TimeRange{From: day, To: day}}
+
+		_, err := service.ListPackages(context.Background(), filter, domain.PageRequest{Page: 1, Size: 10})
+
+		assert.ErrorIs(t, err, domain.ErrInvalidFilter)
+	})
+}
+
+func TestPackageService_SearchPackages(t *testing.T) {
+	mockRepo := new(MockPackageRepository)
+	mockEvents := new(MockEventRepository)
+	service := NewPackageService(mockRepo, mockEvents)
+
+	t.Run("defaults to literal mode", func(t *testing.T) {
+		expectedPackages := []domain.Package{{PackageID: "123"}}
+		query := domain.SearchQuery{Text: "a+b", Mode: domain.SearchLiteral}
+
+		mockRepo.On("Search", mock.Anything, query, defaultListOptions).Return(expectedPackages, int64(1), nil).Once()
+
+		page, err := service.SearchPackages(context.Background(), domain.SearchQuery{Text: "a+b"}, domain.PageRequest{})
+
+		assert.NoError(t, err)
+		assert.Equal(t, expectedPackages, page.Packages)
+		assert.Equal(t, int64(1), page.Total)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("invalid query is not sent to the repository", func(t *testing.T) {
+		_, err := service.SearchPackages(context.Background(), domain.SearchQuery{Text: "(a+)+", Mode: domain.SearchRegex}, domain.PageRequest{Page: 1, Size: 10})
+
+		assert.ErrorIs(t, err, domain.ErrInvalidSearch)
 		mockRepo.AssertExpectations(t)
 	})
 }
 
 func TestPackageService_CreatePackage(t *testing.T) {
 	mockRepo := new(MockPackageRepository)
-	service := NewPackageService(mockRepo)
+	mockEvents := new(MockEventRepository)
+	service := NewPackageService(mockRepo, mockEvents)
 
 	t.Run("successful create", func(t *testing.T) {
 		pkg := &domain.Package{
@@ -147,11 +269,62 @@ func TestPackageService_CreatePackage(t *testing.T) {
 			CurrentStatus: "created",
 		}
 
-		mockRepo.On("Create", pkg).Return(nil)
+		mockRepo.On("Create", mock.Anything, pkg).Return(nil)
 
-		err := service.CreatePackage(pkg)
+		err := service.CreatePackage(context.Background(), pkg)
 
 		assert.NoError(t, err)
+		assert.Equal(t, "CREATED", pkg.CurrentStatus)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("create with events", func(t *testing.T) {
+		events := []domain.Event{
+			{Location: "New York", Status: "CREATED"},
+			{Location: "New York", Status: "PICKED_UP"},
+		}
+		pkg := &domain.Package{
+			PackageID: "321",
+			Sender: domain.Address{
+				Name:    "John Doe",
+				Address: "123 Main St",
+			},
+			Recipient: domain.Address{
+				Name:    "Jane Doe",
+				Address: "456 Oak St",
+			},
+			Origin:        "New York",
+			Destination:   "Los Angeles",
+			CurrentStatus: "CREATED",
+			Events:        events,
+		}
+
+		err := service.CreatePackage(context.Background(), pkg)
+
+		assert.ErrorIs(t, err, ErrInvalidPackage)
+		mockRepo.AssertNotCalled(t, "Create", mock.Anything, pkg)
+		mockEvents.AssertNotCalled(t, "Append", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("unknown status", func(t *testing.T) {
+		pkg := &domain.Package{
+			PackageID: "789",
+			Sender: domain.Address{
+				Name:    "John Doe",
+				Address: "123 Main St",
+			},
+			Recipient: domain.Address{
+				Name:    "Jane Doe",
+				Address: "456 Oak St",
+			},
+			Origin:        "New York",
+			Destination:   "Los Angeles",
+			CurrentStatus: "teleported",
+		}
+
+		err := service.CreatePackage(context.Background(), pkg)
+
+		assert.ErrorIs(t, err, ErrInvalidStatus)
 		mockRepo.AssertExpectations(t)
 	})
 
@@ -160,17 +333,19 @@ func TestPackageService_CreatePackage(t *testing.T) {
 			PackageID: "456",
 		}
 
-		err := service.CreatePackage(pkg)
+		err := service.CreatePackage(context.Background(), pkg)
 
 		assert.Error(t, err)
-		assert.Equal(t, "recipient name and address are required", err.Error())
+		assert.ErrorIs(t, err, ErrInvalidPackage)
+		assert.Contains(t, err.Error(), "sender name and address are required")
 		mockRepo.AssertExpectations(t)
 	})
 }
 
 func TestPackageService_UpdatePackage(t *testing.T) {
 	mockRepo := new(MockPackageRepository)
-	service := NewPackageService(mockRepo)
+	mockEvents := new(MockEventRepository)
+	service := NewPackageService(mockRepo, mockEvents)
 
 	t.Run("successful update", func(t *testing.T) {
 		pkg := &domain.Package{
@@ -185,49 +360,247 @@ func TestPackageService_UpdatePackage(t *testing.T) {
 			},
 			Origin:        "New York",
 			Destination:   "Los Angeles",
-			CurrentStatus: "updated",
+			CurrentStatus: "in transit",
 		}
 
-		mockRepo.On("Update", pkg).Return(nil)
+		latest := &domain.Event{Location: "Memphis Hub", Status: "IN_TRANSIT"}
+		createdAt := time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC)
+		mockRepo.On("FindByID", mock.Anything, "123").Return(&domain.Package{
+			PackageID:     "123",
+			CurrentStatus: "IN_TRANSIT",
+			CreatedAt:     createdAt,
+			LatestEvent:   latest,
+			EventCount:    3,
+			Version:       5,
+		}, nil).Once()
+		mockRepo.On("Update", mock.Anything, pkg).Return(nil).Once()
 
-		err := service.UpdatePackage(pkg)
+		err := service.UpdatePackage(context.Background(), pkg)
 
 		assert.NoError(t, err)
+		assert.Equal(t, "IN_TRANSIT", pkg.CurrentStatus)
+		assert.Equal(t, latest, pkg.LatestEvent)
+		assert.Equal(t, 3, pkg.EventCount)
+		assert.Equal(t, createdAt, pkg.CreatedAt)
+		assert.Equal(t, int64(5), pkg.Version, "the write is conditional on the version read")
 		mockRepo.AssertExpectations(t)
 	})
 
+	t.Run("status change", func(t *testing.T) {
+		pkg := &domain.Package{
+			PackageID:     "123",
+			Sender:        domain.Address{Name: "John Doe", Address: "123 Main St"},
+			Recipient:     domain.Address{Name: "Jane Doe", Address: "456 Oak St"},
+			Origin:        "New York",
+			Destination:   "Los Angeles",
+			CurrentStatus: "IN_TRANSIT",
+		}
+		mockRepo.On("FindByID", mock.Anything, "123").Return(&domain.Package{PackageID: "123", CurrentStatus: "DELIVERED", Version: 5}, nil).Once()
+
+		err := service.UpdatePackage(context.Background(), pkg)
+
+		assert.ErrorIs(t, err, ErrInvalidPackage, "a delivered package cannot move back to in transit")
+		mockRepo.AssertNotCalled(t, "Update", mock.Anything, pkg)
+	})
+
+	t.Run("stale version", func(t *testing.T) {
+		pkg := &domain.Package{
+			PackageID:     "123",
+			Sender:        domain.Address{Name: "John Doe", Address: "123 Main St"},
+			Recipient:     domain.Address{Name: "Jane Doe", Address: "456 Oak St"},
+			Origin:        "New York",
+			Destination:   "Los Angeles",
+			CurrentStatus: "CREATED",
+			Version:       4,
+		}
+		mockRepo.On("FindByID", mock.Anything, "123").Return(&domain.Package{PackageID: "123", Version: 5}, nil).Once()
+
+		err := service.UpdatePackage(context.Background(), pkg)
+
+		assert.ErrorIs(t, err, domain.ErrVersionConflict)
+		mockRepo.AssertNotCalled(t, "Update", mock.Anything, pkg)
+	})
+
 	t.Run("error case", func(t *testing.T) {
 		pkg := &domain.Package{
 			PackageID: "456",
 		}
 
-		err := service.UpdatePackage(pkg)
+		err := service.UpdatePackage(context.Background(), pkg)
 
 		assert.Error(t, err)
-		assert.Equal(t, "recipient name and address are required", err.Error())
+		assert.ErrorIs(t, err, ErrInvalidPackage)
+		assert.Contains(t, err.Error(), "sender name and address are required")
 		mockRepo.AssertExpectations(t)
 	})
 }
 
 func TestPackageService_DeletePackage(t *testing.T) {
 	mockRepo := new(MockPackageRepository)
-	service := NewPackageService(mockRepo)
+	mockEvents := new(MockEventRepository)
+	service := NewPackageService(mockRepo, mockEvents)
 
 	t.Run("successful delete", func(t *testing.T) {
-		mockRepo.On("Delete", "123").Return(nil)
+		mockRepo.On("Delete", mock.Anything, "123").Return(nil)
+		mockEvents.On("DeleteByPackageID", mock.Anything, "123").Return(nil)
 
-		err := service.DeletePackage("123")
+		err := service.DeletePackage(context.Background(), "123")
 
 		assert.NoError(t, err)
 		mockRepo.AssertExpectations(t)
+		mockEvents.AssertExpectations(t)
 	})
 
 	t.Run("error case", func(t *testing.T) {
-		mockRepo.On("Delete", "456").Return(errors.New("database error"))
+		mockEvents.On("DeleteByPackageID", mock.Anything, "456").Return(nil)
+		mockRepo.On("Delete", mock.Anything, "456").Return(errors.New("database error"))
+
+		err := service.DeletePackage(context.Background(), "456")
+
+		assert.Error(t, err)
+		mockRepo.AssertExpectations(t)
+	})
 
-		err := service.DeletePackage("456")
+	t.Run("failed event delete keeps the package", func(t *testing.T) {
+		mockEvents.On("DeleteByPackageID", mock.Anything, "789").Return(errors.New("database error"))
+
+		err := service.DeletePackage(context.Background(), "789")
 
 		assert.Error(t, err)
+		mockRepo.AssertNotCalled(t, "Delete", mock.Anything, "789")
+	})
+}
+
+func TestPackageService_AddEvent(t *testing.T) {
+	t.Run("successful add", func(t *testing.T) {
+		mockRepo := new(MockPackageRepository)
+		mockEvents := new(MockEventRepository)
+		service := NewPackageService(mockRepo, mockEvents)
+
+		pkg := &domain.Package{
+			PackageID:     "123",
+			CurrentStatus: "PICKED_UP",
+		}
+
+		mockRepo.On("FindByID", mock.Anything, "123").Return(pkg, nil)
+		mockRepo.On("Update", mock.Anything, pkg).Return(nil)
+		mockEvents.On("Append", mock.Anything, "123", mock.Anything).Return(nil)
+
+		updated, err := service.AddEvent(context.Background(), "123", &domain.Event{Location: "Memphis Hub", Status: "in transit"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "IN_TRANSIT", updated.CurrentStatus)
+		assert.Equal(t, 1, updated.EventCount)
+		assert.Equal(t, "IN_TRANSIT", updated.LatestEvent.Status)
+		assert.False(t, updated.LatestEvent.Timestamp.IsZero())
+		mockRepo.AssertExpectations(t)
+		mockEvents.AssertExpectations(t)
+	})
+
+	t.Run("retries after a concurrent write", func(t *testing.T) {
+		mockRepo := new(MockPackageRepository)
+		mockEvents := new(MockEventRepository)
+		service := NewPackageService(mockRepo, mockEvents)
+
+		mockRepo.On("FindByID", mock.Anything, "123").Return(&domain.Package{PackageID: "123", CurrentStatus: "PICKED_UP", Version: 1}, nil).Once()
+		mockRepo.On("FindByID", mock.Anything, "123").Return(&domain.Package{PackageID: "123", CurrentStatus: "PICKED_UP", EventCount: 1, Version: 2}, nil).Once()
+		mockRepo.On("Update", mock.Anything, mock.MatchedBy(func(pkg *domain.Package) bool { return pkg.Version == 1 })).Return(domain.ErrVersionConflict).Once()
+		mockRepo.On("Update", mock.Anything, mock.MatchedBy(func(pkg *domain.Package) bool { return pkg.Version == 2 })).Return(nil).Once()
+		mockEvents.On("Append", mock.Anything, "123", mock.Anything).Return(nil).Once()
+
+		updated, err := service.AddEvent(context.Background(), "123", &domain.Event{Location: "Memphis Hub", Status: "IN_TRANSIT"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, updated.EventCount)
+		mockRepo.AssertExpectations(t)
+		mockEvents.AssertExpectations(t)
+	})
+
+	t.Run("gives up after repeated conflicts", func(t *testing.T) {
+		mockRepo := new(MockPackageRepository)
+		mockEvents := new(MockEventRepository)
+		service := NewPackageService(mockRepo, mockEvents)
+
+		mockRepo.On("FindByID", mock.Anything, "123").Return(&domain.Package{PackageID: "123", CurrentStatus: "PICKED_UP", Version: 1}, nil)
+		mockRepo.On("Update", mock.Anything, mock.Anything).Return(domain.ErrVersionConflict)
+		mockEvents.On("Append", mock.Anything, "123", mock.Anything).Return(nil)
+
+		updated, err := service.AddEvent(context.Background(), "123", &domain.Event{Location: "Memphis Hub", Status: "IN_TRANSIT"})
+
+		assert.ErrorIs(t, err, domain.ErrVersionConflict)
+		assert.Nil(t, updated)
+		mockRepo.AssertNumberOfCalls(t, "Update", maxEventAttempts)
+		mockEvents.AssertNumberOfCalls(t, "Append", 1)
+	})
+
+	t.Run("failed append leaves the package unchanged", func(t *testing.T) {
+		mockRepo := new(MockPackageRepository)
+		mockEvents := new(MockEventRepository)
+		service := NewPackageService(mockRepo, mockEvents)
+
+		mockRepo.On("FindByID", mock.Anything, "123").Return(&domain.Package{PackageID: "123", CurrentStatus: "PICKED_UP", Version: 1}, nil)
+		mockEvents.On("Append", mock.Anything, "123", mock.Anything).Return(errors.New("database error"))
+
+		updated, err := service.AddEvent(context.Background(), "123", &domain.Event{Location: "Memphis Hub", Status: "IN_TRANSIT"})
+
+		assert.Error(t, err)
+		assert.Nil(t, updated)
+		mockRepo.AssertNotCalled(t, "Update", mock.Anything, mock.Anything)
+	})
+
+	t.Run("illegal transition", func(t *testing.T) {
+		mockRepo := new(MockPackageRepository)
+		mockEvents := new(MockEventRepository)
+		service := NewPackageService(mockRepo, mockEvents)
+
+		mockRepo.On("FindByID", mock.Anything, "123").Return(&domain.Package{
+			PackageID:     "123",
+			CurrentStatus: "DELIVERED",
+		}, nil)
+
+		updated, err := service.AddEvent(context.Background(), "123", &domain.Event{Location: "Memphis Hub", Status: "IN_TRANSIT"})
+
+		assert.ErrorIs(t, err, ErrInvalidTransition)
+		assert.Nil(t, updated)
+		mockRepo.AssertNotCalled(t, "Update", mock.Anything, mock.Anything)
+		mockEvents.AssertNotCalled(t, "Append", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("missing location", func(t *testing.T) {
+		mockRepo := new(MockPackageRepository)
+		mockEvents := new(MockEventRepository)
+		service := NewPackageService(mockRepo, mockEvents)
+
+		updated, err := service.AddEvent(context.Background(), "123", &domain.Event{Status: "IN_TRANSIT"})
+
+		assert.ErrorIs(t, err, ErrInvalidEvent)
+		assert.Nil(t, updated)
+		mockRepo.AssertExpectations(t)
+	})
+
+	t.Run("custom status machine", func(t *testing.T) {
+		mockRepo := new(MockPackageRepository)
+		mockEvents := new(MockEventRepository)
+		statuses, err := NewStatusMachine(TransitionTable{
+			"CREATED":   {"DELIVERED"},
+			"DELIVERED": {},
+		})
+		assert.NoError(t, err)
+		service := NewPackageService(mockRepo, mockEvents, WithStatusMachine(statuses))
+
+		pkg := &domain.Package{
+			PackageID:     "123",
+			CurrentStatus: "CREATED",
+		}
+
+		mockRepo.On("FindByID", mock.Anything, "123").Return(pkg, nil)
+		mockRepo.On("Update", mock.Anything, pkg).Return(nil)
+		mockEvents.On("Append", mock.Anything, "123", mock.Anything).Return(nil)
+
+		updated, err := service.AddEvent(context.Background(), "123", &domain.Event{Location: "Front Door", Status: "DELIVERED"})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "DELIVERED", updated.CurrentStatus)
 		mockRepo.AssertExpectations(t)
 	})
 }
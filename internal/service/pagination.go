@@ -0,0 +1,180 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+// maxSortFields limits how many fields a client may sort by
+const maxSortFields = 3
+
+// cursor is the decoded form of the opaque next_cursor value
+type cursor struct {
+	Sort   string   `json:"s"`
+	Values []string `json:"v"`
+}
+
+// pageOptions returns the page and size of a page request, defaulting to the
+// first page of 10 and capping the size at 100
+func pageOptions(req domain.PageRequest) domain.ListOptions {
+	opts := domain.ListOptions{Page: req.Page, Size: req.Size}
+	if opts.Page < 1 {
+		opts.Page = 1
+	}
+	if opts.Size < 1 {
+		opts.Size = 10
+	}
+	if opts.Size > 100 {
+		opts.Size = 100
+	}
+	return opts
+}
+
+// listOptions validates a page request and turns it into repository list
+// options. A cursor carries its own sort order, so a sort parameter given
+// alongside it must match.
+func listOptions(req domain.PageRequest) (domain.ListOptions, error) {
+	opts := pageOptions(req)
+
+	sort, err := parseSort(req.Sort)
+	if err != nil {
+		return opts, err
+	}
+	opts.Sort = sort
+
+	if req.Cursor == "" {
+		return opts, nil
+	}
+
+	c, err := decodeCursor(req.Cursor)
+	if err != nil {
+		return opts, err
+	}
+	cursorSort, err := parseSort(c.Sort)
+	if err != nil || len(c.Values) != len(cursorSort) {
+		return opts, domain.ErrInvalidCursor
+	}
+	if req.Sort != "" && formatSort(sort) != c.Sort {
+		return opts, fmt.Errorf("%w: cursor was issued for a different sort", domain.ErrInvalidCursor)
+	}
+
+	after := make([]interface{}, len(cursorSort))
+	for i, order := range cursorSort {
+		if !order.Field.IsTime() {
+			after[i] = c.Values[i]
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, c.Values[i])
+		if err != nil {
+			return opts, domain.ErrInvalidCursor
+		}
+		after[i] = t
+	}
+
+	opts.Sort = cursorSort
+	opts.After = after
+	return opts, nil
+}
+
+// newPackagePage wraps a page of results. A full page gets a cursor pointing
+// after its last package.
+func newPackagePage(packages []domain.Package, total int64, opts domain.ListOptions) *domain.PackagePage {
+	page := &domain.PackagePage{
+		Packages: packages,
+		Total:    total,
+		Page:     opts.Page,
+		Size:     opts.Size,
+	}
+	if len(packages) > 0 && len(packages) == opts.Size {
+		page.NextCursor = encodeCursor(opts.Sort, &packages[len(packages)-1])
+	}
+	return page
+}
+
+// parseSort parses a comma separated list of fields, each optionally
+// prefixed with "-" for descending order. The package ID is appended as a
+// tiebreaker so the order is total. An empty value returns the default sort.
+func parseSort(value string) ([]domain.SortOrder, error) {
+	if strings.TrimSpace(value) == "" {
+		return domain.DefaultSort, nil
+	}
+
+	var (
+		sort []domain.SortOrder
+		seen = map[domain.SortField]bool{}
+	)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		order := domain.SortOrder{Field: domain.SortField(strings.TrimPrefix(part, "-"))}
+		order.Descending = strings.HasPrefix(part, "-")
+
+		if !isSortField(order.Field) {
+			return nil, fmt.Errorf("%w: unknown field %q", domain.ErrInvalidSort, order.Field)
+		}
+		if seen[order.Field] {
+			return nil, fmt.Errorf("%w: duplicate field %q", domain.ErrInvalidSort, order.Field)
+		}
+		seen[order.Field] = true
+		sort = append(sort, order)
+	}
+
+	if len(sort) > maxSortFields {
+		return nil, fmt.Errorf("%w: at most %d fields are allowed", domain.ErrInvalidSort, maxSortFields)
+	}
+	if !seen[domain.SortPackageID] {
+		sort = append(sort, domain.SortOrder{Field: domain.SortPackageID})
+	}
+	return sort, nil
+}
+
+func isSortField(field domain.SortField) bool {
+	for _, f := range domain.SortFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func formatSort(sort []domain.SortOrder) string {
+	parts := make([]string, len(sort))
+	for i, order := range sort {
+		parts[i] = string(order.Field)
+		if order.Descending {
+			parts[i] = "-" + parts[i]
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func encodeCursor(sort []domain.SortOrder, last *domain.Package) string {
+	c := cursor{Sort: formatSort(sort)}
+	for _, value := range domain.SortKey(sort, last) {
+		switch v := value.(type) {
+		case time.Time:
+			c.Values = append(c.Values, v.UTC().Format(time.RFC3339Nano))
+		case string:
+			c.Values = append(c.Values, v)
+		}
+	}
+
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(value string) (cursor, error) {
+	var c cursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return c, domain.ErrInvalidCursor
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, domain.ErrInvalidCursor
+	}
+	return c, nil
+}
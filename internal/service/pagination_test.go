@@ -0,0 +1,85 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []domain.SortOrder
+		wantErr bool
+	}{
+		{"default", "", domain.DefaultSort, false},
+		{"adds package ID tiebreak", "-updatedAt", []domain.SortOrder{
+			{Field: domain.SortUpdatedAt, Descending: true},
+			{Field: domain.SortPackageID},
+		}, false},
+		{"several fields", "currentStatus, -createdAt", []domain.SortOrder{
+			{Field: domain.SortCurrentStatus},
+			{Field: domain.SortCreatedAt, Descending: true},
+			{Field: domain.SortPackageID},
+		}, false},
+		{"explicit package ID", "-packageId", []domain.SortOrder{
+			{Field: domain.SortPackageID, Descending: true},
+		}, false},
+		{"unknown field", "sender.name", nil, true},
+		{"duplicate field", "origin,-origin", nil, true},
+		{"too many fields", "origin,destination,currentStatus,createdAt", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sort, err := parseSort(tt.value)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, domain.ErrInvalidSort)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, sort)
+		})
+	}
+}
+
+func TestListOptions(t *testing.T) {
+	sort := []domain.SortOrder{{Field: domain.SortOrigin}, {Field: domain.SortPackageID}}
+	last := &domain.Package{PackageID: "PKG1", Origin: "Chicago", CreatedAt: time.Now()}
+	cursor := encodeCursor(sort, last)
+
+	t.Run("clamps page and size", func(t *testing.T) {
+		opts, err := listOptions(domain.PageRequest{Page: -1, Size: 1000})
+		require.NoError(t, err)
+		assert.Equal(t, 1, opts.Page)
+		assert.Equal(t, 100, opts.Size)
+	})
+
+	t.Run("cursor restores sort and position", func(t *testing.T) {
+		opts, err := listOptions(domain.PageRequest{Size: 5, Cursor: cursor})
+		require.NoError(t, err)
+		assert.Equal(t, sort, opts.Sort)
+		assert.Equal(t, []interface{}{"Chicago", "PKG1"}, opts.After)
+	})
+
+	t.Run("cursor with matching sort", func(t *testing.T) {
+		_, err := listOptions(domain.PageRequest{Sort: "origin", Cursor: cursor})
+		assert.NoError(t, err)
+	})
+
+	t.Run("cursor with different sort", func(t *testing.T) {
+		_, err := listOptions(domain.PageRequest{Sort: "-origin", Cursor: cursor})
+		assert.ErrorIs(t, err, domain.ErrInvalidCursor)
+	})
+
+	t.Run("malformed cursor", func(t *testing.T) {
+		for _, value := range []string{"not base64!", "bm90IGpzb24", encodeCursor(domain.DefaultSort, last)[:10]} {
+			_, err := listOptions(domain.PageRequest{Cursor: value})
+			assert.ErrorIs(t, err, domain.ErrInvalidCursor, value)
+		}
+	})
+}
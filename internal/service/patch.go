@@ -0,0 +1,133 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+var ErrInvalidPatch = errors.New("invalid patch")
+
+// PatchFormat identifies the format of a patch document
+type PatchFormat string
+
+const (
+	// MergePatch is a JSON merge patch (RFC 7386), media type
+	// application/merge-patch+json
+	MergePatch PatchFormat = "merge"
+	// JSONPatch is a JSON patch (RFC 6902), media type
+	// application/json-patch+json
+	JSONPatch PatchFormat = "json"
+)
+
+// managedFields are maintained by the service and repositories and cannot be
+// changed by a patch. The current status only changes through AddEvent, which
+// enforces the status machine.
+var managedFields = []string{"tenantId", "packageId", "currentStatus", "createdAt", "updatedAt", "latestEvent", "eventCount", "version", "events"}
+
+// PatchPackage applies a patch document to a package. The patched package is
+// validated like a full update and only the changed fields are written. A
+// non-zero version must match the stored version, as in UpdatePackage.
+func (s *PackageService) PatchPackage(ctx context.Context, id string, version int64, format PatchFormat, patch []byte) (*domain.Package, error) {
+	if strings.TrimSpace(id) == "" {
+		return nil, ErrEmptyPackageID
+	}
+
+	existing, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion(existing, version); err != nil {
+		return nil, err
+	}
+
+	patched, err := applyPatch(existing, format, patch)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.validatePackage(patched); err != nil {
+		return nil, err
+	}
+
+	update := packageUpdate(existing, patched)
+	if update.IsEmpty() {
+		return existing, nil
+	}
+	update.Version = existing.Version
+
+	return s.repo.Patch(ctx, id, update)
+}
+
+// applyPatch applies the patch to the package's JSON representation and
+// decodes the result, rejecting unknown fields and changes to managed fields
+func applyPatch(pkg *domain.Package, format PatchFormat, patch []byte) (*domain.Package, error) {
+	original, err := json.Marshal(pkg)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc []byte
+	switch format {
+	case MergePatch:
+		doc, err = jsonpatch.MergePatch(original, patch)
+	case JSONPatch:
+		var ops jsonpatch.Patch
+		if ops, err = jsonpatch.DecodePatch(patch); err == nil {
+			doc, err = ops.Apply(original)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported format %q", ErrInvalidPatch, format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+
+	var before, after map[string]interface{}
+	if err := json.Unmarshal(original, &before); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(doc, &after); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	for _, field := range managedFields {
+		if !reflect.DeepEqual(before[field], after[field]) {
+			return nil, fmt.Errorf("%w: %s cannot be changed", ErrInvalidPatch, field)
+		}
+	}
+
+	var patched domain.Package
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&patched); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPatch, err)
+	}
+	return &patched, nil
+}
+
+// packageUpdate lists the fields that differ between two versions of a
+// package
+func packageUpdate(existing, patched *domain.Package) domain.PackageUpdate {
+	changed := func(old, new string) *string {
+		if old == new {
+			return nil
+		}
+		return &new
+	}
+
+	return domain.PackageUpdate{
+		SenderName:       changed(existing.Sender.Name, patched.Sender.Name),
+		SenderAddress:    changed(existing.Sender.Address, patched.Sender.Address),
+		RecipientName:    changed(existing.Recipient.Name, patched.Recipient.Name),
+		RecipientAddress: changed(existing.Recipient.Address, patched.Recipient.Address),
+		Origin:           changed(existing.Origin, patched.Origin),
+		Destination:      changed(existing.Destination, patched.Destination),
+	}
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func storedPackage() *domain.Package {
+	return &domain.Package{
+		PackageID:     "123",
+		Sender:        domain.Address{Name: "John Doe", Address: "123 Main St"},
+		Recipient:     domain.Address{Name: "Jane Doe", Address: "456 Oak St"},
+		Origin:        "New York",
+		Destination:   "Los Angeles",
+		CurrentStatus: "CREATED",
+		LatestEvent:   &domain.Event{Timestamp: time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC), Location: "New York", Status: "CREATED"},
+		EventCount:    1,
+		CreatedAt:     time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC),
+		UpdatedAt:     time.Date(2024, 5, 1, 8, 0, 0, 0, time.UTC),
+		Version:       3,
+	}
+}
+
+func TestPackageService_PatchPackage(t *testing.T) {
+	destination, recipientName := "Seattle", "Maria Lopez"
+
+	tests := []struct {
+		name   string
+		format PatchFormat
+		patch  string
+		update domain.PackageUpdate
+	}{
+		{
+			name:   "merge patch",
+			format: MergePatch,
+			patch:  `{"destination": "Seattle", "recipient": {"name": "Maria Lopez"}}`,
+			update: domain.PackageUpdate{Destination: &destination, RecipientName: &recipientName, Version: 3},
+		},
+		{
+			name:   "JSON patch",
+			format: JSONPatch,
+			patch:  `[{"op": "test", "path": "/destination", "value": "Los Angeles"}, {"op": "replace", "path": "/destination", "value": "Seattle"}]`,
+			update: domain.PackageUpdate{Destination: &destination, Version: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockPackageRepository)
+			service := NewPackageService(mockRepo, new(MockEventRepository))
+			patched := storedPackage()
+
+			mockRepo.On("FindByID", mock.Anything, "123").Return(storedPackage(), nil)
+			mockRepo.On("Patch", mock.Anything, "123", tt.update).Return(patched, nil)
+
+			pkg, err := service.PatchPackage(context.Background(), "123", 0, tt.format, []byte(tt.patch))
+
+			require.NoError(t, err)
+			assert.Equal(t, patched, pkg)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+
+	t.Run("no changes skip the write", func(t *testing.T) {
+		mockRepo := new(MockPackageRepository)
+		service := NewPackageService(mockRepo, new(MockEventRepository))
+		mockRepo.On("FindByID", mock.Anything, "123").Return(storedPackage(), nil)
+
+		pkg, err := service.PatchPackage(context.Background(), "123", 0, MergePatch, []byte(`{"origin": "New York"}`))
+
+		require.NoError(t, err)
+		assert.Equal(t, storedPackage(), pkg)
+		mockRepo.AssertNotCalled(t, "Patch", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	rejected := []struct {
+		name    string
+		format  PatchFormat
+		patch   string
+		wantErr error
+	}{
+		{"managed field", MergePatch, `{"createdAt": "2020-01-01T00:00:00Z"}`, ErrInvalidPatch},
+		{"package ID", MergePatch, `{"packageId": "456"}`, ErrInvalidPatch},
+		{"version", MergePatch, `{"version": 7}`, ErrInvalidPatch},
+		{"event history", MergePatch, `{"events": [{"location": "Memphis"}]}`, ErrInvalidPatch},
+		{"unknown field", MergePatch, `{"weight": 3}`, ErrInvalidPatch},
+		{"wrong type", MergePatch, `{"origin": 42}`, ErrInvalidPatch},
+		{"malformed document", MergePatch, `{"origin":`, ErrInvalidPatch},
+		{"failed JSON patch test", JSONPatch, `[{"op": "test", "path": "/origin", "value": "Boston"}]`, ErrInvalidPatch},
+		{"removed required field", MergePatch, `{"origin": null}`, ErrInvalidPackage},
+		{"status", MergePatch, `{"currentStatus": "IN_TRANSIT"}`, ErrInvalidPatch},
+		{"status backwards", JSONPatch, `[{"op": "replace", "path": "/currentStatus", "value": "DELIVERED"}]`, ErrInvalidPatch},
+	}
+
+	for _, tt := range rejected {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockPackageRepository)
+			service := NewPackageService(mockRepo, new(MockEventRepository))
+			mockRepo.On("FindByID", mock.Anything, "123").Return(storedPackage(), nil)
+
+			_, err := service.PatchPackage(context.Background(), "123", 0, tt.format, []byte(tt.patch))
+
+			assert.ErrorIs(t, err, tt.wantErr)
+			mockRepo.AssertNotCalled(t, "Patch", mock.Anything, mock.Anything, mock.Anything)
+		})
+	}
+
+	t.Run("not found", func(t *testing.T) {
+		mockRepo := new(MockPackageRepository)
+		service := NewPackageService(mockRepo, new(MockEventRepository))
+		mockRepo.On("FindByID", mock.Anything, "456").Return(nil, domain.ErrPackageNotFound)
+
+		_, err := service.PatchPackage(context.Background(), "456", 0, MergePatch, []byte(`{}`))
+
+		assert.ErrorIs(t, err, domain.ErrPackageNotFound)
+	})
+
+	t.Run("matching version", func(t *testing.T) {
+		mockRepo := new(MockPackageRepository)
+		service := NewPackageService(mockRepo, new(MockEventRepository))
+		patched := storedPackage()
+		mockRepo.On("FindByID", mock.Anything, "123").Return(storedPackage(), nil)
+		mockRepo.On("Patch", mock.Anything, "123", domain.PackageUpdate{Destination: &destination, Version: 3}).Return(patched, nil)
+
+		pkg, err := service.PatchPackage(context.Background(), "123", 3, MergePatch, []byte(`{"destination": "Seattle"}`))
+
+		require.NoError(t, err)
+		assert.Equal(t, patched, pkg)
+	})
+
+	t.Run("stale version", func(t *testing.T) {
+		mockRepo := new(MockPackageRepository)
+		service := NewPackageService(mockRepo, new(MockEventRepository))
+		mockRepo.On("FindByID", mock.Anything, "123").Return(storedPackage(), nil)
+
+		_, err := service.PatchPackage(context.Background(), "123", 2, MergePatch, []byte(`{"destination": "Seattle"}`))
+
+		assert.ErrorIs(t, err, domain.ErrVersionConflict)
+		mockRepo.AssertNotCalled(t, "Patch", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"unicode/utf8"
+
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+const (
+	// maxSearchLength limits the length of search queries in every mode
+	maxSearchLength = 100
+	// maxRegexRepeat limits counted repetitions such as a{1,1000}
+	maxRegexRepeat = 100
+	// maxRegexQuantifiers limits the quantifiers of a regex, including ?
+	maxRegexQuantifiers = 4
+)
+
+// validateSearch checks the search mode and, for regex searches, rejects
+// patterns that are invalid or expensive to evaluate. Text searches need at
+// least one word.
+func validateSearch(query domain.SearchQuery) error {
+	if utf8.RuneCountInString(query.Text) > maxSearchLength {
+		return fmt.Errorf("%w: query is longer than %d characters", domain.ErrInvalidSearch, maxSearchLength)
+	}
+
+	switch query.Mode {
+	case domain.SearchLiteral, domain.SearchPrefix, domain.SearchExact:
+		return nil
+	case domain.SearchRegex:
+		return validateRegex(query.Text)
+	case domain.SearchText:
+		if len(query.Words()) == 0 {
+			return fmt.Errorf("%w: query has no words", domain.ErrInvalidSearch)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown mode %q", domain.ErrInvalidSearch, query.Mode)
+	}
+}
+
+// validateRegex parses the pattern and rejects the constructs that can cause
+// catastrophic backtracking in the database's regex engine: quantifiers or
+// alternations inside a repeated group, like (a+)+, (a?)* or (ab|a)*, large
+// counted repetitions, and more than maxRegexQuantifiers quantifiers, which
+// bounds the backtracking of adjacent ones like a*a*a*b. Single character
+// alternations like (a|b)+ are parsed as character classes and allowed.
+func validateRegex(pattern string) error {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return fmt.Errorf("%w: %v", domain.ErrInvalidSearch, err)
+	}
+	quantifiers := 0
+	return checkRegexComplexity(re, false, &quantifiers)
+}
+
+func checkRegexComplexity(re *syntax.Regexp, insideRepeat bool, quantifiers *int) error {
+	repeats := false
+	switch re.Op {
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest:
+		repeats = true
+	case syntax.OpRepeat:
+		repeats = true
+		if re.Max > maxRegexRepeat || re.Min > maxRegexRepeat {
+			return fmt.Errorf("%w: repetition count exceeds %d", domain.ErrInvalidSearch, maxRegexRepeat)
+		}
+	case syntax.OpAlternate:
+		if insideRepeat {
+			return fmt.Errorf("%w: alternations inside repeated groups are not allowed", domain.ErrInvalidSearch)
+		}
+	}
+
+	if repeats {
+		if insideRepeat {
+			return fmt.Errorf("%w: nested quantifiers are not allowed", domain.ErrInvalidSearch)
+		}
+		if *quantifiers++; *quantifiers > maxRegexQuantifiers {
+			return fmt.Errorf("%w: more than %d quantifiers", domain.ErrInvalidSearch, maxRegexQuantifiers)
+		}
+	}
+
+	for _, sub := range re.Sub {
+		if err := checkRegexComplexity(sub, insideRepeat || repeats, quantifiers); err != nil {
+			return err
+		}
+	}
+	return nil
+}
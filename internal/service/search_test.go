@@ -0,0 +1,50 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateSearch(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   domain.SearchQuery
+		wantErr bool
+	}{
+		{"literal with metacharacters", domain.SearchQuery{Text: "(a+)+", Mode: domain.SearchLiteral}, false},
+		{"prefix", domain.SearchQuery{Text: "PKG", Mode: domain.SearchPrefix}, false},
+		{"exact", domain.SearchQuery{Text: "PKG-1", Mode: domain.SearchExact}, false},
+		{"simple regex", domain.SearchQuery{Text: "^pkg-[0-9]+$", Mode: domain.SearchRegex}, false},
+		{"bounded repeat", domain.SearchQuery{Text: "a{1,100}", Mode: domain.SearchRegex}, false},
+		{"invalid regex", domain.SearchQuery{Text: "(", Mode: domain.SearchRegex}, true},
+		{"nested plus", domain.SearchQuery{Text: "(a+)+", Mode: domain.SearchRegex}, true},
+		{"nested star", domain.SearchQuery{Text: "(ab*)*c", Mode: domain.SearchRegex}, true},
+		{"nested counted repeat", domain.SearchQuery{Text: "(a{2,5}){2,}", Mode: domain.SearchRegex}, true},
+		{"large repeat", domain.SearchQuery{Text: "a{1,1000}", Mode: domain.SearchRegex}, true},
+		{"alternation", domain.SearchQuery{Text: "^(north|south) hub$", Mode: domain.SearchRegex}, false},
+		{"single character alternation inside repeat", domain.SearchQuery{Text: "(a|b)+c", Mode: domain.SearchRegex}, false},
+		{"alternation inside repeat", domain.SearchQuery{Text: "(foo|bar)+", Mode: domain.SearchRegex}, true},
+		{"overlapping alternation inside repeat", domain.SearchQuery{Text: "(a|ab)*c", Mode: domain.SearchRegex}, true},
+		{"optional inside repeat", domain.SearchQuery{Text: "(ab?)+", Mode: domain.SearchRegex}, true},
+		{"quantifiers up to the limit", domain.SearchQuery{Text: "^[a-z]+-?[0-9]{2,4}x*$", Mode: domain.SearchRegex}, false},
+		{"too many quantifiers", domain.SearchQuery{Text: "a*a*a*a*a*b", Mode: domain.SearchRegex}, true},
+		{"too long", domain.SearchQuery{Text: strings.Repeat("a", maxSearchLength+1), Mode: domain.SearchLiteral}, true},
+		{"text", domain.SearchQuery{Text: "zebra town", Mode: domain.SearchText}, false},
+		{"text without words", domain.SearchQuery{Text: " -+ ", Mode: domain.SearchText}, true},
+		{"unknown mode", domain.SearchQuery{Text: "a", Mode: "fuzzy"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSearch(tt.query)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, domain.ErrInvalidSearch)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
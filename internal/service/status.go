@@ -0,0 +1,140 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	ErrInvalidStatus     = errors.New("invalid package status")
+	ErrInvalidTransition = errors.New("invalid status transition")
+)
+
+// Status is a canonical shipment status
+type Status string
+
+const (
+	StatusCreated          Status = "CREATED"
+	StatusPickedUp         Status = "PICKED_UP"
+	StatusInTransit        Status = "IN_TRANSIT"
+	StatusOutForDelivery   Status = "OUT_FOR_DELIVERY"
+	StatusDelivered        Status = "DELIVERED"
+	StatusDeliveryFailed   Status = "DELIVERY_FAILED"
+	StatusException        Status = "EXCEPTION"
+	StatusReturnedToSender Status = "RETURNED_TO_SENDER"
+	StatusCancelled        Status = "CANCELLED"
+)
+
+// TransitionTable maps every status to the statuses it may move to
+type TransitionTable map[Status][]Status
+
+// DefaultTransitions returns the built-in shipment lifecycle
+func DefaultTransitions() TransitionTable {
+	return TransitionTable{
+		StatusCreated:          {StatusPickedUp, StatusCancelled, StatusException},
+		StatusPickedUp:         {StatusInTransit, StatusException},
+		StatusInTransit:        {StatusInTransit, StatusOutForDelivery, StatusException},
+		StatusOutForDelivery:   {StatusDelivered, StatusDeliveryFailed, StatusException},
+		StatusDeliveryFailed:   {StatusOutForDelivery, StatusInTransit, StatusReturnedToSender, StatusException},
+		StatusException:        {StatusInTransit, StatusOutForDelivery, StatusReturnedToSender, StatusCancelled},
+		StatusDelivered:        {},
+		StatusReturnedToSender: {},
+		StatusCancelled:        {},
+	}
+}
+
+// StatusMachine validates statuses and the transitions between them
+type StatusMachine struct {
+	transitions map[Status]map[Status]bool
+}
+
+// NewStatusMachine creates a status machine from a transition table. Every
+// status referenced as a target must also be declared as a key.
+func NewStatusMachine(table TransitionTable) (*StatusMachine, error) {
+	if len(table) == 0 {
+		return nil, errors.New("transition table is empty")
+	}
+
+	transitions := make(map[Status]map[Status]bool, len(table))
+	for from := range table {
+		transitions[NormalizeStatus(string(from))] = make(map[Status]bool)
+	}
+
+	for from, targets := range table {
+		from = NormalizeStatus(string(from))
+		for _, to := range targets {
+			to = NormalizeStatus(string(to))
+			if _, exists := transitions[to]; !exists {
+				return nil, fmt.Errorf("transition %s -> %s targets an undeclared status", from, to)
+			}
+			transitions[from][to] = true
+		}
+	}
+
+	return &StatusMachine{transitions: transitions}, nil
+}
+
+// DefaultStatusMachine returns a status machine using DefaultTransitions
+func DefaultStatusMachine() *StatusMachine {
+	machine, err := NewStatusMachine(DefaultTransitions())
+	if err != nil {
+		panic(err)
+	}
+	return machine
+}
+
+// LoadStatusMachine reads a JSON transition table from the given file, e.g.
+// {"CREATED": ["IN_TRANSIT"], "IN_TRANSIT": ["DELIVERED"], "DELIVERED": []}
+func LoadStatusMachine(path string) (*StatusMachine, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading transition table %s: %v", path, err)
+	}
+
+	var table TransitionTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("error parsing transition table %s: %v", path, err)
+	}
+
+	return NewStatusMachine(table)
+}
+
+// Parse normalizes a status and checks that the machine knows it
+func (m *StatusMachine) Parse(status string) (Status, error) {
+	s := NormalizeStatus(status)
+	if _, exists := m.transitions[s]; !exists {
+		return "", fmt.Errorf("%w: %q", ErrInvalidStatus, status)
+	}
+	return s, nil
+}
+
+// Transition checks that moving from one status to another is allowed and
+// returns the canonical target status
+func (m *StatusMachine) Transition(from, to string) (Status, error) {
+	target, err := m.Parse(to)
+	if err != nil {
+		return "", err
+	}
+
+	current, err := m.Parse(from)
+	if err != nil {
+		return "", fmt.Errorf("%w: current status %q is unknown", ErrInvalidTransition, from)
+	}
+
+	if !m.transitions[current][target] {
+		return "", fmt.Errorf("%w: %s -> %s", ErrInvalidTransition, current, target)
+	}
+
+	return target, nil
+}
+
+// NormalizeStatus converts free-form input such as "in transit" to its
+// canonical form "IN_TRANSIT"
+func NormalizeStatus(status string) Status {
+	s := strings.ToUpper(strings.TrimSpace(status))
+	s = strings.NewReplacer(" ", "_", "-", "_").Replace(s)
+	return Status(s)
+}
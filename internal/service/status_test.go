@@ -0,0 +1,70 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusMachine_Transition(t *testing.T) {
+	statuses := DefaultStatusMachine()
+
+	tests := []struct {
+		name    string
+		from    string
+		to      string
+		want    Status
+		wantErr error
+	}{
+		{name: "created to picked up", from: "CREATED", to: "PICKED_UP", want: StatusPickedUp},
+		{name: "repeated in transit scan", from: "IN_TRANSIT", to: "IN_TRANSIT", want: StatusInTransit},
+		{name: "normalizes input", from: "out for delivery", to: "delivered", want: StatusDelivered},
+		{name: "delivered is terminal", from: "DELIVERED", to: "IN_TRANSIT", wantErr: ErrInvalidTransition},
+		{name: "skipping pickup", from: "CREATED", to: "DELIVERED", wantErr: ErrInvalidTransition},
+		{name: "unknown target", from: "CREATED", to: "LOST_IN_SPACE", wantErr: ErrInvalidStatus},
+		{name: "unknown current status", from: "created-ish", to: "IN_TRANSIT", wantErr: ErrInvalidTransition},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := statuses.Transition(tt.from, tt.to)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestNewStatusMachine(t *testing.T) {
+	t.Run("undeclared target", func(t *testing.T) {
+		_, err := NewStatusMachine(TransitionTable{"CREATED": {"DELIVERED"}})
+		assert.Error(t, err)
+	})
+
+	t.Run("empty table", func(t *testing.T) {
+		_, err := NewStatusMachine(TransitionTable{})
+		assert.Error(t, err)
+	})
+}
+
+func TestLoadStatusMachine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transitions.json")
+	err := os.WriteFile(path, []byte(`{"created": ["in transit"], "IN_TRANSIT": ["DELIVERED"], "DELIVERED": []}`), 0o600)
+	require.NoError(t, err)
+
+	statuses, err := LoadStatusMachine(path)
+	require.NoError(t, err)
+
+	got, err := statuses.Transition("CREATED", "IN_TRANSIT")
+	require.NoError(t, err)
+	assert.Equal(t, StatusInTransit, got)
+
+	_, err = statuses.Transition("CREATED", "DELIVERED")
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+}
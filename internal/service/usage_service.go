@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+)
+
+var ErrInvalidPeriod = errors.New("invalid usage period")
+
+// UsageService reports the request counts the rate limiter keeps for quotas
+type UsageService struct {
+	repo domain.UsageRepository
+	now  func() time.Time
+}
+
+func NewUsageService(repo domain.UsageRepository) *UsageService {
+	return &UsageService{
+		repo: repo,
+		now:  time.Now,
+	}
+}
+
+// ListUsage returns a page of the usage of every caller in a day
+// (2006-01-02) or a month (2006-01), highest count first. An empty period is
+// the current month.
+func (s *UsageService) ListUsage(ctx context.Context, period string, req domain.PageRequest) (*domain.UsagePage, error) {
+	if period == "" {
+		period = domain.MonthlyPeriod(s.now())
+	}
+	if !validPeriod(period) {
+		return nil, fmt.Errorf("%w: %q is neither a day (YYYY-MM-DD) nor a month (YYYY-MM)", ErrInvalidPeriod, period)
+	}
+
+	opts := pageOptions(req)
+	usage, total, err := s.repo.FindByPeriod(ctx, period, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &domain.UsagePage{Usage: usage, Total: total, Page: opts.Page, Size: opts.Size}, nil
+}
+
+func validPeriod(period string) bool {
+	for _, layout := range []string{domain.DailyPeriodLayout, domain.MonthlyPeriodLayout} {
+		if t, err := time.Parse(layout, period); err == nil && t.Format(layout) == period {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/snavarro/microtracker/internal/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// MockUsageRepository is a mock implementation of domain.UsageRepository
+type MockUsageRepository struct {
+	mock.Mock
+}
+
+func (m *MockUsageRepository) Increment(ctx context.Context, identity string, expiresAt time.Time, periods ...string) ([]int64, error) {
+	args := m.Called(ctx, identity, expiresAt, periods)
+	return args.Get(0).([]int64), args.Error(1)
+}
+
+func (m *MockUsageRepository) FindByPeriod(ctx context.Context, period string, opts domain.ListOptions) ([]domain.Usage, int64, error) {
+	args := m.Called(ctx, period, opts)
+	return args.Get(0).([]domain.Usage), args.Get(1).(int64), args.Error(2)
+}
+
+func TestUsageService_ListUsage(t *testing.T) {
+	repo := new(MockUsageRepository)
+	s := NewUsageService(repo)
+	s.now = func() time.Time { return time.Date(2024, 5, 31, 23, 0, 0, 0, time.UTC) }
+
+	usage := []domain.Usage{{Identity: "principal:k1", Period: "2024-05", Count: 42}}
+	repo.On("FindByPeriod", mock.Anything, "2024-05", domain.ListOptions{Page: 1, Size: 10}).Return(usage, int64(1), nil)
+	repo.On("FindByPeriod", mock.Anything, "2024-05-31", domain.ListOptions{Page: 3, Size: 100}).Return([]domain.Usage{}, int64(12), nil)
+
+	t.Run("current month by default", func(t *testing.T) {
+		got, err := s.ListUsage(context.Background(), "", domain.PageRequest{})
+		require.NoError(t, err)
+		assert.Equal(t, &domain.UsagePage{Usage: usage, Total: 1, Page: 1, Size: 10}, got)
+	})
+
+	t.Run("day", func(t *testing.T) {
+		got, err := s.ListUsage(context.Background(), "2024-05-31", domain.PageRequest{Page: 3, Size: 500})
+		require.NoError(t, err)
+		assert.Empty(t, got.Usage)
+		assert.EqualValues(t, 12, got.Total)
+		assert.Equal(t, 100, got.Size, "the size is capped")
+	})
+
+	t.Run("invalid periods", func(t *testing.T) {
+		for _, period := range []string{"2024", "2024-5", "2024-13", "2024-02-30", "May 2024"} {
+			_, err := s.ListUsage(context.Background(), period, domain.PageRequest{})
+			assert.ErrorIs(t, err, ErrInvalidPeriod, period)
+		}
+	})
+}
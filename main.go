@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -10,11 +11,17 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/snavarro/microtracker/config"
 	"github.com/snavarro/microtracker/docs"
+	"github.com/snavarro/microtracker/internal/domain"
 	"github.com/snavarro/microtracker/internal/handler"
 	"github.com/snavarro/microtracker/internal/middleware"
+	"github.com/snavarro/microtracker/internal/repository/memory"
 	"github.com/snavarro/microtracker/internal/repository/mongo"
+	"github.com/snavarro/microtracker/internal/repository/postgres"
+	redisrepo "github.com/snavarro/microtracker/internal/repository/redis"
 	"github.com/snavarro/microtracker/internal/service"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -26,6 +33,13 @@ import (
 // @host            localhost:9090
 // @BasePath        /api/v1
 // @schemes         http
+// @securityDefinitions.apikey APIKey
+// @in header
+// @name X-API-Key
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description OIDC token as "Bearer <token>"
 func main() {
 	// Initialize configuration
 	cfg, err := config.NewConfig()
@@ -33,10 +47,12 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
-	// Connect to MongoDB
-	db, err := config.ConnectDB(cfg)
-	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+	// Run schema migrations instead of the server
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(cfg, os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
 	}
 
 	// Set Gin mode
@@ -45,24 +61,99 @@ func main() {
 	}
 
 	// Initialize repositories
-	packageRepo := mongo.NewPackageRepository(db)
+	store, err := newStorage(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
 
 	// Initialize services
-	packageService := service.NewPackageService(packageRepo)
+	var serviceOpts []service.Option
+	if cfg.StatusTransitionsFile != "" {
+		statuses, err := service.LoadStatusMachine(cfg.StatusTransitionsFile)
+		if err != nil {
+			log.Fatalf("Failed to load status transitions: %v", err)
+		}
+		serviceOpts = append(serviceOpts, service.WithStatusMachine(statuses))
+	}
+	packageService := service.NewPackageService(store.packages, store.events, serviceOpts...)
+
+	plans := make([]string, 0, len(cfg.RateLimit.Plans))
+	for plan := range cfg.RateLimit.Plans {
+		plans = append(plans, plan)
+	}
+	apiKeyService := service.NewAPIKeyService(store.apiKeys, service.WithPlans(plans...))
+	if cfg.Auth.BootstrapKey != "" {
+		created, err := apiKeyService.BootstrapAPIKey(context.Background(), cfg.Auth.BootstrapKey)
+		if err != nil {
+			log.Fatalf("Failed to bootstrap API key: %v", err)
+		}
+		if created {
+			log.Println("Stored the bootstrap admin API key")
+		}
+	}
+	if !cfg.Auth.Enabled {
+		log.Println("Authentication is disabled, every request is treated as an admin")
+	}
 
 	// Initialize handlers
 	packageHandler := handler.NewPackageHandler(packageService)
+	apiKeyHandler := handler.NewAPIKeyHandler(apiKeyService)
+	usageHandler := handler.NewUsageHandler(service.NewUsageService(store.usage))
 
 	// Initialize router
 	router := gin.Default()
 
+	// Only trust the client IP header on requests from our own proxies, so
+	// that access logs and rate limits see the same, unforgeable address
+	if err := middleware.ConfigureClientIP(router, &cfg.Proxy); err != nil {
+		log.Fatalf("Failed to configure client IP: %v", err)
+	}
+
 	// Add middleware for request logging
 	router.Use(gin.Logger())
 	router.Use(gin.Recovery())
 
-	// Add rate limiting middleware with configuration from env
-	rateLimiter := middleware.NewRateLimiter(&cfg.RateLimit)
-	router.Use(rateLimiter.RateLimit())
+	// Authenticate API requests by API key or bearer token and authorize
+	// them by role
+	var authOpts []middleware.AuthOption
+	if cfg.Auth.JWT.Enabled() {
+		tokens, err := middleware.NewJWTAuthenticator(&cfg.Auth.JWT)
+		if err != nil {
+			log.Fatalf("Failed to configure JWT authentication: %v", err)
+		}
+		authOpts = append(authOpts, middleware.WithBearerTokens(tokens))
+	}
+	auth := middleware.NewAuth(apiKeyService, &cfg.Auth, authOpts...)
+	viewer := auth.Require(domain.RoleViewer)
+	operator := auth.Require(domain.RoleOperator)
+	admin := auth.Require(domain.RoleAdmin)
+
+	// Rate limit requests with configuration from env. Every request is
+	// limited by client IP ahead of authentication, so that credential
+	// guessing is limited too, and API requests again by caller after
+	// authentication, so that callers can be identified by principal or
+	// tenant.
+	rateLimitStore, closeRateLimitStore, err := newRateLimitStore(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize rate limit store: %v", err)
+	}
+	defer closeRateLimitStore()
+	var rateLimitOpts []middleware.RateLimiterOption
+	if cfg.RateLimit.TrackUsage {
+		rateLimitOpts = append(rateLimitOpts, middleware.WithUsage(store.usage))
+	}
+	if cfg.MetricsEnabled {
+		rateLimitOpts = append(rateLimitOpts, middleware.WithMetrics(prometheus.DefaultRegisterer))
+	}
+	rateLimiter := middleware.NewRateLimiter(rateLimitStore, &cfg.RateLimit, rateLimitOpts...)
+	rateLimitHandler := handler.NewRateLimitHandler(rateLimiter)
+
+	// Turn away denied networks and limit client IPs before anything else,
+	// including authentication
+	router.Use(rateLimiter.Networks(), rateLimiter.RateLimitIP())
+
+	// Replay responses of retried writes that carry an Idempotency-Key
+	idempotent := middleware.NewIdempotency(store.idempotency, &cfg.Idempotency).Handle()
 
 	// Swagger documentation setup
 	docs.SwaggerInfo.Title = "Package Tracking API"
@@ -77,17 +168,40 @@ func main() {
 		ginSwagger.URL("/swagger/doc.json"),
 		ginSwagger.DefaultModelsExpandDepth(-1)))
 
-	// API routes
-	api := router.Group("/api/v1")
+	// API routes, scoped to the caller's tenant
+	api := router.Group("/api/v1", auth.Authenticate(), middleware.Tenant(), rateLimiter.RateLimit())
 	{
 		packages := api.Group("/packages")
 		{
-			packages.GET("", packageHandler.ListPackages)
-			packages.GET("/search", packageHandler.SearchPackages)
-			packages.GET("/:id", packageHandler.GetPackage)
-			packages.POST("", packageHandler.CreatePackage)
-			packages.PUT("/:id", packageHandler.UpdatePackage)
-			packages.DELETE("/:id", packageHandler.DeletePackage)
+			packages.GET("", viewer, packageHandler.ListPackages)
+			packages.GET("/search", viewer, packageHandler.SearchPackages)
+			packages.GET("/:id", viewer, packageHandler.GetPackage)
+			packages.POST("", operator, idempotent, packageHandler.CreatePackage)
+			packages.PUT("/:id", operator, packageHandler.UpdatePackage)
+			packages.PATCH("/:id", operator, packageHandler.PatchPackage)
+			packages.DELETE("/:id", admin, packageHandler.DeletePackage)
+			packages.POST("/:id/events", operator, idempotent, packageHandler.AddEvent)
+		}
+
+		// Keys of every tenant are managed by platform admins only
+		apiKeys := api.Group("/admin/api-keys", admin, auth.RequirePlatform())
+		{
+			apiKeys.GET("", apiKeyHandler.ListAPIKeys)
+			apiKeys.POST("", apiKeyHandler.IssueAPIKey)
+			apiKeys.POST("/:id/rotate", apiKeyHandler.RotateAPIKey)
+			apiKeys.DELETE("/:id", apiKeyHandler.RevokeAPIKey)
+		}
+
+		api.GET("/admin/usage", admin, auth.RequirePlatform(), usageHandler.ListUsage)
+
+		// Rate limit clients as seen by this replica
+		rateLimits := api.Group("/admin/rate-limits/clients", admin, auth.RequirePlatform())
+		{
+			rateLimits.GET("", rateLimitHandler.ListClients)
+			rateLimits.GET("/:identity", rateLimitHandler.GetClient)
+			rateLimits.POST("/:identity/reset", rateLimitHandler.ResetClient)
+			rateLimits.PUT("/:identity/exemption", rateLimitHandler.ExemptClient)
+			rateLimits.DELETE("/:identity/exemption", rateLimitHandler.RemoveExemption)
 		}
 	}
 
@@ -105,6 +219,24 @@ func main() {
 		}
 	}()
 
+	// Prometheus metrics name callers, so they are served on a listener of
+	// their own for scrapers on the internal network rather than on the API
+	var metricsSrv *http.Server
+	if cfg.MetricsEnabled {
+		metrics := http.NewServeMux()
+		metrics.Handle("/metrics", promhttp.Handler())
+		metricsSrv = &http.Server{
+			Addr:    cfg.MetricsAddress,
+			Handler: metrics,
+		}
+		go func() {
+			log.Printf("Metrics server starting on %s", cfg.MetricsAddress)
+			if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Failed to start metrics server: %v", err)
+			}
+		}()
+	}
+
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -119,6 +251,104 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatal("Server forced to shutdown:", err)
 	}
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(ctx); err != nil {
+			log.Printf("Metrics server forced to shutdown: %v", err)
+		}
+	}
 
 	log.Println("Server exiting")
 }
+
+// storage holds the repositories of the configured storage backend
+type storage struct {
+	packages    domain.PackageRepository
+	events      domain.EventRepository
+	idempotency domain.IdempotencyStore
+	apiKeys     domain.APIKeyRepository
+	usage       domain.UsageRepository
+}
+
+// newStorage creates the repositories for the configured storage backend
+func newStorage(cfg *config.Config) (*storage, error) {
+	if cfg.StorageBackend == config.StorageMemory {
+		packageRepo := memory.NewPackageRepository()
+		eventRepo := memory.NewEventRepository()
+
+		if cfg.SeedFile != "" {
+			seeded, err := memory.Seed(context.Background(), cfg.SeedFile, packageRepo, eventRepo)
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("Seeded %d packages from %s", seeded, cfg.SeedFile)
+		}
+
+		log.Println("Using in-memory storage, data will be lost on restart")
+		return &storage{
+			packages:    packageRepo,
+			events:      eventRepo,
+			idempotency: memory.NewIdempotencyStore(),
+			apiKeys:     memory.NewAPIKeyRepository(),
+			usage:       memory.NewUsageRepository(),
+		}, nil
+	}
+
+	if cfg.StorageBackend == config.StoragePostgres {
+		db, err := config.ConnectPostgres(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to database: %v", err)
+		}
+
+		if cfg.RunMigrations {
+			applied, err := postgres.Migrate(context.Background(), db)
+			if err != nil {
+				return nil, fmt.Errorf("failed to migrate database: %v", err)
+			}
+			log.Printf("Applied %d database migrations", applied)
+		}
+
+		return &storage{
+			packages:    postgres.NewPackageRepository(db, postgres.WithTimeouts(cfg.Timeouts)),
+			events:      postgres.NewEventRepository(db, postgres.WithTimeouts(cfg.Timeouts)),
+			idempotency: postgres.NewIdempotencyStore(db, postgres.WithTimeouts(cfg.Timeouts)),
+			apiKeys:     postgres.NewAPIKeyRepository(db, postgres.WithTimeouts(cfg.Timeouts)),
+			usage:       postgres.NewUsageRepository(db, postgres.WithTimeouts(cfg.Timeouts)),
+		}, nil
+	}
+
+	// Connect to MongoDB
+	db, err := config.ConnectDB(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+
+	if cfg.RunMigrations {
+		applied, err := mongo.Migrate(context.Background(), db)
+		if err != nil {
+			return nil, fmt.Errorf("failed to migrate database: %v", err)
+		}
+		log.Printf("Applied %d database migrations", applied)
+	}
+
+	return &storage{
+		packages:    mongo.NewPackageRepository(db, mongo.WithTimeouts(cfg.Timeouts)),
+		events:      mongo.NewEventRepository(db, mongo.WithTimeouts(cfg.Timeouts)),
+		idempotency: mongo.NewIdempotencyStore(db, mongo.WithTimeouts(cfg.Timeouts)),
+		apiKeys:     mongo.NewAPIKeyRepository(db, mongo.WithTimeouts(cfg.Timeouts)),
+		usage:       mongo.NewUsageRepository(db, mongo.WithTimeouts(cfg.Timeouts)),
+	}, nil
+}
+
+// newRateLimitStore creates the configured rate limit store and a function
+// that stops it
+func newRateLimitStore(cfg *config.Config) (domain.RateLimitStore, func(), error) {
+	if cfg.RateLimit.Store == config.RateLimitStoreRedis {
+		client, err := config.ConnectRedis(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		return redisrepo.NewRateLimitStore(client), func() { client.Close() }, nil
+	}
+	store := memory.NewRateLimitStore(memory.WithMaxBuckets(cfg.RateLimit.MaxBuckets))
+	return store, store.Stop, nil
+}
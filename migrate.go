@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/snavarro/microtracker/config"
+	"github.com/snavarro/microtracker/internal/repository/mongo"
+	"github.com/snavarro/microtracker/internal/repository/postgres"
+)
+
+const migrateUsage = "usage: microtracker migrate up | down [steps] | status"
+
+// migrator runs schema migrations against the configured database
+type migrator struct {
+	up     func(ctx context.Context) (int, error)
+	down   func(ctx context.Context, steps int) (int, error)
+	status func(ctx context.Context) ([]migrationStatus, error)
+}
+
+type migrationStatus struct {
+	version   int
+	name      string
+	appliedAt *time.Time
+}
+
+// runMigrate implements the migrate subcommand. It applies pending
+// migrations, reverts the latest ones or lists them, independently of
+// RUN_MIGRATIONS.
+func runMigrate(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	steps := 1
+	switch {
+	case args[0] == "down" && len(args) == 2:
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("steps must be a positive number: %s", args[1])
+		}
+		steps = n
+	case len(args) != 1:
+		return errors.New(migrateUsage)
+	}
+
+	m, err := newMigrator(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	switch args[0] {
+	case "up":
+		applied, err := m.up(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migrations\n", applied)
+	case "down":
+		reverted, err := m.down(ctx, steps)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Reverted %d migrations\n", reverted)
+	case "status":
+		status, err := m.status(ctx)
+		if err != nil {
+			return err
+		}
+		printMigrationStatus(status)
+	default:
+		return errors.New(migrateUsage)
+	}
+	return nil
+}
+
+func newMigrator(cfg *config.Config) (*migrator, error) {
+	switch cfg.StorageBackend {
+	case config.StoragePostgres:
+		db, err := config.ConnectPostgres(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to database: %v", err)
+		}
+		return &migrator{
+			up: func(ctx context.Context) (int, error) { return postgres.Migrate(ctx, db) },
+			down: func(ctx context.Context, steps int) (int, error) {
+				return postgres.Rollback(ctx, db, steps)
+			},
+			status: func(ctx context.Context) ([]migrationStatus, error) {
+				status, err := postgres.Status(ctx, db)
+				result := make([]migrationStatus, len(status))
+				for i, s := range status {
+					result[i] = migrationStatus{s.Version, s.Name, s.AppliedAt}
+				}
+				return result, err
+			},
+		}, nil
+	case config.StorageMongo:
+		db, err := config.ConnectDB(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to database: %v", err)
+		}
+		return &migrator{
+			up: func(ctx context.Context) (int, error) { return mongo.Migrate(ctx, db) },
+			down: func(ctx context.Context, steps int) (int, error) {
+				return mongo.Rollback(ctx, db, steps)
+			},
+			status: func(ctx context.Context) ([]migrationStatus, error) {
+				status, err := mongo.Status(ctx, db)
+				result := make([]migrationStatus, len(status))
+				for i, s := range status {
+					result[i] = migrationStatus{s.Version, s.Name, s.AppliedAt}
+				}
+				return result, err
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("the %s storage backend has no migrations", cfg.StorageBackend)
+	}
+}
+
+func printMigrationStatus(status []migrationStatus) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, s := range status {
+		applied := "pending"
+		if s.appliedAt != nil {
+			applied = s.appliedAt.UTC().Format(time.RFC3339)
+		}
+		fmt.Fprintf(w, "%04d\t%s\t%s\n", s.version, s.name, applied)
+	}
+	w.Flush()
+}